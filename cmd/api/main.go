@@ -35,12 +35,6 @@ func main() {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 
-	// 初始化 MIS Admin Token
-	adminTokenService := services.NewAdminTokenService(cfg, db)
-	if err := adminTokenService.InitializeAdminToken(); err != nil {
-		log.Printf("Warning: Failed to initialize admin token: %v", err)
-	}
-
 	// 初始化 KeyDB
 	keydbService, err := services.NewKeyDBService(cfg)
 	if err != nil {
@@ -55,27 +49,62 @@ func main() {
 	}
 	defer queueService.Close()
 
+	// 設定 Microsoft 端點與共用的 HTTP client
+	if _, err := services.ConfigureMicrosoftClient(cfg); err != nil {
+		log.Fatalf("Invalid Microsoft endpoint configuration: %v", err)
+	}
+
 	// 初始化 OAuth 服務 (用於 SMTP Receiver fallback)
-	oauthService := microsoft.NewOAuthService(
+	oauthService := microsoft.DefaultOAuthManager.GetOrCreateService(
 		cfg.MicrosoftTenantID,
 		cfg.MicrosoftClientID,
 		cfg.MicrosoftClientSecret,
 	)
 
-	// 初始化 SenderConfigService (用於 API 多租戶 OAuth)
+	// 初始化 SenderConfigService (用於 API 多租戶 OAuth) 與 DKIMService (DKIM 金鑰管理)
+	var encryptionService *services.EncryptionService
 	var senderConfigService *services.EmailSenderConfigService
+	var dkimService *services.DKIMService
 	if cfg.EncryptionKey != "" {
-		encryptionService, err := services.NewEncryptionService(cfg.EncryptionKey)
+		encryptionService, err = services.NewEncryptionService(cfg.EncryptionKey)
 		if err != nil {
 			log.Printf("Warning: Failed to initialize encryption service: %v", err)
+			encryptionService = nil
 		} else {
 			senderConfigService = services.NewEmailSenderConfigService(cfg, db, encryptionService)
 			log.Println("SenderConfigService initialized successfully")
+			senderConfigService.StartCertificateExpiryMonitor()
+			dkimService = services.NewDKIMService(db, encryptionService)
+
+			// Sender Config 憑證變更時清除 Worker 共用的 Access Token 快取
+			if cfg.OAuthTokenCacheShared {
+				microsoft.DefaultOAuthManager.SetTokenStore(services.NewOAuthTokenStore(keydbService, encryptionService))
+			}
 		}
 	} else {
-		log.Println("Warning: ENCRYPTION_KEY not set, sender config API will not be available")
+		log.Println("Warning: ENCRYPTION_KEY not set, sender config, DKIM key and JWT signing key API will not be available")
+	}
+
+	// 初始化 JWT 簽章金鑰服務 (未設定 ENCRYPTION_KEY 時只能以 HS256 簽發)
+	jwtKeyService := services.NewJWTKeyService(cfg, db, encryptionService)
+	if !cfg.JWTAllowHS256 {
+		log.Println("JWT_ALLOW_HS256=false, HS256 tokens will be rejected")
 	}
 
+	// 初始化 MIS Admin Token
+	adminTokenService := services.NewAdminTokenService(cfg, db, jwtKeyService)
+	if err := adminTokenService.InitializeAdminToken(); err != nil {
+		log.Printf("Warning: Failed to initialize admin token: %v", err)
+	}
+
+	// 初始化 API 請求日誌 (非同步批次寫入 api_logs，並維護月分區)
+	apiLogService := services.NewAPILogService(cfg, db)
+	apiLogService.Start()
+
+	// 初始化稽核與一鍵退訂服務 (退訂時寫入抑制清單並記錄稽核事件)
+	auditService := services.NewAuditService(db)
+	unsubscribeService := services.NewUnsubscribeService(cfg, services.NewSuppressionService(db), auditService)
+
 	// 初始化 Gin
 	if cfg.Env == "production" {
 		gin.SetMode(gin.ReleaseMode)
@@ -93,6 +122,14 @@ func main() {
 		QueueService:        queueService,
 		KeyDBService:        keydbService,
 		SenderConfigService: senderConfigService,
+		DKIMService:         dkimService,
+		JWTKeyService:       jwtKeyService,
+		DepartmentService:   services.NewDepartmentService(db),
+		APILogService:       apiLogService,
+		AuditService:        auditService,
+		RateLimiter:         services.NewRateLimiter(cfg, keydbService),
+		QuotaService:        services.NewQuotaService(cfg, db, keydbService, auditService),
+		UnsubscribeService:  unsubscribeService,
 	})
 
 	// 建立 HTTP Server
@@ -124,6 +161,9 @@ func main() {
 		log.Printf("Server forced to shutdown: %v", err)
 	}
 
+	// 寫入緩衝區內剩餘的 API 請求日誌
+	apiLogService.Close()
+
 	log.Println("API Server stopped")
 }
 
@@ -91,7 +91,7 @@ func initDatabase(cfg *config.Config) *gorm.DB {
 	}
 
 	// 自動遷移（確保資料表存在）
-	if err := db.AutoMigrate(&models.Mail{}, &models.Attachment{}); err != nil {
+	if err := db.AutoMigrate(&models.Mail{}, &models.Attachment{}, &models.MailDSNRecipient{}, &models.Suppression{}); err != nil {
 		log.Fatalf("資料庫遷移失敗: %v", err)
 	}
 
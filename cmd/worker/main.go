@@ -39,8 +39,32 @@ func main() {
 	}
 	defer keydbService.Close()
 
-	// 初始化 OAuth 服務
-	oauthService := microsoft.NewOAuthService(
+	// 初始化加密服務 (資料庫 OAuth 配置與 DKIM 私鑰)
+	var encryptionService *services.EncryptionService
+	if cfg.EncryptionKey != "" {
+		encryptionService, err = services.NewEncryptionService(cfg.EncryptionKey)
+		if err != nil {
+			log.Printf("Warning: Failed to initialize encryption service: %v", err)
+			encryptionService = nil
+		}
+	} else {
+		log.Println("Warning: ENCRYPTION_KEY not set, database OAuth config and DKIM signing will not work")
+	}
+
+	// 設定 OAuth Access Token 的 KeyDB 共用快取 (所有 Worker 共用同一組憑證的 Token，加密後儲存)
+	if cfg.OAuthTokenCacheShared && encryptionService != nil {
+		microsoft.DefaultOAuthManager.SetTokenStore(services.NewOAuthTokenStore(keydbService, encryptionService))
+		log.Println("OAuth token cache shared via KeyDB")
+	}
+
+	// 設定 Microsoft 端點與共用的 HTTP client (Token 與 Graph API 共用連線池)
+	microsoftHTTPClient, err := services.ConfigureMicrosoftClient(cfg)
+	if err != nil {
+		log.Fatalf("Invalid Microsoft endpoint configuration: %v", err)
+	}
+
+	// 初始化 OAuth 服務 (環境變數配置，與資料庫配置共用 OAuthManager 快取)
+	oauthService := microsoft.DefaultOAuthManager.GetOrCreateService(
 		cfg.MicrosoftTenantID,
 		cfg.MicrosoftClientID,
 		cfg.MicrosoftClientSecret,
@@ -50,37 +74,55 @@ func main() {
 		log.Println("WARNING: Microsoft OAuth not configured, Graph API mail sending will fail")
 	}
 
+	// 初始化 DKIM 簽章服務 (原始 MIME 與 SendGrid 外送時簽章)
+	var messageSigner services.MessageSigner
+	if encryptionService != nil {
+		messageSigner = services.NewDKIMService(db, encryptionService)
+		log.Println("DKIM signing enabled for raw MIME and SendGrid senders")
+	}
+
 	// 初始化 Graph API 郵件服務
-	graphMailService := services.NewGraphMailService(cfg, oauthService)
+	graphMailService := services.NewGraphMailService(cfg, oauthService, messageSigner, microsoftHTTPClient)
+	graphMailService.SetUploadStateStore(services.NewGraphUploadStore(keydbService))
 
 	// 初始化 SendGrid 郵件服務
-	sendgridService := services.NewSendGridService(cfg)
+	sendgridService := services.NewSendGridService(cfg, messageSigner)
 	if !sendgridService.IsConfigured() {
 		log.Println("WARNING: SendGrid API Key not configured, SendGrid mail sending will fail")
 	}
 
+	// 初始化 SMTP Relay 服務 (原始 MIME 直送的非組織網域出口)
+	var smtpRelayService services.RawMailSender
+	if relay := services.NewSMTPRelayService(cfg, messageSigner); relay.IsConfigured() {
+		smtpRelayService = relay
+	} else {
+		log.Println("WARNING: SMTP relay not configured, DSN (sent with a null envelope sender) will not be delivered")
+		if cfg.SMTPRawPassthrough {
+			log.Println("WARNING: SMTP relay not configured, raw MIME passthrough for non-org senders will fall back to SendGrid")
+		}
+	}
+
 	// 初始化郵件路由服務
-	mailRouter := services.NewMailRouter(cfg, graphMailService, sendgridService)
+	mailRouter := services.NewMailRouter(cfg, graphMailService, sendgridService, smtpRelayService)
 	if err := mailRouter.ValidateConfiguration(); err != nil {
 		log.Printf("WARNING: Mail router configuration issue: %v", err)
 	}
 
 	// 初始化 SenderConfigService (用於 API 多租戶 OAuth)
 	var senderConfigService *services.EmailSenderConfigService
-	if cfg.EncryptionKey != "" {
-		encryptionService, err := services.NewEncryptionService(cfg.EncryptionKey)
-		if err != nil {
-			log.Printf("Warning: Failed to initialize encryption service: %v", err)
-		} else {
-			senderConfigService = services.NewEmailSenderConfigService(cfg, db, encryptionService)
-			log.Println("SenderConfigService initialized for Worker")
-		}
-	} else {
-		log.Println("Warning: ENCRYPTION_KEY not set, database OAuth config will not work")
+	if encryptionService != nil {
+		senderConfigService = services.NewEmailSenderConfigService(cfg, db, encryptionService)
+		log.Println("SenderConfigService initialized for Worker")
 	}
 
+	// 初始化 DSN 服務 (SMTP 接收郵件的投遞狀態通知)
+	dsnService := services.NewDSNService(cfg, db, mailRouter)
+
+	// 初始化發送速率調節 (依供應商 + 寄件信箱，跨 Worker 共用 KeyDB 額度)
+	sendShaper := services.NewSendShaper(cfg, services.NewRateLimiter(cfg, keydbService))
+
 	// 初始化 Consumer
-	consumer := worker.NewConsumer(cfg, db, oauthService, mailRouter, keydbService, senderConfigService, graphMailService)
+	consumer := worker.NewConsumer(cfg, db, oauthService, mailRouter, keydbService, senderConfigService, graphMailService, dsnService, sendShaper)
 
 	// 啟動 Consumer
 	go func() {
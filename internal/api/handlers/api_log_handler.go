@@ -0,0 +1,69 @@
+// internal/api/handlers/api_log_handler.go
+// API 請求日誌查詢 Handler
+
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"mail-proxy/internal/models"
+	"mail-proxy/internal/services"
+)
+
+// APILogHandler API 請求日誌 Handler
+type APILogHandler struct {
+	apiLogs *services.APILogService
+}
+
+// NewAPILogHandler 建立 API 請求日誌 Handler
+func NewAPILogHandler(apiLogs *services.APILogService) *APILogHandler {
+	return &APILogHandler{
+		apiLogs: apiLogs,
+	}
+}
+
+// ListAPILogs 依條件查詢 API 請求日誌
+// GET /api/v1/auth/api-logs
+func (h *APILogHandler) ListAPILogs(c *gin.Context) {
+	var filter models.APILogFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "validation_error",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.Limit < 1 {
+		filter.Limit = 50
+	}
+	if filter.Limit > 500 {
+		filter.Limit = 500
+	}
+	filter.Method = strings.ToUpper(filter.Method)
+
+	logs, total, err := h.apiLogs.Query(&filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "query_error",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"total":   total,
+		"page":    filter.Page,
+		"limit":   filter.Limit,
+		"data":    logs,
+	})
+}
@@ -0,0 +1,195 @@
+// internal/api/handlers/audit_handler.go
+// 管理操作稽核紀錄 Handler
+
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mail-proxy/internal/models"
+	"mail-proxy/internal/services"
+)
+
+// AuditHandler 稽核紀錄 Handler
+type AuditHandler struct {
+	audit *services.AuditService
+}
+
+// NewAuditHandler 建立稽核紀錄 Handler
+func NewAuditHandler(audit *services.AuditService) *AuditHandler {
+	return &AuditHandler{
+		audit: audit,
+	}
+}
+
+// recordAudit 記錄呼叫者的管理操作；寫入失敗只記錄錯誤，不影響已完成的操作
+func recordAudit(c *gin.Context, audit *services.AuditService, action, targetType, targetID string, before, after map[string]interface{}) {
+	if audit == nil {
+		return
+	}
+
+	event := &models.AuditEvent{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Changes:    audit.Diff(before, after),
+		SourceIP:   c.ClientIP(),
+	}
+	if caller := callerToken(c); caller != nil {
+		event.ActorClientID = caller.ClientID
+		event.ActorClientName = caller.ClientName
+		actorTokenID := caller.ID
+		event.ActorTokenID = &actorTokenID
+	}
+
+	if err := audit.Record(event); err != nil {
+		log.Printf("Failed to record audit event %s %s/%s: %v", action, targetType, targetID, err)
+	}
+}
+
+// bindAuditFilter 解析查詢條件；失敗時已寫入回應
+func bindAuditFilter(c *gin.Context) (*models.AuditEventFilter, bool) {
+	var filter models.AuditEventFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "validation_error",
+			"message": err.Error(),
+		})
+		return nil, false
+	}
+	return &filter, true
+}
+
+// ListAuditEvents 依條件查詢稽核紀錄
+// GET /api/v1/auth/audit-events
+func (h *AuditHandler) ListAuditEvents(c *gin.Context) {
+	filter, ok := bindAuditFilter(c)
+	if !ok {
+		return
+	}
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.Limit < 1 {
+		filter.Limit = 50
+	}
+	if filter.Limit > 500 {
+		filter.Limit = 500
+	}
+
+	events, total, err := h.audit.List(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "query_error",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"total":   total,
+		"page":    filter.Page,
+		"limit":   filter.Limit,
+		"data":    events,
+	})
+}
+
+// ExportAuditEvents 匯出稽核紀錄 (依鏈結順序，含 hash / prev_hash 供離線驗證)
+// format=jsonl (預設) 或 csv
+// GET /api/v1/auth/audit-events/export
+func (h *AuditHandler) ExportAuditEvents(c *gin.Context) {
+	filter, ok := bindAuditFilter(c)
+	if !ok {
+		return
+	}
+
+	format := c.DefaultQuery("format", "jsonl")
+	if format != "jsonl" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "invalid_format",
+			"message": "format must be jsonl or csv",
+		})
+		return
+	}
+
+	filename := fmt.Sprintf("audit-events-%s.%s", time.Now().UTC().Format("20060102T150405Z"), format)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	var err error
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Status(http.StatusOK)
+
+		w := csv.NewWriter(c.Writer)
+		_ = w.Write([]string{"id", "created_at", "actor_client_id", "actor_client_name", "actor_token_id", "action", "target_type", "target_id", "changes", "source_ip", "prev_hash", "hash"})
+		err = h.audit.Export(filter, func(e *models.AuditEvent) error {
+			changes, err := json.Marshal(e.Changes)
+			if err != nil {
+				return err
+			}
+			actorTokenID := ""
+			if e.ActorTokenID != nil {
+				actorTokenID = e.ActorTokenID.String()
+			}
+			return w.Write([]string{
+				strconv.FormatInt(e.ID, 10),
+				e.CreatedAt.UTC().Format(time.RFC3339Nano),
+				e.ActorClientID,
+				e.ActorClientName,
+				actorTokenID,
+				e.Action,
+				e.TargetType,
+				e.TargetID,
+				string(changes),
+				e.SourceIP,
+				e.PrevHash,
+				e.Hash,
+			})
+		})
+		w.Flush()
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Status(http.StatusOK)
+
+		enc := json.NewEncoder(c.Writer)
+		err = h.audit.Export(filter, func(e *models.AuditEvent) error {
+			return enc.Encode(e)
+		})
+	}
+
+	// 回應已開始串流，只能記錄錯誤
+	if err != nil {
+		log.Printf("Failed to export audit events: %v", err)
+	}
+}
+
+// VerifyAuditChain 驗證稽核紀錄的 Hash 鏈結是否完整
+// GET /api/v1/auth/audit-events/verify
+func (h *AuditHandler) VerifyAuditChain(c *gin.Context) {
+	result, err := h.audit.Verify()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "verify_error",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    result,
+	})
+}
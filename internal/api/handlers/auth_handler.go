@@ -4,9 +4,9 @@
 package handlers
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"time"
 
@@ -18,19 +18,29 @@ import (
 
 	"mail-proxy/internal/config"
 	"mail-proxy/internal/models"
+	"mail-proxy/internal/services"
 )
 
 // AuthHandler Token 管理 Handler
+// 非 admin 的呼叫者只能管理自己部門內的 Token
 type AuthHandler struct {
-	cfg *config.Config
-	db  *gorm.DB
+	cfg         *config.Config
+	db          *gorm.DB
+	jwtKeys     *services.JWTKeyService
+	departments *services.DepartmentService
+	audit       *services.AuditService
+	quotas      *services.QuotaService
 }
 
 // NewAuthHandler 建立 Auth Handler
-func NewAuthHandler(cfg *config.Config, db *gorm.DB) *AuthHandler {
+func NewAuthHandler(cfg *config.Config, db *gorm.DB, jwtKeys *services.JWTKeyService, departments *services.DepartmentService, audit *services.AuditService, quotas *services.QuotaService) *AuthHandler {
 	return &AuthHandler{
-		cfg: cfg,
-		db:  db,
+		cfg:         cfg,
+		db:          db,
+		jwtKeys:     jwtKeys,
+		departments: departments,
+		audit:       audit,
+		quotas:      quotas,
 	}
 }
 
@@ -46,47 +56,124 @@ func (h *AuthHandler) CreateToken(c *gin.Context) {
 		return
 	}
 
-	// 產生唯一 client_id
-	clientID := fmt.Sprintf("client_%s", uuid.New().String()[:8])
-
-	// 建立 JWT Token (永久有效)
-	now := time.Now()
-	claims := jwt.MapClaims{
-		"iss":         "mail-proxy-system",
-		"sub":         uuid.New().String(),
-		"iat":         now.Unix(),
-		"client_id":   clientID,
-		"client_name": req.ClientName,
-		"department":  req.Department,
-		"permissions": req.Permissions,
+	// 驗證權限範圍，且不可授予超過建立者本身的權限
+	permissions, err := models.ValidateScopes(req.Permissions)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "invalid_permission",
+			"message": err.Error(),
+		})
+		return
+	}
+	if p, ok := h.checkCallerScopes(c, permissions); !ok {
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"error":   "permission_escalation",
+			"message": fmt.Sprintf("Cannot grant %q which the current token does not have", p),
+		})
+		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(h.cfg.JWTSecret))
+	// 決定所屬部門：admin 可指定任何部門，其他呼叫者只能建立自己部門的 Token
+	caller := callerToken(c)
+	department, err := h.departments.Resolve(req.DepartmentID, req.Department)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+		status, code := http.StatusInternalServerError, "database_error"
+		if errors.Is(err, services.ErrDepartmentNotFound) {
+			status, code = http.StatusBadRequest, "department_not_found"
+		}
+		c.JSON(status, gin.H{
 			"success": false,
-			"error":   "token_generation_error",
-			"message": "Failed to generate token",
+			"error":   code,
+			"message": err.Error(),
 		})
 		return
 	}
+	if !caller.IsAdmin() {
+		if caller.DepartmentID == nil {
+			c.JSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"error":   "department_required",
+				"message": "Only admin or department-bound tokens can create tokens",
+			})
+			return
+		}
+		if department != nil && department.ID != *caller.DepartmentID {
+			c.JSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"error":   "department_forbidden",
+				"message": "Cannot create tokens for another department",
+			})
+			return
+		}
+		if department == nil {
+			if department, err = h.departments.GetByID(*caller.DepartmentID); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"success": false,
+					"error":   "database_error",
+					"message": err.Error(),
+				})
+				return
+			}
+		}
+	}
+
+	// 產生唯一 client_id
+	clientID := fmt.Sprintf("client_%s", uuid.New().String()[:8])
 
-	// 計算 token hash
-	hash := sha256.Sum256([]byte(tokenString))
-	tokenHash := hex.EncodeToString(hash[:])
+	// 有效期限 (未指定則永久有效)；非 admin 呼叫者簽發的 Token 不可晚於自己到期
+	now := time.Now()
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := now.AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = &t
+	}
+	if !caller.IsAdmin() {
+		expiresAt = caller.CapExpiry(expiresAt)
+	}
 
-	// 儲存到資料庫
 	clientToken := models.ClientToken{
 		ID:          uuid.New(),
 		ClientID:    clientID,
 		ClientName:  req.ClientName,
-		Department:  req.Department,
-		Permissions: pq.StringArray(req.Permissions),
-		TokenHash:   tokenHash,
+		Permissions: pq.StringArray(permissions),
+		ExpiresAt:   expiresAt,
 		IsActive:    true,
+
+		AllowedFromAddresses:    pq.StringArray(req.AllowedFromAddresses),
+		AllowedRecipientDomains: pq.StringArray(req.AllowedRecipientDomains),
+	}
+
+	if department != nil {
+		clientToken.Department = department.Name
+		clientToken.DepartmentID = &department.ID
+	}
+
+	// 呼叫者有寄件或收件限制時，新 Token 的限制必須是其非空白的子集
+	if !caller.IsAdmin() && !caller.CoversRestrictions(&clientToken) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"error":   "restriction_escalation",
+			"message": "allowed_from_addresses and allowed_recipient_domains must be a non-empty subset of the current token's restrictions",
+		})
+		return
+	}
+
+	// 建立 JWT Token
+	tokenString, err := h.issueToken(&clientToken, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "token_generation_error",
+			"message": "Failed to generate token",
+		})
+		return
 	}
 
+	// 儲存 token hash 到資料庫
+	clientToken.TokenHash = models.HashToken(tokenString)
+
 	if err := h.db.Create(&clientToken).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -96,25 +183,211 @@ func (h *AuthHandler) CreateToken(c *gin.Context) {
 		return
 	}
 
+	recordAudit(c, h.audit, models.AuditActionTokenCreate, models.AuditTargetToken, clientToken.ID.String(), nil, clientToken.AuditState())
+
 	c.JSON(http.StatusCreated, models.CreateTokenResponse{
 		Token:     tokenString,
 		ClientID:  clientID,
+		ExpiresAt: clientToken.ExpiresAt,
 		CreatedAt: clientToken.CreatedAt,
 	})
 }
 
-// GetToken 查詢 Token 資訊
-func (h *AuthHandler) GetToken(c *gin.Context) {
-	tokenID := c.Param("id")
+// RotateToken 輪替 Token
+// 簽發新 Token 並保留舊 Token 至寬限期結束，可同時縮減權限
+// POST /api/v1/auth/token/:id/rotate
+func (h *AuthHandler) RotateToken(c *gin.Context) {
+	var req models.RotateTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "validation_error",
+			"message": err.Error(),
+		})
+		return
+	}
 
-	var clientToken models.ClientToken
+	clientToken, err := h.departments.GetManagedToken(callerToken(c), h.tokenQuery(c.Param("id")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "not_found",
+			"message": "Token not found",
+		})
+		return
+	}
+
+	if !clientToken.IsActive {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"error":   "token_revoked",
+			"message": "Revoked tokens cannot be rotated",
+		})
+		return
+	}
+
+	before := clientToken.AuditState()
+
+	// 權限只能縮減
+	if len(req.Permissions) > 0 {
+		permissions, err := models.ValidateScopes(req.Permissions)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   "invalid_permission",
+				"message": err.Error(),
+			})
+			return
+		}
+		for _, p := range permissions {
+			if !models.HasScope(clientToken.Permissions, p) {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"success": false,
+					"error":   "permission_escalation",
+					"message": fmt.Sprintf("Permission %q is not granted to the current token", p),
+				})
+				return
+			}
+		}
+		clientToken.Permissions = pq.StringArray(permissions)
+	}
+
+	now := time.Now()
+	caller := callerToken(c)
+	previousExpiresAt := clientToken.ExpiresAt
+
+	// 舊 Token 寬限期 (不可超過 TOKEN_ROTATION_MAX_GRACE_HOURS)
+	graceHours := h.cfg.TokenRotationGraceHours
+	if req.GracePeriodHours != nil {
+		graceHours = *req.GracePeriodHours
+	}
+	if graceHours > h.cfg.TokenRotationMaxGraceHours {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "validation_error",
+			"message": fmt.Sprintf("grace_period_hours must not exceed %d", h.cfg.TokenRotationMaxGraceHours),
+		})
+		return
+	}
+
+	// 新 Token 有效期限：指定天數，或沿用原 Token 的有效期長度
+	issuedAt := clientToken.CreatedAt
+	if clientToken.RotatedAt != nil {
+		issuedAt = *clientToken.RotatedAt
+	}
+	if req.ExpiresInDays != nil {
+		t := now.AddDate(0, 0, *req.ExpiresInDays)
+		clientToken.ExpiresAt = &t
+	} else if clientToken.ExpiresAt != nil {
+		t := now.Add(clientToken.ExpiresAt.Sub(issuedAt))
+		clientToken.ExpiresAt = &t
+	}
+	// 非 admin 輪替時不可晚於原 Token 與呼叫者本身到期，避免以輪替無限延長有效期
+	if !caller.IsAdmin() {
+		original := models.ClientToken{ExpiresAt: previousExpiresAt}
+		clientToken.ExpiresAt = caller.CapExpiry(original.CapExpiry(clientToken.ExpiresAt))
+	}
+
+	// 舊 Token 不可晚於其原本的到期時間
+	graceEnd := now.Add(time.Duration(graceHours) * time.Hour)
+	if previousExpiresAt != nil && graceEnd.After(*previousExpiresAt) {
+		graceEnd = *previousExpiresAt
+	}
+
+	tokenString, err := h.issueToken(clientToken, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "token_generation_error",
+			"message": "Failed to generate token",
+		})
+		return
+	}
+
+	clientToken.PreviousTokenHash = clientToken.TokenHash
+	clientToken.PreviousTokenExpiresAt = &graceEnd
+	clientToken.TokenHash = models.HashToken(tokenString)
+	clientToken.RotatedAt = &now
+
+	if err := h.db.Save(clientToken).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "database_error",
+			"message": "Failed to save token",
+		})
+		return
+	}
+
+	recordAudit(c, h.audit, models.AuditActionTokenRotate, models.AuditTargetToken, clientToken.ID.String(), before, clientToken.AuditState())
+
+	c.JSON(http.StatusOK, models.RotateTokenResponse{
+		Token:                  tokenString,
+		ClientID:               clientToken.ClientID,
+		Permissions:            clientToken.Permissions,
+		ExpiresAt:              clientToken.ExpiresAt,
+		PreviousTokenExpiresAt: clientToken.PreviousTokenExpiresAt,
+		RotatedAt:              now,
+	})
+}
+
+// tokenQuery 依 client_id 或 UUID 查詢 Token
+func (h *AuthHandler) tokenQuery(tokenID string) *gorm.DB {
 	// 先嘗試用 client_id 查詢，再嘗試用 UUID 查詢
 	query := h.db.Where("client_id = ?", tokenID)
 	if _, err := uuid.Parse(tokenID); err == nil {
 		query = query.Or("id = ?", tokenID)
 	}
+	return query
+}
+
+// issueToken 簽發 JWT Token，有設定有效期限時加上 exp
+// 使用目前的簽章金鑰並記錄 kid 於 SigningKID
+func (h *AuthHandler) issueToken(clientToken *models.ClientToken, now time.Time) (string, error) {
+	claims := jwt.MapClaims{
+		"iss":         "mail-proxy-system",
+		"sub":         uuid.New().String(),
+		"iat":         now.Unix(),
+		"client_id":   clientToken.ClientID,
+		"client_name": clientToken.ClientName,
+		"department":  clientToken.Department,
+		"permissions": []string(clientToken.Permissions),
+	}
+	if clientToken.ExpiresAt != nil {
+		claims["exp"] = clientToken.ExpiresAt.Unix()
+	}
+
+	tokenString, kid, err := h.jwtKeys.Sign(claims)
+	if err != nil {
+		return "", err
+	}
+	clientToken.SigningKID = kid
+	return tokenString, nil
+}
+
+// callerToken 取得目前請求的 Client Token (由 JWTAuth 設定)
+func callerToken(c *gin.Context) *models.ClientToken {
+	value, _ := c.Get("client_token")
+	clientToken, _ := value.(*models.ClientToken)
+	return clientToken
+}
+
+// checkCallerScopes 檢查要授予的權限是否都在呼叫者的權限內
+// 回傳第一個超出的權限與是否通過
+func (h *AuthHandler) checkCallerScopes(c *gin.Context, scopes []string) (string, bool) {
+	callerPerms, _ := c.Get("permissions")
+	granted, _ := callerPerms.([]string)
+	for _, s := range scopes {
+		if !models.HasScope(granted, s) {
+			return s, false
+		}
+	}
+	return "", true
+}
 
-	if err := query.First(&clientToken).Error; err != nil {
+// GetToken 查詢 Token 資訊
+func (h *AuthHandler) GetToken(c *gin.Context) {
+	clientToken, err := h.departments.GetManagedToken(callerToken(c), h.tokenQuery(c.Param("id")))
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"success": false,
 			"error":   "not_found",
@@ -128,21 +401,101 @@ func (h *AuthHandler) GetToken(c *gin.Context) {
 
 // RevokeToken 撤銷 Token
 func (h *AuthHandler) RevokeToken(c *gin.Context) {
-	tokenID := c.Param("id")
-
-	// 建立查詢條件
-	query := h.db.Model(&models.ClientToken{}).Where("client_id = ?", tokenID)
-	if _, err := uuid.Parse(tokenID); err == nil {
-		query = query.Or("id = ?", tokenID)
+	clientToken, err := h.departments.GetManagedToken(callerToken(c), h.tokenQuery(c.Param("id")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "not_found",
+			"message": "Token not found",
+		})
+		return
 	}
 
+	before := clientToken.AuditState()
 	now := time.Now()
-	result := query.Updates(map[string]interface{}{
+	if err := h.db.Model(clientToken).Updates(map[string]interface{}{
 		"is_active":  false,
 		"revoked_at": now,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "database_error",
+			"message": "Failed to revoke token",
+		})
+		return
+	}
+	clientToken.IsActive = false
+	clientToken.RevokedAt = &now
+
+	recordAudit(c, h.audit, models.AuditActionTokenRevoke, models.AuditTargetToken, clientToken.ID.String(), before, clientToken.AuditState())
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Token 已撤銷",
+	})
+}
+
+// UpdateRateLimits 設定 Token 的速率限制覆寫 (僅限 admin)
+// PUT /api/v1/auth/token/:id/rate-limits
+func (h *AuthHandler) UpdateRateLimits(c *gin.Context) {
+	var req models.UpdateRateLimitsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "validation_error",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	clientToken, err := h.departments.GetManagedToken(callerToken(c), h.tokenQuery(c.Param("id")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "not_found",
+			"message": "Token not found",
+		})
+		return
+	}
+
+	before := clientToken.AuditState()
+	if err := h.db.Model(clientToken).Select("rate_limit_mails_per_hour", "rate_limit_recipient_per_hour").Updates(&models.ClientToken{
+		RateLimitMailsPerHour:     req.MailsPerHour,
+		RateLimitRecipientPerHour: req.RecipientPerHour,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "database_error",
+			"message": "Failed to update rate limits",
+		})
+		return
+	}
+	clientToken.RateLimitMailsPerHour = req.MailsPerHour
+	clientToken.RateLimitRecipientPerHour = req.RecipientPerHour
+
+	recordAudit(c, h.audit, models.AuditActionTokenRateLimits, models.AuditTargetToken, clientToken.ID.String(), before, clientToken.AuditState())
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    clientToken,
 	})
+}
 
-	if result.RowsAffected == 0 {
+// UpdateQuotas 設定 Token 的用量配額 (僅限 admin)
+// PUT /api/v1/auth/token/:id/quotas
+func (h *AuthHandler) UpdateQuotas(c *gin.Context) {
+	var req models.UpdateQuotasRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "validation_error",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	clientToken, err := h.departments.GetManagedToken(callerToken(c), h.tokenQuery(c.Param("id")))
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"success": false,
 			"error":   "not_found",
@@ -151,16 +504,70 @@ func (h *AuthHandler) RevokeToken(c *gin.Context) {
 		return
 	}
 
+	before := clientToken.AuditState()
+	if err := h.db.Model(clientToken).Select("quota_mails_per_day", "quota_recipients_per_month", "quota_attachment_gb_per_month").Updates(&models.ClientToken{
+		QuotaMailsPerDay:          req.MailsPerDay,
+		QuotaRecipientsPerMonth:   req.RecipientsPerMonth,
+		QuotaAttachmentGBPerMonth: req.AttachmentGBPerMonth,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "database_error",
+			"message": "Failed to update quotas",
+		})
+		return
+	}
+	clientToken.QuotaMailsPerDay = req.MailsPerDay
+	clientToken.QuotaRecipientsPerMonth = req.RecipientsPerMonth
+	clientToken.QuotaAttachmentGBPerMonth = req.AttachmentGBPerMonth
+
+	recordAudit(c, h.audit, models.AuditActionTokenQuotas, models.AuditTargetToken, clientToken.ID.String(), before, clientToken.AuditState())
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"message": "Token 已撤銷",
+		"data":    clientToken,
+	})
+}
+
+// GetTokenUsage 查詢管理範圍內 Token 目前週期的用量與配額
+// GET /api/v1/auth/token/:id/usage
+func (h *AuthHandler) GetTokenUsage(c *gin.Context) {
+	clientToken, err := h.departments.GetManagedToken(callerToken(c), h.tokenQuery(c.Param("id")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "not_found",
+			"message": "Token not found",
+		})
+		return
+	}
+
+	usage, err := h.quotas.Usage(clientToken)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "query_error",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    usage,
 	})
 }
 
-// ListTokens 列出所有 Token
+// ListTokens 列出管理範圍內的所有 Token (admin 為全部，部門管理者為該部門)
+// 可用 department_id 查詢參數篩選
 func (h *AuthHandler) ListTokens(c *gin.Context) {
+	query := h.departments.ScopeTokens(callerToken(c), h.db.Model(&models.ClientToken{}))
+	if departmentID := c.Query("department_id"); departmentID != "" {
+		query = query.Where("department_id = ?", departmentID)
+	}
+
 	var tokens []models.ClientToken
-	h.db.Order("created_at DESC").Find(&tokens)
+	query.Order("created_at DESC").Find(&tokens)
 
 	c.JSON(http.StatusOK, gin.H{
 		"total": len(tokens),
@@ -0,0 +1,157 @@
+// internal/api/handlers/department_handler.go
+// 部門 (租戶) 管理 Handler
+
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"mail-proxy/internal/models"
+	"mail-proxy/internal/services"
+)
+
+// DepartmentHandler 部門管理 Handler
+type DepartmentHandler struct {
+	departments *services.DepartmentService
+}
+
+// NewDepartmentHandler 建立部門管理 Handler
+func NewDepartmentHandler(departments *services.DepartmentService) *DepartmentHandler {
+	return &DepartmentHandler{
+		departments: departments,
+	}
+}
+
+// CreateDepartment 建立部門
+// POST /api/v1/auth/department
+func (h *DepartmentHandler) CreateDepartment(c *gin.Context) {
+	var req models.CreateDepartmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "validation_error",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	department, err := h.departments.Create(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "create_error",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    department,
+	})
+}
+
+// ListDepartments 列出所有部門
+// GET /api/v1/auth/departments
+func (h *DepartmentHandler) ListDepartments(c *gin.Context) {
+	departments, err := h.departments.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "list_error",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"total":   len(departments),
+		"data":    departments,
+	})
+}
+
+// GetDepartment 取得部門
+// GET /api/v1/auth/department/:id
+func (h *DepartmentHandler) GetDepartment(c *gin.Context) {
+	id, ok := parseDepartmentID(c)
+	if !ok {
+		return
+	}
+
+	department, err := h.departments.GetByID(id)
+	if err != nil {
+		respondDepartmentError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    department,
+	})
+}
+
+// UpdateDepartment 更新部門
+// PUT /api/v1/auth/department/:id
+func (h *DepartmentHandler) UpdateDepartment(c *gin.Context) {
+	id, ok := parseDepartmentID(c)
+	if !ok {
+		return
+	}
+
+	var req models.UpdateDepartmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "validation_error",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	department, err := h.departments.Update(id, &req)
+	if err != nil {
+		respondDepartmentError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    department,
+	})
+}
+
+// parseDepartmentID 解析路徑中的部門 ID；失敗時已寫入回應
+func parseDepartmentID(c *gin.Context) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "invalid_id",
+			"message": "Invalid department ID",
+		})
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// respondDepartmentError 將部門服務錯誤轉為 HTTP 回應
+func respondDepartmentError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrDepartmentNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "not_found",
+			"message": "Department not found",
+		})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{
+		"success": false,
+		"error":   "update_error",
+		"message": err.Error(),
+	})
+}
@@ -0,0 +1,202 @@
+// internal/api/handlers/dkim_key_handler.go
+// DKIM 金鑰管理 API Handler
+
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"mail-proxy/internal/models"
+	"mail-proxy/internal/services"
+)
+
+// DKIMKeyHandler DKIM 金鑰管理 Handler
+type DKIMKeyHandler struct {
+	dkimService *services.DKIMService
+}
+
+// NewDKIMKeyHandler 建立 DKIM 金鑰 Handler
+func NewDKIMKeyHandler(dkimService *services.DKIMService) *DKIMKeyHandler {
+	return &DKIMKeyHandler{
+		dkimService: dkimService,
+	}
+}
+
+// CreateDKIMKey 產生新的 DKIM 金鑰，回傳需發布的 DNS TXT 記錄
+// POST /api/v1/auth/dkim-key
+func (h *DKIMKeyHandler) CreateDKIMKey(c *gin.Context) {
+	var req models.CreateDKIMKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "validation_error",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	key, err := h.dkimService.GenerateKey(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "create_error",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    key.ToResponse(),
+	})
+}
+
+// ListDKIMKeys 列出所有 DKIM 金鑰
+// GET /api/v1/auth/dkim-keys
+func (h *DKIMKeyHandler) ListDKIMKeys(c *gin.Context) {
+	keys, err := h.dkimService.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "list_error",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	responses := make([]models.DKIMKeyResponse, len(keys))
+	for i := range keys {
+		responses[i] = keys[i].ToResponse()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"total":   len(responses),
+		"data":    responses,
+	})
+}
+
+// GetDKIMKey 查詢單一 DKIM 金鑰 (含 DNS TXT 記錄)
+// GET /api/v1/auth/dkim-key/:id
+func (h *DKIMKeyHandler) GetDKIMKey(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "invalid_id",
+			"message": "Invalid DKIM key ID",
+		})
+		return
+	}
+
+	key, err := h.dkimService.GetByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "not_found",
+			"message": "DKIM key not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    key.ToResponse(),
+	})
+}
+
+// ActivateDKIMKey 啟用 DKIM 金鑰，預設先確認 DNS TXT 記錄已發布
+// POST /api/v1/auth/dkim-key/:id/activate
+func (h *DKIMKeyHandler) ActivateDKIMKey(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "invalid_id",
+			"message": "Invalid DKIM key ID",
+		})
+		return
+	}
+
+	var req models.ActivateDKIMKeyRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   "validation_error",
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+
+	key, err := h.dkimService.Activate(id, !req.SkipDNSCheck)
+	switch {
+	case errors.Is(err, services.ErrDKIMKeyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "not_found",
+			"message": "DKIM key not found",
+		})
+		return
+	case errors.Is(err, services.ErrDKIMDNSRecord):
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"error":   "dns_record_not_published",
+			"message": err.Error(),
+		})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "activate_error",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    key.ToResponse(),
+	})
+}
+
+// DeleteDKIMKey 刪除 DKIM 金鑰
+// DELETE /api/v1/auth/dkim-key/:id
+func (h *DKIMKeyHandler) DeleteDKIMKey(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "invalid_id",
+			"message": "Invalid DKIM key ID",
+		})
+		return
+	}
+
+	if err := h.dkimService.Delete(id); err != nil {
+		if errors.Is(err, services.ErrDKIMKeyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"error":   "not_found",
+				"message": "DKIM key not found",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "delete_error",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "DKIM key 已刪除",
+	})
+}
@@ -0,0 +1,120 @@
+// internal/api/handlers/jwt_key_handler.go
+// JWT 簽章金鑰管理與 JWKS Handler
+
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"mail-proxy/internal/models"
+	"mail-proxy/internal/services"
+)
+
+// JWTKeyHandler JWT 簽章金鑰 Handler
+type JWTKeyHandler struct {
+	jwtKeys *services.JWTKeyService
+}
+
+// NewJWTKeyHandler 建立 JWT 簽章金鑰 Handler
+func NewJWTKeyHandler(jwtKeys *services.JWTKeyService) *JWTKeyHandler {
+	return &JWTKeyHandler{
+		jwtKeys: jwtKeys,
+	}
+}
+
+// CreateJWTKey 產生新的簽章金鑰，之後簽發的 Token 改用此金鑰
+// POST /api/v1/auth/jwt-key
+func (h *JWTKeyHandler) CreateJWTKey(c *gin.Context) {
+	var req models.CreateJWTKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "validation_error",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	key, err := h.jwtKeys.GenerateKey(req.Algorithm)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "create_error",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    key,
+	})
+}
+
+// ListJWTKeys 列出所有簽章金鑰
+// GET /api/v1/auth/jwt-keys
+func (h *JWTKeyHandler) ListJWTKeys(c *gin.Context) {
+	keys, err := h.jwtKeys.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "list_error",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"total":   len(keys),
+		"data":    keys,
+	})
+}
+
+// RetireJWTKey 停用簽章金鑰，以此金鑰簽章的 Token 立即失效
+// DELETE /api/v1/auth/jwt-key/:id
+func (h *JWTKeyHandler) RetireJWTKey(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "invalid_id",
+			"message": "Invalid JWT key ID",
+		})
+		return
+	}
+
+	if err := h.jwtKeys.Retire(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "retire_error",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "JWT signing key 已停用",
+	})
+}
+
+// JWKS 公開的 JSON Web Key Set，供內部服務離線驗證 Token
+// GET /.well-known/jwks.json
+func (h *JWTKeyHandler) JWKS(c *gin.Context) {
+	set, err := h.jwtKeys.JWKS()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "jwks_error",
+			"message": "Failed to load signing keys",
+		})
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, set)
+}
@@ -30,16 +30,26 @@ type MailHandler struct {
 	queueService        *services.QueueService
 	keydbService        *services.KeyDBService
 	senderConfigService *services.EmailSenderConfigService
+	departments         *services.DepartmentService
+	rateLimiter         *services.RateLimiter
+	quotas              *services.QuotaService
+	content             *services.ContentPipeline
+	unsubscribes        *services.UnsubscribeService
 }
 
 // NewMailHandler 建立 Mail Handler
-func NewMailHandler(cfg *config.Config, db *gorm.DB, queueService *services.QueueService, keydbService *services.KeyDBService, senderConfigService *services.EmailSenderConfigService) *MailHandler {
+func NewMailHandler(cfg *config.Config, db *gorm.DB, queueService *services.QueueService, keydbService *services.KeyDBService, senderConfigService *services.EmailSenderConfigService, departments *services.DepartmentService, rateLimiter *services.RateLimiter, quotas *services.QuotaService, unsubscribes *services.UnsubscribeService) *MailHandler {
 	return &MailHandler{
 		cfg:                 cfg,
 		db:                  db,
 		queueService:        queueService,
 		keydbService:        keydbService,
 		senderConfigService: senderConfigService,
+		departments:         departments,
+		rateLimiter:         rateLimiter,
+		quotas:              quotas,
+		content:             services.NewContentPipeline(cfg),
+		unsubscribes:        unsubscribes,
 	}
 }
 
@@ -54,6 +64,92 @@ type SendRequest struct {
 	HTML        string              `json:"html,omitempty"`
 	Attachments []AttachmentRequest `json:"attachments,omitempty"`
 	Metadata    map[string]string   `json:"metadata,omitempty"`
+
+	// 顯示名稱、Reply-To、回覆串接標頭、重要性與自訂標頭 (皆為選填)
+	FromName     string            `json:"from_name,omitempty"`
+	DisplayNames map[string]string `json:"display_names,omitempty"` // 地址 -> 顯示名稱
+	ReplyTo      []string          `json:"reply_to,omitempty" binding:"omitempty,max=10,dive,email"`
+	InReplyTo    string            `json:"in_reply_to,omitempty"`
+	References   []string          `json:"references,omitempty"`
+	Importance   string            `json:"importance,omitempty" binding:"omitempty,oneof=low normal high"`
+	Headers      map[string]string `json:"headers,omitempty"` // 只允許 X- 開頭
+
+	// 會議邀請 (產生 text/calendar; method=REQUEST 或 CANCEL 內容)
+	Calendar *models.CalendarEvent `json:"calendar,omitempty"`
+
+	// 一鍵退訂 (加上 List-Unsubscribe / List-Unsubscribe-Post 標頭，限單一收件者)
+	ListUnsubscribe *ListUnsubscribeRequest `json:"list_unsubscribe,omitempty"`
+}
+
+// ListUnsubscribeRequest 一鍵退訂請求
+type ListUnsubscribeRequest struct {
+	Category string `json:"category,omitempty"` // 退訂類別，空白表示退訂該 Client 的所有郵件
+}
+
+// messageOptions 驗證並取得郵件選項 (未設定任何選項時為 nil)
+func (r *SendRequest) messageOptions() (*models.MessageOptions, error) {
+	options := &models.MessageOptions{
+		FromName:     r.FromName,
+		DisplayNames: r.DisplayNames,
+		ReplyTo:      r.ReplyTo,
+		InReplyTo:    r.InReplyTo,
+		References:   r.References,
+		Importance:   r.Importance,
+		Headers:      r.Headers,
+	}
+	if r.ListUnsubscribe != nil {
+		// 退訂連結綁定單一收件者，群發時需每位收件者各發一封 (可使用批次發送)
+		if len(r.To) != 1 || len(r.CC)+len(r.BCC) != 0 {
+			return nil, fmt.Errorf("list_unsubscribe: requires exactly one to recipient and no cc or bcc")
+		}
+		options.ListUnsubscribe = &models.ListUnsubscribe{Category: r.ListUnsubscribe.Category}
+	}
+	if options.IsZero() {
+		return nil, nil
+	}
+
+	addresses := make([]string, 0, 1+len(r.To)+len(r.CC)+len(r.BCC))
+	addresses = append(addresses, r.From)
+	addresses = append(addresses, r.To...)
+	addresses = append(addresses, r.CC...)
+	addresses = append(addresses, r.BCC...)
+	if err := options.Validate(addresses); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+// prepareContent 驗證內嵌附件並產生會議邀請 (未設定 calendar 時為 nil)
+// 內嵌附件的 content_id 會正規化 (去除角括號)，設定 content_id 即視為內嵌
+func (r *SendRequest) prepareContent(options *models.MessageOptions) (*models.CalendarInvite, error) {
+	seen := make(map[string]bool)
+	for i := range r.Attachments {
+		att := &r.Attachments[i]
+		if att.ContentID == "" {
+			if att.Inline {
+				return nil, fmt.Errorf("attachments: inline attachment %s requires content_id", att.Filename)
+			}
+			continue
+		}
+		contentID, err := models.NormalizeContentID(att.ContentID)
+		if err != nil {
+			return nil, fmt.Errorf("attachments: %s: %w", att.Filename, err)
+		}
+		if seen[strings.ToLower(contentID)] {
+			return nil, fmt.Errorf("attachments: duplicate content_id %s", contentID)
+		}
+		seen[strings.ToLower(contentID)] = true
+		att.ContentID = contentID
+		att.Inline = true
+	}
+	if len(seen) > 0 && r.HTML == "" {
+		return nil, fmt.Errorf("attachments: inline attachments require html")
+	}
+
+	if r.Calendar == nil {
+		return nil, nil
+	}
+	return r.Calendar.Invite(r.Subject, r.From, r.To, r.CC, options, time.Now())
 }
 
 // AttachmentRequest 附件請求
@@ -61,6 +157,27 @@ type AttachmentRequest struct {
 	Filename    string `json:"filename" binding:"required"`
 	Content     string `json:"content" binding:"required"`
 	ContentType string `json:"content_type"`
+	ContentID   string `json:"content_id,omitempty"` // 內嵌附件 (HTML 以 cid:<content_id> 參照)
+	Inline      bool   `json:"inline,omitempty"`
+}
+
+// decodeAttachments 解碼附件並檢查大小 (於計算配額與速率限制前完成驗證)
+// 回傳的 code 為空白表示通過
+func decodeAttachments(attachments []AttachmentRequest, maxSizeMB int) ([][]byte, string, string) {
+	decoded := make([][]byte, 0, len(attachments))
+	for _, att := range attachments {
+		content, err := base64.StdEncoding.DecodeString(att.Content)
+		if err != nil {
+			return nil, "invalid_attachment", fmt.Sprintf("Invalid base64 content for %s", att.Filename)
+		}
+
+		sizeMB := float64(len(content)) / 1024 / 1024
+		if sizeMB > float64(maxSizeMB) {
+			return nil, "attachment_too_large", fmt.Sprintf("%s exceeds maximum size of %dMB", att.Filename, maxSizeMB)
+		}
+		decoded = append(decoded, content)
+	}
+	return decoded, "", ""
 }
 
 // Send 發送單封郵件
@@ -75,6 +192,68 @@ func (h *MailHandler) Send(c *gin.Context) {
 		return
 	}
 
+	options, err := req.messageOptions()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "validation_error",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	calendar, err := req.prepareContent(options)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "validation_error",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// 內容處理 (CSS 內嵌、HTML 消毒、由 HTML 產生純文字)
+	content, err := h.content.Process(req.Body, req.HTML)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "validation_error",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// 檢查 Token 的寄件地址與收件網域限制
+	if code, message := checkAddressPolicy(c, &req); code != "" {
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"error":   code,
+			"message": message,
+		})
+		return
+	}
+
+	// 收件者已退訂或被抑制時拒絕 (不論是否設定 list_unsubscribe)
+	if status, code, message := h.checkSuppressions(&req, c.GetString("client_id")); code != "" {
+		c.JSON(status, gin.H{
+			"success": false,
+			"error":   code,
+			"message": message,
+		})
+		return
+	}
+
+	// 一鍵退訂 (收件者已退訂該類別時拒絕，否則產生簽章退訂連結)
+	mailID := uuid.New()
+	if status, code, message := h.prepareListUnsubscribe(options, mailID, &req, c.GetString("client_id")); code != "" {
+		c.JSON(status, gin.H{
+			"success": false,
+			"error":   code,
+			"message": message,
+		})
+		return
+	}
+
 	// 取得 client 資訊
 	clientID, _ := c.Get("client_id")
 	clientName, _ := c.Get("client_name")
@@ -114,46 +293,53 @@ func (h *MailHandler) Send(c *gin.Context) {
 		senderConfigID = &senderConfig.ID
 	}
 
+	// 解碼並檢查附件
+	decoded, code, message := decodeAttachments(req.Attachments, h.cfg.MaxAttachmentSizeMB)
+	if code != "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   code,
+			"message": message,
+		})
+		return
+	}
+
+	// 用量配額 (請求通過驗證後才計算；先於速率限制檢查，被拒絕的請求不消耗速率額度)
+	if !allowQuota(c, h.quotas, quotaRequest(req)) {
+		return
+	}
+
+	// 速率限制
+	if !allowSend(c, h.rateLimiter, 1, services.CountRecipients(nil, req.To, req.CC, req.BCC)) {
+		return
+	}
+
 	// 建立郵件記錄
 	mail := models.Mail{
-		ID:             uuid.New(),
+		ID:             mailID,
 		FromAddress:    req.From,
 		ToAddresses:    pq.StringArray(req.To),
 		CCAddresses:    pq.StringArray(req.CC),
 		BCCAddresses:   pq.StringArray(req.BCC),
 		Subject:        req.Subject,
-		Body:           req.Body,
-		HTML:           req.HTML,
+		Body:           content.Body,
+		HTML:           content.HTML,
 		Status:         models.MailStatusQueued,
 		ClientID:       clientID.(string),
 		ClientName:     clientName.(string),
 		Metadata:       req.Metadata,
 		SenderConfigID: senderConfigID,
+		Options:        options,
+		Calendar:       calendar,
+
+		OriginalHTML:      content.OriginalHTML,
+		ContentProcessing: content.Processing,
 	}
 
 	// 處理附件
 	var attachments []models.AttachmentInfo
-	for _, att := range req.Attachments {
-		// 檢查附件大小
-		content, err := base64.StdEncoding.DecodeString(att.Content)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"success": false,
-				"error":   "invalid_attachment",
-				"message": fmt.Sprintf("Invalid base64 content for %s", att.Filename),
-			})
-			return
-		}
-
-		sizeMB := float64(len(content)) / 1024 / 1024
-		if sizeMB > float64(h.cfg.MaxAttachmentSizeMB) {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"success": false,
-				"error":   "attachment_too_large",
-				"message": fmt.Sprintf("%s exceeds maximum size of %dMB", att.Filename, h.cfg.MaxAttachmentSizeMB),
-			})
-			return
-		}
+	for i, att := range req.Attachments {
+		content := decoded[i]
 
 		// 儲存附件
 		storagePath := filepath.Join(
@@ -189,6 +375,8 @@ func (h *MailHandler) Send(c *gin.Context) {
 			ContentType: att.ContentType,
 			SizeBytes:   int64(len(content)),
 			StoragePath: storagePath,
+			ContentID:   att.ContentID,
+			Inline:      att.Inline,
 		}
 		mail.Attachments = append(mail.Attachments, attachment)
 
@@ -196,6 +384,8 @@ func (h *MailHandler) Send(c *gin.Context) {
 			Filename:    att.Filename,
 			ContentType: att.ContentType,
 			StoragePath: storagePath,
+			ContentID:   att.ContentID,
+			Inline:      att.Inline,
 		})
 	}
 
@@ -208,6 +398,7 @@ func (h *MailHandler) Send(c *gin.Context) {
 		})
 		return
 	}
+	c.Set("mail_id", mail.ID.String())
 
 	// 建立 RabbitMQ 訊息
 	job := models.MailJob{
@@ -222,6 +413,8 @@ func (h *MailHandler) Send(c *gin.Context) {
 		Attachments:  attachments,
 		Metadata:     req.Metadata,
 		RetryCount:   0,
+		Options:      options,
+		Calendar:     calendar,
 	}
 	if senderConfigID != nil {
 		job.SenderConfigID = senderConfigID.String()
@@ -242,18 +435,22 @@ func (h *MailHandler) Send(c *gin.Context) {
 	// 更新 KeyDB 狀態
 	h.keydbService.SetStatus(c.Request.Context(), mail.ID.String(), "queued", 0, "")
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"success": true,
 		"mail_id": mail.ID.String(),
 		"status":  "queued",
 		"message": "郵件已加入發送隊列",
-	})
+	}
+	if calendar != nil {
+		response["calendar_uid"] = calendar.UID
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 // SendBatch 批次發送郵件
 func (h *MailHandler) SendBatch(c *gin.Context) {
 	var req struct {
-		Mails []SendRequest `json:"mails" binding:"required,min=1"`
+		Mails []SendRequest `json:"mails" binding:"required,min=1,dive"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -269,61 +466,133 @@ func (h *MailHandler) SendBatch(c *gin.Context) {
 	clientID, _ := c.Get("client_id")
 	clientName, _ := c.Get("client_name")
 
-	batchID := uuid.New().String()
-	results := make([]gin.H, 0, len(req.Mails))
+	// 先逐封驗證，未通過的郵件不計入配額與速率限制
+	results := make([]gin.H, len(req.Mails))
+	var accepted []*batchMail
+	for i, mailReq := range req.Mails {
+		prepared, result := h.prepareSingleMail(c, mailReq, clientID.(string), clientName.(string))
+		if result != nil {
+			results[i] = result
+			continue
+		}
+		prepared.index = i
+		accepted = append(accepted, prepared)
+	}
 
-	for _, mailReq := range req.Mails {
-		result := h.processSingleMail(c, mailReq, clientID.(string), clientName.(string))
-		results = append(results, result)
+	// 用量配額與速率限制 (以通過驗證的郵件一起計算，超過時整批拒絕)
+	if len(accepted) > 0 {
+		acceptedReqs := make([]SendRequest, 0, len(accepted))
+		recipients := make(map[string]int)
+		for _, prepared := range accepted {
+			acceptedReqs = append(acceptedReqs, prepared.req)
+			services.CountRecipients(recipients, prepared.req.To, prepared.req.CC, prepared.req.BCC)
+		}
+		if !allowQuota(c, h.quotas, quotaRequest(acceptedReqs...)) {
+			return
+		}
+		if !allowSend(c, h.rateLimiter, len(accepted), recipients) {
+			return
+		}
+	}
+
+	for _, prepared := range accepted {
+		results[prepared.index] = h.queueSingleMail(c, prepared)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success":  true,
-		"batch_id": batchID,
+		"batch_id": uuid.New().String(),
 		"results":  results,
 	})
 }
 
-// processSingleMail 處理單封郵件 (批次發送內部使用)
-func (h *MailHandler) processSingleMail(c *gin.Context, req SendRequest, clientID, clientName string) gin.H {
-	// 建立郵件記錄
-	mail := models.Mail{
-		ID:           uuid.New(),
-		FromAddress:  req.From,
-		ToAddresses:  pq.StringArray(req.To),
-		CCAddresses:  pq.StringArray(req.CC),
-		BCCAddresses: pq.StringArray(req.BCC),
-		Subject:      req.Subject,
-		Body:         req.Body,
-		HTML:         req.HTML,
-		Status:       models.MailStatusQueued,
-		ClientID:     clientID,
-		ClientName:   clientName,
-		Metadata:     req.Metadata,
+// batchMail 通過驗證、待加入隊列的批次郵件
+type batchMail struct {
+	index       int
+	req         SendRequest
+	mail        models.Mail
+	attachments [][]byte
+}
+
+// prepareSingleMail 驗證單封郵件並建立郵件記錄 (批次發送內部使用)
+// 驗證失敗時回傳該封郵件的結果
+func (h *MailHandler) prepareSingleMail(c *gin.Context, req SendRequest, clientID, clientName string) (*batchMail, gin.H) {
+	failed := func(code, message string) gin.H {
+		return gin.H{
+			"mail_id": nil,
+			"status":  "failed",
+			"error":   message,
+			"code":    code,
+		}
+	}
+
+	options, err := req.messageOptions()
+	if err != nil {
+		return nil, failed("validation_error", err.Error())
+	}
+
+	calendar, err := req.prepareContent(options)
+	if err != nil {
+		return nil, failed("validation_error", err.Error())
+	}
+
+	content, err := h.content.Process(req.Body, req.HTML)
+	if err != nil {
+		return nil, failed("validation_error", err.Error())
+	}
+
+	if code, message := checkAddressPolicy(c, &req); code != "" {
+		return nil, failed(code, message)
 	}
 
+	if _, code, message := h.checkSuppressions(&req, clientID); code != "" {
+		return nil, failed(code, message)
+	}
+
+	mailID := uuid.New()
+	if _, code, message := h.prepareListUnsubscribe(options, mailID, &req, clientID); code != "" {
+		return nil, failed(code, message)
+	}
+
+	decoded, code, message := decodeAttachments(req.Attachments, h.cfg.MaxAttachmentSizeMB)
+	if code != "" {
+		return nil, failed(code, message)
+	}
+
+	return &batchMail{
+		req: req,
+		mail: models.Mail{
+			ID:           mailID,
+			FromAddress:  req.From,
+			ToAddresses:  pq.StringArray(req.To),
+			CCAddresses:  pq.StringArray(req.CC),
+			BCCAddresses: pq.StringArray(req.BCC),
+			Subject:      req.Subject,
+			Body:         content.Body,
+			HTML:         content.HTML,
+			Status:       models.MailStatusQueued,
+			ClientID:     clientID,
+			ClientName:   clientName,
+			Metadata:     req.Metadata,
+			Options:      options,
+			Calendar:     calendar,
+
+			OriginalHTML:      content.OriginalHTML,
+			ContentProcessing: content.Processing,
+		},
+		attachments: decoded,
+	}, nil
+}
+
+// queueSingleMail 儲存附件與郵件記錄並加入發送隊列 (批次發送內部使用)
+func (h *MailHandler) queueSingleMail(c *gin.Context, prepared *batchMail) gin.H {
+	req := prepared.req
+	mail := prepared.mail
+
 	// 處理附件
 	var attachments []models.AttachmentInfo
-	for _, att := range req.Attachments {
-		// 解碼 Base64
-		content, err := base64.StdEncoding.DecodeString(att.Content)
-		if err != nil {
-			return gin.H{
-				"mail_id": nil,
-				"status":  "failed",
-				"error":   fmt.Sprintf("Invalid base64 content for %s", att.Filename),
-			}
-		}
-
-		// 檢查大小
-		sizeMB := float64(len(content)) / 1024 / 1024
-		if sizeMB > float64(h.cfg.MaxAttachmentSizeMB) {
-			return gin.H{
-				"mail_id": nil,
-				"status":  "failed",
-				"error":   fmt.Sprintf("%s exceeds maximum size of %dMB", att.Filename, h.cfg.MaxAttachmentSizeMB),
-			}
-		}
+	for i, att := range req.Attachments {
+		content := prepared.attachments[i]
 
 		// 儲存附件
 		storagePath := filepath.Join(
@@ -357,6 +626,8 @@ func (h *MailHandler) processSingleMail(c *gin.Context, req SendRequest, clientI
 			ContentType: att.ContentType,
 			SizeBytes:   int64(len(content)),
 			StoragePath: storagePath,
+			ContentID:   att.ContentID,
+			Inline:      att.Inline,
 		}
 		mail.Attachments = append(mail.Attachments, attachment)
 
@@ -364,6 +635,8 @@ func (h *MailHandler) processSingleMail(c *gin.Context, req SendRequest, clientI
 			Filename:    att.Filename,
 			ContentType: att.ContentType,
 			StoragePath: storagePath,
+			ContentID:   att.ContentID,
+			Inline:      att.Inline,
 		})
 	}
 
@@ -389,6 +662,8 @@ func (h *MailHandler) processSingleMail(c *gin.Context, req SendRequest, clientI
 		Attachments:  attachments,
 		Metadata:     req.Metadata,
 		RetryCount:   0,
+		Options:      mail.Options,
+		Calendar:     mail.Calendar,
 	}
 
 	// 發送到 RabbitMQ
@@ -405,26 +680,24 @@ func (h *MailHandler) processSingleMail(c *gin.Context, req SendRequest, clientI
 	// 更新 KeyDB 狀態
 	h.keydbService.SetStatus(c.Request.Context(), mail.ID.String(), "queued", 0, "")
 
-	return gin.H{
+	result := gin.H{
 		"mail_id": mail.ID.String(),
 		"status":  "queued",
 	}
+	if mail.Calendar != nil {
+		result["calendar_uid"] = mail.Calendar.UID
+	}
+	return result
 }
 
-// GetStatus 查詢郵件狀態
+// GetStatus 查詢郵件狀態 (限自己或管理範圍內 Client 的郵件，其餘一律回應 404)
 func (h *MailHandler) GetStatus(c *gin.Context) {
 	mailID := c.Param("id")
+	c.Set("mail_id", mailID)
 
-	// 先查 KeyDB
-	status, err := h.keydbService.GetStatus(c.Request.Context(), mailID)
-	if err == nil && status != nil {
-		c.JSON(http.StatusOK, status)
-		return
-	}
-
-	// 查資料庫
+	// 先以資料庫確認郵件歸屬 (KeyDB 快取不含 client_id)
 	var mail models.Mail
-	if err := h.db.Where("id = ?", mailID).First(&mail).Error; err != nil {
+	if err := h.db.Where("id = ?", mailID).First(&mail).Error; err != nil || !h.canViewMail(c, &mail) {
 		c.JSON(http.StatusNotFound, gin.H{
 			"success": false,
 			"error":   "not_found",
@@ -433,6 +706,13 @@ func (h *MailHandler) GetStatus(c *gin.Context) {
 		return
 	}
 
+	// KeyDB 有最新狀態時優先回傳
+	status, err := h.keydbService.GetStatus(c.Request.Context(), mailID)
+	if err == nil && status != nil {
+		c.JSON(http.StatusOK, status)
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"mail_id":       mail.ID.String(),
 		"status":        mail.Status,
@@ -443,9 +723,22 @@ func (h *MailHandler) GetStatus(c *gin.Context) {
 	})
 }
 
+// canViewMail 呼叫者是否可查詢該郵件：自己的郵件，或寄件 Client 在呼叫者的管理範圍內 (與 GetHistory 相同)
+func (h *MailHandler) canViewMail(c *gin.Context, mail *models.Mail) bool {
+	if mail.ClientID == c.GetString("client_id") {
+		return true
+	}
+	caller := callerToken(c)
+	if caller == nil {
+		return false
+	}
+	_, err := h.departments.GetManagedToken(caller, h.db.Where("client_id = ?", mail.ClientID))
+	return err == nil
+}
+
 // GetHistory 查詢郵件歷史
 func (h *MailHandler) GetHistory(c *gin.Context) {
-	clientID, _ := c.Get("client_id")
+	clientID := c.GetString("client_id")
 
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
@@ -457,7 +750,38 @@ func (h *MailHandler) GetHistory(c *gin.Context) {
 	var total int64
 	var mails []models.Mail
 
-	query := h.db.Model(&models.Mail{}).Where("client_id = ?", clientID)
+	query := h.db.Model(&models.Mail{})
+
+	// 查詢範圍：預設為自己的郵件；client_id / department_id 需在管理範圍內
+	caller := callerToken(c)
+	switch {
+	case c.Query("client_id") != "" && c.Query("client_id") != clientID:
+		target, err := h.departments.GetManagedToken(caller, h.db.Where("client_id = ?", c.Query("client_id")))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"error":   "client_not_found",
+				"message": "Client not found",
+			})
+			return
+		}
+		query = query.Where("client_id = ?", target.ClientID)
+	case c.Query("department_id") != "":
+		departmentID, err := uuid.Parse(c.Query("department_id"))
+		if err != nil || caller == nil ||
+			(!caller.IsAdmin() && (caller.DepartmentID == nil || *caller.DepartmentID != departmentID)) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"error":   "department_forbidden",
+				"message": "You can only query mails of your own department",
+			})
+			return
+		}
+		query = query.Where("client_id IN (?)",
+			h.db.Model(&models.ClientToken{}).Select("client_id").Where("department_id = ?", departmentID))
+	default:
+		query = query.Where("client_id = ?", clientID)
+	}
 
 	// 狀態過濾
 	if status := c.Query("status"); status != "" {
@@ -475,9 +799,39 @@ func (h *MailHandler) GetHistory(c *gin.Context) {
 	})
 }
 
+// GetUsage 查詢呼叫者目前週期的用量與配額
+// GET /api/v1/mail/usage
+func (h *MailHandler) GetUsage(c *gin.Context) {
+	client := callerToken(c)
+	if client == nil || h.quotas == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"error":   "quota_unavailable",
+			"message": "Quota service not available",
+		})
+		return
+	}
+
+	usage, err := h.quotas.Usage(client)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "query_error",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    usage,
+	})
+}
+
 // Cancel 取消郵件
 func (h *MailHandler) Cancel(c *gin.Context) {
 	mailID := c.Param("id")
+	c.Set("mail_id", mailID)
 	clientID, _ := c.Get("client_id")
 
 	var mail models.Mail
@@ -511,3 +865,28 @@ func (h *MailHandler) Cancel(c *gin.Context) {
 		"message": "郵件已取消",
 	})
 }
+
+// checkAddressPolicy 檢查 Token 的寄件地址與收件網域限制
+// 回傳錯誤代碼與訊息，通過時代碼為空字串
+func checkAddressPolicy(c *gin.Context, req *SendRequest) (string, string) {
+	value, exists := c.Get("client_token")
+	if !exists {
+		return "", ""
+	}
+	clientToken := value.(*models.ClientToken)
+
+	if !clientToken.AllowsFrom(req.From) {
+		return "sender_not_allowed", fmt.Sprintf("Sender '%s' is not allowed for this token", req.From)
+	}
+
+	recipients := make([]string, 0, len(req.To)+len(req.CC)+len(req.BCC))
+	recipients = append(recipients, req.To...)
+	recipients = append(recipients, req.CC...)
+	recipients = append(recipients, req.BCC...)
+	for _, rcpt := range recipients {
+		if !clientToken.AllowsRecipient(rcpt) {
+			return "recipient_not_allowed", fmt.Sprintf("Recipient '%s' is not allowed for this token", rcpt)
+		}
+	}
+	return "", ""
+}
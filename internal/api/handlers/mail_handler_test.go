@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMessageOptionsListUnsubscribeRecipients(t *testing.T) {
+	tests := []struct {
+		name    string
+		to      []string
+		cc      []string
+		bcc     []string
+		wantErr bool
+	}{
+		{name: "single to", to: []string{"a@example.com"}},
+		{name: "two to", to: []string{"a@example.com", "b@example.com"}, wantErr: true},
+		{name: "to and cc", to: []string{"a@example.com"}, cc: []string{"b@example.com"}, wantErr: true},
+		{name: "only cc", cc: []string{"a@example.com"}, wantErr: true},
+		{name: "only bcc", bcc: []string{"a@example.com"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := SendRequest{
+				From:            "sender@example.com",
+				To:              tt.to,
+				CC:              tt.cc,
+				BCC:             tt.bcc,
+				ListUnsubscribe: &ListUnsubscribeRequest{Category: "newsletter"},
+			}
+			options, err := req.messageOptions()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("messageOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (options == nil || options.ListUnsubscribe == nil || options.ListUnsubscribe.Category != "newsletter") {
+				t.Fatalf("messageOptions() = %+v, want list_unsubscribe with category", options)
+			}
+		})
+	}
+}
+
+func TestSendBatchValidatesEachMail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/send/batch", (&MailHandler{}).SendBatch)
+
+	// 第二封缺少 to，只有一個 bcc 並設定 list_unsubscribe：整批應在驗證階段被拒絕
+	body := `{"mails":[
+		{"from":"sender@example.com","to":["a@example.com"],"subject":"ok","body":"hi"},
+		{"from":"sender@example.com","bcc":["b@example.com"],"subject":"bad","body":"hi","list_unsubscribe":{}}
+	]}`
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/send/batch", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d (body %s)", w.Code, http.StatusBadRequest, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "validation_error") {
+		t.Fatalf("body = %s, want validation_error", w.Body.String())
+	}
+}
+
+func TestDecodeAttachments(t *testing.T) {
+	oneMB := base64.StdEncoding.EncodeToString(make([]byte, 1024*1024+1))
+	tests := []struct {
+		name        string
+		attachments []AttachmentRequest
+		wantCode    string
+	}{
+		{name: "none"},
+		{name: "valid", attachments: []AttachmentRequest{{Filename: "a.txt", Content: "aGVsbG8="}}},
+		{name: "invalid base64", attachments: []AttachmentRequest{{Filename: "a.txt", Content: "!!"}}, wantCode: "invalid_attachment"},
+		{name: "too large", attachments: []AttachmentRequest{{Filename: "big.bin", Content: oneMB}}, wantCode: "attachment_too_large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded, code, _ := decodeAttachments(tt.attachments, 1)
+			if code != tt.wantCode {
+				t.Fatalf("decodeAttachments() code = %q, want %q", code, tt.wantCode)
+			}
+			if code == "" && len(decoded) != len(tt.attachments) {
+				t.Fatalf("decodeAttachments() decoded %d, want %d", len(decoded), len(tt.attachments))
+			}
+		})
+	}
+}
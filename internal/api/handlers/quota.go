@@ -0,0 +1,65 @@
+// internal/api/handlers/quota.go
+// 發送 API 的用量配額檢查
+
+package handlers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mail-proxy/internal/services"
+)
+
+// quotaRequest 彙總發送請求的郵件數、收件者數與附件大小 (附件以 base64 長度估算)
+func quotaRequest(reqs ...SendRequest) services.QuotaRequest {
+	usage := services.QuotaRequest{Mails: len(reqs)}
+	for _, req := range reqs {
+		usage.Recipients += len(req.To) + len(req.CC) + len(req.BCC)
+		for _, att := range req.Attachments {
+			usage.AttachmentBytes += int64(base64.StdEncoding.DecodedLen(len(att.Content)))
+		}
+	}
+	return usage
+}
+
+// allowQuota 檢查用量配額，達到警告門檻時設定 X-Quota-Warning 標頭
+// 超過上限時回應 429 quota_exceeded 並回傳 false；無法查詢用量時放行
+func allowQuota(c *gin.Context, quotas *services.QuotaService, req services.QuotaRequest) bool {
+	client := callerToken(c)
+	if quotas == nil || client == nil {
+		return true
+	}
+
+	result, err := quotas.Check(client, req)
+	if err != nil {
+		log.Printf("Quota check failed, allowing request: %v", err)
+		return true
+	}
+
+	for _, w := range result.Warnings {
+		c.Writer.Header().Add("X-Quota-Warning", fmt.Sprintf("%s; used=%d; limit=%d; percent=%.1f", w.Metric, w.Used, w.Limit, w.Percent))
+	}
+
+	if q := result.Exceeded; q != nil {
+		retryAfter := int(math.Max(1, math.Ceil(time.Until(q.PeriodEnd).Seconds())))
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"success":  false,
+			"error":    "quota_exceeded",
+			"message":  fmt.Sprintf("Quota exceeded (%s: %d/%d), resets at %s", q.Metric, q.Used, q.Limit, q.PeriodEnd.Format(time.RFC3339)),
+			"metric":   q.Metric,
+			"used":     q.Used,
+			"limit":    q.Limit,
+			"reset_at": q.PeriodEnd,
+		})
+		return false
+	}
+	return true
+}
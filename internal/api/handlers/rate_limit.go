@@ -0,0 +1,68 @@
+// internal/api/handlers/rate_limit.go
+// 發送 API 的速率限制檢查
+
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"mail-proxy/internal/services"
+)
+
+// allowSend 依部門請求數、Client 郵件數與收件者郵件數檢查速率限制，並設定 X-RateLimit-* 標頭
+// 超過限制時回應 429 並回傳 false；單次用量大於限制本身時回應 413 (重試也無法通過)
+// KeyDB 無法使用時放行
+func allowSend(c *gin.Context, limiter *services.RateLimiter, mails int, recipients map[string]int) bool {
+	client := callerToken(c)
+	if !limiter.Enabled() || client == nil {
+		return true
+	}
+
+	limits := []services.RateLimit{
+		limiter.DepartmentRequestLimit(client),
+		limiter.ClientMailLimit(client, mails),
+	}
+	limits = append(limits, limiter.RecipientLimits(client, recipients)...)
+
+	result, err := limiter.Allow(c.Request.Context(), limits)
+	if err != nil {
+		log.Printf("Rate limit check failed, allowing request: %v", err)
+		return true
+	}
+	if result.Exceeds {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"success": false,
+			"error":   "request_exceeds_limit",
+			"message": fmt.Sprintf("Request exceeds the rate limit itself (%s: %d), split it into smaller requests", result.Scope, result.Limit),
+			"scope":   result.Scope,
+			"limit":   result.Limit,
+		})
+		return false
+	}
+	if result.Limit == 0 {
+		return true
+	}
+
+	c.Header("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Header("X-RateLimit-Reset", strconv.Itoa(int(result.ResetAfter.Seconds()+0.5)))
+	c.Header("X-RateLimit-Scope", result.Scope)
+
+	if !result.Allowed {
+		c.Header("Retry-After", strconv.Itoa(result.RetryAfterSeconds()))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"success":     false,
+			"error":       "rate_limited",
+			"message":     fmt.Sprintf("Rate limit exceeded (%s: %d), retry after %d seconds", result.Scope, result.Limit, result.RetryAfterSeconds()),
+			"scope":       result.Scope,
+			"retry_after": result.RetryAfterSeconds(),
+		})
+		return false
+	}
+	return true
+}
@@ -14,41 +14,25 @@ import (
 )
 
 // SenderConfigHandler Sender Config 管理 Handler
+// 呼叫者只能管理自己或管理範圍內 (同部門) Token 的 sender config
 type SenderConfigHandler struct {
 	senderConfigService *services.EmailSenderConfigService
+	departments         *services.DepartmentService
+	audit               *services.AuditService
 }
 
 // NewSenderConfigHandler 建立 Sender Config Handler
-func NewSenderConfigHandler(senderConfigService *services.EmailSenderConfigService) *SenderConfigHandler {
+func NewSenderConfigHandler(senderConfigService *services.EmailSenderConfigService, departments *services.DepartmentService, audit *services.AuditService) *SenderConfigHandler {
 	return &SenderConfigHandler{
 		senderConfigService: senderConfigService,
+		departments:         departments,
+		audit:               audit,
 	}
 }
 
 // CreateSenderConfig 建立新的 sender config
 // POST /api/v1/auth/sender-config
 func (h *SenderConfigHandler) CreateSenderConfig(c *gin.Context) {
-	// 從 context 取得 client_token_id
-	clientTokenIDStr, exists := c.Get("client_token_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"success": false,
-			"error":   "unauthorized",
-			"message": "Client token ID not found",
-		})
-		return
-	}
-
-	clientTokenID, err := uuid.Parse(clientTokenIDStr.(string))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "invalid_client_token_id",
-			"message": "Invalid client token ID",
-		})
-		return
-	}
-
 	var req models.CreateSenderConfigRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -59,7 +43,13 @@ func (h *SenderConfigHandler) CreateSenderConfig(c *gin.Context) {
 		return
 	}
 
-	config, err := h.senderConfigService.Create(clientTokenID, &req)
+	// 決定擁有者 Token (預設為呼叫者本身)
+	owner, ok := h.resolveOwner(c, req.ClientTokenID)
+	if !ok {
+		return
+	}
+
+	config, err := h.senderConfigService.Create(owner.ID, &req)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
@@ -69,37 +59,41 @@ func (h *SenderConfigHandler) CreateSenderConfig(c *gin.Context) {
 		return
 	}
 
-	// 回傳遮罩後的 response
+	recordAudit(c, h.audit, models.AuditActionSenderConfigCreate, models.AuditTargetSenderConfig, config.ID.String(), nil, config.AuditState())
+
+	// 回傳遮罩後的 response (憑證即將到期時附上警告)
+	resp := config.ToResponse(req.MSClientSecret)
+	resp.Warnings = h.senderConfigService.CertificateWarnings(config)
 	c.JSON(http.StatusCreated, gin.H{
 		"success": true,
-		"data":    config.ToResponse(req.MSClientSecret),
+		"data":    resp,
 	})
 }
 
 // ListSenderConfigs 列出當前 Client 的所有 sender configs
+// 可用 client_token_id 查詢參數列出管理範圍內其他 Token 的配置
 // GET /api/v1/auth/sender-configs
 func (h *SenderConfigHandler) ListSenderConfigs(c *gin.Context) {
-	clientTokenIDStr, exists := c.Get("client_token_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"success": false,
-			"error":   "unauthorized",
-			"message": "Client token ID not found",
-		})
-		return
+	var ownerID *uuid.UUID
+	if idStr := c.Query("client_token_id"); idStr != "" {
+		id, err := uuid.Parse(idStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   "invalid_client_token_id",
+				"message": "Invalid client token ID",
+			})
+			return
+		}
+		ownerID = &id
 	}
 
-	clientTokenID, err := uuid.Parse(clientTokenIDStr.(string))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "invalid_client_token_id",
-			"message": "Invalid client token ID",
-		})
+	owner, ok := h.resolveOwner(c, ownerID)
+	if !ok {
 		return
 	}
 
-	configs, err := h.senderConfigService.ListByClientTokenID(clientTokenID)
+	configs, err := h.senderConfigService.ListByClientTokenID(owner.ID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -137,13 +131,8 @@ func (h *SenderConfigHandler) GetSenderConfig(c *gin.Context) {
 		return
 	}
 
-	config, err := h.senderConfigService.GetByID(id)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"success": false,
-			"error":   "not_found",
-			"message": "Sender config not found",
-		})
+	config, ok := h.getManagedConfig(c, id)
+	if !ok {
 		return
 	}
 
@@ -178,6 +167,11 @@ func (h *SenderConfigHandler) UpdateSenderConfig(c *gin.Context) {
 		return
 	}
 
+	existing, ok := h.getManagedConfig(c, id)
+	if !ok {
+		return
+	}
+
 	config, err := h.senderConfigService.Update(id, &req)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -188,9 +182,13 @@ func (h *SenderConfigHandler) UpdateSenderConfig(c *gin.Context) {
 		return
 	}
 
+	recordAudit(c, h.audit, models.AuditActionSenderConfigUpdate, models.AuditTargetSenderConfig, config.ID.String(), existing.AuditState(), config.AuditState())
+
+	resp := config.ToResponse("")
+	resp.Warnings = h.senderConfigService.CertificateWarnings(config)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"data":    config.ToResponse(""),
+		"data":    resp,
 	})
 }
 
@@ -209,6 +207,11 @@ func (h *SenderConfigHandler) DeleteSenderConfig(c *gin.Context) {
 		return
 	}
 
+	existing, ok := h.getManagedConfig(c, id)
+	if !ok {
+		return
+	}
+
 	if err := h.senderConfigService.Delete(id); err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"success": false,
@@ -218,8 +221,55 @@ func (h *SenderConfigHandler) DeleteSenderConfig(c *gin.Context) {
 		return
 	}
 
+	recordAudit(c, h.audit, models.AuditActionSenderConfigDelete, models.AuditTargetSenderConfig, existing.ID.String(), existing.AuditState(), nil)
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "Sender config 已刪除",
 	})
 }
+
+// resolveOwner 取得 sender config 的擁有者 Token
+// 未指定時為呼叫者本身，指定時需在呼叫者的管理範圍內；失敗時已寫入回應
+func (h *SenderConfigHandler) resolveOwner(c *gin.Context, clientTokenID *uuid.UUID) (*models.ClientToken, bool) {
+	caller := callerToken(c)
+	if caller == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"error":   "unauthorized",
+			"message": "Client token not found",
+		})
+		return nil, false
+	}
+	if clientTokenID == nil || *clientTokenID == caller.ID {
+		return caller, true
+	}
+
+	owner, err := h.departments.GetManagedTokenByID(caller, *clientTokenID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "client_token_not_found",
+			"message": "Client token not found",
+		})
+		return nil, false
+	}
+	return owner, true
+}
+
+// getManagedConfig 取得管理範圍內的 sender config；失敗時已寫入回應
+func (h *SenderConfigHandler) getManagedConfig(c *gin.Context, id uuid.UUID) (*models.EmailSenderConfig, bool) {
+	config, err := h.senderConfigService.GetByID(id)
+	if err == nil {
+		_, err = h.departments.GetManagedTokenByID(callerToken(c), config.ClientTokenID)
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "not_found",
+			"message": "Sender config not found",
+		})
+		return nil, false
+	}
+	return config, true
+}
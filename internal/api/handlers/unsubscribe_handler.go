@@ -0,0 +1,164 @@
+// internal/api/handlers/unsubscribe_handler.go
+// 一鍵退訂 Handler (RFC 8058) 與發送 API 的退訂連結產生
+
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"mail-proxy/internal/models"
+	"mail-proxy/internal/services"
+)
+
+// unsubscribePage 退訂確認與結果頁面 (GET 只顯示確認按鈕，避免連結預覽或安全掃描誤觸退訂)
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html lang="zh-Hant">
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>取消訂閱</title></head>
+<body>
+{{if .Error}}<p>{{.Error}}</p>
+{{else if .Done}}<p>{{.Email}} 已取消訂閱，之後不會再收到此類郵件。</p>
+{{else}}<p>確定要讓 {{.Email}} 取消訂閱此類郵件嗎？</p>
+<form method="post"><input type="hidden" name="List-Unsubscribe" value="One-Click"><button type="submit">取消訂閱</button></form>
+{{end}}
+</body>
+</html>
+`))
+
+// unsubscribePageData 退訂頁面資料
+type unsubscribePageData struct {
+	Email string
+	Done  bool
+	Error string
+}
+
+// UnsubscribeHandler 一鍵退訂 Handler (公開端點，以簽章連結驗證)
+type UnsubscribeHandler struct {
+	unsubscribes *services.UnsubscribeService
+}
+
+// NewUnsubscribeHandler 建立 Unsubscribe Handler
+func NewUnsubscribeHandler(unsubscribes *services.UnsubscribeService) *UnsubscribeHandler {
+	return &UnsubscribeHandler{unsubscribes: unsubscribes}
+}
+
+// Page 顯示退訂確認頁面
+func (h *UnsubscribeHandler) Page(c *gin.Context) {
+	claims, err := h.unsubscribes.Verify(c.Param("token"))
+	if err != nil {
+		renderUnsubscribePage(c, http.StatusBadRequest, unsubscribePageData{Error: "退訂連結無效"})
+		return
+	}
+	renderUnsubscribePage(c, http.StatusOK, unsubscribePageData{Email: claims.Email})
+}
+
+// Unsubscribe 一鍵退訂：將收件者加入抑制清單 (重複退訂同樣回應成功)
+// 郵件服務商以 List-Unsubscribe=One-Click 表單 POST；瀏覽器由確認頁送出時回應 HTML
+func (h *UnsubscribeHandler) Unsubscribe(c *gin.Context) {
+	browser := strings.Contains(c.GetHeader("Accept"), "text/html")
+
+	result, err := h.unsubscribes.Unsubscribe(c.Param("token"), c.ClientIP())
+	if errors.Is(err, services.ErrInvalidUnsubscribeToken) {
+		if browser {
+			renderUnsubscribePage(c, http.StatusBadRequest, unsubscribePageData{Error: "退訂連結無效"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "invalid_unsubscribe_token",
+			"message": "Invalid or tampered unsubscribe link",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to unsubscribe: %v", err)
+		if browser {
+			renderUnsubscribePage(c, http.StatusInternalServerError, unsubscribePageData{Error: "暫時無法處理退訂，請稍後再試"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "database_error",
+			"message": "Failed to unsubscribe",
+		})
+		return
+	}
+
+	if browser {
+		renderUnsubscribePage(c, http.StatusOK, unsubscribePageData{Email: result.Claims.Email, Done: true})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "已取消訂閱",
+	})
+}
+
+// renderUnsubscribePage 輸出退訂頁面
+func renderUnsubscribePage(c *gin.Context, status int, data unsubscribePageData) {
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(status)
+	if err := unsubscribePage.Execute(c.Writer, data); err != nil {
+		log.Printf("Failed to render unsubscribe page: %v", err)
+	}
+}
+
+// checkSuppressions 檢查所有收件者 (To / CC / BCC) 是否已退訂該 Client 的郵件或被全域抑制
+// 不論是否設定 list_unsubscribe 都會檢查；回傳的 code 為空白表示通過
+func (h *MailHandler) checkSuppressions(req *SendRequest, clientID string) (int, string, string) {
+	if h.unsubscribes == nil {
+		return 0, "", ""
+	}
+	recipients := make([]string, 0, len(req.To)+len(req.CC)+len(req.BCC))
+	recipients = append(append(append(recipients, req.To...), req.CC...), req.BCC...)
+
+	suppressed, err := h.unsubscribes.SuppressedRecipients(recipients, clientID)
+	if err != nil {
+		log.Printf("Failed to check suppressions for client %s: %v", clientID, err)
+		return http.StatusInternalServerError, "database_error", "Failed to check suppressions"
+	}
+	if len(suppressed) > 0 {
+		return http.StatusForbidden, "recipient_suppressed", "Recipients have unsubscribed: " + strings.Join(suppressed, ", ")
+	}
+	return 0, "", ""
+}
+
+// prepareListUnsubscribe 檢查一鍵退訂設定與收件者是否已退訂，並產生簽章退訂連結
+// 未設定 list_unsubscribe 時直接通過；回傳的 code 為空白表示通過
+func (h *MailHandler) prepareListUnsubscribe(options *models.MessageOptions, mailID uuid.UUID, req *SendRequest, clientID string) (int, string, string) {
+	if options == nil || options.ListUnsubscribe == nil {
+		return 0, "", ""
+	}
+	if !h.unsubscribes.Enabled() {
+		return http.StatusBadRequest, "unsubscribe_not_configured", "List-Unsubscribe is not configured (UNSUBSCRIBE_BASE_URL / UNSUBSCRIBE_SECRET)"
+	}
+
+	recipient := req.To[0]
+	category := options.ListUnsubscribe.Category
+	unsubscribed, err := h.unsubscribes.IsUnsubscribed(recipient, clientID, category)
+	if err != nil {
+		log.Printf("Failed to check suppressions for %s: %v", recipient, err)
+		return http.StatusInternalServerError, "database_error", "Failed to check suppressions"
+	}
+	if unsubscribed {
+		return http.StatusForbidden, "recipient_suppressed", "Recipient " + recipient + " has unsubscribed"
+	}
+
+	url, err := h.unsubscribes.URL(&services.UnsubscribeClaims{
+		MailID:   mailID.String(),
+		Email:    recipient,
+		ClientID: clientID,
+		Category: category,
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "unsubscribe_error", err.Error()
+	}
+	options.ListUnsubscribe.URL = url
+	return 0, "", ""
+}
@@ -0,0 +1,56 @@
+// internal/api/middlewares/audit.go
+// API 請求日誌中介軟體
+
+package middlewares
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"mail-proxy/internal/models"
+	"mail-proxy/internal/services"
+)
+
+// APIAudit 記錄已認證請求至 api_logs (需註冊在 JWTAuth 之前，才能取得最終狀態碼與耗時)
+// 未通過認證的請求沒有 client_id，不會記錄
+func APIAudit(apiLogs *services.APILogService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		clientID := c.GetString("client_id")
+		if clientID == "" {
+			return
+		}
+
+		endpoint := c.FullPath()
+		if endpoint == "" {
+			endpoint = c.Request.URL.Path
+		}
+
+		entry := &models.APILog{
+			ClientID:       clientID,
+			ClientName:     c.GetString("client_name"),
+			RequestIP:      c.ClientIP(),
+			Endpoint:       endpoint,
+			Method:         c.Request.Method,
+			MailID:         auditMailID(c),
+			StatusCode:     c.Writer.Status(),
+			ResponseTimeMs: int(time.Since(start).Milliseconds()),
+			CreatedAt:      start,
+		}
+		apiLogs.Record(entry)
+	}
+}
+
+// auditMailID 取得郵件 handler 設定於 context 的 mail_id
+func auditMailID(c *gin.Context) *string {
+	mailID := c.GetString("mail_id")
+	if _, err := uuid.Parse(mailID); err != nil {
+		return nil
+	}
+	return &mailID
+}
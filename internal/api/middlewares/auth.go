@@ -4,8 +4,11 @@
 package middlewares
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
@@ -13,10 +16,12 @@ import (
 
 	"mail-proxy/internal/config"
 	"mail-proxy/internal/models"
+	"mail-proxy/internal/services"
 )
 
 // JWTAuth JWT 認證中介軟體
-func JWTAuth(cfg *config.Config, db *gorm.DB) gin.HandlerFunc {
+// 簽章金鑰由 JWTKeyService 依 kid 提供 (HS256 僅在 JWT_ALLOW_HS256 時接受)
+func JWTAuth(cfg *config.Config, db *gorm.DB, jwtKeys *services.JWTKeyService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 取得 Authorization header
 		authHeader := c.GetHeader("Authorization")
@@ -45,13 +50,17 @@ func JWTAuth(cfg *config.Config, db *gorm.DB) gin.HandlerFunc {
 		tokenString := parts[1]
 
 		// 解析 JWT Token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// 確認簽名方法
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrSignatureInvalid
-			}
-			return []byte(cfg.JWTSecret), nil
-		})
+		token, err := jwt.Parse(tokenString, jwtKeys.Keyfunc, jwt.WithValidMethods(jwtKeys.ValidMethods()))
+
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"error":   "token_expired",
+				"message": "Token has expired",
+			})
+			c.Abort()
+			return
+		}
 
 		if err != nil || !token.Valid {
 			c.JSON(http.StatusUnauthorized, gin.H{
@@ -99,11 +108,30 @@ func JWTAuth(cfg *config.Config, db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		// 設定 context
+		// 驗證 Token 為本服務簽發 (比對 TokenHash，輪替寬限期內的舊 Token 亦可)
+		if err := clientToken.VerifyToken(tokenString, time.Now()); err != nil {
+			code := "invalid_token"
+			switch {
+			case errors.Is(err, models.ErrTokenExpired):
+				code = "token_expired"
+			case errors.Is(err, models.ErrTokenRotated):
+				code = "token_rotated"
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"error":   code,
+				"message": err.Error(),
+			})
+			c.Abort()
+			return
+		}
+
+		// 設定 context (權限以資料庫為準，輪替時縮減的權限對寬限期內的舊 Token 同樣生效)
 		c.Set("client_id", clientID)
 		c.Set("client_name", claims["client_name"])
 		c.Set("department", claims["department"])
-		c.Set("permissions", claims["permissions"])
+		c.Set("permissions", []string(clientToken.Permissions))
+		c.Set("client_token", &clientToken)
 		c.Set("client_token_id", clientToken.ID.String())
 
 		c.Next()
@@ -111,6 +139,7 @@ func JWTAuth(cfg *config.Config, db *gorm.DB) gin.HandlerFunc {
 }
 
 // RequirePermission 權限檢查中介軟體
+// permission 為 models.Scope* 之一，具備 admin 權限者一律通過
 func RequirePermission(permission string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		permsInterface, exists := c.Get("permissions")
@@ -138,19 +167,11 @@ func RequirePermission(permission string) gin.HandlerFunc {
 		}
 
 		// 檢查權限
-		hasPermission := false
-		for _, p := range permissions {
-			if p == permission || p == "admin" {
-				hasPermission = true
-				break
-			}
-		}
-
-		if !hasPermission {
+		if !models.HasScope(permissions, permission) {
 			c.JSON(http.StatusForbidden, gin.H{
 				"success": false,
 				"error":   "permission_denied",
-				"message": "You don't have permission to access this resource",
+				"message": fmt.Sprintf("This token requires the %s permission", permission),
 			})
 			c.Abort()
 			return
@@ -10,6 +10,7 @@ import (
 	"mail-proxy/internal/api/handlers"
 	"mail-proxy/internal/api/middlewares"
 	"mail-proxy/internal/config"
+	"mail-proxy/internal/models"
 	"mail-proxy/internal/services"
 	"mail-proxy/pkg/microsoft"
 )
@@ -22,50 +23,109 @@ type Dependencies struct {
 	QueueService        *services.QueueService
 	KeyDBService        *services.KeyDBService
 	SenderConfigService *services.EmailSenderConfigService
+	DKIMService         *services.DKIMService
+	JWTKeyService       *services.JWTKeyService
+	DepartmentService   *services.DepartmentService
+	APILogService       *services.APILogService
+	AuditService        *services.AuditService
+	RateLimiter         *services.RateLimiter
+	QuotaService        *services.QuotaService
+	UnsubscribeService  *services.UnsubscribeService
 }
 
 // RegisterRoutes 註冊所有路由
 func RegisterRoutes(router *gin.Engine, deps *Dependencies) {
 	// 初始化 Handlers
 	healthHandler := handlers.NewHealthHandler(deps.Config, deps.DB, deps.KeyDBService)
-	mailHandler := handlers.NewMailHandler(deps.Config, deps.DB, deps.QueueService, deps.KeyDBService, deps.SenderConfigService)
-	authHandler := handlers.NewAuthHandler(deps.Config, deps.DB)
+	mailHandler := handlers.NewMailHandler(deps.Config, deps.DB, deps.QueueService, deps.KeyDBService, deps.SenderConfigService, deps.DepartmentService, deps.RateLimiter, deps.QuotaService, deps.UnsubscribeService)
+	authHandler := handlers.NewAuthHandler(deps.Config, deps.DB, deps.JWTKeyService, deps.DepartmentService, deps.AuditService, deps.QuotaService)
+	jwtKeyHandler := handlers.NewJWTKeyHandler(deps.JWTKeyService)
+	departmentHandler := handlers.NewDepartmentHandler(deps.DepartmentService)
+	apiLogHandler := handlers.NewAPILogHandler(deps.APILogService)
+	auditHandler := handlers.NewAuditHandler(deps.AuditService)
+	unsubscribeHandler := handlers.NewUnsubscribeHandler(deps.UnsubscribeService)
 
 	// 公開路由
 	router.GET("/health", healthHandler.Health)
+	router.GET("/.well-known/jwks.json", jwtKeyHandler.JWKS)
+
+	// 一鍵退訂 (RFC 8058，以簽章連結驗證，不需認證)
+	router.GET("/unsubscribe/:token", unsubscribeHandler.Page)
+	router.POST("/unsubscribe/:token", unsubscribeHandler.Unsubscribe)
 
 	// API v1 路由群組
 	v1 := router.Group("/api/v1")
 	{
 		// 郵件相關 API (需認證)
 		mail := v1.Group("/mail")
-		mail.Use(middlewares.JWTAuth(deps.Config, deps.DB))
+		mail.Use(middlewares.APIAudit(deps.APILogService), middlewares.JWTAuth(deps.Config, deps.DB, deps.JWTKeyService))
 		{
-			mail.POST("/send", mailHandler.Send)
-			mail.POST("/send/batch", mailHandler.SendBatch)
-			mail.GET("/status/:id", mailHandler.GetStatus)
-			mail.GET("/history", mailHandler.GetHistory)
-			mail.DELETE("/cancel/:id", mailHandler.Cancel)
+			mail.POST("/send", middlewares.RequirePermission(models.ScopeMailSend), mailHandler.Send)
+			mail.POST("/send/batch", middlewares.RequirePermission(models.ScopeMailBatch), mailHandler.SendBatch)
+			mail.GET("/status/:id", middlewares.RequirePermission(models.ScopeMailRead), mailHandler.GetStatus)
+			mail.GET("/history", middlewares.RequirePermission(models.ScopeMailRead), mailHandler.GetHistory)
+			mail.GET("/usage", middlewares.RequirePermission(models.ScopeMailSend), mailHandler.GetUsage)
+			mail.DELETE("/cancel/:id", middlewares.RequirePermission(models.ScopeMailCancel), mailHandler.Cancel)
 		}
 
-		// Token 管理 API (需 admin 權限)
+		// 管理 API (依路由需對應權限，admin 涵蓋全部；非 admin 的管理操作限於所屬部門)
 		auth := v1.Group("/auth")
-		auth.Use(middlewares.JWTAuth(deps.Config, deps.DB))
-		auth.Use(middlewares.RequirePermission("admin"))
+		auth.Use(middlewares.APIAudit(deps.APILogService), middlewares.JWTAuth(deps.Config, deps.DB, deps.JWTKeyService))
 		{
-			auth.POST("/token", authHandler.CreateToken)
-			auth.GET("/token/:id", authHandler.GetToken)
-			auth.DELETE("/token/:id", authHandler.RevokeToken)
-			auth.GET("/tokens", authHandler.ListTokens)
+			// Token 管理 API
+			tokens := auth.Group("", middlewares.RequirePermission(models.ScopeTokensManage))
+			tokens.POST("/token", authHandler.CreateToken)
+			tokens.GET("/token/:id", authHandler.GetToken)
+			tokens.DELETE("/token/:id", authHandler.RevokeToken)
+			tokens.POST("/token/:id/rotate", authHandler.RotateToken)
+			tokens.PUT("/token/:id/rate-limits", middlewares.RequirePermission(models.ScopeAdmin), authHandler.UpdateRateLimits)
+			tokens.PUT("/token/:id/quotas", middlewares.RequirePermission(models.ScopeAdmin), authHandler.UpdateQuotas)
+			tokens.GET("/token/:id/usage", authHandler.GetTokenUsage)
+			tokens.GET("/tokens", authHandler.ListTokens)
+
+			// JWT 簽章金鑰管理 API (停用金鑰會使其簽發的所有 Token 失效，僅限 admin)
+			signingKeys := auth.Group("", middlewares.RequirePermission(models.ScopeAdmin))
+			signingKeys.POST("/jwt-key", jwtKeyHandler.CreateJWTKey)
+			signingKeys.GET("/jwt-keys", jwtKeyHandler.ListJWTKeys)
+			signingKeys.DELETE("/jwt-key/:id", jwtKeyHandler.RetireJWTKey)
+
+			// 部門管理 API (僅限 admin)
+			departments := auth.Group("", middlewares.RequirePermission(models.ScopeAdmin))
+			departments.POST("/department", departmentHandler.CreateDepartment)
+			departments.GET("/departments", departmentHandler.ListDepartments)
+			departments.GET("/department/:id", departmentHandler.GetDepartment)
+			departments.PUT("/department/:id", departmentHandler.UpdateDepartment)
+
+			// API 請求日誌查詢 (僅限 admin)
+			apiLogs := auth.Group("", middlewares.RequirePermission(models.ScopeAdmin))
+			apiLogs.GET("/api-logs", apiLogHandler.ListAPILogs)
+
+			// 管理操作稽核紀錄 (僅限 admin)
+			auditEvents := auth.Group("", middlewares.RequirePermission(models.ScopeAdmin))
+			auditEvents.GET("/audit-events", auditHandler.ListAuditEvents)
+			auditEvents.GET("/audit-events/export", auditHandler.ExportAuditEvents)
+			auditEvents.GET("/audit-events/verify", auditHandler.VerifyAuditChain)
+
+			senderConfigs := auth.Group("", middlewares.RequirePermission(models.ScopeSenderConfigManage))
 
 			// Sender Config 管理 API
 			if deps.SenderConfigService != nil {
-				senderConfigHandler := handlers.NewSenderConfigHandler(deps.SenderConfigService)
-				auth.POST("/sender-config", senderConfigHandler.CreateSenderConfig)
-				auth.GET("/sender-configs", senderConfigHandler.ListSenderConfigs)
-				auth.GET("/sender-config/:id", senderConfigHandler.GetSenderConfig)
-				auth.PUT("/sender-config/:id", senderConfigHandler.UpdateSenderConfig)
-				auth.DELETE("/sender-config/:id", senderConfigHandler.DeleteSenderConfig)
+				senderConfigHandler := handlers.NewSenderConfigHandler(deps.SenderConfigService, deps.DepartmentService, deps.AuditService)
+				senderConfigs.POST("/sender-config", senderConfigHandler.CreateSenderConfig)
+				senderConfigs.GET("/sender-configs", senderConfigHandler.ListSenderConfigs)
+				senderConfigs.GET("/sender-config/:id", senderConfigHandler.GetSenderConfig)
+				senderConfigs.PUT("/sender-config/:id", senderConfigHandler.UpdateSenderConfig)
+				senderConfigs.DELETE("/sender-config/:id", senderConfigHandler.DeleteSenderConfig)
+			}
+
+			// DKIM 金鑰管理 API (金鑰以網域為單位、不屬於特定部門，僅限 admin)
+			if deps.DKIMService != nil {
+				dkimKeyHandler := handlers.NewDKIMKeyHandler(deps.DKIMService)
+				signingKeys.POST("/dkim-key", dkimKeyHandler.CreateDKIMKey)
+				signingKeys.GET("/dkim-keys", dkimKeyHandler.ListDKIMKeys)
+				signingKeys.GET("/dkim-key/:id", dkimKeyHandler.GetDKIMKey)
+				signingKeys.POST("/dkim-key/:id/activate", dkimKeyHandler.ActivateDKIMKey)
+				signingKeys.DELETE("/dkim-key/:id", dkimKeyHandler.DeleteDKIMKey)
 			}
 		}
 	}
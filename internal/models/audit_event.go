@@ -0,0 +1,178 @@
+// internal/models/audit_event.go
+// 管理操作稽核紀錄資料模型
+
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// 稽核動作
+const (
+	AuditActionTokenCreate        = "token.create"
+	AuditActionTokenRevoke        = "token.revoke"
+	AuditActionTokenRotate        = "token.rotate"
+	AuditActionTokenRateLimits    = "token.rate_limits"
+	AuditActionTokenQuotas        = "token.quotas"
+	AuditActionSenderConfigCreate = "sender_config.create"
+	AuditActionSenderConfigUpdate = "sender_config.update"
+	AuditActionSenderConfigDelete = "sender_config.delete"
+	AuditActionUnsubscribe        = "suppression.unsubscribe"
+	AuditActionQuotaWarning       = "quota.warning"
+)
+
+// 稽核目標類型
+const (
+	AuditTargetToken        = "client_token"
+	AuditTargetSenderConfig = "sender_config"
+	AuditTargetSuppression  = "suppression"
+)
+
+// AuditRedacted 機密欄位在稽核紀錄中的替代值
+const AuditRedacted = "[REDACTED]"
+
+// AuditChange 單一欄位的變更前後值
+type AuditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// AuditChanges 欄位名稱 -> 變更內容，以 jsonb 儲存
+type AuditChanges map[string]AuditChange
+
+// Value 實作 driver.Valuer
+func (c AuditChanges) Value() (driver.Value, error) {
+	if c == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan 實作 sql.Scanner
+func (c *AuditChanges) Scan(value interface{}) error {
+	var b []byte
+	switch v := value.(type) {
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	case nil:
+		*c = nil
+		return nil
+	default:
+		return errors.New("unsupported type for AuditChanges")
+	}
+	return json.Unmarshal(b, c)
+}
+
+// AuditEvent 管理操作稽核紀錄 (只能新增)
+// 每筆的 Hash 涵蓋前一筆的 Hash，竄改或刪除任一筆都會使之後的鏈結驗證失敗
+type AuditEvent struct {
+	ID              int64        `json:"id" gorm:"primaryKey;autoIncrement"`
+	ActorClientID   string       `json:"actor_client_id" gorm:"not null"`
+	ActorClientName string       `json:"actor_client_name,omitempty"`
+	ActorTokenID    *uuid.UUID   `json:"actor_token_id,omitempty" gorm:"type:uuid"`
+	Action          string       `json:"action" gorm:"not null"`
+	TargetType      string       `json:"target_type" gorm:"not null"`
+	TargetID        string       `json:"target_id" gorm:"not null"`
+	Changes         AuditChanges `json:"changes" gorm:"type:jsonb;not null"`
+	SourceIP        string       `json:"source_ip,omitempty"`
+	CreatedAt       time.Time    `json:"created_at" gorm:"not null"`
+	PrevHash        string       `json:"prev_hash"`
+	Hash            string       `json:"hash" gorm:"not null"`
+}
+
+// TableName 指定資料表名稱
+func (AuditEvent) TableName() string {
+	return "audit_events"
+}
+
+// ComputeHash 計算紀錄的鏈結 Hash
+// SHA-256(以下欄位依序組成的 JSON 陣列)：prev_hash、created_at (UTC RFC 3339，微秒)、
+// actor_client_id、actor_client_name、actor_token_id、action、target_type、target_id、changes、source_ip
+func (e *AuditEvent) ComputeHash() (string, error) {
+	actorTokenID := ""
+	if e.ActorTokenID != nil {
+		actorTokenID = e.ActorTokenID.String()
+	}
+	changes := e.Changes
+	if changes == nil {
+		changes = AuditChanges{}
+	}
+
+	payload, err := json.Marshal([]interface{}{
+		e.PrevHash,
+		e.CreatedAt.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		e.ActorClientID,
+		e.ActorClientName,
+		actorTokenID,
+		e.Action,
+		e.TargetType,
+		e.TargetID,
+		changes,
+		e.SourceIP,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// AuditEventFilter 稽核紀錄查詢條件
+type AuditEventFilter struct {
+	ActorClientID string     `form:"actor_client_id"`
+	Action        string     `form:"action"`
+	TargetType    string     `form:"target_type"`
+	TargetID      string     `form:"target_id"`
+	From          *time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To            *time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Page          int        `form:"page"`
+	Limit         int        `form:"limit"`
+}
+
+// AuditVerifyResult 稽核鏈結驗證結果
+type AuditVerifyResult struct {
+	Valid       bool   `json:"valid"`
+	Checked     int64  `json:"checked"`
+	BrokenAtID  int64  `json:"broken_at_id,omitempty"` // 第一筆驗證失敗的紀錄
+	BrokenError string `json:"broken_error,omitempty"`
+	LastHash    string `json:"last_hash,omitempty"`
+}
+
+// auditState 將資料模型轉為稽核用的欄位快照
+func auditState(v interface{}) map[string]interface{} {
+	state := map[string]interface{}{}
+	if b, err := json.Marshal(v); err == nil {
+		_ = json.Unmarshal(b, &state)
+	}
+	return state
+}
+
+// AuditState 稽核用的 Token 欄位快照 (含 token_hash，寫入時會遮罩)
+func (t *ClientToken) AuditState() map[string]interface{} {
+	state := auditState(t)
+	state["token_hash"] = t.TokenHash
+	return state
+}
+
+// AuditState 稽核用的 Sender Config 欄位快照 (含加密後的 secret 與私鑰，寫入時會遮罩)
+func (c *EmailSenderConfig) AuditState() map[string]interface{} {
+	state := auditState(c)
+	delete(state, "client_token")
+	state["ms_client_secret"] = c.MSClientSecretEncrypted
+	state["ms_private_key"] = c.MSPrivateKeyEncrypted
+	state["ms_certificate"] = c.MSCertificate
+	return state
+}
@@ -0,0 +1,262 @@
+// internal/models/calendar.go
+// 會議邀請：驗證 calendar 請求並產生 iCalendar (RFC 5545 / iTIP RFC 5546) 內容
+
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// iTIP 方法
+const (
+	CalendarMethodRequest = "REQUEST"
+	CalendarMethodCancel  = "CANCEL"
+)
+
+// 會議邀請欄位長度上限
+const (
+	MaxCalendarUIDLength  = 255
+	MaxCalendarTextLength = 8192
+)
+
+// icsTimeFormat iCalendar UTC 時間格式
+const icsTimeFormat = "20060102T150405Z"
+
+// CalendarEvent 會議邀請請求 (API 請求的 calendar 欄位)
+// 與會者取自郵件收件者：To 為必要與會者、CC 為選擇性與會者，BCC 不列入
+type CalendarEvent struct {
+	Method      string    `json:"method,omitempty"` // REQUEST (預設) / CANCEL
+	UID         string    `json:"uid,omitempty"`    // 未指定時自動產生，更新或取消同一會議時需沿用
+	Sequence    int       `json:"sequence,omitempty"`
+	Summary     string    `json:"summary,omitempty"` // 未指定時使用郵件主旨
+	Description string    `json:"description,omitempty"`
+	Location    string    `json:"location,omitempty"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Organizer   string    `json:"organizer,omitempty"` // 預設為寄件者
+}
+
+// CalendarInvite 已產生的會議邀請 (隨 MailJob 傳給發送服務並以 jsonb 儲存於 mails)
+type CalendarInvite struct {
+	Method  string `json:"method"`
+	UID     string `json:"uid"`
+	Content string `json:"content"` // text/calendar 內容 (CRLF 換行)
+}
+
+// Invite 驗證會議邀請並產生 iCalendar 內容
+// stamp 為 DTSTAMP (建立時間)，重試時沿用同一份內容
+func (e *CalendarEvent) Invite(subject, from string, to, cc []string, options *MessageOptions, stamp time.Time) (*CalendarInvite, error) {
+	method := strings.ToUpper(strings.TrimSpace(e.Method))
+	switch method {
+	case "":
+		method = CalendarMethodRequest
+	case CalendarMethodRequest, CalendarMethodCancel:
+	default:
+		return nil, fmt.Errorf("calendar.method: must be REQUEST or CANCEL")
+	}
+
+	uid := strings.TrimSpace(e.UID)
+	if uid == "" {
+		uid = uuid.New().String() + "@" + addressDomain(from)
+	}
+	if len(uid) > MaxCalendarUIDLength {
+		return nil, fmt.Errorf("calendar.uid: exceeds %d characters", MaxCalendarUIDLength)
+	}
+	for _, r := range uid {
+		if r < 0x21 || r > 0x7e {
+			return nil, fmt.Errorf("calendar.uid: must be printable ASCII without spaces")
+		}
+	}
+
+	if e.Sequence < 0 {
+		return nil, fmt.Errorf("calendar.sequence: must not be negative")
+	}
+	if e.Start.IsZero() || e.End.IsZero() {
+		return nil, fmt.Errorf("calendar.start and calendar.end are required")
+	}
+	if !e.End.After(e.Start) {
+		return nil, fmt.Errorf("calendar.end must be after calendar.start")
+	}
+
+	summary := e.Summary
+	if summary == "" {
+		summary = subject
+	}
+	for field, value := range map[string]string{"summary": summary, "description": e.Description, "location": e.Location} {
+		if len(value) > MaxCalendarTextLength {
+			return nil, fmt.Errorf("calendar.%s: exceeds %d characters", field, MaxCalendarTextLength)
+		}
+		if !utf8.ValidString(value) {
+			return nil, fmt.Errorf("calendar.%s: must be valid UTF-8", field)
+		}
+	}
+
+	organizer := from
+	if e.Organizer != "" {
+		parsed, err := mail.ParseAddress(e.Organizer)
+		if err != nil || parsed.Name != "" || parsed.Address != e.Organizer {
+			return nil, fmt.Errorf("calendar.organizer: invalid address %q", e.Organizer)
+		}
+		organizer = parsed.Address
+	}
+	organizerName := options.DisplayName(organizer)
+	if organizerName == "" && strings.EqualFold(organizer, from) && options != nil {
+		organizerName = options.FromName
+	}
+
+	status := "CONFIRMED"
+	if method == CalendarMethodCancel {
+		status = "CANCELLED"
+	}
+
+	var b icsBuilder
+	b.line("BEGIN:VCALENDAR")
+	b.line("PRODID:-//mail-proxy//Calendar Invite//EN")
+	b.line("VERSION:2.0")
+	b.line("CALSCALE:GREGORIAN")
+	b.line("METHOD:" + method)
+	b.line("BEGIN:VEVENT")
+	b.line("UID:" + uid)
+	b.line("DTSTAMP:" + stamp.UTC().Format(icsTimeFormat))
+	b.line(fmt.Sprintf("SEQUENCE:%d", e.Sequence))
+	b.line("DTSTART:" + e.Start.UTC().Format(icsTimeFormat))
+	b.line("DTEND:" + e.End.UTC().Format(icsTimeFormat))
+	b.line("SUMMARY:" + icsEscape(summary))
+	if e.Description != "" {
+		b.line("DESCRIPTION:" + icsEscape(e.Description))
+	}
+	if e.Location != "" {
+		b.line("LOCATION:" + icsEscape(e.Location))
+	}
+	b.line("ORGANIZER" + icsCommonName(organizerName) + ":mailto:" + organizer)
+	for _, attendee := range calendarAttendees(to, cc) {
+		b.line(fmt.Sprintf("ATTENDEE%s;ROLE=%s;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:mailto:%s",
+			icsCommonName(options.DisplayName(attendee.address)), attendee.role, attendee.address))
+	}
+	b.line("STATUS:" + status)
+	b.line("TRANSP:OPAQUE")
+	b.line("END:VEVENT")
+	b.line("END:VCALENDAR")
+
+	return &CalendarInvite{Method: method, UID: uid, Content: b.String()}, nil
+}
+
+// calendarAttendee 與會者與角色
+type calendarAttendee struct {
+	address string
+	role    string
+}
+
+// calendarAttendees To 為 REQ-PARTICIPANT、CC 為 OPT-PARTICIPANT (去除重複)
+func calendarAttendees(to, cc []string) []calendarAttendee {
+	seen := make(map[string]bool, len(to)+len(cc))
+	var attendees []calendarAttendee
+	for _, group := range []struct {
+		addresses []string
+		role      string
+	}{{to, "REQ-PARTICIPANT"}, {cc, "OPT-PARTICIPANT"}} {
+		for _, addr := range group.addresses {
+			key := strings.ToLower(addr)
+			if addr == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			attendees = append(attendees, calendarAttendee{address: addr, role: group.role})
+		}
+	}
+	return attendees
+}
+
+// icsBuilder 以 CRLF 換行並依 RFC 5545 3.1 於 75 octets 折行
+type icsBuilder struct {
+	strings.Builder
+}
+
+// line 寫入單一內容行 (不切斷 UTF-8 字元)
+func (b *icsBuilder) line(s string) {
+	const limit = 75
+	width := 0
+	for _, r := range s {
+		n := utf8.RuneLen(r)
+		if width+n > limit {
+			b.WriteString("\r\n ")
+			width = 1
+		}
+		b.WriteRune(r)
+		width += n
+	}
+	b.WriteString("\r\n")
+}
+
+// icsEscape 跳脫 TEXT 值 (反斜線、分號、逗號與換行)，並移除其他控制字元
+func icsEscape(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == ';' || r == ',':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString("\\n")
+		case r == '\t':
+			b.WriteRune(' ')
+		case r < 0x20 || r == 0x7f:
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// icsCommonName CN 參數 (以雙引號包住，值內不可含雙引號)
+func icsCommonName(name string) string {
+	name = strings.ReplaceAll(name, `"`, "")
+	if name == "" {
+		return ""
+	}
+	return `;CN="` + name + `"`
+}
+
+// addressDomain 取得地址的網域 (用於產生 UID)
+func addressDomain(addr string) string {
+	if i := strings.LastIndex(addr, "@"); i >= 0 {
+		return addr[i+1:]
+	}
+	return "mail-proxy"
+}
+
+// Value 實作 driver.Valuer
+func (i CalendarInvite) Value() (driver.Value, error) {
+	b, err := json.Marshal(i)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan 實作 sql.Scanner
+func (i *CalendarInvite) Scan(value interface{}) error {
+	var b []byte
+	switch v := value.(type) {
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	case nil:
+		*i = CalendarInvite{}
+		return nil
+	default:
+		return errors.New("unsupported type for CalendarInvite")
+	}
+	return json.Unmarshal(b, i)
+}
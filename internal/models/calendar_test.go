@@ -0,0 +1,205 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// unfoldICS 還原折行並拆成內容行
+func unfoldICS(content string) []string {
+	return strings.Split(strings.TrimSuffix(strings.ReplaceAll(content, "\r\n ", ""), "\r\n"), "\r\n")
+}
+
+// icsProperty 取得第一個以 name 開頭的內容行
+func icsProperty(lines []string, name string) string {
+	for _, line := range lines {
+		if strings.HasPrefix(line, name) {
+			return line
+		}
+	}
+	return ""
+}
+
+func TestCalendarInvite(t *testing.T) {
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.FixedZone("CST", 8*3600))
+	stamp := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	event := &CalendarEvent{
+		Start:       start,
+		End:         start.Add(time.Hour),
+		Description: "Line 1\r\nLine 2; a, b \\ c",
+		Location:    "Room 1",
+	}
+	options := &MessageOptions{
+		FromName:     `Organizer "Boss"`,
+		DisplayNames: map[string]string{"a@example.com": "Alice"},
+	}
+
+	invite, err := event.Invite("Weekly sync", "boss@example.com", []string{"a@example.com", "A@example.com"}, []string{"b@example.com", "a@example.com"}, options, stamp)
+	if err != nil {
+		t.Fatalf("Invite() error = %v", err)
+	}
+	if invite.Method != CalendarMethodRequest {
+		t.Errorf("Method = %q, want %q", invite.Method, CalendarMethodRequest)
+	}
+	if !strings.HasSuffix(invite.UID, "@example.com") {
+		t.Errorf("UID = %q, want generated UID at the sender domain", invite.UID)
+	}
+
+	lines := unfoldICS(invite.Content)
+	for _, want := range []string{
+		"BEGIN:VCALENDAR",
+		"METHOD:REQUEST",
+		"UID:" + invite.UID,
+		"DTSTAMP:20260201T000000Z",
+		"SEQUENCE:0",
+		"DTSTART:20260302T010000Z",
+		"DTEND:20260302T020000Z",
+		"SUMMARY:Weekly sync",
+		`DESCRIPTION:Line 1\nLine 2\; a\, b \\ c`,
+		"LOCATION:Room 1",
+		`ORGANIZER;CN="Organizer Boss":mailto:boss@example.com`,
+		"STATUS:CONFIRMED",
+		"END:VCALENDAR",
+	} {
+		if icsProperty(lines, want) != want {
+			t.Errorf("content missing line %q:\n%s", want, invite.Content)
+		}
+	}
+
+	// To 為必要與會者、CC 為選擇性與會者，重複地址只列一次
+	var attendees []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "ATTENDEE") {
+			attendees = append(attendees, line)
+		}
+	}
+	wantAttendees := []string{
+		`ATTENDEE;CN="Alice";ROLE=REQ-PARTICIPANT;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:mailto:a@example.com`,
+		`ATTENDEE;ROLE=OPT-PARTICIPANT;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:mailto:b@example.com`,
+	}
+	if strings.Join(attendees, "\n") != strings.Join(wantAttendees, "\n") {
+		t.Errorf("attendees = %q, want %q", attendees, wantAttendees)
+	}
+}
+
+func TestCalendarInviteCancel(t *testing.T) {
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	event := &CalendarEvent{Method: "cancel", UID: "meeting-1@example.com", Sequence: 2, Summary: "Sync", Start: start, End: start.Add(time.Hour), Organizer: "owner@example.com"}
+
+	invite, err := event.Invite("ignored", "boss@example.com", []string{"a@example.com"}, nil, nil, start)
+	if err != nil {
+		t.Fatalf("Invite() error = %v", err)
+	}
+	lines := unfoldICS(invite.Content)
+	for _, want := range []string{"METHOD:CANCEL", "UID:meeting-1@example.com", "SEQUENCE:2", "SUMMARY:Sync", "ORGANIZER:mailto:owner@example.com", "STATUS:CANCELLED"} {
+		if icsProperty(lines, want) != want {
+			t.Errorf("content missing line %q:\n%s", want, invite.Content)
+		}
+	}
+	if invite.Method != CalendarMethodCancel || invite.UID != "meeting-1@example.com" {
+		t.Errorf("Invite() = %+v", invite)
+	}
+}
+
+func TestCalendarInviteFolding(t *testing.T) {
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	summary := strings.Repeat("會議", 40) // 每字 3 octets，需折行
+	event := &CalendarEvent{Summary: summary, Start: start, End: start.Add(time.Hour)}
+
+	invite, err := event.Invite("", "boss@example.com", []string{"a@example.com"}, nil, nil, start)
+	if err != nil {
+		t.Fatalf("Invite() error = %v", err)
+	}
+	if !strings.HasSuffix(invite.Content, "\r\n") {
+		t.Error("content must end with CRLF")
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(invite.Content, "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line exceeds 75 octets (%d): %q", len(line), line)
+		}
+		if !utf8.ValidString(line) {
+			t.Errorf("folding split a UTF-8 character: %q", line)
+		}
+	}
+	if got := icsProperty(unfoldICS(invite.Content), "SUMMARY:"); got != "SUMMARY:"+summary {
+		t.Errorf("unfolded SUMMARY = %q", got)
+	}
+}
+
+func TestCalendarInviteRejects(t *testing.T) {
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		event   CalendarEvent
+		wantErr string
+	}{
+		{name: "method", event: CalendarEvent{Method: "PUBLISH", Start: start, End: start.Add(time.Hour)}, wantErr: "calendar.method"},
+		{name: "uid with space", event: CalendarEvent{UID: "a b", Start: start, End: start.Add(time.Hour)}, wantErr: "calendar.uid"},
+		{name: "uid with CRLF", event: CalendarEvent{UID: "a\r\nATTENDEE:mailto:x@evil.test", Start: start, End: start.Add(time.Hour)}, wantErr: "calendar.uid"},
+		{name: "uid too long", event: CalendarEvent{UID: strings.Repeat("a", MaxCalendarUIDLength+1), Start: start, End: start.Add(time.Hour)}, wantErr: "calendar.uid"},
+		{name: "negative sequence", event: CalendarEvent{Sequence: -1, Start: start, End: start.Add(time.Hour)}, wantErr: "calendar.sequence"},
+		{name: "missing start", event: CalendarEvent{End: start}, wantErr: "required"},
+		{name: "end before start", event: CalendarEvent{Start: start, End: start}, wantErr: "after"},
+		{name: "organizer with name", event: CalendarEvent{Organizer: "Boss <boss@example.com>", Start: start, End: start.Add(time.Hour)}, wantErr: "calendar.organizer"},
+		{name: "invalid UTF-8", event: CalendarEvent{Location: "\xff", Start: start, End: start.Add(time.Hour)}, wantErr: "calendar.location"},
+		{name: "description too long", event: CalendarEvent{Description: strings.Repeat("a", MaxCalendarTextLength+1), Start: start, End: start.Add(time.Hour)}, wantErr: "calendar.description"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.event.Invite("Subject", "boss@example.com", []string{"a@example.com"}, nil, nil, start)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Invite() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestICSEscape(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "plain", want: "plain"},
+		{in: `a\b;c,d`, want: `a\\b\;c\,d`},
+		{in: "line1\r\nline2\nline3", want: `line1\nline2\nline3`},
+		{in: "tab\there", want: "tab here"},
+		{in: "bell\x07\rDEL\x7f", want: "bellDEL"},
+	}
+	for _, tt := range tests {
+		if got := icsEscape(tt.in); got != tt.want {
+			t.Errorf("icsEscape(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeContentID(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "logo", want: "logo"},
+		{in: "<logo@example.com>", want: "logo@example.com"},
+		{in: "cid:logo.png", want: "logo.png"},
+		{in: " CID:<logo> ", want: "logo"},
+		{in: "", wantErr: true},
+		{in: "<>", wantErr: true},
+		{in: "logo image", wantErr: true},
+		{in: "logo\r\nX-Injected: 1", wantErr: true},
+		{in: "a@b@c", wantErr: true},
+		{in: "logo\"", wantErr: true},
+		{in: strings.Repeat("a", MaxContentIDLength+1), wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeContentID(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NormalizeContentID(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("NormalizeContentID(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -4,6 +4,11 @@
 package models
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -17,10 +22,31 @@ type ClientToken struct {
 	ClientName  string         `json:"client_name" gorm:"not null"`
 	Department  string         `json:"department,omitempty"`
 	Permissions pq.StringArray `json:"permissions" gorm:"type:text[];not null"`
-	TokenHash   string         `json:"-" gorm:"not null"`
-	CreatedAt   time.Time      `json:"created_at" gorm:"autoCreateTime"`
-	RevokedAt   *time.Time     `json:"revoked_at,omitempty"`
-	IsActive    bool           `json:"is_active" gorm:"default:true"`
+	// DepartmentID 所屬部門 (Department 為其名稱；空白表示不屬於任何部門)
+	DepartmentID *uuid.UUID `json:"department_id,omitempty" gorm:"type:uuid"`
+	// AllowedFromAddresses 允許的寄件地址或網域 (如 noreply@example.com、@example.com；空白表示不限制)
+	AllowedFromAddresses pq.StringArray `json:"allowed_from_addresses,omitempty" gorm:"type:text[]"`
+	// AllowedRecipientDomains 允許的收件網域 (空白表示不限制)
+	AllowedRecipientDomains pq.StringArray `json:"allowed_recipient_domains,omitempty" gorm:"type:text[]"`
+	TokenHash               string         `json:"-" gorm:"not null"`
+	// PreviousTokenHash 輪替前的 Token hash，在 PreviousTokenExpiresAt 之前仍可使用
+	PreviousTokenHash      string     `json:"-"`
+	PreviousTokenExpiresAt *time.Time `json:"previous_token_expires_at,omitempty"`
+	ExpiresAt              *time.Time `json:"expires_at,omitempty"` // 空白表示永久有效
+	RotatedAt              *time.Time `json:"rotated_at,omitempty"`
+	SigningKID             string     `json:"signing_kid,omitempty" gorm:"column:signing_kid"` // 目前 Token 的簽章金鑰 kid (HS256 為空白)
+	CreatedAt              time.Time  `json:"created_at" gorm:"autoCreateTime"`
+	RevokedAt              *time.Time `json:"revoked_at,omitempty"`
+	IsActive               bool       `json:"is_active" gorm:"default:true"`
+
+	// 速率限制覆寫 (nil 使用全域設定，0 表示不限制)
+	RateLimitMailsPerHour     *int `json:"rate_limit_mails_per_hour,omitempty"`
+	RateLimitRecipientPerHour *int `json:"rate_limit_recipient_per_hour,omitempty"`
+
+	// 用量配額 (nil 使用全域設定，0 表示不限制)
+	QuotaMailsPerDay          *int     `json:"quota_mails_per_day,omitempty"`
+	QuotaRecipientsPerMonth   *int     `json:"quota_recipients_per_month,omitempty"`
+	QuotaAttachmentGBPerMonth *float64 `json:"quota_attachment_gb_per_month,omitempty" gorm:"column:quota_attachment_gb_per_month"`
 }
 
 // TableName 指定資料表名稱
@@ -28,6 +54,167 @@ func (ClientToken) TableName() string {
 	return "client_tokens"
 }
 
+// Token 驗證錯誤
+var (
+	ErrTokenExpired  = errors.New("token has expired")
+	ErrTokenRotated  = errors.New("token has been rotated and its grace period has ended")
+	ErrTokenMismatch = errors.New("token was not issued by this service")
+)
+
+// IsAdmin 是否具備全域 admin 權限
+func (t *ClientToken) IsAdmin() bool {
+	return HasScope(t.Permissions, ScopeAdmin)
+}
+
+// CanManage 檢查是否可管理目標 Token (及其 Sender Config 與郵件)
+// admin 可管理全部；其他 Token 可管理自己，以及綁定部門時同部門、權限與寄件 / 收件限制不超過自己的 Token
+func (t *ClientToken) CanManage(target *ClientToken) bool {
+	if t.IsAdmin() || t.ID == target.ID {
+		return true
+	}
+	if t.DepartmentID == nil || target.DepartmentID == nil || *t.DepartmentID != *target.DepartmentID {
+		return false
+	}
+	// 避免透過輪替取得權限更高的 Token
+	for _, scope := range target.Permissions {
+		if !HasScope(t.Permissions, NormalizeScope(scope)) {
+			return false
+		}
+	}
+	return t.CoversRestrictions(target)
+}
+
+// CoversRestrictions 檢查 target 的寄件地址與收件網域限制是否在 t 的限制範圍內
+// t 有限制時 target 也必須設定限制 (空白表示不限制)，且每條規則都被 t 的規則涵蓋
+func (t *ClientToken) CoversRestrictions(target *ClientToken) bool {
+	return addressRulesCovered(t.AllowedFromAddresses, target.AllowedFromAddresses) &&
+		addressRulesCovered(t.AllowedRecipientDomains, target.AllowedRecipientDomains)
+}
+
+// CapExpiry 將到期時間限制在 t 的到期時間之前 (t 永久有效時原樣回傳)
+func (t *ClientToken) CapExpiry(expiresAt *time.Time) *time.Time {
+	if t.ExpiresAt == nil || (expiresAt != nil && !expiresAt.After(*t.ExpiresAt)) {
+		return expiresAt
+	}
+	capped := *t.ExpiresAt
+	return &capped
+}
+
+// AllowsFrom 檢查寄件地址是否在 AllowedFromAddresses 內
+func (t *ClientToken) AllowsFrom(address string) bool {
+	return matchesAddressRules(t.AllowedFromAddresses, address)
+}
+
+// AllowsRecipient 檢查收件地址是否在 AllowedRecipientDomains 內
+func (t *ClientToken) AllowsRecipient(address string) bool {
+	return matchesAddressRules(t.AllowedRecipientDomains, address)
+}
+
+// addressRulesCovered 檢查 rules 的每條規則是否都被 parent 涵蓋 (parent 空白表示不限制)
+// 完整地址須符合 parent 的規則；網域規則須與 parent 的網域規則相同
+func addressRulesCovered(parent, rules []string) bool {
+	parentRules := normalizeAddressRules(parent)
+	if len(parentRules) == 0 {
+		return true
+	}
+	normalized := normalizeAddressRules(rules)
+	if len(normalized) == 0 {
+		return false
+	}
+	for _, rule := range normalized {
+		if strings.HasPrefix(rule, "@") {
+			if !containsString(parentRules, rule) {
+				return false
+			}
+		} else if !matchesAddressRules(parent, rule) {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeAddressRules 將規則轉為小寫，網域規則統一為 @example.com 格式並移除空白規則
+func normalizeAddressRules(rules []string) []string {
+	var normalized []string
+	for _, rule := range rules {
+		rule = strings.ToLower(strings.TrimSpace(rule))
+		switch {
+		case rule == "":
+			continue
+		case !strings.Contains(rule, "@"):
+			rule = "@" + rule
+		}
+		normalized = append(normalized, rule)
+	}
+	return normalized
+}
+
+// containsString 檢查字串是否在清單內
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAddressRules 比對地址規則 (空白表示不限制)
+// 規則可為完整地址 (user@example.com)、@example.com 或 example.com
+func matchesAddressRules(rules []string, address string) bool {
+	if len(rules) == 0 {
+		return true
+	}
+	address = strings.ToLower(strings.TrimSpace(address))
+	for _, rule := range rules {
+		rule = strings.ToLower(strings.TrimSpace(rule))
+		switch {
+		case rule == "":
+			continue
+		case strings.HasPrefix(rule, "@"):
+			if strings.HasSuffix(address, rule) {
+				return true
+			}
+		case strings.Contains(rule, "@"):
+			if address == rule {
+				return true
+			}
+		default:
+			if strings.HasSuffix(address, "@"+rule) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HashToken 計算 Token 的 SHA-256 hex，與 TokenHash 欄位比對
+func HashToken(tokenString string) string {
+	hash := sha256.Sum256([]byte(tokenString))
+	return hex.EncodeToString(hash[:])
+}
+
+// VerifyToken 確認 Token 為本服務簽發的目前 Token，或仍在輪替寬限期內的前一個 Token
+func (t *ClientToken) VerifyToken(tokenString string, now time.Time) error {
+	hash := HashToken(tokenString)
+
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(t.TokenHash)) == 1 {
+		if t.ExpiresAt != nil && !now.Before(*t.ExpiresAt) {
+			return ErrTokenExpired
+		}
+		return nil
+	}
+
+	if t.PreviousTokenHash != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(t.PreviousTokenHash)) == 1 {
+		if t.PreviousTokenExpiresAt == nil || !now.Before(*t.PreviousTokenExpiresAt) {
+			return ErrTokenRotated
+		}
+		return nil
+	}
+
+	return ErrTokenMismatch
+}
+
 // APILog API 請求日誌
 type APILog struct {
 	ID             int64     `json:"id" gorm:"primaryKey;autoIncrement"`
@@ -47,6 +234,19 @@ func (APILog) TableName() string {
 	return "api_logs"
 }
 
+// APILogFilter API 請求日誌查詢條件
+type APILogFilter struct {
+	ClientID   string     `form:"client_id"`
+	Endpoint   string     `form:"endpoint"` // 路由前綴 (如 /api/v1/mail)
+	Method     string     `form:"method"`
+	StatusCode int        `form:"status_code"`
+	MailID     string     `form:"mail_id" binding:"omitempty,uuid"`
+	From       *time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To         *time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Page       int        `form:"page"`
+	Limit      int        `form:"limit"`
+}
+
 // JWTClaims JWT Token Claims
 type JWTClaims struct {
 	Issuer      string   `json:"iss"`
@@ -61,13 +261,58 @@ type JWTClaims struct {
 // CreateTokenRequest 建立 Token 請求
 type CreateTokenRequest struct {
 	ClientName  string   `json:"client_name" binding:"required"`
-	Department  string   `json:"department"`
+	Department  string   `json:"department"` // 部門名稱 (可改用 department_id)
 	Permissions []string `json:"permissions" binding:"required,min=1"`
+
+	// DepartmentID 所屬部門；非 admin 呼叫者只能建立自己部門的 Token
+	DepartmentID *uuid.UUID `json:"department_id"`
+
+	// AllowedFromAddresses 允許的寄件地址或網域 (API 與 SMTP 接收皆套用)
+	AllowedFromAddresses []string `json:"allowed_from_addresses"`
+	// AllowedRecipientDomains 允許的收件網域 (API 與 SMTP 接收皆套用)
+	AllowedRecipientDomains []string `json:"allowed_recipient_domains"`
+
+	// ExpiresInDays Token 有效天數 (0 或未填表示永久有效)
+	ExpiresInDays int `json:"expires_in_days" binding:"omitempty,min=1"`
 }
 
 // CreateTokenResponse 建立 Token 回應
 type CreateTokenResponse struct {
-	Token     string    `json:"token"`
-	ClientID  string    `json:"client_id"`
-	CreatedAt time.Time `json:"created_at"`
+	Token     string     `json:"token"`
+	ClientID  string     `json:"client_id"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// RotateTokenRequest 輪替 Token 請求 (所有欄位皆可選)
+type RotateTokenRequest struct {
+	// GracePeriodHours 舊 Token 的寬限時數 (未填使用 TOKEN_ROTATION_GRACE_HOURS，0 表示立即失效)
+	GracePeriodHours *int `json:"grace_period_hours" binding:"omitempty,min=0"`
+	// ExpiresInDays 新 Token 有效天數 (未填沿用原 Token 的有效期長度)
+	ExpiresInDays *int `json:"expires_in_days" binding:"omitempty,min=1"`
+	// Permissions 縮減新 Token 的權限 (只能是現有權限的子集)
+	Permissions []string `json:"permissions"`
+}
+
+// UpdateRateLimitsRequest 設定 Token 速率限制覆寫 (整組取代；null 或未填恢復全域設定，0 表示不限制)
+type UpdateRateLimitsRequest struct {
+	MailsPerHour     *int `json:"mails_per_hour" binding:"omitempty,min=0"`
+	RecipientPerHour *int `json:"recipient_per_hour" binding:"omitempty,min=0"`
+}
+
+// UpdateQuotasRequest 設定 Token 用量配額 (整組取代；null 或未填恢復全域設定，0 表示不限制)
+type UpdateQuotasRequest struct {
+	MailsPerDay          *int     `json:"mails_per_day" binding:"omitempty,min=0"`
+	RecipientsPerMonth   *int     `json:"recipients_per_month" binding:"omitempty,min=0"`
+	AttachmentGBPerMonth *float64 `json:"attachment_gb_per_month" binding:"omitempty,min=0"`
+}
+
+// RotateTokenResponse 輪替 Token 回應
+type RotateTokenResponse struct {
+	Token                  string     `json:"token"`
+	ClientID               string     `json:"client_id"`
+	Permissions            []string   `json:"permissions"`
+	ExpiresAt              *time.Time `json:"expires_at,omitempty"`
+	PreviousTokenExpiresAt *time.Time `json:"previous_token_expires_at,omitempty"`
+	RotatedAt              time.Time  `json:"rotated_at"`
 }
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+func TestCoversRestrictions(t *testing.T) {
+	tests := []struct {
+		name   string
+		caller []string
+		target []string
+		want   bool
+	}{
+		{name: "unrestricted caller", caller: nil, target: nil, want: true},
+		{name: "empty target", caller: []string{"@example.com"}, target: nil, want: false},
+		{name: "same domain", caller: []string{"example.com"}, target: []string{"@Example.com"}, want: true},
+		{name: "address in domain", caller: []string{"@example.com"}, target: []string{"noreply@example.com"}, want: true},
+		{name: "other domain", caller: []string{"@example.com"}, target: []string{"@other.com"}, want: false},
+		{name: "domain wider than address", caller: []string{"noreply@example.com"}, target: []string{"@example.com"}, want: false},
+		{name: "one rule outside", caller: []string{"@example.com"}, target: []string{"a@example.com", "b@other.com"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caller := &ClientToken{AllowedFromAddresses: pq.StringArray(tt.caller)}
+			target := &ClientToken{AllowedFromAddresses: pq.StringArray(tt.target)}
+			if got := caller.CoversRestrictions(target); got != tt.want {
+				t.Fatalf("CoversRestrictions() = %v, want %v", got, tt.want)
+			}
+
+			// 收件網域套用相同規則
+			caller = &ClientToken{AllowedRecipientDomains: pq.StringArray(tt.caller)}
+			target = &ClientToken{AllowedRecipientDomains: pq.StringArray(tt.target)}
+			if got := caller.CoversRestrictions(target); got != tt.want {
+				t.Fatalf("CoversRestrictions() for recipients = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanManageRequiresCoveredRestrictions(t *testing.T) {
+	department := uuid.New()
+	caller := &ClientToken{
+		ID:                   uuid.New(),
+		DepartmentID:         &department,
+		Permissions:          pq.StringArray{ScopeMailSend, ScopeTokensManage},
+		AllowedFromAddresses: pq.StringArray{"@example.com"},
+	}
+	restricted := &ClientToken{
+		ID:                   uuid.New(),
+		DepartmentID:         &department,
+		Permissions:          pq.StringArray{ScopeMailSend},
+		AllowedFromAddresses: pq.StringArray{"noreply@example.com"},
+	}
+	unrestricted := &ClientToken{ID: uuid.New(), DepartmentID: &department, Permissions: pq.StringArray{ScopeMailSend}}
+
+	if !caller.CanManage(restricted) {
+		t.Fatal("CanManage() rejected a token within the caller's restrictions")
+	}
+	if caller.CanManage(unrestricted) {
+		t.Fatal("CanManage() allowed an unrestricted token for a restricted caller")
+	}
+}
+
+func TestCapExpiry(t *testing.T) {
+	now := time.Now()
+	soon, later := now.Add(time.Hour), now.Add(48*time.Hour)
+
+	permanent := &ClientToken{}
+	if got := permanent.CapExpiry(&later); got != &later {
+		t.Fatalf("CapExpiry() for a permanent caller = %v, want unchanged", got)
+	}
+
+	caller := &ClientToken{ExpiresAt: &soon}
+	if got := caller.CapExpiry(nil); got == nil || !got.Equal(soon) {
+		t.Fatalf("CapExpiry(nil) = %v, want %v", got, soon)
+	}
+	if got := caller.CapExpiry(&later); got == nil || !got.Equal(soon) {
+		t.Fatalf("CapExpiry(later) = %v, want %v", got, soon)
+	}
+	earlier := now.Add(time.Minute)
+	if got := caller.CapExpiry(&earlier); got == nil || !got.Equal(earlier) {
+		t.Fatalf("CapExpiry(earlier) = %v, want %v", got, earlier)
+	}
+}
@@ -0,0 +1,51 @@
+// internal/models/content_processing.go
+// 內容處理紀錄：排入佇列前對 HTML / 純文字內文所做的處理 (供稽核實際送出的內容)
+
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+)
+
+// ContentProcessing 內容處理紀錄 (以 jsonb 儲存於 mails.content_processing)
+// mails.body / mails.html 為處理後實際送出的內容，處理前的 HTML 存於 mails.original_html
+type ContentProcessing struct {
+	TextGenerated     bool     `json:"text_generated,omitempty"`     // 由 HTML 產生 text/plain
+	CSSInlined        bool     `json:"css_inlined,omitempty"`        // <style> 規則已內嵌至 style 屬性
+	Sanitized         bool     `json:"sanitized,omitempty"`          // 已依允許清單消毒
+	RemovedTags       []string `json:"removed_tags,omitempty"`       // 消毒時移除的標籤
+	RemovedAttributes []string `json:"removed_attributes,omitempty"` // 消毒時移除的屬性 (tag.attr)
+}
+
+// IsZero 是否未做任何處理
+func (p *ContentProcessing) IsZero() bool {
+	return p == nil || (!p.TextGenerated && !p.CSSInlined && !p.Sanitized)
+}
+
+// Value 實作 driver.Valuer
+func (p ContentProcessing) Value() (driver.Value, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan 實作 sql.Scanner
+func (p *ContentProcessing) Scan(value interface{}) error {
+	var b []byte
+	switch v := value.(type) {
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	case nil:
+		*p = ContentProcessing{}
+		return nil
+	default:
+		return errors.New("unsupported type for ContentProcessing")
+	}
+	return json.Unmarshal(b, p)
+}
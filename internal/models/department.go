@@ -0,0 +1,37 @@
+// internal/models/department.go
+// 部門 (租戶) 資料模型
+
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Department 部門，Client Token 的租戶邊界
+// 綁定部門的非 admin Token 只能管理同部門的 Token、Sender Config 與郵件歷史
+type Department struct {
+	ID          uuid.UUID `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	Name        string    `json:"name" gorm:"uniqueIndex;not null"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt   time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+// TableName 指定資料表名稱
+func (Department) TableName() string {
+	return "departments"
+}
+
+// CreateDepartmentRequest 建立部門請求
+type CreateDepartmentRequest struct {
+	Name        string `json:"name" binding:"required,max=100"`
+	Description string `json:"description"`
+}
+
+// UpdateDepartmentRequest 更新部門請求
+type UpdateDepartmentRequest struct {
+	Name        string  `json:"name" binding:"omitempty,max=100"`
+	Description *string `json:"description"`
+}
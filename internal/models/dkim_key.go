@@ -0,0 +1,94 @@
+// internal/models/dkim_key.go
+// DKIM 簽章金鑰資料模型
+
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DKIM 簽章演算法
+const (
+	DKIMAlgorithmRSASHA256     = "rsa-sha256"
+	DKIMAlgorithmEd25519SHA256 = "ed25519-sha256"
+)
+
+// DKIMKey 每個寄件網域的 DKIM 簽章金鑰
+// 私鑰以 EncryptionService 加密後儲存；同一網域有多把啟用中的金鑰時使用最新的一把
+type DKIMKey struct {
+	ID                  uuid.UUID `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	Domain              string    `json:"domain" gorm:"not null"`
+	Selector            string    `json:"selector" gorm:"not null"`
+	Algorithm           string    `json:"algorithm" gorm:"not null"`
+	PrivateKeyEncrypted string    `json:"-" gorm:"column:private_key_encrypted;not null"`
+	PublicKey           string    `json:"public_key" gorm:"not null"`     // DNS TXT 記錄 p= 的值 (base64)
+	IsActive            bool      `json:"is_active" gorm:"default:false"` // 產生後需發布 DNS 記錄再啟用
+	CreatedAt           time.Time `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt           time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+// TableName 指定資料表名稱
+func (DKIMKey) TableName() string {
+	return "dkim_keys"
+}
+
+// CreateDKIMKeyRequest 產生 DKIM 金鑰請求
+type CreateDKIMKeyRequest struct {
+	Domain    string `json:"domain" binding:"required,fqdn"`
+	Selector  string `json:"selector" binding:"required,hostname_rfc1123"`
+	Algorithm string `json:"algorithm" binding:"omitempty,oneof=rsa-sha256 ed25519-sha256"` // 預設 rsa-sha256
+}
+
+// ActivateDKIMKeyRequest 啟用 DKIM 金鑰請求
+type ActivateDKIMKeyRequest struct {
+	SkipDNSCheck bool `json:"skip_dns_check"` // 不檢查 DNS TXT 記錄 (例如內外部 DNS 不同時)
+}
+
+// DKIMDNSRecord 需發布的 DNS TXT 記錄
+type DKIMDNSRecord struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// DKIMKeyResponse DKIM 金鑰回應
+type DKIMKeyResponse struct {
+	ID        uuid.UUID     `json:"id"`
+	Domain    string        `json:"domain"`
+	Selector  string        `json:"selector"`
+	Algorithm string        `json:"algorithm"`
+	IsActive  bool          `json:"is_active"`
+	DNSRecord DKIMDNSRecord `json:"dns_record"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// DNSRecord 產生需發布的 DNS TXT 記錄 (RFC 6376 §3.6.1、RFC 8463)
+func (k *DKIMKey) DNSRecord() DKIMDNSRecord {
+	keyType := "rsa"
+	if k.Algorithm == DKIMAlgorithmEd25519SHA256 {
+		keyType = "ed25519"
+	}
+	return DKIMDNSRecord{
+		Name:  fmt.Sprintf("%s._domainkey.%s", k.Selector, k.Domain),
+		Type:  "TXT",
+		Value: fmt.Sprintf("v=DKIM1; k=%s; p=%s", keyType, k.PublicKey),
+	}
+}
+
+// ToResponse 轉換為回應結構 (不含私鑰)
+func (k *DKIMKey) ToResponse() DKIMKeyResponse {
+	return DKIMKeyResponse{
+		ID:        k.ID,
+		Domain:    k.Domain,
+		Selector:  k.Selector,
+		Algorithm: k.Algorithm,
+		IsActive:  k.IsActive,
+		DNSRecord: k.DNSRecord(),
+		CreatedAt: k.CreatedAt,
+		UpdatedAt: k.UpdatedAt,
+	}
+}
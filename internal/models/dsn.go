@@ -0,0 +1,49 @@
+// internal/models/dsn.go
+// DSN (Delivery Status Notification) 資料模型
+
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// DSN NOTIFY 參數值 (RFC 3461 §4.1)
+const (
+	DSNNotifyNever   = "NEVER"
+	DSNNotifySuccess = "SUCCESS"
+	DSNNotifyFailure = "FAILURE"
+	DSNNotifyDelay   = "DELAY"
+)
+
+// MailDSNRecipient 單一收件者的 DSN 參數
+// 僅在信封寄件者非空 (MAIL FROM:<>) 時建立，DSN 寄回該信封寄件者
+type MailDSNRecipient struct {
+	ID                uuid.UUID      `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	MailID            uuid.UUID      `json:"mail_id" gorm:"type:uuid;not null;index"`
+	Recipient         string         `json:"recipient" gorm:"not null"`
+	Notify            pq.StringArray `json:"notify,omitempty" gorm:"type:text[]"` // 空白表示未指定 (預設僅失敗時通知)
+	OriginalRecipient string         `json:"original_recipient,omitempty"`        // ORCPT，格式為 "addr-type; address"
+	CreatedAt         time.Time      `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// TableName 指定資料表名稱
+func (MailDSNRecipient) TableName() string {
+	return "mail_dsn_recipients"
+}
+
+// WantsNotify 判斷收件者是否要求指定類型的通知
+// 未指定 NOTIFY 時依 RFC 3461 預設只在失敗時通知
+func (r *MailDSNRecipient) WantsNotify(kind string) bool {
+	if len(r.Notify) == 0 {
+		return kind == DSNNotifyFailure
+	}
+	for _, n := range r.Notify {
+		if n == kind {
+			return true
+		}
+	}
+	return false
+}
@@ -9,6 +9,12 @@ import (
 	"github.com/google/uuid"
 )
 
+// Sender Config 的 Microsoft 應用程式認證方式
+const (
+	SenderAuthMethodClientSecret = "client_secret" // client secret (預設)
+	SenderAuthMethodCertificate  = "certificate"   // 憑證簽署的 JWT client assertion
+)
+
 // EmailSenderConfig Email Sender Config 資料模型
 // 儲存每個 Client 的 Microsoft OAuth 配置
 type EmailSenderConfig struct {
@@ -17,11 +23,22 @@ type EmailSenderConfig struct {
 	SenderEmail             string    `json:"sender_email" gorm:"not null"`
 	MSTenantID              string    `json:"ms_tenant_id" gorm:"not null"`
 	MSClientID              string    `json:"ms_client_id" gorm:"not null"`
-	MSClientSecretEncrypted string    `json:"-" gorm:"column:ms_client_secret_encrypted;not null"`
+	MSClientSecretEncrypted string    `json:"-" gorm:"column:ms_client_secret_encrypted"`
 	IsActive                bool      `json:"is_active" gorm:"default:true"`
 	CreatedAt               time.Time `json:"created_at" gorm:"autoCreateTime"`
 	UpdatedAt               time.Time `json:"updated_at" gorm:"autoUpdateTime"`
 
+	// 憑證認證 (MSAuthMethod 為 certificate 時使用；私鑰加密儲存)
+	MSAuthMethod            string     `json:"ms_auth_method" gorm:"column:ms_auth_method;not null;default:'client_secret'"`
+	MSCertificate           string     `json:"-" gorm:"column:ms_certificate"`
+	MSPrivateKeyEncrypted   string     `json:"-" gorm:"column:ms_private_key_encrypted"`
+	MSCertificateThumbprint string     `json:"ms_certificate_thumbprint,omitempty" gorm:"column:ms_certificate_thumbprint"`
+	MSCertificateExpiresAt  *time.Time `json:"ms_certificate_expires_at,omitempty" gorm:"column:ms_certificate_expires_at"`
+
+	// 個別的 Microsoft 端點 (國家雲端；空白使用全域設定)
+	MSAuthorityURL string `json:"ms_authority_url,omitempty" gorm:"column:ms_authority_url"`
+	MSGraphURL     string `json:"ms_graph_url,omitempty" gorm:"column:ms_graph_url"`
+
 	// 關聯
 	ClientToken *ClientToken `json:"client_token,omitempty" gorm:"foreignKey:ClientTokenID"`
 }
@@ -33,10 +50,22 @@ func (EmailSenderConfig) TableName() string {
 
 // CreateSenderConfigRequest 建立 Sender Config 請求
 type CreateSenderConfigRequest struct {
+	// ClientTokenID 擁有此配置的 Token (未填為呼叫者本身；部門管理者可指定同部門 Token)
+	ClientTokenID *uuid.UUID `json:"client_token_id"`
+
 	SenderEmail    string `json:"sender_email" binding:"required,email"`
 	MSTenantID     string `json:"ms_tenant_id" binding:"required"`
 	MSClientID     string `json:"ms_client_id" binding:"required"`
-	MSClientSecret string `json:"ms_client_secret" binding:"required"`
+	MSClientSecret string `json:"ms_client_secret"`
+
+	// MSAuthMethod 認證方式 (未填為 client_secret)；certificate 需提供 PEM 格式的憑證與 RSA 私鑰
+	MSAuthMethod  string `json:"ms_auth_method" binding:"omitempty,oneof=client_secret certificate"`
+	MSCertificate string `json:"ms_certificate"`
+	MSPrivateKey  string `json:"ms_private_key"`
+
+	// MSAuthorityURL / MSGraphURL 國家雲端的端點 (未填使用全域設定；主機需在允許清單內)
+	MSAuthorityURL string `json:"ms_authority_url"`
+	MSGraphURL     string `json:"ms_graph_url"`
 }
 
 // UpdateSenderConfigRequest 更新 Sender Config 請求
@@ -45,6 +74,15 @@ type UpdateSenderConfigRequest struct {
 	MSClientID     string `json:"ms_client_id"`
 	MSClientSecret string `json:"ms_client_secret"`
 	IsActive       *bool  `json:"is_active"`
+
+	// 切換認證方式或更換憑證 (憑證與私鑰需一起提供)
+	MSAuthMethod  string `json:"ms_auth_method" binding:"omitempty,oneof=client_secret certificate"`
+	MSCertificate string `json:"ms_certificate"`
+	MSPrivateKey  string `json:"ms_private_key"`
+
+	// 變更端點 (空字串恢復為全域設定)
+	MSAuthorityURL *string `json:"ms_authority_url"`
+	MSGraphURL     *string `json:"ms_graph_url"`
 }
 
 // SenderConfigResponse Sender Config 回應
@@ -54,26 +92,55 @@ type SenderConfigResponse struct {
 	SenderEmail    string    `json:"sender_email"`
 	MSTenantID     string    `json:"ms_tenant_id"`
 	MSClientID     string    `json:"ms_client_id"`
-	MSClientSecret string    `json:"ms_client_secret"` // 遮罩後的值
+	MSClientSecret string    `json:"ms_client_secret,omitempty"` // 遮罩後的值 (憑證認證時為空白)
 	IsActive       bool      `json:"is_active"`
 	CreatedAt      time.Time `json:"created_at"`
 	UpdatedAt      time.Time `json:"updated_at"`
+
+	MSAuthMethod            string     `json:"ms_auth_method"`
+	MSCertificateThumbprint string     `json:"ms_certificate_thumbprint,omitempty"`
+	MSCertificateExpiresAt  *time.Time `json:"ms_certificate_expires_at,omitempty"`
+	MSCertificateDaysLeft   *int       `json:"ms_certificate_days_left,omitempty"`
+	Warnings                []string   `json:"warnings,omitempty"` // 如憑證即將到期
+
+	MSAuthorityURL string `json:"ms_authority_url,omitempty"`
+	MSGraphURL     string `json:"ms_graph_url,omitempty"`
 }
 
 // ToResponse 轉換為回應結構 (secret 遮罩)
 func (e *EmailSenderConfig) ToResponse(decryptedSecret string) SenderConfigResponse {
-	maskedSecret := maskSecret(decryptedSecret)
-	return SenderConfigResponse{
-		ID:             e.ID,
-		ClientTokenID:  e.ClientTokenID,
-		SenderEmail:    e.SenderEmail,
-		MSTenantID:     e.MSTenantID,
-		MSClientID:     e.MSClientID,
-		MSClientSecret: maskedSecret,
-		IsActive:       e.IsActive,
-		CreatedAt:      e.CreatedAt,
-		UpdatedAt:      e.UpdatedAt,
+	resp := SenderConfigResponse{
+		ID:                      e.ID,
+		ClientTokenID:           e.ClientTokenID,
+		SenderEmail:             e.SenderEmail,
+		MSTenantID:              e.MSTenantID,
+		MSClientID:              e.MSClientID,
+		IsActive:                e.IsActive,
+		CreatedAt:               e.CreatedAt,
+		UpdatedAt:               e.UpdatedAt,
+		MSAuthMethod:            e.AuthMethod(),
+		MSCertificateThumbprint: e.MSCertificateThumbprint,
+		MSCertificateExpiresAt:  e.MSCertificateExpiresAt,
+		MSAuthorityURL:          e.MSAuthorityURL,
+		MSGraphURL:              e.MSGraphURL,
+	}
+	if e.AuthMethod() == SenderAuthMethodCertificate {
+		if e.MSCertificateExpiresAt != nil {
+			daysLeft := int(time.Until(*e.MSCertificateExpiresAt).Hours() / 24)
+			resp.MSCertificateDaysLeft = &daysLeft
+		}
+	} else {
+		resp.MSClientSecret = maskSecret(decryptedSecret)
+	}
+	return resp
+}
+
+// AuthMethod 認證方式 (舊資料未設定時為 client_secret)
+func (e *EmailSenderConfig) AuthMethod() string {
+	if e.MSAuthMethod == "" {
+		return SenderAuthMethodClientSecret
 	}
+	return e.MSAuthMethod
 }
 
 // maskSecret 遮罩 secret (顯示前4後4碼)
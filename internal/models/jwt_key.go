@@ -0,0 +1,65 @@
+// internal/models/jwt_key.go
+// JWT 簽章金鑰資料模型
+
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// JWT 非對稱簽章演算法
+const (
+	JWTAlgorithmRS256 = "RS256"
+	JWTAlgorithmES256 = "ES256"
+	JWTAlgorithmEdDSA = "EdDSA"
+)
+
+// JWTSigningKey Client Token 的 JWT 簽章金鑰
+// 私鑰以 EncryptionService 加密後儲存；啟用中的金鑰皆可驗證，最新的一把用於簽發
+type JWTSigningKey struct {
+	ID                  uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	KID                 string     `json:"kid" gorm:"column:kid;uniqueIndex;not null"`
+	Algorithm           string     `json:"algorithm" gorm:"not null"`
+	PrivateKeyEncrypted string     `json:"-" gorm:"column:private_key_encrypted;not null"`
+	PublicKey           string     `json:"public_key" gorm:"not null"` // PKIX PEM
+	IsActive            bool       `json:"is_active" gorm:"default:true"`
+	CreatedAt           time.Time  `json:"created_at" gorm:"autoCreateTime"`
+	RetiredAt           *time.Time `json:"retired_at,omitempty"`
+}
+
+// TableName 指定資料表名稱
+func (JWTSigningKey) TableName() string {
+	return "jwt_signing_keys"
+}
+
+// CreateJWTKeyRequest 產生 JWT 簽章金鑰請求
+type CreateJWTKeyRequest struct {
+	Algorithm string `json:"algorithm" binding:"required,oneof=RS256 ES256 EdDSA"`
+}
+
+// JWTSigningKeyResponse JWT 簽章金鑰回應
+type JWTSigningKeyResponse struct {
+	JWTSigningKey
+	Signing      bool  `json:"signing"`       // 是否為目前用於簽發的金鑰
+	ActiveTokens int64 `json:"active_tokens"` // 仍以此金鑰簽章的有效 Token 數
+}
+
+// JWK JSON Web Key (RFC 7517)，只包含公鑰欄位
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet JSON Web Key Set
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
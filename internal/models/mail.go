@@ -21,6 +21,18 @@ const (
 	MailStatusCancelled  MailStatus = "cancelled"
 )
 
+// MailSendMode 郵件發送模式
+type MailSendMode string
+
+const (
+	MailSendModeParsed MailSendMode = "parsed" // 依解析後欄位重新組信 (預設)
+	MailSendModeRaw    MailSendMode = "raw"    // 原始 MIME 直送
+)
+
+// MetadataEnvelopeOnly 郵件 metadata 鍵：只投遞給信封收件者，不依原始標頭中的收件者展開
+// LMTP 接收的郵件每個收件者各自一筆記錄，原始 MIME 標頭仍列有其他收件者
+const MetadataEnvelopeOnly = "envelope_only"
+
 // Mail 郵件資料模型
 type Mail struct {
 	ID           uuid.UUID         `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
@@ -44,8 +56,27 @@ type Mail struct {
 	// Sender Config 關聯 (API 請求時設定)
 	SenderConfigID *uuid.UUID `json:"sender_config_id,omitempty" gorm:"type:uuid"`
 
+	// 原始 MIME (SMTP 接收時保存)
+	RawMessagePath string       `json:"raw_message_path,omitempty" gorm:"column:raw_message_path"`
+	SendMode       MailSendMode `json:"send_mode" gorm:"not null;default:'parsed'"`
+
+	// DSN 參數 (RFC 3461，SMTP 接收時由 MAIL FROM 的 RET / ENVID 設定)
+	DSNReturn     string `json:"dsn_return,omitempty" gorm:"column:dsn_return"`
+	DSNEnvelopeID string `json:"dsn_envelope_id,omitempty" gorm:"column:dsn_envelope_id"`
+
+	// 顯示名稱、Reply-To、回覆串接標頭、重要性與自訂標頭 (API 請求時設定)
+	Options *MessageOptions `json:"options,omitempty" gorm:"column:message_options;type:jsonb"`
+
+	// 會議邀請 (API 請求時產生的 iCalendar 內容)
+	Calendar *CalendarInvite `json:"calendar,omitempty" gorm:"column:calendar_invite;type:jsonb"`
+
+	// 內容處理 (Body / HTML 為處理後實際送出的內容，OriginalHTML 為處理前的 HTML)
+	OriginalHTML      string             `json:"original_html,omitempty" gorm:"column:original_html"`
+	ContentProcessing *ContentProcessing `json:"content_processing,omitempty" gorm:"column:content_processing;type:jsonb"`
+
 	// 關聯
-	Attachments []Attachment `json:"attachments,omitempty" gorm:"foreignKey:MailID"`
+	Attachments   []Attachment       `json:"attachments,omitempty" gorm:"foreignKey:MailID"`
+	DSNRecipients []MailDSNRecipient `json:"dsn_recipients,omitempty" gorm:"foreignKey:MailID"`
 }
 
 // TableName 指定資料表名稱
@@ -61,6 +92,8 @@ type Attachment struct {
 	ContentType string    `json:"content_type,omitempty"`
 	SizeBytes   int64     `json:"size_bytes,omitempty"`
 	StoragePath string    `json:"storage_path" gorm:"not null"`
+	ContentID   string    `json:"content_id,omitempty" gorm:"column:content_id"`
+	Inline      bool      `json:"inline,omitempty" gorm:"column:is_inline;not null;default:false"`
 	CreatedAt   time.Time `json:"created_at" gorm:"autoCreateTime"`
 }
 
@@ -85,14 +118,36 @@ type MailJob struct {
 
 	// Sender Config ID (API 請求時設定，用於 Worker 查詢 OAuth 配置)
 	SenderConfigID string `json:"sender_config_id,omitempty"`
+
+	// 原始 MIME 直送 (SendMode 為 raw 時，Worker 直接送出 RawMessagePath 的內容)
+	SendMode       MailSendMode `json:"send_mode,omitempty"`
+	RawMessagePath string       `json:"raw_message_path,omitempty"`
+
+	// 是否需在最終成功或失敗時產生 DSN (收件者參數存於 mail_dsn_recipients)
+	DSNRequested bool `json:"dsn_requested,omitempty"`
+
+	// 顯示名稱、Reply-To、回覆串接標頭、重要性與自訂標頭
+	Options *MessageOptions `json:"options,omitempty"`
+
+	// 會議邀請 (text/calendar 內容)
+	Calendar *CalendarInvite `json:"calendar,omitempty"`
+
+	// SMTP 收信時 MAIL FROM 協商的參數 (SMTP Relay 轉送時沿用，下一跳不支援則永久失敗)
+	SMTPUTF8 bool   `json:"smtputf8,omitempty"`
+	BodyType string `json:"body_type,omitempty"` // 7BIT / 8BITMIME / BINARYMIME
+
+	// 信封寄件者為空 (MAIL FROM:<>)，用於 DSN 等自動產生的通知，只能經 SMTP Relay 送出
+	NullSender bool `json:"null_sender,omitempty"`
 }
 
 // AttachmentInfo 附件資訊
 type AttachmentInfo struct {
 	Filename    string `json:"filename"`
 	ContentType string `json:"content_type"`
-	SizeBytes   int64  `json:"size_bytes"`   // 附件大小（bytes）
-	StoragePath string `json:"storage_path"` // 附件儲存路徑
+	SizeBytes   int64  `json:"size_bytes"`           // 附件大小（bytes）
+	StoragePath string `json:"storage_path"`         // 附件儲存路徑
+	ContentID   string `json:"content_id,omitempty"` // 內嵌附件 Content-ID (不含角括號)
+	Inline      bool   `json:"inline,omitempty"`     // 內嵌於 HTML (以 cid: 參照)
 }
 
 // MailStatusCache KeyDB 快取格式
@@ -0,0 +1,259 @@
+// internal/models/message_options.go
+// 郵件選項：顯示名稱、Reply-To、回覆串接標頭、重要性、自訂標頭與一鍵退訂
+
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+// 郵件重要性
+const (
+	ImportanceLow    = "low"
+	ImportanceNormal = "normal"
+	ImportanceHigh   = "high"
+)
+
+// 選項數量與長度上限
+const (
+	MaxDisplayNameLength = 256
+	MaxReferences        = 50
+	MaxCustomHeaders     = 20
+	MaxHeaderValueLength = 998 // RFC 5322 單行上限
+)
+
+var (
+	// messageIDPattern RFC 5322 msg-id (<id-left@id-right>)
+	messageIDPattern = regexp.MustCompile(`^<[^<>@\s]+@[^<>@\s]+>$`)
+	// customHeaderPattern 自訂標頭名稱 (只允許 X- 開頭，與 Graph internetMessageHeaders 限制相同)
+	customHeaderPattern = regexp.MustCompile(`^[Xx]-[A-Za-z0-9][A-Za-z0-9-]*$`)
+	// contentIDPattern 內嵌附件 Content-ID (不含角括號，HTML 以 cid: 參照)
+	contentIDPattern = regexp.MustCompile(`^[A-Za-z0-9!#$%&'*+\-/=?^_{|}~.]+(@[A-Za-z0-9!#$%&'*+\-/=?^_{|}~.]+)?$`)
+	// unsubscribeCategoryPattern 退訂類別 (如 newsletter、product-updates)
+	unsubscribeCategoryPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+)
+
+// MaxContentIDLength 內嵌附件 Content-ID 長度上限
+const MaxContentIDLength = 250
+
+// reservedCustomHeaders 由 importance 產生、不可自訂的標頭 (小寫)
+var reservedCustomHeaders = map[string]bool{
+	"x-priority":        true,
+	"x-msmail-priority": true,
+}
+
+// MessageOptions 郵件選項 (API 請求設定，隨 MailJob 傳給發送服務並以 jsonb 儲存於 mails)
+type MessageOptions struct {
+	FromName     string            `json:"from_name,omitempty"`
+	DisplayNames map[string]string `json:"display_names,omitempty"` // 地址 (小寫) -> 顯示名稱
+	ReplyTo      []string          `json:"reply_to,omitempty"`
+	InReplyTo    string            `json:"in_reply_to,omitempty"` // <msg-id>
+	References   []string          `json:"references,omitempty"`  // <msg-id> 列表，依串接順序
+	Importance   string            `json:"importance,omitempty"`  // low / normal / high
+	Headers      map[string]string `json:"headers,omitempty"`     // X- 自訂標頭
+
+	ListUnsubscribe *ListUnsubscribe `json:"list_unsubscribe,omitempty"`
+}
+
+// ListUnsubscribe 一鍵退訂 (RFC 8058)
+// URL 由 API 於建立郵件時簽章產生，收件者退訂後加入該 Client (或 Client 下該類別) 的抑制清單
+type ListUnsubscribe struct {
+	Category string `json:"category,omitempty"` // 空白表示退訂該 Client 的所有郵件
+	URL      string `json:"url,omitempty"`
+}
+
+// UnsubscribeHeaders List-Unsubscribe 與 List-Unsubscribe-Post 標頭 (未設定時為 nil)
+func (o *MessageOptions) UnsubscribeHeaders() map[string]string {
+	if o == nil || o.ListUnsubscribe == nil || o.ListUnsubscribe.URL == "" {
+		return nil
+	}
+	return map[string]string{
+		"List-Unsubscribe":      "<" + o.ListUnsubscribe.URL + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+}
+
+// IsZero 是否未設定任何選項
+func (o *MessageOptions) IsZero() bool {
+	return o == nil || (o.FromName == "" && len(o.DisplayNames) == 0 && len(o.ReplyTo) == 0 &&
+		o.InReplyTo == "" && len(o.References) == 0 && o.Importance == "" && len(o.Headers) == 0 &&
+		o.ListUnsubscribe == nil)
+}
+
+// DisplayName 取得地址的顯示名稱 (未設定時為空白)
+func (o *MessageOptions) DisplayName(address string) string {
+	if o == nil {
+		return ""
+	}
+	return o.DisplayNames[strings.ToLower(address)]
+}
+
+// Validate 驗證並正規化選項 (防止標頭注入)
+// addresses 為寄件者與所有收件者，display_names 只能指定這些地址或 reply_to 的名稱
+func (o *MessageOptions) Validate(addresses []string) error {
+	if o == nil {
+		return nil
+	}
+
+	var err error
+	if o.FromName, err = normalizeDisplayName("from_name", o.FromName); err != nil {
+		return err
+	}
+
+	known := make(map[string]bool, len(addresses)+len(o.ReplyTo))
+	for _, addr := range addresses {
+		known[strings.ToLower(addr)] = true
+	}
+	for i, addr := range o.ReplyTo {
+		parsed, err := mail.ParseAddress(addr)
+		if err != nil || parsed.Name != "" || parsed.Address != addr {
+			return fmt.Errorf("reply_to: invalid address %q", addr)
+		}
+		o.ReplyTo[i] = parsed.Address
+		known[strings.ToLower(parsed.Address)] = true
+	}
+
+	if len(o.DisplayNames) > 0 {
+		names := make(map[string]string, len(o.DisplayNames))
+		for addr, name := range o.DisplayNames {
+			key := strings.ToLower(strings.TrimSpace(addr))
+			if !known[key] {
+				return fmt.Errorf("display_names: %q is not a sender, recipient or reply_to address", addr)
+			}
+			if names[key], err = normalizeDisplayName("display_names", name); err != nil {
+				return err
+			}
+		}
+		o.DisplayNames = names
+	}
+
+	if o.InReplyTo != "" {
+		if o.InReplyTo, err = normalizeMessageID("in_reply_to", o.InReplyTo); err != nil {
+			return err
+		}
+	}
+	if len(o.References) > MaxReferences {
+		return fmt.Errorf("references: at most %d message IDs are allowed", MaxReferences)
+	}
+	for i, id := range o.References {
+		if o.References[i], err = normalizeMessageID("references", id); err != nil {
+			return err
+		}
+	}
+
+	switch o.Importance {
+	case "", ImportanceLow, ImportanceNormal, ImportanceHigh:
+	default:
+		return fmt.Errorf("importance: must be one of low, normal, high")
+	}
+
+	if len(o.Headers) > MaxCustomHeaders {
+		return fmt.Errorf("headers: at most %d custom headers are allowed", MaxCustomHeaders)
+	}
+	seen := make(map[string]bool, len(o.Headers))
+	for name, value := range o.Headers {
+		if !customHeaderPattern.MatchString(name) {
+			return fmt.Errorf("headers: invalid header name %q (must start with X- and contain only letters, digits and hyphens)", name)
+		}
+		if reservedCustomHeaders[strings.ToLower(name)] {
+			return fmt.Errorf("headers: %s cannot be set directly", name)
+		}
+		if seen[strings.ToLower(name)] {
+			return fmt.Errorf("headers: duplicate header %s", name)
+		}
+		seen[strings.ToLower(name)] = true
+		if len(value) > MaxHeaderValueLength {
+			return fmt.Errorf("headers: value of %s exceeds %d characters", name, MaxHeaderValueLength)
+		}
+		for _, r := range value {
+			if (r < 0x20 && r != '\t') || r > 0x7e {
+				return fmt.Errorf("headers: value of %s must be printable ASCII without line breaks", name)
+			}
+		}
+	}
+
+	if o.ListUnsubscribe != nil {
+		category := strings.ToLower(strings.TrimSpace(o.ListUnsubscribe.Category))
+		if category != "" && !unsubscribeCategoryPattern.MatchString(category) {
+			return fmt.Errorf("list_unsubscribe.category: must be 1-64 letters, digits, dots, underscores or hyphens")
+		}
+		o.ListUnsubscribe.Category = category
+	}
+	return nil
+}
+
+// normalizeDisplayName 顯示名稱不可含控制字元 (含 CR / LF)
+func normalizeDisplayName(field, name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if len([]rune(name)) > MaxDisplayNameLength {
+		return "", fmt.Errorf("%s: display name exceeds %d characters", field, MaxDisplayNameLength)
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f {
+			return "", fmt.Errorf("%s: display name must not contain control characters or line breaks", field)
+		}
+	}
+	return name, nil
+}
+
+// normalizeMessageID 補上角括號並驗證 msg-id 格式
+func normalizeMessageID(field, id string) (string, error) {
+	id = strings.TrimSpace(id)
+	if !strings.HasPrefix(id, "<") {
+		id = "<" + id + ">"
+	}
+	if len(id) > MaxHeaderValueLength || !messageIDPattern.MatchString(id) {
+		return "", fmt.Errorf("%s: invalid message ID %q", field, id)
+	}
+	for _, r := range id {
+		if r < 0x21 || r > 0x7e {
+			return "", fmt.Errorf("%s: invalid message ID %q", field, id)
+		}
+	}
+	return id, nil
+}
+
+// NormalizeContentID 去除角括號與 cid: 前綴並驗證 Content-ID 格式
+func NormalizeContentID(id string) (string, error) {
+	id = strings.TrimSpace(id)
+	if len(id) >= 4 && strings.EqualFold(id[:4], "cid:") {
+		id = id[4:]
+	}
+	id = strings.TrimSuffix(strings.TrimPrefix(id, "<"), ">")
+	if id == "" || len(id) > MaxContentIDLength || !contentIDPattern.MatchString(id) {
+		return "", fmt.Errorf("invalid content ID %q", id)
+	}
+	return id, nil
+}
+
+// Value 實作 driver.Valuer
+func (o MessageOptions) Value() (driver.Value, error) {
+	b, err := json.Marshal(o)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan 實作 sql.Scanner
+func (o *MessageOptions) Scan(value interface{}) error {
+	var b []byte
+	switch v := value.(type) {
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	case nil:
+		*o = MessageOptions{}
+		return nil
+	default:
+		return errors.New("unsupported type for MessageOptions")
+	}
+	return json.Unmarshal(b, o)
+}
@@ -0,0 +1,96 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMessageOptionsValidateRejects(t *testing.T) {
+	addresses := []string{"sender@example.com", "to@example.com"}
+	tests := []struct {
+		name    string
+		options MessageOptions
+		wantErr string
+	}{
+		{name: "from_name CRLF", options: MessageOptions{FromName: "Sender\r\nBcc: x@evil.test"}, wantErr: "from_name"},
+		{name: "from_name LF", options: MessageOptions{FromName: "Sender\nX"}, wantErr: "from_name"},
+		{name: "from_name NUL", options: MessageOptions{FromName: "Send\x00er"}, wantErr: "from_name"},
+		{name: "from_name DEL", options: MessageOptions{FromName: "Send\x7fer"}, wantErr: "from_name"},
+		{name: "from_name too long", options: MessageOptions{FromName: strings.Repeat("a", MaxDisplayNameLength+1)}, wantErr: "from_name"},
+		{name: "display name CR", options: MessageOptions{DisplayNames: map[string]string{"to@example.com": "To\rX"}}, wantErr: "display_names"},
+		{name: "display name tab", options: MessageOptions{DisplayNames: map[string]string{"to@example.com": "To\tX"}}, wantErr: "display_names"},
+		{name: "display name unknown address", options: MessageOptions{DisplayNames: map[string]string{"other@example.com": "Other"}}, wantErr: "display_names"},
+		{name: "reply_to with name", options: MessageOptions{ReplyTo: []string{"Help <help@example.com>"}}, wantErr: "reply_to"},
+		{name: "reply_to CRLF", options: MessageOptions{ReplyTo: []string{"help@example.com\r\nBcc: x@evil.test"}}, wantErr: "reply_to"},
+		{name: "header name not X-", options: MessageOptions{Headers: map[string]string{"Bcc": "x@evil.test"}}, wantErr: "invalid header name"},
+		{name: "header name with colon", options: MessageOptions{Headers: map[string]string{"X-Test:": "v"}}, wantErr: "invalid header name"},
+		{name: "reserved X-Priority", options: MessageOptions{Headers: map[string]string{"X-Priority": "1"}}, wantErr: "cannot be set directly"},
+		{name: "reserved X-MSMail-Priority", options: MessageOptions{Headers: map[string]string{"x-msmail-priority": "High"}}, wantErr: "cannot be set directly"},
+		{name: "duplicate header", options: MessageOptions{Headers: map[string]string{"X-Tag": "a", "x-tag": "b"}}, wantErr: "duplicate header"},
+		{name: "header value CRLF", options: MessageOptions{Headers: map[string]string{"X-Tag": "a\r\nBcc: x@evil.test"}}, wantErr: "printable ASCII"},
+		{name: "header value LF", options: MessageOptions{Headers: map[string]string{"X-Tag": "a\nb"}}, wantErr: "printable ASCII"},
+		{name: "header value control", options: MessageOptions{Headers: map[string]string{"X-Tag": "a\x01b"}}, wantErr: "printable ASCII"},
+		{name: "header value non-ASCII", options: MessageOptions{Headers: map[string]string{"X-Tag": "café"}}, wantErr: "printable ASCII"},
+		{name: "header value too long", options: MessageOptions{Headers: map[string]string{"X-Tag": strings.Repeat("a", MaxHeaderValueLength+1)}}, wantErr: "exceeds"},
+		{name: "in_reply_to CRLF", options: MessageOptions{InReplyTo: "<a@example.com>\r\nBcc: x@evil.test"}, wantErr: "in_reply_to"},
+		{name: "in_reply_to without domain", options: MessageOptions{InReplyTo: "abc"}, wantErr: "in_reply_to"},
+		{name: "references with space", options: MessageOptions{References: []string{"<a b@example.com>"}}, wantErr: "references"},
+		{name: "too many references", options: MessageOptions{References: make([]string, MaxReferences+1)}, wantErr: "references"},
+		{name: "invalid importance", options: MessageOptions{Importance: "urgent"}, wantErr: "importance"},
+		{name: "invalid unsubscribe category", options: MessageOptions{ListUnsubscribe: &ListUnsubscribe{Category: "news letter"}}, wantErr: "list_unsubscribe.category"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := tt.options
+			err := options.Validate(addresses)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMessageOptionsValidateNormalizes(t *testing.T) {
+	options := &MessageOptions{
+		FromName:     "  Sender Name  ",
+		DisplayNames: map[string]string{" To@Example.com ": " 收件者 ", "HELP@example.com": "Help Desk"},
+		ReplyTo:      []string{"help@example.com"},
+		InReplyTo:    " abc@mail.example.com ",
+		References:   []string{"first@mail.example.com", " <second@mail.example.com> "},
+		Importance:   ImportanceHigh,
+		Headers:      map[string]string{"X-Campaign": "spring\t2026"},
+		ListUnsubscribe: &ListUnsubscribe{
+			Category: " Newsletter ",
+		},
+	}
+	if err := options.Validate([]string{"sender@example.com", "to@example.com"}); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	want := &MessageOptions{
+		FromName:     "Sender Name",
+		DisplayNames: map[string]string{"to@example.com": "收件者", "help@example.com": "Help Desk"},
+		ReplyTo:      []string{"help@example.com"},
+		InReplyTo:    "<abc@mail.example.com>",
+		References:   []string{"<first@mail.example.com>", "<second@mail.example.com>"},
+		Importance:   ImportanceHigh,
+		Headers:      map[string]string{"X-Campaign": "spring\t2026"},
+		ListUnsubscribe: &ListUnsubscribe{
+			Category: "newsletter",
+		},
+	}
+	if !reflect.DeepEqual(options, want) {
+		t.Fatalf("Validate() normalized to %+v, want %+v", options, want)
+	}
+	if got := options.DisplayName("TO@example.com"); got != "收件者" {
+		t.Errorf("DisplayName() = %q, want %q", got, "收件者")
+	}
+}
+
+func TestMessageOptionsValidateNil(t *testing.T) {
+	var options *MessageOptions
+	if err := options.Validate(nil); err != nil {
+		t.Fatalf("Validate() on nil options error = %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+// internal/models/quota.go
+// Client 用量配額資料模型
+
+package models
+
+import "time"
+
+// 配額項目
+const (
+	QuotaMetricMailsPerDay             = "mails_per_day"              // 每日郵件數
+	QuotaMetricRecipientsPerMonth      = "recipients_per_month"       // 每月收件者數 (To + CC + BCC)
+	QuotaMetricAttachmentBytesPerMonth = "attachment_bytes_per_month" // 每月附件位元組數
+)
+
+// QuotaMetricUsage 單一配額項目在目前週期的用量
+type QuotaMetricUsage struct {
+	Metric      string     `json:"metric"`
+	Used        int64      `json:"used"`
+	Limit       int64      `json:"limit"`   // 0 表示不限制
+	Percent     float64    `json:"percent"` // 不限制時為 0
+	PeriodStart time.Time  `json:"period_start"`
+	PeriodEnd   time.Time  `json:"period_end"`
+	Warning     bool       `json:"warning"`  // 已達警告門檻
+	Exceeded    bool       `json:"exceeded"` // 已達上限，新的發送請求會被拒絕
+	WarnedAt    *time.Time `json:"warned_at,omitempty"`
+}
+
+// QuotaUsage Client 目前週期的用量
+type QuotaUsage struct {
+	ClientID       string             `json:"client_id"`
+	WarningPercent int                `json:"warning_percent"`
+	Quotas         []QuotaMetricUsage `json:"quotas"`
+}
+
+// QuotaWarning 配額警告紀錄 (每個 Client、項目、週期只記錄一次)
+type QuotaWarning struct {
+	ID          int64     `json:"id" gorm:"primaryKey;autoIncrement"`
+	ClientID    string    `json:"client_id" gorm:"not null"`
+	Metric      string    `json:"metric" gorm:"not null"`
+	PeriodStart time.Time `json:"period_start" gorm:"not null"`
+	Used        int64     `json:"used"`
+	Limit       int64     `json:"limit" gorm:"column:quota_limit"`
+	CreatedAt   time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// TableName 指定資料表名稱
+func (QuotaWarning) TableName() string {
+	return "quota_warnings"
+}
@@ -0,0 +1,73 @@
+// internal/models/scope.go
+// Client Token 權限範圍 (Scope) 定義
+
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Client Token 權限範圍
+const (
+	ScopeAdmin              = "admin" // 涵蓋所有權限
+	ScopeMailSend           = "mail:send"
+	ScopeMailBatch          = "mail:batch"
+	ScopeMailRead           = "mail:read"
+	ScopeMailCancel         = "mail:cancel"
+	ScopeSenderConfigManage = "sender-config:manage"
+	ScopeTokensManage       = "tokens:manage"
+	ScopeTemplatesManage    = "templates:manage"
+)
+
+// KnownScopes 所有可指派的權限範圍
+var KnownScopes = []string{
+	ScopeAdmin,
+	ScopeMailSend,
+	ScopeMailBatch,
+	ScopeMailRead,
+	ScopeMailCancel,
+	ScopeSenderConfigManage,
+	ScopeTokensManage,
+	ScopeTemplatesManage,
+}
+
+// NormalizeScope 正規化權限名稱 (舊版以 `mail.send` 形式記錄)
+func NormalizeScope(scope string) string {
+	return strings.ReplaceAll(strings.TrimSpace(scope), ".", ":")
+}
+
+// ValidateScopes 驗證並正規化權限列表，包含未知權限時回傳錯誤
+func ValidateScopes(scopes []string) ([]string, error) {
+	result := make([]string, 0, len(scopes))
+	seen := make(map[string]bool, len(scopes))
+	for _, s := range scopes {
+		scope := NormalizeScope(s)
+		known := false
+		for _, k := range KnownScopes {
+			if scope == k {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown permission %q (allowed: %s)", s, strings.Join(KnownScopes, ", "))
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			result = append(result, scope)
+		}
+	}
+	return result, nil
+}
+
+// HasScope 檢查權限列表是否包含指定權限 (admin 涵蓋所有權限)
+func HasScope(granted []string, scope string) bool {
+	for _, g := range granted {
+		g = NormalizeScope(g)
+		if g == scope || g == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
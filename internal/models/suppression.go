@@ -0,0 +1,32 @@
+// internal/models/suppression.go
+// 收件者抑制清單資料模型
+
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// 抑制原因
+const (
+	SuppressionReasonUnsubscribe = "unsubscribe" // 收件者透過 List-Unsubscribe 退訂
+)
+
+// Suppression 收件者抑制清單
+// ClientID 為空白表示全域抑制，否則只對該 Client 生效；
+// Category 不為空白時只抑制該 Client 帶有相同退訂類別的郵件
+type Suppression struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	Email     string    `json:"email" gorm:"not null;uniqueIndex:idx_suppressions_email_client"`
+	ClientID  string    `json:"client_id,omitempty" gorm:"not null;default:'';uniqueIndex:idx_suppressions_email_client"`
+	Category  string    `json:"category,omitempty" gorm:"not null;default:'';uniqueIndex:idx_suppressions_email_client"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// TableName 指定資料表名稱
+func (Suppression) TableName() string {
+	return "suppressions"
+}
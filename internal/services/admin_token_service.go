@@ -28,15 +28,17 @@ const (
 
 // AdminTokenService Admin Token 初始化服務
 type AdminTokenService struct {
-	cfg *config.Config
-	db  *gorm.DB
+	cfg     *config.Config
+	db      *gorm.DB
+	jwtKeys *JWTKeyService
 }
 
 // NewAdminTokenService 建立 Admin Token 服務
-func NewAdminTokenService(cfg *config.Config, db *gorm.DB) *AdminTokenService {
+func NewAdminTokenService(cfg *config.Config, db *gorm.DB, jwtKeys *JWTKeyService) *AdminTokenService {
 	return &AdminTokenService{
-		cfg: cfg,
-		db:  db,
+		cfg:     cfg,
+		db:      db,
+		jwtKeys: jwtKeys,
 	}
 }
 
@@ -84,7 +86,7 @@ func (s *AdminTokenService) InitializeAdminToken() error {
 // createNewToken 建立新的 MIS Admin Token
 func (s *AdminTokenService) createNewToken() error {
 	// 建立 JWT Token (永久有效)
-	tokenString, err := s.generateJWTToken()
+	tokenString, kid, err := s.generateJWTToken()
 	if err != nil {
 		return err
 	}
@@ -101,6 +103,7 @@ func (s *AdminTokenService) createNewToken() error {
 		Department:  MISAdminDepartment,
 		Permissions: pq.StringArray{"admin"},
 		TokenHash:   tokenHash,
+		SigningKID:  kid,
 		IsActive:    true,
 	}
 
@@ -117,7 +120,7 @@ func (s *AdminTokenService) createNewToken() error {
 // regenerateToken 重新生成 Token (針對已撤銷的 Token)
 func (s *AdminTokenService) regenerateToken(existingToken *models.ClientToken) error {
 	// 建立 JWT Token (永久有效)
-	tokenString, err := s.generateJWTToken()
+	tokenString, kid, err := s.generateJWTToken()
 	if err != nil {
 		return err
 	}
@@ -128,6 +131,10 @@ func (s *AdminTokenService) regenerateToken(existingToken *models.ClientToken) e
 
 	// 更新資料庫
 	existingToken.TokenHash = tokenHash
+	existingToken.SigningKID = kid
+	existingToken.PreviousTokenHash = ""
+	existingToken.PreviousTokenExpiresAt = nil
+	existingToken.ExpiresAt = nil
 	existingToken.IsActive = true
 	existingToken.RevokedAt = nil
 	existingToken.ClientName = s.cfg.AdminTokenName
@@ -142,8 +149,8 @@ func (s *AdminTokenService) regenerateToken(existingToken *models.ClientToken) e
 	return nil
 }
 
-// generateJWTToken 生成 JWT Token
-func (s *AdminTokenService) generateJWTToken() (string, error) {
+// generateJWTToken 生成 JWT Token，回傳 Token 與簽章金鑰 kid
+func (s *AdminTokenService) generateJWTToken() (string, string, error) {
 	now := time.Now()
 	claims := jwt.MapClaims{
 		"iss":         "mail-proxy-system",
@@ -155,8 +162,7 @@ func (s *AdminTokenService) generateJWTToken() (string, error) {
 		"permissions": []string{"admin"},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(s.cfg.JWTSecret))
+	return s.jwtKeys.Sign(claims)
 }
 
 // printTokenToLogs 輸出 Token 到 logs
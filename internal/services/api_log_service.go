@@ -0,0 +1,249 @@
+// internal/services/api_log_service.go
+// API 請求日誌服務 - 非同步批次寫入 api_logs、查詢與依保留期限清除分區
+
+package services
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+
+	"mail-proxy/internal/config"
+	"mail-proxy/internal/models"
+)
+
+// apiLogPartitionPattern api_logs 月分區名稱 (api_logs_yYYYYmMM)
+var apiLogPartitionPattern = regexp.MustCompile(`^api_logs_y(\d{4})m(\d{2})$`)
+
+// APILogService API 請求日誌服務
+// Record 只將日誌放入緩衝區，由背景 goroutine 累積成批寫入，不阻塞請求
+type APILogService struct {
+	cfg     *config.Config
+	db      *gorm.DB
+	entries chan *models.APILog
+	quit    chan struct{}
+	wg      sync.WaitGroup
+	dropped atomic.Int64
+	once    sync.Once
+}
+
+// NewAPILogService 建立 API 請求日誌服務
+func NewAPILogService(cfg *config.Config, db *gorm.DB) *APILogService {
+	batchSize := cfg.APILogBatchSize
+	if batchSize <= 0 {
+		batchSize = 200
+	}
+	return &APILogService{
+		cfg:     cfg,
+		db:      db,
+		entries: make(chan *models.APILog, batchSize*10),
+		quit:    make(chan struct{}),
+	}
+}
+
+// Start 啟動批次寫入與分區維護的背景 goroutine
+func (s *APILogService) Start() {
+	s.wg.Add(2)
+	go s.runWriter()
+	go s.runMaintenance()
+}
+
+// Close 停止背景 goroutine，並寫入緩衝區內剩餘的日誌
+func (s *APILogService) Close() {
+	s.once.Do(func() {
+		close(s.quit)
+		s.wg.Wait()
+	})
+}
+
+// Record 非同步記錄一筆請求日誌；緩衝區已滿時捨棄並計數
+func (s *APILogService) Record(entry *models.APILog) {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	select {
+	case s.entries <- entry:
+	default:
+		if n := s.dropped.Add(1); n == 1 || n%1000 == 0 {
+			log.Printf("API log buffer full, %d entries dropped so far", n)
+		}
+	}
+}
+
+// runWriter 累積日誌，達到批次大小或間隔時間時寫入資料庫
+func (s *APILogService) runWriter() {
+	defer s.wg.Done()
+
+	batchSize := cap(s.entries) / 10
+	interval := time.Duration(s.cfg.APILogFlushIntervalMs) * time.Millisecond
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	batch := make([]*models.APILog, 0, batchSize)
+	for {
+		select {
+		case entry := <-s.entries:
+			batch = append(batch, entry)
+			if len(batch) >= batchSize {
+				batch = s.flush(batch)
+			}
+		case <-ticker.C:
+			batch = s.flush(batch)
+		case <-s.quit:
+			for {
+				select {
+				case entry := <-s.entries:
+					batch = append(batch, entry)
+				default:
+					s.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush 寫入一批日誌，回傳清空後的切片供重複使用
+func (s *APILogService) flush(batch []*models.APILog) []*models.APILog {
+	if len(batch) == 0 {
+		return batch
+	}
+	if err := s.db.CreateInBatches(batch, len(batch)).Error; err != nil {
+		log.Printf("Failed to write %d API logs: %v", len(batch), err)
+	}
+	return batch[:0]
+}
+
+// runMaintenance 啟動時與每日建立後續月分區並清除過期分區
+func (s *APILogService) runMaintenance() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+		if err := s.EnsurePartitions(now); err != nil {
+			log.Printf("Failed to create API log partitions: %v", err)
+		}
+		if dropped, err := s.PrunePartitions(now); err != nil {
+			log.Printf("Failed to prune API log partitions: %v", err)
+		} else if len(dropped) > 0 {
+			log.Printf("Pruned API log partitions: %v", dropped)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// EnsurePartitions 建立當月與下個月的 api_logs 分區
+func (s *APILogService) EnsurePartitions(now time.Time) error {
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		start := month.AddDate(0, i, 0)
+		end := start.AddDate(0, 1, 0)
+		sql := fmt.Sprintf(
+			"CREATE TABLE IF NOT EXISTS api_logs_y%04dm%02d PARTITION OF api_logs FOR VALUES FROM ('%s') TO ('%s')",
+			start.Year(), int(start.Month()), start.Format(time.RFC3339), end.Format(time.RFC3339),
+		)
+		if err := s.db.Exec(sql).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PrunePartitions 刪除整個月份都超過保留天數的分區，並清除預設分區內的過期資料
+// API_LOG_RETENTION_DAYS <= 0 表示永久保留
+func (s *APILogService) PrunePartitions(now time.Time) ([]string, error) {
+	if s.cfg.APILogRetentionDays <= 0 {
+		return nil, nil
+	}
+	cutoff := now.AddDate(0, 0, -s.cfg.APILogRetentionDays)
+
+	var partitions []string
+	err := s.db.Raw(`
+		SELECT c.relname FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		JOIN pg_class p ON p.oid = i.inhparent
+		WHERE p.relname = 'api_logs'`).Scan(&partitions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var dropped []string
+	for _, name := range partitions {
+		m := apiLogPartitionPattern.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		start, err := time.Parse("2006-01", m[1]+"-"+m[2])
+		if err != nil {
+			continue
+		}
+		if !start.AddDate(0, 1, 0).After(cutoff) {
+			if err := s.db.Exec("DROP TABLE IF EXISTS " + name).Error; err != nil {
+				return dropped, err
+			}
+			dropped = append(dropped, name)
+		}
+	}
+
+	if err := s.db.Exec("DELETE FROM api_logs_default WHERE created_at < ?", cutoff).Error; err != nil {
+		return dropped, err
+	}
+	return dropped, nil
+}
+
+// Query 依條件分頁查詢請求日誌 (依時間新到舊)
+func (s *APILogService) Query(filter *models.APILogFilter) ([]models.APILog, int64, error) {
+	query := s.db.Model(&models.APILog{})
+	if filter.ClientID != "" {
+		query = query.Where("client_id = ?", filter.ClientID)
+	}
+	if filter.Endpoint != "" {
+		query = query.Where("endpoint LIKE ?", filter.Endpoint+"%")
+	}
+	if filter.Method != "" {
+		query = query.Where("method = ?", filter.Method)
+	}
+	if filter.StatusCode != 0 {
+		query = query.Where("status_code = ?", filter.StatusCode)
+	}
+	if filter.MailID != "" {
+		query = query.Where("mail_id = ?", filter.MailID)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var logs []models.APILog
+	err := query.Order("created_at DESC").
+		Offset((filter.Page - 1) * filter.Limit).
+		Limit(filter.Limit).
+		Find(&logs).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return logs, total, nil
+}
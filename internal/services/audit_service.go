@@ -0,0 +1,198 @@
+// internal/services/audit_service.go
+// 管理操作稽核服務 - 以 Hash 鏈結寫入只能新增的 audit_events
+
+package services
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"mail-proxy/internal/models"
+)
+
+// auditChainLockKey 寫入稽核紀錄時序列化的 advisory lock 鍵值
+const auditChainLockKey = 0x61756474 // "audt"
+
+// auditSecretMarkers 欄位名稱包含這些字串時視為機密，稽核紀錄只記錄是否變更
+var auditSecretMarkers = []string{"secret", "password", "token_hash", "private_key", "encrypted"}
+
+// AuditService 管理操作稽核服務
+type AuditService struct {
+	db *gorm.DB
+}
+
+// NewAuditService 建立稽核服務
+func NewAuditService(db *gorm.DB) *AuditService {
+	return &AuditService{db: db}
+}
+
+// Diff 比較變更前後的欄位快照，只保留有變更的欄位並遮罩機密值
+// before 為 nil 表示新增，after 為 nil 表示刪除
+func (s *AuditService) Diff(before, after map[string]interface{}) models.AuditChanges {
+	changes := models.AuditChanges{}
+	for key, b := range before {
+		a, ok := after[key]
+		if after != nil && ok && reflect.DeepEqual(a, b) {
+			continue
+		}
+		changes[key] = redactChange(key, b, a)
+	}
+	for key, a := range after {
+		if _, ok := before[key]; ok {
+			continue
+		}
+		changes[key] = redactChange(key, nil, a)
+	}
+	return changes
+}
+
+// redactChange 建立欄位變更，機密欄位的非空值以 AuditRedacted 取代
+func redactChange(key string, before, after interface{}) models.AuditChange {
+	lower := strings.ToLower(key)
+	for _, marker := range auditSecretMarkers {
+		if strings.Contains(lower, marker) {
+			return models.AuditChange{Before: redactValue(before), After: redactValue(after)}
+		}
+	}
+	return models.AuditChange{Before: before, After: after}
+}
+
+func redactValue(v interface{}) interface{} {
+	if v == nil || v == "" {
+		return v
+	}
+	return models.AuditRedacted
+}
+
+// Record 將稽核紀錄接在鏈結最後一筆之後寫入
+// 以 advisory lock 序列化寫入，多個 API 實例同時寫入時鏈結仍為單一序列
+func (s *AuditService) Record(event *models.AuditEvent) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", auditChainLockKey).Error; err != nil {
+			return err
+		}
+
+		var prevHash string
+		err := tx.Model(&models.AuditEvent{}).
+			Order("id DESC").
+			Limit(1).
+			Pluck("hash", &prevHash).Error
+		if err != nil {
+			return err
+		}
+
+		// PostgreSQL 時間精度為微秒，先截斷以便日後重新計算 Hash
+		event.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+		event.PrevHash = prevHash
+		if event.Changes == nil {
+			event.Changes = models.AuditChanges{}
+		}
+		if event.Hash, err = event.ComputeHash(); err != nil {
+			return err
+		}
+		return tx.Create(event).Error
+	})
+}
+
+// filterQuery 依條件建立查詢
+func (s *AuditService) filterQuery(filter *models.AuditEventFilter) *gorm.DB {
+	query := s.db.Model(&models.AuditEvent{})
+	if filter.ActorClientID != "" {
+		query = query.Where("actor_client_id = ?", filter.ActorClientID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.TargetType != "" {
+		query = query.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		query = query.Where("target_id = ?", filter.TargetID)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+	return query
+}
+
+// List 依條件分頁查詢稽核紀錄 (依時間新到舊)
+func (s *AuditService) List(filter *models.AuditEventFilter) ([]models.AuditEvent, int64, error) {
+	query := s.filterQuery(filter)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var events []models.AuditEvent
+	err := query.Order("id DESC").
+		Offset((filter.Page - 1) * filter.Limit).
+		Limit(filter.Limit).
+		Find(&events).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
+
+// Export 依鏈結順序逐批讀取符合條件的稽核紀錄 (不分頁)
+func (s *AuditService) Export(filter *models.AuditEventFilter, fn func(*models.AuditEvent) error) error {
+	var batch []models.AuditEvent
+	return s.filterQuery(filter).FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			if err := fn(&batch[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+// Verify 依序重新計算整條鏈結，回報第一筆 Hash 或 prev_hash 不符的紀錄
+func (s *AuditService) Verify() (*models.AuditVerifyResult, error) {
+	result := &models.AuditVerifyResult{Valid: true}
+	prevHash := ""
+
+	var batch []models.AuditEvent
+	err := s.db.Model(&models.AuditEvent{}).FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			event := &batch[i]
+			if !result.Valid {
+				return nil
+			}
+			result.Checked++
+
+			if event.PrevHash != prevHash {
+				result.Valid = false
+				result.BrokenAtID = event.ID
+				result.BrokenError = "prev_hash does not match the previous event (events removed or reordered)"
+				return nil
+			}
+			hash, err := event.ComputeHash()
+			if err != nil {
+				return fmt.Errorf("failed to hash audit event %d: %w", event.ID, err)
+			}
+			if hash != event.Hash {
+				result.Valid = false
+				result.BrokenAtID = event.ID
+				result.BrokenError = "hash does not match event content (event modified)"
+				return nil
+			}
+			prevHash = event.Hash
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result.LastHash = prevHash
+	return result, nil
+}
@@ -0,0 +1,325 @@
+// internal/services/content_pipeline.go
+// 內容處理 - API 排入佇列前的 CSS 內嵌、HTML 消毒與純文字產生
+
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+
+	"mail-proxy/internal/config"
+	"mail-proxy/internal/models"
+)
+
+// ContentPipeline 內容處理 (依設定啟用各階段，順序為 CSS 內嵌 → HTML 消毒 → 產生純文字)
+type ContentPipeline struct {
+	generateText bool
+	sanitize     bool
+	inlineCSS    bool
+
+	allowedTags    map[string]bool
+	allowedAttrs   map[string]bool
+	allowedSchemes map[string]bool
+}
+
+// ContentResult 內容處理結果
+type ContentResult struct {
+	Body         string
+	HTML         string
+	OriginalHTML string                    // HTML 有變更時為處理前的內容
+	Processing   *models.ContentProcessing // 未做任何處理時為 nil
+}
+
+// structuralTags 文件結構標籤 (不受允許清單限制，屬性仍會過濾)
+var structuralTags = map[string]bool{
+	"html": true, "head": true, "body": true, "title": true,
+}
+
+// dropContentTags 不在允許清單時連同內容一併移除的標籤 (其餘標籤只移除標籤本身、保留內容)
+var dropContentTags = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true, "applet": true,
+	"noscript": true, "noembed": true, "noframes": true, "template": true, "frame": true,
+	"frameset": true, "xmp": true, "plaintext": true, "textarea": true, "select": true,
+}
+
+// urlAttributes 值為 URL 的屬性 (需檢查 scheme)
+var urlAttributes = map[string]bool{
+	"href": true, "src": true, "background": true, "cite": true, "action": true,
+	"formaction": true, "poster": true, "longdesc": true, "usemap": true, "srcset": true,
+}
+
+var (
+	// cssCommentPattern CSS 註解
+	cssCommentPattern = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	// cssURLPattern CSS url(...) 參數
+	cssURLPattern = regexp.MustCompile(`url\(\s*['"]?([^'")]*)['"]?\s*\)`)
+	// unsafeCSSPattern 可執行程式碼或載入外部資源的 CSS 語法
+	unsafeCSSPattern = regexp.MustCompile(`expression\s*\(|javascript:|vbscript:|behavior\s*:|-moz-binding|@import`)
+	// htmlDocumentPattern 判斷輸入是否為完整 HTML 文件 (否則只輸出 body 內容)
+	htmlDocumentPattern = regexp.MustCompile(`(?i)<(!doctype|html)[\s>]`)
+)
+
+// NewContentPipeline 建立內容處理
+func NewContentPipeline(cfg *config.Config) *ContentPipeline {
+	return &ContentPipeline{
+		generateText:   cfg.ContentGenerateText,
+		sanitize:       cfg.ContentSanitizeHTML,
+		inlineCSS:      cfg.ContentInlineCSS,
+		allowedTags:    lowerSet(cfg.ContentAllowedTags),
+		allowedAttrs:   lowerSet(cfg.ContentAllowedAttrs),
+		allowedSchemes: lowerSet(cfg.ContentAllowedURLSchemes),
+	}
+}
+
+// lowerSet 轉為小寫集合
+func lowerSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[strings.ToLower(strings.TrimSpace(v))] = true
+	}
+	return set
+}
+
+// Process 處理郵件內文
+// 只有在 CSS 內嵌或消毒實際改變 HTML 時才重新輸出，否則保留原始 HTML
+func (p *ContentPipeline) Process(body, htmlContent string) (*ContentResult, error) {
+	result := &ContentResult{Body: body, HTML: htmlContent}
+	needText := p.generateText && strings.TrimSpace(body) == ""
+	if htmlContent == "" || (!p.inlineCSS && !p.sanitize && !needText) {
+		return result, nil
+	}
+
+	doc, err := html.Parse(strings.NewReader(htmlContent))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse html: %w", err)
+	}
+
+	processing := &models.ContentProcessing{}
+	changed := false
+	if p.inlineCSS && inlineCSS(doc) {
+		processing.CSSInlined = true
+		changed = true
+	}
+	if p.sanitize {
+		report := &sanitizeReport{tags: map[string]bool{}, attrs: map[string]bool{}}
+		p.sanitizeChildren(doc, report)
+		processing.Sanitized = true
+		processing.RemovedTags = report.sorted(report.tags)
+		processing.RemovedAttributes = report.sorted(report.attrs)
+		if len(report.tags) > 0 || len(report.attrs) > 0 {
+			changed = true
+		}
+	}
+
+	if changed {
+		rendered, err := renderHTML(doc, htmlDocumentPattern.MatchString(htmlContent))
+		if err != nil {
+			return nil, fmt.Errorf("failed to render html: %w", err)
+		}
+		result.HTML = rendered
+		result.OriginalHTML = htmlContent
+	}
+
+	if needText {
+		if text := htmlToText(doc); text != "" {
+			result.Body = text
+			processing.TextGenerated = true
+		}
+	}
+
+	if !processing.IsZero() {
+		result.Processing = processing
+	}
+	return result, nil
+}
+
+// renderHTML 輸出 HTML；片段輸入只輸出 head 與 body 的內容
+// (片段開頭的 <style> 等標籤會被解析器移入 head，需一併輸出)
+func renderHTML(doc *html.Node, document bool) (string, error) {
+	var buf bytes.Buffer
+	if document {
+		if err := html.Render(&buf, doc); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+
+	for _, tag := range []string{"head", "body"} {
+		parent := findElement(doc, tag)
+		if parent == nil {
+			continue
+		}
+		for c := parent.FirstChild; c != nil; c = c.NextSibling {
+			if err := html.Render(&buf, c); err != nil {
+				return "", err
+			}
+		}
+	}
+	return buf.String(), nil
+}
+
+// findElement 深度優先尋找第一個指定標籤
+func findElement(n *html.Node, tag string) *html.Node {
+	if n.Type == html.ElementNode && n.Data == tag {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, tag); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// sanitizeReport 消毒時移除的標籤與屬性
+type sanitizeReport struct {
+	tags  map[string]bool
+	attrs map[string]bool
+}
+
+// sorted 排序後的清單
+func (r *sanitizeReport) sorted(set map[string]bool) []string {
+	if len(set) == 0 {
+		return nil
+	}
+	list := make([]string, 0, len(set))
+	for v := range set {
+		list = append(list, v)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// sanitizeChildren 依允許清單過濾子節點：移除註解、不允許的標籤 (保留或移除內容) 與屬性
+func (p *ContentPipeline) sanitizeChildren(n *html.Node, report *sanitizeReport) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+
+		switch c.Type {
+		case html.CommentNode:
+			// 註解 (含 Outlook 條件式註解) 可夾帶任意標記，一律移除
+			report.tags["comment"] = true
+			n.RemoveChild(c)
+
+		case html.ElementNode:
+			tag := c.Data
+			switch {
+			case c.Namespace != "":
+				// SVG / MathML 有獨立的解析規則，不納入允許清單
+				report.tags[tag] = true
+				n.RemoveChild(c)
+			case structuralTags[tag]:
+				p.sanitizeAttrs(c, report)
+				p.sanitizeChildren(c, report)
+			case !p.allowedTags[tag]:
+				report.tags[tag] = true
+				if !dropContentTags[tag] {
+					// 保留內容：先過濾子節點，再移到原標籤的位置
+					p.sanitizeChildren(c, report)
+					for gc := c.FirstChild; gc != nil; {
+						gnext := gc.NextSibling
+						c.RemoveChild(gc)
+						n.InsertBefore(gc, c)
+						gc = gnext
+					}
+				}
+				n.RemoveChild(c)
+			case tag == "style" && p.unsafeCSS(nodeText(c)):
+				report.tags[tag] = true
+				n.RemoveChild(c)
+			default:
+				p.sanitizeAttrs(c, report)
+				p.sanitizeChildren(c, report)
+			}
+		}
+
+		c = next
+	}
+}
+
+// sanitizeAttrs 移除不在允許清單、事件處理或含不安全 URL / CSS 的屬性
+func (p *ContentPipeline) sanitizeAttrs(n *html.Node, report *sanitizeReport) {
+	attrs := n.Attr[:0]
+	for _, attr := range n.Attr {
+		key := strings.ToLower(attr.Key)
+		ok := attr.Namespace == "" && p.allowedAttrs[key] && !strings.HasPrefix(key, "on")
+		if ok && urlAttributes[key] {
+			ok = p.safeURLs(key, attr.Val)
+		}
+		if ok && key == "style" {
+			ok = !p.unsafeCSS(attr.Val)
+		}
+		if !ok {
+			report.attrs[n.Data+"."+key] = true
+			continue
+		}
+		attrs = append(attrs, attr)
+	}
+	n.Attr = attrs
+}
+
+// safeURLs 檢查 URL 屬性 (srcset 為逗號分隔的多個 URL)
+func (p *ContentPipeline) safeURLs(key, value string) bool {
+	if key != "srcset" {
+		return p.safeURL(value)
+	}
+	for _, candidate := range strings.Split(value, ",") {
+		if fields := strings.Fields(candidate); len(fields) > 0 && !p.safeURL(fields[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+// safeURL 相對 URL 或 scheme 在允許清單內
+// 瀏覽器會忽略 URL 中的空白與控制字元 (如 "java\tscript:")，檢查前先移除
+func (p *ContentPipeline) safeURL(raw string) bool {
+	cleaned := strings.Map(func(r rune) rune {
+		if r <= 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, raw)
+
+	i := strings.IndexAny(cleaned, ":/?#")
+	if i <= 0 || cleaned[i] != ':' {
+		return true // 相對 URL、錨點或 //host
+	}
+	return p.allowedSchemes[strings.ToLower(cleaned[:i])]
+}
+
+// unsafeCSS CSS 是否含可執行程式碼、跳脫字元或不允許的 url()
+func (p *ContentPipeline) unsafeCSS(css string) bool {
+	css = strings.ToLower(cssCommentPattern.ReplaceAllString(css, ""))
+	// 反斜線跳脫可繞過關鍵字比對 (如 "\65xpression")
+	if strings.Contains(css, `\`) || unsafeCSSPattern.MatchString(css) {
+		return true
+	}
+	for _, m := range cssURLPattern.FindAllStringSubmatch(css, -1) {
+		if !p.safeURL(m[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeText 節點下所有文字節點的內容
+func nodeText(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return b.String()
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestContentPipeline 以精簡的允許清單建立內容處理 (svg 列入允許清單，確認仍依命名空間移除)
+func newTestContentPipeline() *ContentPipeline {
+	return &ContentPipeline{
+		generateText:   true,
+		sanitize:       true,
+		inlineCSS:      true,
+		allowedTags:    lowerSet([]string{"a", "b", "p", "img", "style", "svg", "ul", "li", "table", "tr", "td"}),
+		allowedAttrs:   lowerSet([]string{"href", "src", "srcset", "style", "class", "alt"}),
+		allowedSchemes: lowerSet([]string{"http", "https", "mailto", "cid"}),
+	}
+}
+
+func TestContentPipelineSanitize(t *testing.T) {
+	tests := []struct {
+		name         string
+		html         string
+		wantHTML     string
+		removedTags  []string
+		removedAttrs []string
+	}{
+		{
+			name:        "script with content",
+			html:        `<p>hi</p><script>alert(1)</script>`,
+			wantHTML:    `<p>hi</p>`,
+			removedTags: []string{"script"},
+		},
+		{
+			name:        "iframe",
+			html:        `<p>hi</p><iframe src="https://example.com/"><p>fallback</p></iframe>`,
+			wantHTML:    `<p>hi</p>`,
+			removedTags: []string{"iframe"},
+		},
+		{
+			name:        "conditional comment",
+			html:        `<!--[if mso]><b>outlook</b><![endif]--><p>hi</p>`,
+			wantHTML:    `<p>hi</p>`,
+			removedTags: []string{"comment"},
+		},
+		{
+			name:        "disallowed tag keeps content",
+			html:        `<p><u>under</u>line</p>`,
+			wantHTML:    `<p>underline</p>`,
+			removedTags: []string{"u"},
+		},
+		{
+			name:        "svg namespace",
+			html:        `<p>hi</p><svg><a href="https://example.com/">link</a><text>secret</text></svg>`,
+			wantHTML:    `<p>hi</p>`,
+			removedTags: []string{"svg"},
+		},
+		{
+			name:         "javascript href",
+			html:         `<a href="javascript:alert(1)">x</a>`,
+			wantHTML:     `<a>x</a>`,
+			removedAttrs: []string{"a.href"},
+		},
+		{
+			name:         "javascript href with tab",
+			html:         `<a href="java&#9;script:alert(1)">x</a>`,
+			wantHTML:     `<a>x</a>`,
+			removedAttrs: []string{"a.href"},
+		},
+		{
+			name:         "uppercase scheme",
+			html:         `<a href="JAVASCRIPT:alert(1)">x</a>`,
+			wantHTML:     `<a>x</a>`,
+			removedAttrs: []string{"a.href"},
+		},
+		{
+			name:         "srcset candidate",
+			html:         `<img src="a.png" srcset="a.png 1x, javascript:alert(1) 2x">`,
+			wantHTML:     `<img src="a.png"/>`,
+			removedAttrs: []string{"img.srcset"},
+		},
+		{
+			name:         "event handler",
+			html:         `<p onclick="alert(1)">hi</p>`,
+			wantHTML:     `<p>hi</p>`,
+			removedAttrs: []string{"p.onclick"},
+		},
+		{
+			name:         "css expression",
+			html:         `<p style="width: expression(alert(1))">hi</p>`,
+			wantHTML:     `<p>hi</p>`,
+			removedAttrs: []string{"p.style"},
+		},
+		{
+			name:         "escaped css expression",
+			html:         `<p style="width: \65xpression(alert(1))">hi</p>`,
+			wantHTML:     `<p>hi</p>`,
+			removedAttrs: []string{"p.style"},
+		},
+		{
+			name:         "css url javascript",
+			html:         `<p style="background: url( 'javascript:alert(1)' )">hi</p>`,
+			wantHTML:     `<p>hi</p>`,
+			removedAttrs: []string{"p.style"},
+		},
+		{
+			name:         "css url with comment",
+			html:         `<p style="background: url(java/**/script:alert(1))">hi</p>`,
+			wantHTML:     `<p>hi</p>`,
+			removedAttrs: []string{"p.style"},
+		},
+		{
+			name:        "style element with expression",
+			html:        `<style>@media screen { p { width: expression(alert(1)) } }</style><p>hi</p>`,
+			wantHTML:    `<p>hi</p>`,
+			removedTags: []string{"style"},
+		},
+		{
+			name:     "safe urls kept",
+			html:     `<a href="https://example.com/">a</a><a href="/path">b</a><a href="mailto:a@example.com">c</a><img src="cid:logo">`,
+			wantHTML: `<a href="https://example.com/">a</a><a href="/path">b</a><a href="mailto:a@example.com">c</a><img src="cid:logo">`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 只測消毒，避免 CSS 內嵌改動輸出
+			p := newTestContentPipeline()
+			p.inlineCSS = false
+
+			result, err := p.Process("text", tt.html)
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if result.HTML != tt.wantHTML {
+				t.Errorf("HTML = %q, want %q", result.HTML, tt.wantHTML)
+			}
+			if result.Processing == nil || !result.Processing.Sanitized {
+				t.Fatalf("Processing = %+v, want sanitized", result.Processing)
+			}
+			if !reflect.DeepEqual(result.Processing.RemovedTags, tt.removedTags) {
+				t.Errorf("RemovedTags = %v, want %v", result.Processing.RemovedTags, tt.removedTags)
+			}
+			if !reflect.DeepEqual(result.Processing.RemovedAttributes, tt.removedAttrs) {
+				t.Errorf("RemovedAttributes = %v, want %v", result.Processing.RemovedAttributes, tt.removedAttrs)
+			}
+			wantOriginal := ""
+			if result.HTML != tt.html {
+				wantOriginal = tt.html
+			}
+			if result.OriginalHTML != wantOriginal {
+				t.Errorf("OriginalHTML = %q, want %q", result.OriginalHTML, wantOriginal)
+			}
+		})
+	}
+}
+
+func TestContentPipelineUnchangedPassthrough(t *testing.T) {
+	// 內容未變更時保留原始 HTML (不經重新輸出，屬性引號與空白維持原樣)
+	input := "<p class='greeting'>Hello   <b>world</b></p>\n<a href=https://example.com/>link</a>"
+	result, err := newTestContentPipeline().Process("Hello world", input)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if result.HTML != input {
+		t.Errorf("HTML = %q, want unchanged %q", result.HTML, input)
+	}
+	if result.OriginalHTML != "" {
+		t.Errorf("OriginalHTML = %q, want empty", result.OriginalHTML)
+	}
+	if result.Body != "Hello world" {
+		t.Errorf("Body = %q, want the given text", result.Body)
+	}
+	if result.Processing == nil || result.Processing.CSSInlined || result.Processing.TextGenerated {
+		t.Errorf("Processing = %+v, want only sanitized", result.Processing)
+	}
+}
+
+func TestContentPipelineDisabled(t *testing.T) {
+	input := `<p onclick="x">hi</p>`
+	result, err := (&ContentPipeline{}).Process("", input)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if result.HTML != input || result.Body != "" || result.Processing != nil {
+		t.Errorf("Process() = %+v, want input returned untouched", result)
+	}
+}
+
+func TestContentPipelineGenerateText(t *testing.T) {
+	p := newTestContentPipeline()
+	result, err := p.Process("", `<p>Hello <a href="https://example.com/">site</a></p>`)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if result.Body != "Hello site (https://example.com/)" {
+		t.Errorf("Body = %q", result.Body)
+	}
+	if result.Processing == nil || !result.Processing.TextGenerated {
+		t.Errorf("Processing = %+v, want text generated", result.Processing)
+	}
+
+	// 已有純文字時不覆寫
+	result, err = p.Process("given", `<p>Hello</p>`)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if result.Body != "given" || (result.Processing != nil && result.Processing.TextGenerated) {
+		t.Errorf("Process() = %+v, want body kept", result)
+	}
+}
+
+func TestContentPipelineDocumentOutput(t *testing.T) {
+	// 完整文件輸出 html / head / body，片段只輸出內容
+	p := newTestContentPipeline()
+	result, err := p.Process("x", `<!DOCTYPE html><html><head><title>t</title></head><body><p onclick="x">hi</p></body></html>`)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if !strings.HasPrefix(result.HTML, "<!DOCTYPE html><html><head><title>t</title></head><body>") {
+		t.Errorf("HTML = %q, want full document", result.HTML)
+	}
+}
@@ -0,0 +1,361 @@
+// internal/services/css_inliner.go
+// CSS 內嵌 - 將 <style> 中的簡單選擇器規則寫入元素的 style 屬性 (許多郵件用戶端會忽略 <style>)
+
+package services
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// cssCompoundPattern 可內嵌的複合選擇器 (標籤、*、.class、#id 的組合)
+// 屬性選擇器、虛擬類別 (:hover) 與虛擬元素無法內嵌，保留於 <style>
+var cssCompoundPattern = regexp.MustCompile(`^(\*|[A-Za-z][A-Za-z0-9-]*)?((?:[.#][A-Za-z_-][A-Za-z0-9_-]*)*)$`)
+
+// cssSimplePattern 複合選擇器中的 .class / #id
+var cssSimplePattern = regexp.MustCompile(`[.#][^.#]+`)
+
+// cssRule 可內嵌的 CSS 規則 (單一選擇器)
+type cssRule struct {
+	compounds    []cssCompound // 由左至右
+	combinators  []byte        // compounds 之間的組合子：' ' (子孫) 或 '>' (子元素)
+	specificity  [3]int        // id、class、標籤數
+	order        int           // 出現順序
+	declarations []cssDeclaration
+}
+
+// cssCompound 複合選擇器
+type cssCompound struct {
+	tag     string
+	id      string
+	classes []string
+}
+
+// cssDeclaration CSS 宣告
+type cssDeclaration struct {
+	property string
+	value    string
+}
+
+// inlineCSS 將 <style> 規則內嵌至元素，回傳是否有變更
+// 已內嵌的規則自 <style> 移除，@media 等無法內嵌的規則保留；原有 style 屬性優先於樣式表 (!important 除外)
+func inlineCSS(doc *html.Node) bool {
+	var styles []*html.Node
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Namespace == "" && n.Data == "style" {
+			styles = append(styles, n)
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			collect(c)
+		}
+	}
+	collect(doc)
+
+	var rules []cssRule
+	order := 0
+	for _, style := range styles {
+		switch strings.ToLower(strings.TrimSpace(htmlAttr(style, "media"))) {
+		case "", "all", "screen":
+		default:
+			continue
+		}
+
+		parsed, remaining := parseCSS(nodeText(style), &order)
+		if len(parsed) == 0 {
+			continue
+		}
+		rules = append(rules, parsed...)
+
+		if strings.TrimSpace(remaining) == "" {
+			style.Parent.RemoveChild(style)
+			continue
+		}
+		for c := style.FirstChild; c != nil; {
+			next := c.NextSibling
+			style.RemoveChild(c)
+			c = next
+		}
+		style.AppendChild(&html.Node{Type: html.TextNode, Data: remaining})
+	}
+	if len(rules) == 0 {
+		return false
+	}
+
+	sort.SliceStable(rules, func(i, j int) bool {
+		a, b := rules[i], rules[j]
+		for k := 0; k < 3; k++ {
+			if a.specificity[k] != b.specificity[k] {
+				return a.specificity[k] < b.specificity[k]
+			}
+		}
+		return a.order < b.order
+	})
+
+	if body := findElement(doc, "body"); body != nil {
+		applyCSSRules(body, rules)
+	}
+	return true
+}
+
+// applyCSSRules 依特異性由低至高套用規則，再以原有 style 屬性與 !important 宣告覆寫
+func applyCSSRules(n *html.Node, rules []cssRule) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.Namespace != "" {
+			continue
+		}
+
+		var normal, important []cssDeclaration
+		for i := range rules {
+			if !rules[i].matches(c) {
+				continue
+			}
+			for _, d := range rules[i].declarations {
+				if strings.HasSuffix(strings.ToLower(d.value), "!important") {
+					important = append(important, d)
+				} else {
+					normal = append(normal, d)
+				}
+			}
+		}
+
+		if len(normal) > 0 || len(important) > 0 {
+			existing := parseDeclarations(htmlAttr(c, "style"))
+			merged := mergeDeclarations(normal, existing, important)
+			setHTMLAttr(c, "style", formatDeclarations(merged))
+		}
+
+		applyCSSRules(c, rules)
+	}
+}
+
+// parseCSS 解析樣式表，回傳可內嵌的規則與需保留於 <style> 的內容
+func parseCSS(css string, order *int) ([]cssRule, string) {
+	css = cssCommentPattern.ReplaceAllString(css, "")
+
+	var rules []cssRule
+	var remaining strings.Builder
+	for i := 0; i < len(css); {
+		for i < len(css) && strings.ContainsRune(" \t\r\n\f", rune(css[i])) {
+			i++
+		}
+		if i >= len(css) {
+			break
+		}
+
+		// @media、@font-face 等 at-rule 原樣保留
+		if css[i] == '@' {
+			end := atRuleEnd(css, i)
+			remaining.WriteString(strings.TrimSpace(css[i:end]) + "\n")
+			i = end
+			continue
+		}
+
+		open := strings.IndexByte(css[i:], '{')
+		if open < 0 {
+			remaining.WriteString(css[i:])
+			break
+		}
+		end := strings.IndexByte(css[i+open:], '}')
+		if end < 0 {
+			remaining.WriteString(css[i:])
+			break
+		}
+		selectors := css[i : i+open]
+		block := css[i+open+1 : i+open+end]
+		i += open + end + 1
+
+		declarations := parseDeclarations(block)
+		var keep []string
+		for _, selector := range strings.Split(selectors, ",") {
+			selector = strings.TrimSpace(selector)
+			if selector == "" {
+				continue
+			}
+			rule, ok := parseSelector(selector)
+			if !ok {
+				keep = append(keep, selector)
+				continue
+			}
+			rule.declarations = declarations
+			rule.order = *order
+			*order++
+			rules = append(rules, rule)
+		}
+		if len(keep) > 0 {
+			remaining.WriteString(strings.Join(keep, ", ") + " {" + block + "}\n")
+		}
+	}
+	return rules, remaining.String()
+}
+
+// atRuleEnd at-rule 的結束位置 (分號或對應的右大括號之後)
+func atRuleEnd(css string, start int) int {
+	depth := 0
+	for i := start; i < len(css); i++ {
+		switch css[i] {
+		case ';':
+			if depth == 0 {
+				return i + 1
+			}
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth <= 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(css)
+}
+
+// parseSelector 解析選擇器 (支援子孫與子元素組合子)
+func parseSelector(selector string) (cssRule, bool) {
+	var rule cssRule
+	pending := byte(' ')
+	for _, token := range strings.Fields(strings.ReplaceAll(selector, ">", " > ")) {
+		if token == ">" {
+			if len(rule.compounds) == 0 || pending == '>' {
+				return rule, false
+			}
+			pending = '>'
+			continue
+		}
+
+		m := cssCompoundPattern.FindStringSubmatch(token)
+		if m == nil {
+			return rule, false
+		}
+		compound := cssCompound{tag: strings.ToLower(m[1])}
+		if compound.tag == "*" {
+			compound.tag = ""
+		} else if compound.tag != "" {
+			rule.specificity[2]++
+		}
+		for _, part := range cssSimplePattern.FindAllString(m[2], -1) {
+			if part[0] == '#' {
+				if compound.id != "" {
+					return rule, false
+				}
+				compound.id = part[1:]
+				rule.specificity[0]++
+			} else {
+				compound.classes = append(compound.classes, part[1:])
+				rule.specificity[1]++
+			}
+		}
+
+		if len(rule.compounds) > 0 {
+			rule.combinators = append(rule.combinators, pending)
+		}
+		rule.compounds = append(rule.compounds, compound)
+		pending = ' '
+	}
+	return rule, len(rule.compounds) > 0 && pending != '>'
+}
+
+// matches 元素是否符合選擇器 (由右至左比對)
+func (r *cssRule) matches(n *html.Node) bool {
+	last := len(r.compounds) - 1
+	return r.compounds[last].matches(n) && r.matchAncestors(n, last)
+}
+
+// matchAncestors 比對第 i 個複合選擇器左側的祖先條件
+func (r *cssRule) matchAncestors(n *html.Node, i int) bool {
+	if i == 0 {
+		return true
+	}
+	target := r.compounds[i-1]
+	for p := n.Parent; p != nil && p.Type == html.ElementNode; p = p.Parent {
+		if target.matches(p) && r.matchAncestors(p, i-1) {
+			return true
+		}
+		if r.combinators[i-1] == '>' {
+			return false
+		}
+	}
+	return false
+}
+
+// matches 元素是否符合複合選擇器
+func (c cssCompound) matches(n *html.Node) bool {
+	if n.Type != html.ElementNode || (c.tag != "" && n.Data != c.tag) {
+		return false
+	}
+	if c.id != "" && htmlAttr(n, "id") != c.id {
+		return false
+	}
+	if len(c.classes) > 0 {
+		classes := strings.Fields(htmlAttr(n, "class"))
+		for _, want := range c.classes {
+			found := false
+			for _, class := range classes {
+				if class == want {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// parseDeclarations 解析宣告區塊 ("prop: value; ...")
+func parseDeclarations(block string) []cssDeclaration {
+	var declarations []cssDeclaration
+	for _, part := range strings.Split(block, ";") {
+		property, value, ok := strings.Cut(part, ":")
+		property = strings.ToLower(strings.TrimSpace(property))
+		value = strings.TrimSpace(value)
+		if !ok || property == "" || value == "" {
+			continue
+		}
+		declarations = append(declarations, cssDeclaration{property: property, value: value})
+	}
+	return declarations
+}
+
+// mergeDeclarations 依序合併宣告，後者覆寫前者 (屬性保留第一次出現的位置)
+func mergeDeclarations(groups ...[]cssDeclaration) []cssDeclaration {
+	index := make(map[string]int)
+	var merged []cssDeclaration
+	for _, group := range groups {
+		for _, d := range group {
+			if i, ok := index[d.property]; ok {
+				merged[i] = d
+				continue
+			}
+			index[d.property] = len(merged)
+			merged = append(merged, d)
+		}
+	}
+	return merged
+}
+
+// formatDeclarations 輸出 style 屬性值
+func formatDeclarations(declarations []cssDeclaration) string {
+	parts := make([]string, len(declarations))
+	for i, d := range declarations {
+		parts[i] = d.property + ": " + d.value
+	}
+	return strings.Join(parts, "; ")
+}
+
+// setHTMLAttr 設定屬性值 (不存在時新增)
+func setHTMLAttr(n *html.Node, key, value string) {
+	for i := range n.Attr {
+		if n.Attr[i].Key == key {
+			n.Attr[i].Val = value
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: value})
+}
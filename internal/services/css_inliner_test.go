@@ -0,0 +1,114 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+// inlineCSSString 解析 HTML 片段、內嵌 CSS 後輸出 body 內容
+func inlineCSSString(t *testing.T, input string) (string, bool) {
+	t.Helper()
+	doc, err := html.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("html.Parse() error = %v", err)
+	}
+	changed := inlineCSS(doc)
+	out, err := renderHTML(doc, false)
+	if err != nil {
+		t.Fatalf("renderHTML() error = %v", err)
+	}
+	return out, changed
+}
+
+func TestInlineCSS(t *testing.T) {
+	tests := []struct {
+		name        string
+		html        string
+		want        string
+		wantChanged bool
+	}{
+		{
+			name:        "specificity order",
+			html:        `<style>#y { color: green } .x { color: blue } p { color: red; margin: 0 }</style><p class="x" id="y">a</p><p>b</p>`,
+			want:        `<p class="x" id="y" style="color: green; margin: 0">a</p><p style="color: red; margin: 0">b</p>`,
+			wantChanged: true,
+		},
+		{
+			name:        "existing style and important",
+			html:        `<style>p { color: red; font-size: 12px !important }</style><p style="color: blue; font-size: 20px">a</p>`,
+			want:        `<p style="color: blue; font-size: 12px !important">a</p>`,
+			wantChanged: true,
+		},
+		{
+			name:        "descendant and child combinators",
+			html:        `<style>div p { color: red } div > span { color: blue }</style><div><p>a</p><p><span>b</span></p><span>c</span></div><p>d</p>`,
+			want:        `<div><p style="color: red">a</p><p style="color: red"><span>b</span></p><span style="color: blue">c</span></div><p>d</p>`,
+			wantChanged: true,
+		},
+		{
+			name:        "media query kept",
+			html:        `<style>p { color: red } @media (max-width: 600px) { p { color: blue } }</style><p>a</p>`,
+			want:        "<style>@media (max-width: 600px) { p { color: blue } }\n</style><p style=\"color: red\">a</p>",
+			wantChanged: true,
+		},
+		{
+			name: "print stylesheet ignored",
+			html: `<style media="print">p { color: red }</style><p>a</p>`,
+			want: `<style media="print">p { color: red }</style><p>a</p>`,
+		},
+		{
+			name: "pseudo class not inlined",
+			html: `<style>a:hover { color: red }</style><a href="/">a</a>`,
+			want: `<style>a:hover { color: red }</style><a href="/">a</a>`,
+		},
+		{
+			name: "no style",
+			html: `<p>a</p>`,
+			want: `<p>a</p>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed := inlineCSSString(t, tt.html)
+			if got != tt.want {
+				t.Errorf("inlineCSS() = %q, want %q", got, tt.want)
+			}
+			if changed != tt.wantChanged {
+				t.Errorf("inlineCSS() changed = %v, want %v", changed, tt.wantChanged)
+			}
+		})
+	}
+}
+
+func TestInlineCSSUnsafeRulesSanitized(t *testing.T) {
+	// 內嵌在消毒之前：不安全的規則寫入 style 屬性後仍會被消毒移除
+	tests := []string{
+		`<style>p { width: expression(alert(1)) }</style><p>hi</p>`,
+		`<style>p { width: \65xpression(alert(1)) }</style><p>hi</p>`,
+		`<style>p { background: url(javascript:alert(1)) }</style><p>hi</p>`,
+	}
+	for _, input := range tests {
+		result, err := newTestContentPipeline().Process("text", input)
+		if err != nil {
+			t.Fatalf("Process(%q) error = %v", input, err)
+		}
+		if result.HTML != `<p>hi</p>` {
+			t.Errorf("Process(%q) HTML = %q, want %q", input, result.HTML, `<p>hi</p>`)
+		}
+		if result.Processing == nil || !result.Processing.CSSInlined {
+			t.Errorf("Process(%q) Processing = %+v, want CSS inlined", input, result.Processing)
+		}
+	}
+}
+
+func TestParseDeclarations(t *testing.T) {
+	got := formatDeclarations(mergeDeclarations(
+		parseDeclarations("color: red; margin:0;;"),
+		parseDeclarations(" COLOR : blue ; padding: 1px"),
+	))
+	if want := "color: blue; margin: 0; padding: 1px"; got != want {
+		t.Errorf("merged declarations = %q, want %q", got, want)
+	}
+}
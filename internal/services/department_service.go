@@ -0,0 +1,143 @@
+// internal/services/department_service.go
+// 部門 (租戶) 服務 - 部門管理與委派管理的範圍判斷
+
+package services
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"mail-proxy/internal/models"
+)
+
+// ErrDepartmentNotFound 部門不存在
+var ErrDepartmentNotFound = errors.New("department not found")
+
+// DepartmentService 部門服務
+type DepartmentService struct {
+	db *gorm.DB
+}
+
+// NewDepartmentService 建立部門服務
+func NewDepartmentService(db *gorm.DB) *DepartmentService {
+	return &DepartmentService{db: db}
+}
+
+// Create 建立部門
+func (s *DepartmentService) Create(req *models.CreateDepartmentRequest) (*models.Department, error) {
+	department := &models.Department{
+		ID:          uuid.New(),
+		Name:        strings.TrimSpace(req.Name),
+		Description: req.Description,
+	}
+
+	if err := s.db.Create(department).Error; err != nil {
+		if strings.Contains(err.Error(), "duplicate key") || strings.Contains(err.Error(), "unique constraint") {
+			return nil, errors.New("department name already exists")
+		}
+		return nil, err
+	}
+	return department, nil
+}
+
+// List 列出所有部門
+func (s *DepartmentService) List() ([]models.Department, error) {
+	var departments []models.Department
+	if err := s.db.Order("name ASC").Find(&departments).Error; err != nil {
+		return nil, err
+	}
+	return departments, nil
+}
+
+// GetByID 根據 ID 查詢
+func (s *DepartmentService) GetByID(id uuid.UUID) (*models.Department, error) {
+	var department models.Department
+	if err := s.db.First(&department, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrDepartmentNotFound
+		}
+		return nil, err
+	}
+	return &department, nil
+}
+
+// Update 更新部門，名稱變更時同步更新所屬 Token 的部門名稱
+func (s *DepartmentService) Update(id uuid.UUID, req *models.UpdateDepartmentRequest) (*models.Department, error) {
+	department, err := s.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if name := strings.TrimSpace(req.Name); name != "" && name != department.Name {
+			department.Name = name
+			if err := tx.Model(&models.ClientToken{}).
+				Where("department_id = ?", department.ID).
+				Update("department", name).Error; err != nil {
+				return err
+			}
+		}
+		if req.Description != nil {
+			department.Description = *req.Description
+		}
+		return tx.Save(department).Error
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") || strings.Contains(err.Error(), "unique constraint") {
+			return nil, errors.New("department name already exists")
+		}
+		return nil, err
+	}
+	return department, nil
+}
+
+// Resolve 依 ID 或名稱取得部門 (兩者皆空白時回傳 nil)
+func (s *DepartmentService) Resolve(id *uuid.UUID, name string) (*models.Department, error) {
+	if id != nil {
+		return s.GetByID(*id)
+	}
+	if name = strings.TrimSpace(name); name == "" {
+		return nil, nil
+	}
+
+	var department models.Department
+	if err := s.db.First(&department, "name = ?", name).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrDepartmentNotFound
+		}
+		return nil, err
+	}
+	return &department, nil
+}
+
+// GetManagedToken 取得呼叫者可管理的 Token
+// 查無或超出管理範圍時回傳 gorm.ErrRecordNotFound，避免洩漏其他部門的資料
+func (s *DepartmentService) GetManagedToken(caller *models.ClientToken, query *gorm.DB) (*models.ClientToken, error) {
+	var target models.ClientToken
+	if err := query.First(&target).Error; err != nil {
+		return nil, err
+	}
+	if !caller.CanManage(&target) {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &target, nil
+}
+
+// GetManagedTokenByID 依 ID 取得呼叫者可管理的 Token
+func (s *DepartmentService) GetManagedTokenByID(caller *models.ClientToken, id uuid.UUID) (*models.ClientToken, error) {
+	return s.GetManagedToken(caller, s.db.Where("id = ?", id))
+}
+
+// ScopeTokens 將 client_tokens 查詢限制在呼叫者的管理範圍
+func (s *DepartmentService) ScopeTokens(caller *models.ClientToken, query *gorm.DB) *gorm.DB {
+	if caller.IsAdmin() {
+		return query
+	}
+	if caller.DepartmentID != nil {
+		return query.Where("department_id = ?", *caller.DepartmentID)
+	}
+	return query.Where("id = ?", caller.ID)
+}
@@ -0,0 +1,277 @@
+// internal/services/dkim_service.go
+// DKIM 簽章服務 - 管理每個網域的簽章金鑰並簽署外送 MIME
+
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+	"github.com/emersion/go-message/textproto"
+	"github.com/emersion/go-msgauth/dkim"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"mail-proxy/internal/models"
+)
+
+// dkimRSAKeyBits RSA 金鑰長度
+const dkimRSAKeyBits = 2048
+
+// dkimSignedHeaders 納入簽章的標頭 (存在時才簽署)
+// 不包含 Bcc、Received 等可能在傳遞過程中被移除或改動的標頭
+var dkimSignedHeaders = []string{
+	"From", "Sender", "Reply-To", "To", "Cc", "Subject", "Date", "Message-ID",
+	"In-Reply-To", "References", "MIME-Version", "Content-Type", "Content-Transfer-Encoding",
+	"List-Unsubscribe", "List-Unsubscribe-Post",
+}
+
+// ErrDKIMKeyNotFound DKIM 金鑰不存在
+var ErrDKIMKeyNotFound = errors.New("dkim key not found")
+
+// ErrDKIMDNSRecord DNS 尚未發布金鑰的公鑰
+var ErrDKIMDNSRecord = errors.New("dkim dns record not published")
+
+// DKIMService DKIM 簽章服務
+// 實作 MessageSigner interface
+type DKIMService struct {
+	db         *gorm.DB
+	encryption *EncryptionService
+	lookupTXT  func(name string) ([]string, error) // 啟用前檢查 DNS TXT 記錄
+}
+
+// NewDKIMService 建立 DKIM 簽章服務
+func NewDKIMService(db *gorm.DB, encryption *EncryptionService) *DKIMService {
+	return &DKIMService{
+		db:         db,
+		encryption: encryption,
+		lookupTXT:  net.LookupTXT,
+	}
+}
+
+// GenerateKey 產生新的 DKIM 金鑰並加密儲存
+// 新金鑰為停用狀態，需待 DNS TXT 記錄發布後以 Activate 啟用，避免簽出收件端無法驗證的郵件
+func (s *DKIMService) GenerateKey(req *models.CreateDKIMKeyRequest) (*models.DKIMKey, error) {
+	algorithm := req.Algorithm
+	if algorithm == "" {
+		algorithm = models.DKIMAlgorithmRSASHA256
+	}
+
+	var signer crypto.Signer
+	var publicKey string
+	switch algorithm {
+	case models.DKIMAlgorithmRSASHA256:
+		key, err := rsa.GenerateKey(rand.Reader, dkimRSAKeyBits)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate RSA key: %w", err)
+		}
+		der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode RSA public key: %w", err)
+		}
+		signer, publicKey = key, base64.StdEncoding.EncodeToString(der)
+	case models.DKIMAlgorithmEd25519SHA256:
+		pub, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate Ed25519 key: %w", err)
+		}
+		// RFC 8463：p= 為原始 32 bytes 公鑰
+		signer, publicKey = key, base64.StdEncoding.EncodeToString(pub)
+	default:
+		return nil, fmt.Errorf("unsupported DKIM algorithm: %s", algorithm)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode private key: %w", err)
+	}
+	encryptedKey, err := s.encryption.Encrypt(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})))
+	if err != nil {
+		return nil, errors.New("failed to encrypt private key")
+	}
+
+	key := &models.DKIMKey{
+		ID:                  uuid.New(),
+		Domain:              strings.ToLower(strings.TrimSuffix(req.Domain, ".")),
+		Selector:            strings.ToLower(req.Selector),
+		Algorithm:           algorithm,
+		PrivateKeyEncrypted: encryptedKey,
+		PublicKey:           publicKey,
+		IsActive:            false,
+	}
+
+	if err := s.db.Create(key).Error; err != nil {
+		if strings.Contains(err.Error(), "duplicate key") || strings.Contains(err.Error(), "unique constraint") {
+			return nil, errors.New("selector already exists for this domain")
+		}
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// GetByID 根據 ID 查詢
+func (s *DKIMService) GetByID(id uuid.UUID) (*models.DKIMKey, error) {
+	var key models.DKIMKey
+	if err := s.db.First(&key, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrDKIMKeyNotFound
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+// Activate 啟用金鑰 (啟用後該網域以最新的啟用金鑰簽章)
+// verifyDNS 時先確認 <selector>._domainkey.<domain> 已發布相同的公鑰，未發布時回傳 ErrDKIMDNSRecord
+func (s *DKIMService) Activate(id uuid.UUID, verifyDNS bool) (*models.DKIMKey, error) {
+	key, err := s.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if verifyDNS {
+		if err := s.verifyDNSRecord(key); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.db.Model(key).Update("is_active", true).Error; err != nil {
+		return nil, fmt.Errorf("failed to activate dkim key: %w", err)
+	}
+	return key, nil
+}
+
+// verifyDNSRecord 確認 DNS TXT 記錄的 p= 與金鑰的公鑰相同
+func (s *DKIMService) verifyDNSRecord(key *models.DKIMKey) error {
+	name := key.DNSRecord().Name
+	records, err := s.lookupTXT(name)
+	if err != nil {
+		return fmt.Errorf("%w: failed to look up %s: %v", ErrDKIMDNSRecord, name, err)
+	}
+	for _, record := range records {
+		if dkimRecordPublicKey(record) == key.PublicKey {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s does not contain the public key", ErrDKIMDNSRecord, name)
+}
+
+// dkimRecordPublicKey 取得 DKIM TXT 記錄 p= 標籤的值 (移除空白)
+func dkimRecordPublicKey(record string) string {
+	for _, tag := range strings.Split(record, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(tag), "=")
+		if ok && strings.TrimSpace(name) == "p" {
+			return strings.Join(strings.Fields(value), "")
+		}
+	}
+	return ""
+}
+
+// List 列出所有金鑰
+func (s *DKIMService) List() ([]models.DKIMKey, error) {
+	var keys []models.DKIMKey
+	if err := s.db.Order("domain ASC, created_at DESC").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Delete 刪除金鑰
+func (s *DKIMService) Delete(id uuid.UUID) error {
+	result := s.db.Delete(&models.DKIMKey{}, "id = ?", id)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete dkim key: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrDKIMKeyNotFound
+	}
+	return nil
+}
+
+// Sign 以 From 標頭網域的啟用金鑰簽署原始 MIME
+// 該網域沒有金鑰時原封不動回傳
+func (s *DKIMService) Sign(raw []byte) ([]byte, error) {
+	header, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message header: %w", err)
+	}
+
+	mh := mail.Header{Header: message.Header{Header: header}}
+	from, err := mh.AddressList("From")
+	if err != nil || len(from) == 0 {
+		return raw, nil
+	}
+	domain := strings.ToLower(from[0].Address[strings.LastIndex(from[0].Address, "@")+1:])
+
+	var key models.DKIMKey
+	err = s.db.Where("domain = ? AND is_active = ?", domain, true).Order("created_at DESC").First(&key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return raw, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load DKIM key for %s: %w", domain, err)
+	}
+
+	signer, err := s.decryptSigner(&key)
+	if err != nil {
+		return nil, err
+	}
+
+	var headerKeys []string
+	for _, k := range dkimSignedHeaders {
+		if header.Has(k) {
+			headerKeys = append(headerKeys, k)
+		}
+	}
+
+	var signed bytes.Buffer
+	err = dkim.Sign(&signed, bytes.NewReader(raw), &dkim.SignOptions{
+		Domain:                 key.Domain,
+		Selector:               key.Selector,
+		Signer:                 signer,
+		HeaderCanonicalization: dkim.CanonicalizationRelaxed,
+		BodyCanonicalization:   dkim.CanonicalizationRelaxed,
+		HeaderKeys:             headerKeys,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign message: %w", err)
+	}
+	return signed.Bytes(), nil
+}
+
+// decryptSigner 解密私鑰
+func (s *DKIMService) decryptSigner(key *models.DKIMKey) (crypto.Signer, error) {
+	keyPEM, err := s.encryption.Decrypt(key.PrivateKeyEncrypted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt DKIM key: %w", err)
+	}
+
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, errors.New("invalid DKIM private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse DKIM key: %w", err)
+	}
+
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported DKIM private key type")
+	}
+	return signer, nil
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"mail-proxy/internal/models"
+)
+
+func TestDKIMRecordPublicKey(t *testing.T) {
+	tests := []struct {
+		record string
+		want   string
+	}{
+		{record: "v=DKIM1; k=rsa; p=MIIBIjAN", want: "MIIBIjAN"},
+		{record: "v=DKIM1;k=ed25519;p=abc def", want: "abcdef"},
+		{record: "v=DKIM1; k=rsa; p=", want: ""},
+		{record: "v=spf1 -all", want: ""},
+	}
+	for _, tt := range tests {
+		if got := dkimRecordPublicKey(tt.record); got != tt.want {
+			t.Errorf("dkimRecordPublicKey(%q) = %q, want %q", tt.record, got, tt.want)
+		}
+	}
+}
+
+func TestDKIMVerifyDNSRecord(t *testing.T) {
+	key := &models.DKIMKey{Domain: "example.com", Selector: "mp2026", Algorithm: models.DKIMAlgorithmRSASHA256, PublicKey: "MIIBIjAN"}
+	tests := []struct {
+		name    string
+		records []string
+		err     error
+		wantErr bool
+	}{
+		{name: "published", records: []string{"v=DKIM1; k=rsa; p=MIIBIjAN"}},
+		{name: "other key", records: []string{"v=DKIM1; k=rsa; p=OTHER"}, wantErr: true},
+		{name: "lookup failed", err: errors.New("no such host"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &DKIMService{lookupTXT: func(name string) ([]string, error) {
+				if name != "mp2026._domainkey.example.com" {
+					t.Fatalf("lookup %q", name)
+				}
+				return tt.records, tt.err
+			}}
+			err := s.verifyDNSRecord(key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verifyDNSRecord() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrDKIMDNSRecord) {
+				t.Fatalf("verifyDNSRecord() error = %v, want ErrDKIMDNSRecord", err)
+			}
+		})
+	}
+}
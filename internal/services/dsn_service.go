@@ -0,0 +1,267 @@
+// internal/services/dsn_service.go
+// DSN 服務 - 依 RFC 3464 產生投遞狀態通知並寄回信封寄件者
+
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+	"github.com/emersion/go-message/textproto"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"mail-proxy/internal/config"
+	"mail-proxy/internal/models"
+)
+
+// DSNService DSN 服務
+type DSNService struct {
+	cfg        *config.Config
+	db         *gorm.DB
+	mailRouter *MailRouter
+}
+
+// NewDSNService 建立 DSN 服務
+func NewDSNService(cfg *config.Config, db *gorm.DB, mailRouter *MailRouter) *DSNService {
+	return &DSNService{
+		cfg:        cfg,
+		db:         db,
+		mailRouter: mailRouter,
+	}
+}
+
+// dsnRecipientStatus 單一收件者的投遞結果
+type dsnRecipientStatus struct {
+	recipient  models.MailDSNRecipient
+	action     string // relayed / failed
+	status     string // RFC 3463 狀態碼
+	diagnostic string
+}
+
+// NotifyRelayed 郵件交由 Graph API / SendGrid / SMTP Relay 送出後，對要求 NOTIFY=SUCCESS 的收件者寄出 DSN
+// 之後的投遞不再回報，依 RFC 3464 §2.3.3 以 relayed 表示，而非 delivered
+func (s *DSNService) NotifyRelayed(job *models.MailJob) {
+	s.notify(job, models.DSNNotifySuccess, "relayed", "2.0.0", "")
+}
+
+// NotifyFailed 郵件最終發送失敗時，對要求 NOTIFY=FAILURE (或未指定) 的收件者寄出 DSN
+// status 為 RFC 3463 增強狀態碼 (例如 5.6.7)
+func (s *DSNService) NotifyFailed(job *models.MailJob, status, errorMsg string) {
+	s.notify(job, models.DSNNotifyFailure, "failed", status, errorMsg)
+}
+
+// notify 產生並寄出 DSN，失敗只記錄日誌
+// DSN 以空的信封寄件者 (MAIL FROM:<>) 送出，避免產生 DSN 的 DSN (RFC 3461 §6.2)
+func (s *DSNService) notify(job *models.MailJob, kind, action, status, diagnostic string) {
+	if !job.DSNRequested {
+		return
+	}
+
+	var mailRecord models.Mail
+	if err := s.db.Preload("DSNRecipients").Where("id = ?", job.MailID).First(&mailRecord).Error; err != nil {
+		log.Printf("Failed to load DSN parameters for mail %s: %v", job.MailID, err)
+		return
+	}
+
+	var statuses []dsnRecipientStatus
+	for _, rcpt := range mailRecord.DSNRecipients {
+		if rcpt.WantsNotify(kind) {
+			statuses = append(statuses, dsnRecipientStatus{
+				recipient:  rcpt,
+				action:     action,
+				status:     status,
+				diagnostic: diagnostic,
+			})
+		}
+	}
+	if len(statuses) == 0 {
+		return
+	}
+
+	from := s.fromAddress()
+	raw, err := buildDSNMessage(&mailRecord, from, s.cfg.SMTPHostname, statuses)
+	if err != nil {
+		log.Printf("Failed to build DSN for mail %s: %v", job.MailID, err)
+		return
+	}
+
+	dsnJob := &models.MailJob{
+		MailID:      uuid.New().String(),
+		FromAddress: from,
+		ToAddresses: []string{mailRecord.FromAddress},
+		Subject:     dsnSubject(action),
+		Body:        dsnHumanText(statuses),
+		NullSender:  true,
+	}
+	if err := s.mailRouter.SendRawMessage(dsnJob, raw); err != nil {
+		log.Printf("Failed to send DSN for mail %s to %s: %v", job.MailID, mailRecord.FromAddress, err)
+		return
+	}
+
+	log.Printf("DSN (%s) sent for mail %s to %s", action, job.MailID, mailRecord.FromAddress)
+}
+
+// fromAddress 取得 DSN 寄件者
+func (s *DSNService) fromAddress() string {
+	if s.cfg.DSNFromAddress != "" {
+		return s.cfg.DSNFromAddress
+	}
+	return "postmaster" + s.cfg.OrgEmailDomain
+}
+
+// buildDSNMessage 組裝 RFC 3464 multipart/report 郵件
+// 包含說明文字、message/delivery-status 與原始郵件 (RET=FULL) 或原始標頭
+func buildDSNMessage(original *models.Mail, from, reportingMTA string, statuses []dsnRecipientStatus) ([]byte, error) {
+	var h mail.Header
+	h.SetDate(time.Now())
+	h.SetSubject(dsnSubject(statuses[0].action))
+	h.SetAddressList("From", []*mail.Address{{Name: "Mail Delivery System", Address: from}})
+	h.SetAddressList("To", []*mail.Address{{Address: original.FromAddress}})
+	h.Set("Auto-Submitted", "auto-replied")
+	h.SetContentType("multipart/report", map[string]string{"report-type": "delivery-status"})
+	if err := h.GenerateMessageID(); err != nil {
+		return nil, fmt.Errorf("failed to generate message id: %w", err)
+	}
+
+	var buf bytes.Buffer
+	w, err := message.CreateWriter(&buf, h.Header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DSN writer: %w", err)
+	}
+
+	// 說明文字
+	var textHeader message.Header
+	textHeader.SetContentType("text/plain", map[string]string{"charset": "utf-8"})
+	if err := writeDSNPart(w, textHeader, strings.NewReader(dsnHumanText(statuses))); err != nil {
+		return nil, err
+	}
+
+	// 機器可讀的投遞狀態
+	var statusHeader message.Header
+	statusHeader.SetContentType("message/delivery-status", nil)
+	if err := writeDSNPart(w, statusHeader, strings.NewReader(dsnDeliveryStatus(reportingMTA, original, statuses))); err != nil {
+		return nil, err
+	}
+
+	// 原始郵件或原始標頭
+	if original.RawMessagePath != "" {
+		if err := writeDSNOriginal(w, original); err != nil {
+			log.Printf("Failed to attach original message to DSN: %v", err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish DSN: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// writeDSNPart 寫入 multipart/report 的單一部分
+func writeDSNPart(w *message.Writer, header message.Header, r io.Reader) error {
+	pw, err := w.CreatePart(header)
+	if err != nil {
+		return fmt.Errorf("failed to create DSN part: %w", err)
+	}
+	if _, err := io.Copy(pw, r); err != nil {
+		return fmt.Errorf("failed to write DSN part: %w", err)
+	}
+	return pw.Close()
+}
+
+// writeDSNOriginal 附上原始郵件
+// RET=FULL 時附上完整郵件，否則只附上原始標頭 (RFC 3461 §4.3)
+func writeDSNOriginal(w *message.Writer, original *models.Mail) error {
+	f, err := os.Open(original.RawMessagePath)
+	if err != nil {
+		return fmt.Errorf("failed to open raw message: %w", err)
+	}
+	defer f.Close()
+
+	var header message.Header
+	if original.DSNReturn == "FULL" {
+		header.SetContentType("message/rfc822", nil)
+		return writeDSNPart(w, header, f)
+	}
+
+	origHeader, err := textproto.ReadHeader(bufio.NewReader(f))
+	if err != nil {
+		return fmt.Errorf("failed to read raw message header: %w", err)
+	}
+	var headerBuf bytes.Buffer
+	if err := textproto.WriteHeader(&headerBuf, origHeader); err != nil {
+		return fmt.Errorf("failed to write raw message header: %w", err)
+	}
+
+	header.SetContentType("text/rfc822-headers", nil)
+	return writeDSNPart(w, header, &headerBuf)
+}
+
+// dsnDeliveryStatus 產生 message/delivery-status 內容 (RFC 3464 §2)
+// reportingMTA 為 SMTP Receiver 問候時使用的主機名稱
+func dsnDeliveryStatus(reportingMTA string, original *models.Mail, statuses []dsnRecipientStatus) string {
+	var b strings.Builder
+
+	// Per-Message 欄位
+	fmt.Fprintf(&b, "Reporting-MTA: dns; %s\r\n", reportingMTA)
+	if original.DSNEnvelopeID != "" {
+		fmt.Fprintf(&b, "Original-Envelope-Id: %s\r\n", original.DSNEnvelopeID)
+	}
+	fmt.Fprintf(&b, "Arrival-Date: %s\r\n", original.CreatedAt.Format(time.RFC1123Z))
+
+	// Per-Recipient 欄位
+	now := time.Now().Format(time.RFC1123Z)
+	for _, st := range statuses {
+		b.WriteString("\r\n")
+		if st.recipient.OriginalRecipient != "" {
+			fmt.Fprintf(&b, "Original-Recipient: %s\r\n", st.recipient.OriginalRecipient)
+		}
+		fmt.Fprintf(&b, "Final-Recipient: rfc822; %s\r\n", st.recipient.Recipient)
+		fmt.Fprintf(&b, "Action: %s\r\n", st.action)
+		fmt.Fprintf(&b, "Status: %s\r\n", st.status)
+		if st.diagnostic != "" {
+			fmt.Fprintf(&b, "Diagnostic-Code: X-Mail-Proxy; %s\r\n", singleLine(st.diagnostic))
+		}
+		fmt.Fprintf(&b, "Last-Attempt-Date: %s\r\n", now)
+	}
+
+	return b.String()
+}
+
+// dsnHumanText 產生 DSN 的說明文字
+func dsnHumanText(statuses []dsnRecipientStatus) string {
+	var b strings.Builder
+	if statuses[0].action == "relayed" {
+		b.WriteString("Your message has been relayed to the following recipients. No further notification will be sent:\r\n\r\n")
+	} else {
+		b.WriteString("Your message could not be delivered to the following recipients:\r\n\r\n")
+	}
+	for _, st := range statuses {
+		fmt.Fprintf(&b, "  %s\r\n", st.recipient.Recipient)
+		if st.diagnostic != "" {
+			fmt.Fprintf(&b, "    %s\r\n", singleLine(st.diagnostic))
+		}
+	}
+	return b.String()
+}
+
+// dsnSubject 取得 DSN 主旨
+func dsnSubject(action string) string {
+	if action == "relayed" {
+		return "Delivery Status Notification (Relayed)"
+	}
+	return "Delivery Status Notification (Failure)"
+}
+
+// singleLine 移除換行，避免破壞 delivery-status 欄位格式
+func singleLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
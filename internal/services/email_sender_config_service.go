@@ -5,13 +5,17 @@ package services
 
 import (
 	"errors"
+	"fmt"
+	"log"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 
 	"mail-proxy/internal/config"
 	"mail-proxy/internal/models"
+	"mail-proxy/pkg/microsoft"
 )
 
 // EmailSenderConfigService Email Sender Config 服務
@@ -19,14 +23,18 @@ type EmailSenderConfigService struct {
 	db         *gorm.DB
 	cfg        *config.Config
 	encryption *EncryptionService
+
+	// oauthManager 憑證變更時清除快取的 Access Token
+	oauthManager *microsoft.OAuthManager
 }
 
 // NewEmailSenderConfigService 建立 Email Sender Config 服務
 func NewEmailSenderConfigService(cfg *config.Config, db *gorm.DB, encryption *EncryptionService) *EmailSenderConfigService {
 	return &EmailSenderConfigService{
-		db:         db,
-		cfg:        cfg,
-		encryption: encryption,
+		db:           db,
+		cfg:          cfg,
+		encryption:   encryption,
+		oauthManager: microsoft.DefaultOAuthManager,
 	}
 }
 
@@ -37,20 +45,21 @@ func (s *EmailSenderConfigService) Create(clientTokenID uuid.UUID, req *models.C
 		return nil, errors.New("sender_email must be organization domain")
 	}
 
-	// 加密 client secret
-	encryptedSecret, err := s.encryption.Encrypt(req.MSClientSecret)
-	if err != nil {
-		return nil, errors.New("failed to encrypt client secret")
+	config := &models.EmailSenderConfig{
+		ID:            uuid.New(),
+		ClientTokenID: clientTokenID,
+		SenderEmail:   strings.ToLower(req.SenderEmail),
+		MSTenantID:    req.MSTenantID,
+		MSClientID:    req.MSClientID,
+		IsActive:      true,
 	}
 
-	config := &models.EmailSenderConfig{
-		ID:                      uuid.New(),
-		ClientTokenID:           clientTokenID,
-		SenderEmail:             strings.ToLower(req.SenderEmail),
-		MSTenantID:              req.MSTenantID,
-		MSClientID:              req.MSClientID,
-		MSClientSecretEncrypted: encryptedSecret,
-		IsActive:                true,
+	// 驗證並加密 client secret 或憑證私鑰
+	if err := s.applyCredential(config, req.MSAuthMethod, req.MSClientSecret, req.MSCertificate, req.MSPrivateKey); err != nil {
+		return nil, err
+	}
+	if err := s.applyEndpoints(config, &req.MSAuthorityURL, &req.MSGraphURL); err != nil {
+		return nil, err
 	}
 
 	if err := s.db.Create(config).Error; err != nil {
@@ -107,6 +116,7 @@ func (s *EmailSenderConfigService) Update(id uuid.UUID, req *models.UpdateSender
 	if err := s.db.First(&config, "id = ?", id).Error; err != nil {
 		return nil, err
 	}
+	previous := config
 
 	// 更新欄位
 	if req.MSTenantID != "" {
@@ -115,12 +125,11 @@ func (s *EmailSenderConfigService) Update(id uuid.UUID, req *models.UpdateSender
 	if req.MSClientID != "" {
 		config.MSClientID = req.MSClientID
 	}
-	if req.MSClientSecret != "" {
-		encryptedSecret, err := s.encryption.Encrypt(req.MSClientSecret)
-		if err != nil {
-			return nil, errors.New("failed to encrypt client secret")
-		}
-		config.MSClientSecretEncrypted = encryptedSecret
+	if err := s.applyCredential(&config, req.MSAuthMethod, req.MSClientSecret, req.MSCertificate, req.MSPrivateKey); err != nil {
+		return nil, err
+	}
+	if err := s.applyEndpoints(&config, req.MSAuthorityURL, req.MSGraphURL); err != nil {
+		return nil, err
 	}
 	if req.IsActive != nil {
 		config.IsActive = *req.IsActive
@@ -130,34 +139,229 @@ func (s *EmailSenderConfigService) Update(id uuid.UUID, req *models.UpdateSender
 		return nil, err
 	}
 
+	// 憑證變更後清除舊憑證快取的 Access Token
+	if req.MSTenantID != "" || req.MSClientID != "" || req.MSClientSecret != "" || req.MSAuthMethod != "" || req.MSCertificate != "" ||
+		req.MSAuthorityURL != nil || req.MSGraphURL != nil {
+		s.invalidateToken(&previous)
+	}
+
 	return &config, nil
 }
 
 // Delete 刪除配置
 func (s *EmailSenderConfigService) Delete(id uuid.UUID) error {
+	var config models.EmailSenderConfig
+	if err := s.db.First(&config, "id = ?", id).Error; err != nil {
+		return errors.New("config not found")
+	}
+
 	result := s.db.Delete(&models.EmailSenderConfig{}, "id = ?", id)
 	if result.RowsAffected == 0 {
 		return errors.New("config not found")
 	}
+	if result.Error == nil {
+		s.invalidateToken(&config)
+	}
 	return result.Error
 }
 
+// applyCredential 驗證並設定認證方式與對應的憑證內容
+// method 空白時：有提供憑證則為 certificate，否則沿用目前的方式；
+// 憑證需與私鑰相符且目前有效，切換方式時會清除另一種方式的憑證內容
+func (s *EmailSenderConfigService) applyCredential(config *models.EmailSenderConfig, method, secret, certificatePEM, privateKeyPEM string) error {
+	if method == "" {
+		method = config.AuthMethod()
+		if certificatePEM != "" || privateKeyPEM != "" {
+			method = models.SenderAuthMethodCertificate
+		}
+	}
+
+	switch method {
+	case models.SenderAuthMethodClientSecret:
+		if certificatePEM != "" || privateKeyPEM != "" {
+			return errors.New("ms_certificate and ms_private_key require ms_auth_method certificate")
+		}
+		if secret != "" {
+			encryptedSecret, err := s.encryption.Encrypt(secret)
+			if err != nil {
+				return errors.New("failed to encrypt client secret")
+			}
+			config.MSClientSecretEncrypted = encryptedSecret
+		}
+		if config.MSClientSecretEncrypted == "" {
+			return errors.New("ms_client_secret is required for client_secret auth")
+		}
+		config.MSCertificate = ""
+		config.MSPrivateKeyEncrypted = ""
+		config.MSCertificateThumbprint = ""
+		config.MSCertificateExpiresAt = nil
+
+	case models.SenderAuthMethodCertificate:
+		if secret != "" {
+			return errors.New("ms_client_secret cannot be used with ms_auth_method certificate")
+		}
+		if certificatePEM != "" || privateKeyPEM != "" {
+			if certificatePEM == "" || privateKeyPEM == "" {
+				return errors.New("ms_certificate and ms_private_key must be provided together")
+			}
+			credential, err := microsoft.ParseCertificateCredential(certificatePEM, privateKeyPEM)
+			if err != nil {
+				return err
+			}
+			if err := credential.ValidAt(time.Now()); err != nil {
+				return err
+			}
+			encryptedKey, err := s.encryption.Encrypt(strings.TrimSpace(privateKeyPEM))
+			if err != nil {
+				return errors.New("failed to encrypt private key")
+			}
+			expiresAt := credential.Certificate.NotAfter
+			config.MSCertificate = strings.TrimSpace(certificatePEM)
+			config.MSPrivateKeyEncrypted = encryptedKey
+			config.MSCertificateThumbprint = credential.Thumbprint
+			config.MSCertificateExpiresAt = &expiresAt
+		}
+		if config.MSCertificate == "" || config.MSPrivateKeyEncrypted == "" {
+			return errors.New("ms_certificate and ms_private_key are required for certificate auth")
+		}
+		config.MSClientSecretEncrypted = ""
+
+	default:
+		return errors.New("ms_auth_method must be client_secret or certificate")
+	}
+
+	config.MSAuthMethod = method
+	return nil
+}
+
+// applyEndpoints 驗證並設定個別的 authority / Graph 端點 (nil 不變更，空字串恢復為全域設定)
+// 端點會收到應用程式憑證與 Access Token，主機必須在允許清單內
+func (s *EmailSenderConfigService) applyEndpoints(config *models.EmailSenderConfig, authorityURL, graphURL *string) error {
+	allowedHosts := senderEndpointHosts(s.cfg)
+	normalize := func(field, raw string) (string, error) {
+		value := strings.TrimRight(strings.TrimSpace(raw), "/")
+		if value == "" {
+			return "", nil
+		}
+		if err := microsoft.ValidateEndpointURL(value, allowedHosts); err != nil {
+			return "", fmt.Errorf("%s: %w", field, err)
+		}
+		return value, nil
+	}
+
+	if authorityURL != nil {
+		value, err := normalize("ms_authority_url", *authorityURL)
+		if err != nil {
+			return err
+		}
+		config.MSAuthorityURL = value
+	}
+	if graphURL != nil {
+		value, err := normalize("ms_graph_url", *graphURL)
+		if err != nil {
+			return err
+		}
+		config.MSGraphURL = value
+	}
+	return nil
+}
+
+// CertificateWarnings 憑證即將到期的警告 (距到期不足 SENDER_CERT_EXPIRY_WARNING_DAYS 天)
+func (s *EmailSenderConfigService) CertificateWarnings(config *models.EmailSenderConfig) []string {
+	if config.AuthMethod() != models.SenderAuthMethodCertificate || config.MSCertificateExpiresAt == nil {
+		return nil
+	}
+	remaining := time.Until(*config.MSCertificateExpiresAt)
+	if remaining > time.Duration(s.cfg.SenderCertExpiryWarningDays)*24*time.Hour {
+		return nil
+	}
+	return []string{fmt.Sprintf("certificate %s expires at %s (%d days left), upload a new certificate before it expires",
+		config.MSCertificateThumbprint, config.MSCertificateExpiresAt.Format(time.RFC3339), int(remaining.Hours()/24))}
+}
+
+// CheckCertificateExpiry 記錄啟用中且即將到期 (或已到期) 的憑證
+func (s *EmailSenderConfigService) CheckCertificateExpiry() error {
+	deadline := time.Now().AddDate(0, 0, s.cfg.SenderCertExpiryWarningDays)
+
+	var configs []models.EmailSenderConfig
+	err := s.db.Where("ms_auth_method = ? AND is_active = true AND ms_certificate_expires_at < ?", models.SenderAuthMethodCertificate, deadline).
+		Order("ms_certificate_expires_at").
+		Find(&configs).Error
+	if err != nil {
+		return err
+	}
+
+	for i := range configs {
+		config := &configs[i]
+		if config.MSCertificateExpiresAt.Before(time.Now()) {
+			log.Printf("Sender config %s (%s) certificate %s EXPIRED at %s, mail sending will fail",
+				config.ID, config.SenderEmail, config.MSCertificateThumbprint, config.MSCertificateExpiresAt.Format(time.RFC3339))
+			continue
+		}
+		for _, warning := range s.CertificateWarnings(config) {
+			log.Printf("Sender config %s (%s): %s", config.ID, config.SenderEmail, warning)
+		}
+	}
+	return nil
+}
+
+// StartCertificateExpiryMonitor 每日檢查一次憑證到期狀況 (背景執行至程序結束)
+func (s *EmailSenderConfigService) StartCertificateExpiryMonitor() {
+	go func() {
+		ticker := time.NewTicker(24 * time.Hour)
+		defer ticker.Stop()
+		for {
+			if err := s.CheckCertificateExpiry(); err != nil {
+				log.Printf("Failed to check sender certificate expiry: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// invalidateToken 清除配置憑證在 OAuthManager 與共用快取中的 Access Token
+func (s *EmailSenderConfigService) invalidateToken(config *models.EmailSenderConfig) {
+	credential, err := s.Credential(config)
+	if err != nil {
+		return
+	}
+	s.oauthManager.InvalidateCredential(config.MSTenantID, config.MSClientID, credential)
+}
+
 // DecryptSecret 解密 client secret
 func (s *EmailSenderConfigService) DecryptSecret(config *models.EmailSenderConfig) (string, error) {
 	return s.encryption.Decrypt(config.MSClientSecretEncrypted)
 }
 
+// Credential 取得解密後的 Microsoft 應用程式憑證 (client secret 或憑證 + 私鑰)
+func (s *EmailSenderConfigService) Credential(config *models.EmailSenderConfig) (microsoft.Credential, error) {
+	endpoints := microsoft.Endpoints{AuthorityURL: config.MSAuthorityURL, GraphURL: config.MSGraphURL}
+	if config.AuthMethod() == models.SenderAuthMethodCertificate {
+		privateKey, err := s.encryption.Decrypt(config.MSPrivateKeyEncrypted)
+		if err != nil {
+			return microsoft.Credential{}, err
+		}
+		return microsoft.Credential{CertificatePEM: config.MSCertificate, PrivateKeyPEM: privateKey, Endpoints: endpoints}, nil
+	}
+
+	secret, err := s.DecryptSecret(config)
+	if err != nil {
+		return microsoft.Credential{}, err
+	}
+	return microsoft.Credential{ClientSecret: secret, Endpoints: endpoints}, nil
+}
+
 // GetDecryptedConfig 取得解密後的完整配置 (用於 Worker)
-func (s *EmailSenderConfigService) GetDecryptedConfig(id uuid.UUID) (*models.EmailSenderConfig, string, error) {
+func (s *EmailSenderConfigService) GetDecryptedConfig(id uuid.UUID) (*models.EmailSenderConfig, microsoft.Credential, error) {
 	config, err := s.GetByID(id)
 	if err != nil {
-		return nil, "", err
+		return nil, microsoft.Credential{}, err
 	}
 
-	secret, err := s.DecryptSecret(config)
+	credential, err := s.Credential(config)
 	if err != nil {
-		return nil, "", err
+		return nil, microsoft.Credential{}, err
 	}
 
-	return config, secret, nil
+	return config, credential, nil
 }
@@ -0,0 +1,484 @@
+// internal/services/graph_upload.go
+// Graph 大型附件發送：建立草稿 → 附件 (upload session 分段上傳) → 送出，進度可跨重試續傳
+
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"mail-proxy/internal/models"
+	"mail-proxy/pkg/microsoft"
+)
+
+const (
+	graphAttachmentPostLimit = 3 << 20   // 單一附件以 POST 直接加入草稿的上限，超過時使用 upload session
+	graphUploadChunkUnit     = 320 << 10 // upload session 分段大小須為 320 KiB 的倍數
+	graphUploadStateTimeout  = 2 * time.Second
+)
+
+// GraphUploadState 大型附件郵件的上傳進度 (以 mail ID 儲存)
+type GraphUploadState struct {
+	MailID      string                  `json:"mail_id"`
+	Mailbox     string                  `json:"mailbox"`
+	GraphURL    string                  `json:"graph_url"`
+	DraftID     string                  `json:"draft_id"`
+	Attachments []GraphUploadAttachment `json:"attachments"` // 與 MailJob.Attachments 順序相同
+}
+
+// GraphUploadAttachment 單一附件的上傳進度
+type GraphUploadAttachment struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	Done      bool   `json:"done"`
+	UploadURL string `json:"upload_url,omitempty"` // 進行中的 upload session (已含授權，不需 Access Token)
+}
+
+// GraphUploadStateStore 上傳進度的儲存 (如 KeyDB)；Get 不存在時回傳 nil
+type GraphUploadStateStore interface {
+	Get(ctx context.Context, mailID string) (*GraphUploadState, error)
+	Set(ctx context.Context, state *GraphUploadState) error
+	Delete(ctx context.Context, mailID string) error
+}
+
+// SetUploadStateStore 設定上傳進度儲存 (啟動時呼叫)
+func (s *GraphMailService) SetUploadStateStore(store GraphUploadStateStore) {
+	s.uploads = store
+}
+
+// needsUploadSession 附件合計大小是否超過內嵌門檻
+func (s *GraphMailService) needsUploadSession(job *models.MailJob) (bool, error) {
+	if len(job.Attachments) == 0 {
+		return false, nil
+	}
+
+	var total int64
+	for _, att := range job.Attachments {
+		info, err := os.Stat(att.StoragePath)
+		if err != nil {
+			return false, fmt.Errorf("failed to read attachment %s: %w", att.Filename, err)
+		}
+		total += info.Size()
+	}
+	return total > int64(s.cfg.GraphUploadThresholdKB)<<10, nil
+}
+
+// uploadChunkSize upload session 的分段大小 (320 KiB 的倍數)
+func (s *GraphMailService) uploadChunkSize() int64 {
+	size := int64(s.cfg.GraphUploadChunkSizeKB) << 10 / graphUploadChunkUnit * graphUploadChunkUnit
+	if size < graphUploadChunkUnit {
+		return graphUploadChunkUnit
+	}
+	return size
+}
+
+// sendWithUploadSession 以草稿發送大型附件郵件
+// 每完成一個步驟即儲存進度，重試時沿用草稿與已上傳的附件；最後一次嘗試仍失敗時刪除草稿
+func (s *GraphMailService) sendWithUploadSession(oauthService *microsoft.OAuthService, job *models.MailJob) (err error) {
+	endpoints := oauthService.Endpoints()
+	mailboxURL := fmt.Sprintf("%s/users/%s", endpoints.GraphAPIURL(), job.FromAddress)
+
+	state, err := s.resumeUpload(oauthService, job, endpoints, mailboxURL)
+	if err != nil || state == nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil && job.RetryCount+1 >= s.cfg.MaxRetryCount {
+			s.discardDraft(oauthService, mailboxURL, state)
+		}
+	}()
+
+	resumed := state.DraftID != ""
+	if !resumed {
+		draftID, err := s.createDraft(oauthService, job, mailboxURL)
+		if err != nil {
+			return err
+		}
+		state.DraftID = draftID
+		s.saveUploadState(state)
+	}
+	messageURL := mailboxURL + "/messages/" + state.DraftID
+
+	if resumed {
+		if err := s.skipExistingAttachments(oauthService, messageURL, state); err != nil {
+			return err
+		}
+	}
+
+	for i, att := range job.Attachments {
+		if state.Attachments[i].Done {
+			continue
+		}
+		if err := s.uploadAttachment(oauthService, messageURL, state, i, att); err != nil {
+			return fmt.Errorf("failed to upload attachment %s: %w", att.Filename, err)
+		}
+		state.Attachments[i].Done = true
+		state.Attachments[i].UploadURL = ""
+		s.saveUploadState(state)
+	}
+
+	if err := s.graphCall(oauthService, http.MethodPost, messageURL+"/send", nil, nil); err != nil {
+		return err
+	}
+	s.deleteUploadState(job.MailID)
+	return nil
+}
+
+// resumeUpload 取得可續傳的進度；上次已送出時回傳 nil (視為成功)
+// 草稿已不存在或寄件信箱、附件已變更時重新開始
+func (s *GraphMailService) resumeUpload(oauthService *microsoft.OAuthService, job *models.MailJob, endpoints microsoft.Endpoints, mailboxURL string) (*GraphUploadState, error) {
+	state := s.loadUploadState(job.MailID)
+	if state != nil && (state.Mailbox != job.FromAddress || state.GraphURL != endpoints.GraphURL || len(state.Attachments) != len(job.Attachments)) {
+		state = nil
+	}
+
+	if state != nil && state.DraftID != "" {
+		var draft struct {
+			IsDraft bool `json:"isDraft"`
+		}
+		err := s.graphCall(oauthService, http.MethodGet, mailboxURL+"/messages/"+state.DraftID+"?$select=isDraft", nil, &draft)
+		var apiErr *GraphAPIError
+		switch {
+		case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound:
+			state = nil
+		case err != nil:
+			return nil, err
+		case !draft.IsDraft:
+			log.Printf("Mail %s draft %s was already sent by a previous attempt", job.MailID, state.DraftID)
+			s.deleteUploadState(job.MailID)
+			return nil, nil
+		default:
+			log.Printf("Resuming Graph upload for mail %s (draft %s)", job.MailID, state.DraftID)
+		}
+	}
+
+	if state == nil {
+		state = &GraphUploadState{
+			MailID:      job.MailID,
+			Mailbox:     job.FromAddress,
+			GraphURL:    endpoints.GraphURL,
+			Attachments: make([]GraphUploadAttachment, len(job.Attachments)),
+		}
+		for i, att := range job.Attachments {
+			state.Attachments[i].Name = filepath.Base(att.Filename)
+		}
+	}
+	return state, nil
+}
+
+// createDraft 建立不含附件的草稿
+func (s *GraphMailService) createDraft(oauthService *microsoft.OAuthService, job *models.MailJob, mailboxURL string) (string, error) {
+	message := s.buildGraphRequest(job).Message
+
+	var draft struct {
+		ID string `json:"id"`
+	}
+	if err := s.graphCall(oauthService, http.MethodPost, mailboxURL+"/messages", message, &draft); err != nil {
+		return "", fmt.Errorf("failed to create draft: %w", err)
+	}
+	if draft.ID == "" {
+		return "", errors.New("failed to create draft: empty message id")
+	}
+	return draft.ID, nil
+}
+
+// skipExistingAttachments 續傳時列出草稿已有的附件，將尚未標記完成但已存在的附件視為完成
+// 上次 POST 附件成功但回應或進度遺失時，避免重複加入同一附件；upload session 上傳中的附件
+// 完成前不會出現在清單中。Graph 回傳的 size 含附件項目本身的額外大小，因此只比對名稱 (同名依序對應)
+func (s *GraphMailService) skipExistingAttachments(oauthService *microsoft.OAuthService, messageURL string, state *GraphUploadState) error {
+	var existing struct {
+		Value []struct {
+			Name string `json:"name"`
+		} `json:"value"`
+	}
+	if err := s.graphCall(oauthService, http.MethodGet, messageURL+"/attachments?$select=name", nil, &existing); err != nil {
+		return fmt.Errorf("failed to list draft attachments: %w", err)
+	}
+
+	present := make(map[string]int, len(existing.Value))
+	for _, att := range existing.Value {
+		present[att.Name]++
+	}
+	for _, progress := range state.Attachments {
+		if progress.Done {
+			present[progress.Name]--
+		}
+	}
+
+	changed := false
+	for i := range state.Attachments {
+		progress := &state.Attachments[i]
+		if progress.Done || present[progress.Name] <= 0 {
+			continue
+		}
+		log.Printf("Attachment %s already exists on draft %s, skipping", progress.Name, state.DraftID)
+		present[progress.Name]--
+		progress.Done = true
+		progress.UploadURL = ""
+		changed = true
+	}
+	if changed {
+		s.saveUploadState(state)
+	}
+	return nil
+}
+
+// uploadAttachment 加入單一附件：小於 3 MB 直接 POST，否則以 upload session 分段上傳
+func (s *GraphMailService) uploadAttachment(oauthService *microsoft.OAuthService, messageURL string, state *GraphUploadState, i int, att models.AttachmentInfo) error {
+	contentType := att.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	file, err := os.Open(att.StoragePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	progress := &state.Attachments[i]
+	progress.Size = size
+
+	if size < graphAttachmentPostLimit {
+		content, err := io.ReadAll(file)
+		if err != nil {
+			return err
+		}
+		return s.graphCall(oauthService, http.MethodPost, messageURL+"/attachments", GraphAttachment{
+			ODataType:    "#microsoft.graph.fileAttachment",
+			Name:         progress.Name,
+			ContentType:  contentType,
+			ContentBytes: base64.StdEncoding.EncodeToString(content),
+			ContentID:    att.ContentID,
+			IsInline:     att.Inline,
+		}, nil)
+	}
+
+	// 續傳：向 upload session 查詢下一段的起點，session 已失效時重新建立
+	var offset int64
+	if progress.UploadURL != "" {
+		offset, err = s.uploadOffset(progress.UploadURL)
+		if err != nil {
+			log.Printf("Graph upload session for %s expired, restarting: %v", progress.Name, err)
+			progress.UploadURL = ""
+			offset = 0
+		}
+	}
+	if progress.UploadURL == "" {
+		var session struct {
+			UploadURL string `json:"uploadUrl"`
+		}
+		item := map[string]interface{}{
+			"attachmentType": "file",
+			"name":           progress.Name,
+			"size":           size,
+			"contentType":    contentType,
+		}
+		if att.Inline {
+			item["isInline"] = true
+			item["contentId"] = att.ContentID
+		}
+		body := map[string]interface{}{"AttachmentItem": item}
+		if err := s.graphCall(oauthService, http.MethodPost, messageURL+"/attachments/createUploadSession", body, &session); err != nil {
+			return fmt.Errorf("failed to create upload session: %w", err)
+		}
+		progress.UploadURL = session.UploadURL
+		s.saveUploadState(state)
+	}
+
+	chunkSize := s.uploadChunkSize()
+	for offset < size {
+		n := chunkSize
+		if offset+n > size {
+			n = size - offset
+		}
+		next, done, err := s.uploadChunk(progress.UploadURL, io.NewSectionReader(file, offset, n), offset, n, size)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+		offset = next
+	}
+	return nil
+}
+
+// uploadChunk PUT 一個分段至 upload session (不帶 Authorization)
+// 回傳下一段的起點；201 表示附件已完成
+func (s *GraphMailService) uploadChunk(uploadURL string, chunk io.Reader, offset, n, size int64) (int64, bool, error) {
+	req, err := http.NewRequest(http.MethodPut, uploadURL, chunk)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.ContentLength = n
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+n-1, size))
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to upload chunk: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return size, true, nil
+	case http.StatusOK, http.StatusAccepted:
+		next, ok := parseNextExpectedRange(resp.Body)
+		if !ok || next <= offset {
+			next = offset + n
+		}
+		return next, false, nil
+	default:
+		return 0, false, newGraphAPIError(resp)
+	}
+}
+
+// uploadOffset 查詢 upload session 的下一段起點
+func (s *GraphMailService) uploadOffset(uploadURL string) (int64, error) {
+	resp, err := s.httpClient.Get(uploadURL)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, newGraphAPIError(resp)
+	}
+	next, ok := parseNextExpectedRange(resp.Body)
+	if !ok {
+		return 0, errors.New("upload session has no expected ranges")
+	}
+	return next, nil
+}
+
+// parseNextExpectedRange 解析 nextExpectedRanges 第一個區段的起點 (如 "327680-")
+func parseNextExpectedRange(body io.Reader) (int64, bool) {
+	var status struct {
+		NextExpectedRanges []string `json:"nextExpectedRanges"`
+	}
+	if err := json.NewDecoder(body).Decode(&status); err != nil || len(status.NextExpectedRanges) == 0 {
+		return 0, false
+	}
+	start, _, _ := strings.Cut(status.NextExpectedRanges[0], "-")
+	next, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return next, true
+}
+
+// discardDraft 刪除未送出的草稿與進度 (最後一次嘗試失敗時)
+func (s *GraphMailService) discardDraft(oauthService *microsoft.OAuthService, mailboxURL string, state *GraphUploadState) {
+	if state.DraftID != "" {
+		if err := s.graphCall(oauthService, http.MethodDelete, mailboxURL+"/messages/"+state.DraftID, nil, nil); err != nil {
+			log.Printf("Failed to delete draft %s of mail %s: %v", state.DraftID, state.MailID, err)
+		}
+	}
+	s.deleteUploadState(state.MailID)
+}
+
+// graphCall 以 JSON 呼叫 Graph API，out 不為 nil 時解析回應
+func (s *GraphMailService) graphCall(oauthService *microsoft.OAuthService, method, url string, body, out interface{}) error {
+	accessToken, err := oauthService.GetAccessToken()
+	if err != nil {
+		return fmt.Errorf("failed to get access token: %w", err)
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	// 不可變 ID：草稿送出移至寄件備份後 ID 不變，續傳時才能判斷是否已送出
+	req.Header.Set("Prefer", `IdType="ImmutableId"`)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return newGraphAPIError(resp)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	return nil
+}
+
+// loadUploadState 讀取上傳進度 (無儲存或讀取失敗時重新開始)
+func (s *GraphMailService) loadUploadState(mailID string) *GraphUploadState {
+	if s.uploads == nil || mailID == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), graphUploadStateTimeout)
+	defer cancel()
+
+	state, err := s.uploads.Get(ctx, mailID)
+	if err != nil {
+		log.Printf("Failed to load Graph upload state of mail %s: %v", mailID, err)
+		return nil
+	}
+	return state
+}
+
+// saveUploadState 儲存上傳進度 (失敗只記錄，下次重試重新上傳)
+func (s *GraphMailService) saveUploadState(state *GraphUploadState) {
+	if s.uploads == nil || state.MailID == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), graphUploadStateTimeout)
+	defer cancel()
+
+	if err := s.uploads.Set(ctx, state); err != nil {
+		log.Printf("Failed to save Graph upload state of mail %s: %v", state.MailID, err)
+	}
+}
+
+// deleteUploadState 刪除上傳進度
+func (s *GraphMailService) deleteUploadState(mailID string) {
+	if s.uploads == nil || mailID == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), graphUploadStateTimeout)
+	defer cancel()
+
+	if err := s.uploads.Delete(ctx, mailID); err != nil {
+		log.Printf("Failed to delete Graph upload state of mail %s: %v", mailID, err)
+	}
+}
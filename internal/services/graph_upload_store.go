@@ -0,0 +1,59 @@
+// internal/services/graph_upload_store.go
+// Graph 大型附件上傳進度的 KeyDB 儲存 (Worker 重試時續傳)
+
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// graphUploadStateTTL 上傳進度保留時間 (upload session 與重試皆在此期間內)
+const graphUploadStateTTL = 24 * time.Hour
+
+// GraphUploadStore 實作 GraphUploadStateStore
+// upload session URL 已含授權，與 Access Token 同為敏感資料，僅存於內部 KeyDB
+type GraphUploadStore struct {
+	client *redis.Client
+}
+
+// NewGraphUploadStore 建立上傳進度儲存 (與狀態快取共用 KeyDB 連線)
+func NewGraphUploadStore(keydbService *KeyDBService) *GraphUploadStore {
+	return &GraphUploadStore{client: keydbService.client}
+}
+
+// Get 取得上傳進度
+func (s *GraphUploadStore) Get(ctx context.Context, mailID string) (*GraphUploadState, error) {
+	data, err := s.client.Get(ctx, "graph:upload:"+mailID).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get upload state: %w", err)
+	}
+
+	var state GraphUploadState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal upload state: %w", err)
+	}
+	return &state, nil
+}
+
+// Set 儲存上傳進度
+func (s *GraphUploadStore) Set(ctx context.Context, state *GraphUploadState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal upload state: %w", err)
+	}
+	return s.client.Set(ctx, "graph:upload:"+state.MailID, data, graphUploadStateTTL).Err()
+}
+
+// Delete 刪除上傳進度
+func (s *GraphUploadStore) Delete(ctx context.Context, mailID string) error {
+	return s.client.Del(ctx, "graph:upload:"+mailID).Err()
+}
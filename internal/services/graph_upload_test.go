@@ -0,0 +1,275 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"mail-proxy/internal/config"
+	"mail-proxy/internal/models"
+	"mail-proxy/pkg/microsoft/graphtest"
+)
+
+// memoryUploadStore 以記憶體儲存上傳進度 (模擬 KeyDB)
+type memoryUploadStore struct {
+	mu     sync.Mutex
+	states map[string]GraphUploadState
+}
+
+func newMemoryUploadStore() *memoryUploadStore {
+	return &memoryUploadStore{states: make(map[string]GraphUploadState)}
+}
+
+func (m *memoryUploadStore) Get(ctx context.Context, mailID string) (*GraphUploadState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, ok := m.states[mailID]
+	if !ok {
+		return nil, nil
+	}
+	state.Attachments = append([]GraphUploadAttachment(nil), state.Attachments...)
+	return &state, nil
+}
+
+func (m *memoryUploadStore) Set(ctx context.Context, state *GraphUploadState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	copied := *state
+	copied.Attachments = append([]GraphUploadAttachment(nil), state.Attachments...)
+	m.states[state.MailID] = copied
+	return nil
+}
+
+func (m *memoryUploadStore) Delete(ctx context.Context, mailID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.states, mailID)
+	return nil
+}
+
+// failingPutTransport 第 failAt 個 PUT (upload session 分段) 回傳連線錯誤，模擬上傳中斷
+type failingPutTransport struct {
+	base   http.RoundTripper
+	mu     sync.Mutex
+	puts   int
+	failAt int
+}
+
+func (t *failingPutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodPut {
+		t.mu.Lock()
+		t.puts++
+		fail := t.puts == t.failAt
+		t.mu.Unlock()
+		if fail {
+			return nil, errors.New("simulated connection reset")
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// lostAttachmentResponseTransport 第一個附件 POST 送達伺服器後回傳連線錯誤，模擬附件已加入但回應遺失
+type lostAttachmentResponseTransport struct {
+	base http.RoundTripper
+	mu   sync.Mutex
+	lost bool
+}
+
+func (t *lostAttachmentResponseTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || req.Method != http.MethodPost || !strings.HasSuffix(req.URL.Path, "/attachments") {
+		return resp, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.lost {
+		return resp, nil
+	}
+	t.lost = true
+	resp.Body.Close()
+	return nil, errors.New("simulated connection reset")
+}
+
+// uploadTestConfig 超過 1 KB 即使用草稿流程，分段大小 1 MiB
+func uploadTestConfig() *config.Config {
+	return &config.Config{
+		GraphUploadThresholdKB: 1,
+		GraphUploadChunkSizeKB: 1024,
+		MaxRetryCount:          3,
+	}
+}
+
+// writeAttachment 於暫存目錄建立指定大小的附件
+func writeAttachment(t *testing.T, name string, size int) (models.AttachmentInfo, []byte) {
+	t.Helper()
+	content := bytes.Repeat([]byte(name), size/len(name)+1)[:size]
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return models.AttachmentInfo{Filename: name, ContentType: "application/octet-stream", StoragePath: path, SizeBytes: int64(size)}, content
+}
+
+func largeAttachmentJob(t *testing.T) (*models.MailJob, []byte, []byte) {
+	large, largeContent := writeAttachment(t, "large.bin", 3<<20+512<<10) // 3.5 MiB：upload session 4 段
+	small, smallContent := writeAttachment(t, "small.txt", 2048)
+	job := &models.MailJob{
+		MailID:      "mail-upload",
+		FromAddress: "sender@example.com",
+		ToAddresses: []string{"a@example.com"},
+		Subject:     "Large",
+		Body:        "see attachments",
+		Attachments: []models.AttachmentInfo{small, large},
+	}
+	return job, smallContent, largeContent
+}
+
+func TestGraphUploadSession(t *testing.T) {
+	srv := graphtest.NewServer()
+	defer srv.Close()
+	service := newTestGraphService(srv, uploadTestConfig())
+	store := newMemoryUploadStore()
+	service.SetUploadStateStore(store)
+
+	job, smallContent, largeContent := largeAttachmentJob(t)
+	if err := service.SendMail(job); err != nil {
+		t.Fatalf("SendMail() error = %v", err)
+	}
+
+	drafts := srv.Drafts()
+	if len(drafts) != 1 || !drafts[0].Sent || len(drafts[0].Attachments) != 2 {
+		t.Fatalf("unexpected drafts %+v", drafts)
+	}
+	small, large := drafts[0].Attachments[0], drafts[0].Attachments[1]
+	if small.Name != "small.txt" || small.Uploaded || !bytes.Equal(small.Content, smallContent) {
+		t.Fatalf("unexpected small attachment %q (uploaded=%v)", small.Name, small.Uploaded)
+	}
+	if large.Name != "large.bin" || !large.Uploaded || large.Chunks != 4 || !bytes.Equal(large.Content, largeContent) {
+		t.Fatalf("unexpected large attachment %q (uploaded=%v, chunks=%d)", large.Name, large.Uploaded, large.Chunks)
+	}
+
+	requests := srv.SendRequests()
+	if len(requests) != 1 || requests[0].DraftID != drafts[0].ID {
+		t.Fatalf("unexpected send requests %+v", requests)
+	}
+	if state, _ := store.Get(context.Background(), job.MailID); state != nil {
+		t.Fatalf("upload state was not deleted: %+v", state)
+	}
+}
+
+func TestGraphUploadSessionResume(t *testing.T) {
+	srv := graphtest.NewServer()
+	defer srv.Close()
+	service := newTestGraphService(srv, uploadTestConfig())
+	store := newMemoryUploadStore()
+	service.SetUploadStateStore(store)
+
+	// 第三段上傳時連線中斷
+	transport := &failingPutTransport{base: srv.Client().Transport, failAt: 3}
+	service.httpClient = &http.Client{Transport: transport}
+
+	job, _, largeContent := largeAttachmentJob(t)
+	if err := service.SendMail(job); err == nil {
+		t.Fatal("SendMail() succeeded despite the interrupted upload")
+	}
+	state, _ := store.Get(context.Background(), job.MailID)
+	if state == nil || state.DraftID == "" || !state.Attachments[0].Done || state.Attachments[1].Done || state.Attachments[1].UploadURL == "" {
+		t.Fatalf("unexpected upload state after interruption %+v", state)
+	}
+
+	// 重試時沿用草稿與 upload session，只上傳剩下的分段
+	job.RetryCount++
+	if err := service.SendMail(job); err != nil {
+		t.Fatalf("SendMail() retry error = %v", err)
+	}
+
+	drafts := srv.Drafts()
+	if len(drafts) != 1 || drafts[0].ID != state.DraftID || !drafts[0].Sent {
+		t.Fatalf("unexpected drafts %+v", drafts)
+	}
+	if len(drafts[0].Attachments) != 2 {
+		t.Fatalf("attachments = %d, want 2", len(drafts[0].Attachments))
+	}
+	large := drafts[0].Attachments[1]
+	if large.Chunks != 4 || !bytes.Equal(large.Content, largeContent) {
+		t.Fatalf("resumed attachment has %d chunks (want 4) or mismatched content", large.Chunks)
+	}
+	if transport.puts != 5 {
+		t.Fatalf("PUT requests = %d, want 5 (2 before and 2 after the failed one)", transport.puts)
+	}
+}
+
+func TestGraphUploadSessionDeletesDraftOnFinalRetry(t *testing.T) {
+	srv := graphtest.NewServer()
+	defer srv.Close()
+	cfg := uploadTestConfig()
+	service := newTestGraphService(srv, cfg)
+	store := newMemoryUploadStore()
+	service.SetUploadStateStore(store)
+
+	job, _, _ := largeAttachmentJob(t)
+
+	// 非最後一次嘗試：草稿保留供下次續傳
+	srv.FailNextSend(http.StatusServiceUnavailable, "ServiceUnavailable", "try again")
+	if err := service.SendMail(job); err == nil {
+		t.Fatal("SendMail() succeeded despite the send failure")
+	}
+	if drafts := srv.Drafts(); len(drafts) != 1 {
+		t.Fatalf("drafts = %d, want 1 kept for the next retry", len(drafts))
+	}
+
+	// 最後一次嘗試仍失敗：刪除草稿與進度
+	job.RetryCount = cfg.MaxRetryCount - 1
+	srv.FailNextSend(http.StatusServiceUnavailable, "ServiceUnavailable", "try again")
+	if err := service.SendMail(job); err == nil {
+		t.Fatal("SendMail() succeeded despite the send failure")
+	}
+	if drafts := srv.Drafts(); len(drafts) != 0 {
+		t.Fatalf("drafts = %+v, want deleted", drafts)
+	}
+	if state, _ := store.Get(context.Background(), job.MailID); state != nil {
+		t.Fatalf("upload state was not deleted: %+v", state)
+	}
+}
+
+func TestGraphUploadSessionResumeSkipsExistingAttachments(t *testing.T) {
+	srv := graphtest.NewServer()
+	defer srv.Close()
+	service := newTestGraphService(srv, uploadTestConfig())
+	store := newMemoryUploadStore()
+	service.SetUploadStateStore(store)
+	service.httpClient = &http.Client{Transport: &lostAttachmentResponseTransport{base: srv.Client().Transport}}
+
+	job, smallContent, largeContent := largeAttachmentJob(t)
+	if err := service.SendMail(job); err == nil {
+		t.Fatal("SendMail() succeeded despite the lost attachment response")
+	}
+	state, _ := store.Get(context.Background(), job.MailID)
+	if state == nil || state.DraftID == "" || state.Attachments[0].Done {
+		t.Fatalf("unexpected upload state after the lost response %+v", state)
+	}
+
+	// 重試時草稿已有小附件，不應再次加入
+	job.RetryCount++
+	if err := service.SendMail(job); err != nil {
+		t.Fatalf("SendMail() retry error = %v", err)
+	}
+
+	drafts := srv.Drafts()
+	if len(drafts) != 1 || !drafts[0].Sent || len(drafts[0].Attachments) != 2 {
+		t.Fatalf("unexpected drafts %+v", drafts)
+	}
+	small, large := drafts[0].Attachments[0], drafts[0].Attachments[1]
+	if small.Name != "small.txt" || !bytes.Equal(small.Content, smallContent) {
+		t.Fatalf("unexpected small attachment %q", small.Name)
+	}
+	if large.Name != "large.bin" || !bytes.Equal(large.Content, largeContent) {
+		t.Fatalf("unexpected large attachment %q", large.Name)
+	}
+}
@@ -0,0 +1,357 @@
+// internal/services/html_text.go
+// 由 HTML 產生可讀的純文字內文 (連結、清單、表格與引用)
+
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+)
+
+// 純文字輸出時略過的標籤
+var textSkipTags = map[string]bool{
+	"head": true, "script": true, "style": true, "title": true, "noscript": true, "template": true,
+}
+
+// 段落標籤 (前後空一行)
+var textParagraphTags = map[string]bool{
+	"p": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"dl": true, "figure": true, "address": true,
+}
+
+// 區塊標籤 (前後換行)
+var textBlockTags = map[string]bool{
+	"div": true, "section": true, "article": true, "header": true, "footer": true, "main": true,
+	"nav": true, "aside": true, "center": true, "form": true, "fieldset": true, "dt": true,
+	"dd": true, "figcaption": true, "li": true, "tr": true,
+}
+
+var (
+	// textBlankLinesPattern 連續三個以上的換行
+	textBlankLinesPattern = regexp.MustCompile(`\n{3,}`)
+	// textTrailingSpacePattern 行尾空白
+	textTrailingSpacePattern = regexp.MustCompile(`[ \t]+\n`)
+)
+
+// htmlToText 將 HTML 文件轉為純文字
+func htmlToText(doc *html.Node) string {
+	w := &textWriter{lineStart: true}
+	w.render(doc)
+	return w.String()
+}
+
+// textWriter 純文字輸出 (處理空白合併、換行與行首前綴)
+type textWriter struct {
+	b         strings.Builder
+	prefixes  []string // 每行開頭的前綴 (引用 "> "、清單縮排)
+	marker    string   // 清單項目第一行的標記 ("- " / "1. ")
+	lineStart bool
+	space     bool // 下一個字前需補空白
+	newlines  int  // 結尾連續換行數
+	pre       int  // 位於 <pre> 內 (保留空白)
+	item      int  // 位於清單項目內 (段落不空行)
+}
+
+// String 輸出結果 (去除行尾空白與多餘空行)
+func (w *textWriter) String() string {
+	text := textTrailingSpacePattern.ReplaceAllString(w.b.String()+"\n", "\n")
+	text = textBlankLinesPattern.ReplaceAllString(text, "\n\n")
+	return strings.TrimSpace(text)
+}
+
+// word 輸出一段不含換行的文字
+func (w *textWriter) word(s string) {
+	if w.lineStart {
+		if w.marker != "" {
+			w.b.WriteString(strings.Join(w.prefixes[:len(w.prefixes)-1], ""))
+			w.b.WriteString(w.marker)
+			w.marker = ""
+		} else {
+			w.b.WriteString(strings.Join(w.prefixes, ""))
+		}
+		w.lineStart = false
+	} else if w.space {
+		w.b.WriteByte(' ')
+	}
+	w.space = false
+	w.b.WriteString(s)
+	w.newlines = 0
+}
+
+// text 輸出文字節點 (<pre> 外合併空白)
+func (w *textWriter) text(s string) {
+	if w.pre > 0 {
+		w.lines(s)
+		return
+	}
+
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		if s != "" {
+			w.space = true
+		}
+		return
+	}
+	if strings.TrimLeftFunc(s, unicode.IsSpace) != s {
+		w.space = true
+	}
+	for i, f := range fields {
+		if i > 0 {
+			w.space = true
+		}
+		w.word(f)
+	}
+	if strings.TrimRightFunc(s, unicode.IsSpace) != s {
+		w.space = true
+	}
+}
+
+// lines 逐行輸出 (保留行內空白)
+func (w *textWriter) lines(s string) {
+	for i, line := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n") {
+		if i > 0 {
+			w.newline()
+		}
+		if line != "" {
+			w.word(line)
+		}
+	}
+}
+
+// newline 換行
+func (w *textWriter) newline() {
+	w.b.WriteByte('\n')
+	w.lineStart = true
+	w.space = false
+	w.newlines++
+}
+
+// block 確保結尾至少有 n 個換行 (1 為換行，2 為空一行)
+func (w *textWriter) block(n int) {
+	if w.b.Len() == 0 {
+		return
+	}
+	if w.item > 0 && n > 1 {
+		n = 1
+	}
+	for w.newlines < n {
+		w.newline()
+	}
+}
+
+// render 依節點類型輸出
+func (w *textWriter) render(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		w.text(n.Data)
+		return
+	case html.DocumentNode:
+		w.children(n)
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+
+	tag := n.Data
+	switch {
+	case textSkipTags[tag]:
+	case tag == "br":
+		w.newline()
+	case tag == "hr":
+		w.block(2)
+		w.word("----------------------------------------")
+		w.block(2)
+	case tag == "img":
+		if alt := strings.Join(strings.Fields(htmlAttr(n, "alt")), " "); alt != "" {
+			w.word("[" + alt + "]")
+		}
+	case tag == "a":
+		w.link(n)
+	case tag == "ul" || tag == "ol":
+		w.list(n)
+	case tag == "table":
+		w.table(n)
+	case tag == "pre":
+		w.block(2)
+		w.pre++
+		w.children(n)
+		w.pre--
+		w.block(2)
+	case tag == "blockquote":
+		w.block(2)
+		w.prefixes = append(w.prefixes, "> ")
+		w.children(n)
+		w.prefixes = w.prefixes[:len(w.prefixes)-1]
+		w.block(2)
+	case textParagraphTags[tag]:
+		w.block(2)
+		w.children(n)
+		w.block(2)
+	case textBlockTags[tag]:
+		w.block(1)
+		w.children(n)
+		w.block(1)
+	default:
+		w.children(n)
+	}
+}
+
+// children 輸出所有子節點
+func (w *textWriter) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		w.render(c)
+	}
+}
+
+// link 輸出連結文字，並在文字與網址不同時於後方附上網址
+func (w *textWriter) link(n *html.Node) {
+	href := strings.TrimSpace(htmlAttr(n, "href"))
+	lower := strings.ToLower(href)
+	if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(lower, "javascript:") || strings.HasPrefix(lower, "cid:") {
+		w.children(n)
+		return
+	}
+
+	display := href
+	if strings.HasPrefix(lower, "mailto:") || strings.HasPrefix(lower, "tel:") {
+		display = href[strings.Index(href, ":")+1:]
+		if i := strings.Index(display, "?"); i >= 0 {
+			display = display[:i]
+		}
+	}
+
+	text := strings.Join(strings.Fields(nodeText(n)), " ")
+	if text == "" && !hasImageAlt(n) {
+		w.word(display)
+		return
+	}
+
+	w.children(n)
+	if text != display && text != href {
+		w.space = true
+		w.word("(" + display + ")")
+	}
+}
+
+// hasImageAlt 是否含有具替代文字的圖片
+func hasImageAlt(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.Data == "img" && strings.TrimSpace(htmlAttr(c, "alt")) != "" {
+			return true
+		}
+		if hasImageAlt(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// list 輸出清單 (ul 以 "- "、ol 以編號標記，巢狀清單縮排)
+func (w *textWriter) list(n *html.Node) {
+	ordered := n.Data == "ol"
+	index := 1
+	if start, err := strconv.Atoi(htmlAttr(n, "start")); err == nil && ordered {
+		index = start
+	}
+
+	w.block(2)
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.Data != "li" {
+			w.render(c)
+			continue
+		}
+
+		marker := "- "
+		if ordered {
+			marker = fmt.Sprintf("%d. ", index)
+			index++
+		}
+		w.block(1)
+		w.marker = marker
+		w.prefixes = append(w.prefixes, strings.Repeat(" ", len(marker)))
+		w.item++
+		w.children(c)
+		w.item--
+		w.prefixes = w.prefixes[:len(w.prefixes)-1]
+		w.marker = ""
+		w.block(1)
+	}
+	w.block(2)
+}
+
+// table 逐列輸出表格：多個單行儲存格以 " | " 分隔，
+// 含多行內容的儲存格 (常見於排版用表格) 則依序輸出為區塊
+func (w *textWriter) table(n *html.Node) {
+	w.block(1)
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.Data == "caption" {
+			w.children(c)
+			w.block(1)
+		}
+	}
+	for _, row := range tableRows(n) {
+		var cells []string
+		multiline := false
+		for c := row.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode || (c.Data != "td" && c.Data != "th") {
+				continue
+			}
+			sub := &textWriter{lineStart: true}
+			sub.children(c)
+			if cell := sub.String(); cell != "" {
+				cells = append(cells, cell)
+				multiline = multiline || strings.Contains(cell, "\n")
+			}
+		}
+
+		if len(cells) > 1 && !multiline {
+			w.block(1)
+			w.word(strings.Join(cells, " | "))
+			w.block(1)
+			continue
+		}
+		for _, cell := range cells {
+			w.block(1)
+			w.lines(cell)
+			w.block(1)
+		}
+	}
+	w.block(1)
+}
+
+// tableRows 表格的列 (含 thead / tbody / tfoot 內的列，不含巢狀表格)
+func tableRows(table *html.Node) []*html.Node {
+	var rows []*html.Node
+	for c := table.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		switch c.Data {
+		case "tr":
+			rows = append(rows, c)
+		case "thead", "tbody", "tfoot":
+			for r := c.FirstChild; r != nil; r = r.NextSibling {
+				if r.Type == html.ElementNode && r.Data == "tr" {
+					rows = append(rows, r)
+				}
+			}
+		}
+	}
+	return rows
+}
+
+// htmlAttr 取得屬性值
+func htmlAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
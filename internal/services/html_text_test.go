@@ -0,0 +1,90 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestHTMLToText(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{
+			name: "link text differs from url",
+			html: `<p>See <a href="https://example.com/">our site</a>.</p>`,
+			want: "See our site (https://example.com/).",
+		},
+		{
+			name: "link text equals url",
+			html: `<a href="https://example.com/">https://example.com/</a>`,
+			want: "https://example.com/",
+		},
+		{
+			name: "empty link shows url",
+			html: `<a href="https://example.com/"></a>`,
+			want: "https://example.com/",
+		},
+		{
+			name: "mailto shows address",
+			html: `<a href="mailto:help@example.com?subject=Hi">Contact us</a>`,
+			want: "Contact us (help@example.com)",
+		},
+		{
+			name: "anchor javascript and cid links keep text only",
+			html: `<a href="#top">Top</a> <a href="javascript:void(0)">Run</a> <a href="cid:logo"><img alt="Logo"></a>`,
+			want: "Top Run [Logo]",
+		},
+		{
+			name: "image link with alt",
+			html: `<a href="https://example.com/"><img src="logo.png" alt="Example"></a>`,
+			want: "[Example] (https://example.com/)",
+		},
+		{
+			name: "unordered and nested list",
+			html: `<ul><li>one</li><li>two<ul><li>nested</li></ul></li></ul>`,
+			want: "- one\n- two\n  - nested",
+		},
+		{
+			name: "ordered list with start",
+			html: `<p>Steps:</p><ol start="3"><li>three</li><li><p>four</p></li></ol><p>Done</p>`,
+			want: "Steps:\n\n3. three\n4. four\n\nDone",
+		},
+		{
+			name: "data table",
+			html: `<table><caption>Order</caption><thead><tr><th>Name</th><th>Qty</th></tr></thead><tbody><tr><td>Apple</td><td>3</td></tr></tbody></table>`,
+			want: "Order\nName | Qty\nApple | 3",
+		},
+		{
+			name: "layout table with multi-line cells",
+			html: `<table><tr><td><p>Left</p><p>column</p></td><td>Right</td></tr></table>`,
+			want: "Left\n\ncolumn\nRight",
+		},
+		{
+			name: "blockquote and pre",
+			html: `<blockquote><p>quoted</p><p>text</p></blockquote><pre>a  b
+  c</pre>`,
+			want: "> quoted\n\n> text\n\na  b\n  c",
+		},
+		{
+			name: "whitespace, br, head and script skipped",
+			html: `<html><head><title>T</title><style>p{}</style></head><body><script>x()</script><p>Hello
+			   world<br>again</p></body></html>`,
+			want: "Hello world\nagain",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := html.Parse(strings.NewReader(tt.html))
+			if err != nil {
+				t.Fatalf("html.Parse() error = %v", err)
+			}
+			if got := htmlToText(doc); got != tt.want {
+				t.Errorf("htmlToText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
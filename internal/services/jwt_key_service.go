@@ -0,0 +1,377 @@
+// internal/services/jwt_key_service.go
+// JWT 簽章金鑰服務 - 非對稱簽章、kid 輪替與 JWKS
+
+package services
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"mail-proxy/internal/config"
+	"mail-proxy/internal/models"
+)
+
+const (
+	// jwtRSAKeyBits RSA 金鑰長度
+	jwtRSAKeyBits = 2048
+	// jwtKeyCacheTTL 驗證用公鑰快取的有效時間
+	jwtKeyCacheTTL = time.Minute
+	// jwtKeyMissReloadInterval 遇到未知 kid 時重新載入的最短間隔
+	jwtKeyMissReloadInterval = 10 * time.Second
+)
+
+// JWTKeyService JWT 簽章金鑰服務
+// 有啟用中的非對稱金鑰時以最新一把簽發 (header 帶 kid)，否則退回 HS256 + JWTSecret
+type JWTKeyService struct {
+	cfg        *config.Config
+	db         *gorm.DB
+	encryption *EncryptionService // 可為 nil，此時只能驗證不能以非對稱金鑰簽發
+
+	mu       sync.RWMutex
+	keys     map[string]*cachedJWTKey
+	loadedAt time.Time
+}
+
+// cachedJWTKey 快取的驗證用公鑰
+type cachedJWTKey struct {
+	algorithm string
+	publicKey crypto.PublicKey
+}
+
+// NewJWTKeyService 建立 JWT 簽章金鑰服務
+func NewJWTKeyService(cfg *config.Config, db *gorm.DB, encryption *EncryptionService) *JWTKeyService {
+	return &JWTKeyService{
+		cfg:        cfg,
+		db:         db,
+		encryption: encryption,
+		keys:       make(map[string]*cachedJWTKey),
+	}
+}
+
+// GenerateKey 產生新的簽章金鑰，成為之後簽發 Token 使用的金鑰
+func (s *JWTKeyService) GenerateKey(algorithm string) (*models.JWTSigningKey, error) {
+	if s.encryption == nil {
+		return nil, errors.New("ENCRYPTION_KEY is required to store JWT signing keys")
+	}
+
+	var signer crypto.Signer
+	var err error
+	switch algorithm {
+	case models.JWTAlgorithmRS256:
+		signer, err = rsa.GenerateKey(rand.Reader, jwtRSAKeyBits)
+	case models.JWTAlgorithmES256:
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case models.JWTAlgorithmEdDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported JWT algorithm: %s", algorithm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate %s key: %w", algorithm, err)
+	}
+
+	privDER, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode private key: %w", err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode public key: %w", err)
+	}
+
+	encryptedKey, err := s.encryption.Encrypt(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER})))
+	if err != nil {
+		return nil, errors.New("failed to encrypt private key")
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, fmt.Errorf("failed to generate kid: %w", err)
+	}
+
+	key := &models.JWTSigningKey{
+		ID:                  uuid.New(),
+		KID:                 fmt.Sprintf("%s-%s", time.Now().UTC().Format("20060102"), hex.EncodeToString(suffix)),
+		Algorithm:           algorithm,
+		PrivateKeyEncrypted: encryptedKey,
+		PublicKey:           string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})),
+		IsActive:            true,
+	}
+
+	if err := s.db.Create(key).Error; err != nil {
+		return nil, err
+	}
+
+	s.invalidate()
+	return key, nil
+}
+
+// List 列出所有金鑰，附上是否為簽發金鑰與仍使用中的 Token 數
+func (s *JWTKeyService) List() ([]models.JWTSigningKeyResponse, error) {
+	var keys []models.JWTSigningKey
+	if err := s.db.Order("created_at DESC").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+
+	signingKID := ""
+	for _, k := range keys {
+		if k.IsActive {
+			signingKID = k.KID
+			break
+		}
+	}
+
+	responses := make([]models.JWTSigningKeyResponse, len(keys))
+	for i, k := range keys {
+		var count int64
+		if err := s.db.Model(&models.ClientToken{}).
+			Where("signing_kid = ? AND is_active = ?", k.KID, true).
+			Count(&count).Error; err != nil {
+			return nil, err
+		}
+		responses[i] = models.JWTSigningKeyResponse{
+			JWTSigningKey: k,
+			Signing:       k.KID == signingKID,
+			ActiveTokens:  count,
+		}
+	}
+	return responses, nil
+}
+
+// Retire 停用金鑰，以此金鑰簽章的 Token 將無法再通過驗證，且自 JWKS 移除
+func (s *JWTKeyService) Retire(id uuid.UUID) error {
+	now := time.Now()
+	result := s.db.Model(&models.JWTSigningKey{}).
+		Where("id = ? AND is_active = ?", id, true).
+		Updates(map[string]interface{}{
+			"is_active":  false,
+			"retired_at": now,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("jwt signing key not found or already retired")
+	}
+
+	s.invalidate()
+	return nil
+}
+
+// Sign 簽發 JWT Token，回傳 Token 與使用的 kid (HS256 時為空字串)
+func (s *JWTKeyService) Sign(claims jwt.MapClaims) (string, string, error) {
+	var key models.JWTSigningKey
+	err := s.db.Where("is_active = ?", true).Order("created_at DESC").First(&key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// 尚未建立非對稱金鑰，沿用 HS256
+		if !s.cfg.JWTAllowHS256 {
+			return "", "", errors.New("no active JWT signing key and HS256 is disabled")
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		signed, err := token.SignedString([]byte(s.cfg.JWTSecret))
+		return signed, "", err
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to load JWT signing key: %w", err)
+	}
+
+	if s.encryption == nil {
+		return "", "", errors.New("ENCRYPTION_KEY is required to sign with JWT signing keys")
+	}
+	keyPEM, err := s.encryption.Decrypt(key.PrivateKeyEncrypted)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to decrypt JWT signing key: %w", err)
+	}
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return "", "", errors.New("invalid JWT signing key")
+	}
+	privateKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse JWT signing key: %w", err)
+	}
+
+	method := jwt.GetSigningMethod(key.Algorithm)
+	if method == nil {
+		return "", "", fmt.Errorf("unsupported JWT algorithm: %s", key.Algorithm)
+	}
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = key.KID
+	signed, err := token.SignedString(privateKey)
+	if err != nil {
+		return "", "", err
+	}
+	return signed, key.KID, nil
+}
+
+// ValidMethods 驗證時接受的簽章演算法
+func (s *JWTKeyService) ValidMethods() []string {
+	methods := []string{models.JWTAlgorithmRS256, models.JWTAlgorithmES256, models.JWTAlgorithmEdDSA}
+	if s.cfg.JWTAllowHS256 {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	return methods
+}
+
+// Keyfunc 提供 jwt.Parse 使用的驗證金鑰
+// HS256 只在 JWT_ALLOW_HS256 啟用時接受；非對稱演算法依 kid 取得公鑰且演算法需與金鑰一致
+func (s *JWTKeyService) Keyfunc(token *jwt.Token) (interface{}, error) {
+	alg := token.Method.Alg()
+	if alg == jwt.SigningMethodHS256.Alg() {
+		if !s.cfg.JWTAllowHS256 {
+			return nil, jwt.ErrTokenUnverifiable
+		}
+		return []byte(s.cfg.JWTSecret), nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("token missing kid")
+	}
+
+	key, err := s.lookup(kid)
+	if err != nil {
+		return nil, err
+	}
+	if key.algorithm != alg {
+		return nil, jwt.ErrTokenSignatureInvalid
+	}
+	return key.publicKey, nil
+}
+
+// JWKS 回傳所有啟用中金鑰的公鑰集合
+func (s *JWTKeyService) JWKS() (*models.JWKSet, error) {
+	var keys []models.JWTSigningKey
+	if err := s.db.Where("is_active = ?", true).Order("created_at DESC").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+
+	set := &models.JWKSet{Keys: make([]models.JWK, 0, len(keys))}
+	for _, k := range keys {
+		pub, err := parseJWTPublicKey(k.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %s: %w", k.KID, err)
+		}
+		jwk, err := toJWK(k.KID, k.Algorithm, pub)
+		if err != nil {
+			return nil, err
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set, nil
+}
+
+// lookup 從快取取得公鑰，過期或遇到未知 kid 時重新載入
+func (s *JWTKeyService) lookup(kid string) (*cachedJWTKey, error) {
+	s.mu.RLock()
+	key, ok := s.keys[kid]
+	age := time.Since(s.loadedAt)
+	s.mu.RUnlock()
+
+	if (ok && age < jwtKeyCacheTTL) || (!ok && age < jwtKeyMissReloadInterval) {
+		if !ok {
+			return nil, fmt.Errorf("unknown kid: %s", kid)
+		}
+		return key, nil
+	}
+
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown kid: %s", kid)
+}
+
+// reload 重新載入所有啟用中的公鑰
+func (s *JWTKeyService) reload() error {
+	var keys []models.JWTSigningKey
+	if err := s.db.Where("is_active = ?", true).Find(&keys).Error; err != nil {
+		return fmt.Errorf("failed to load JWT keys: %w", err)
+	}
+
+	loaded := make(map[string]*cachedJWTKey, len(keys))
+	for _, k := range keys {
+		pub, err := parseJWTPublicKey(k.PublicKey)
+		if err != nil {
+			continue
+		}
+		loaded[k.KID] = &cachedJWTKey{algorithm: k.Algorithm, publicKey: pub}
+	}
+
+	s.mu.Lock()
+	s.keys = loaded
+	s.loadedAt = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// invalidate 清除快取，下次驗證時重新載入
+func (s *JWTKeyService) invalidate() {
+	s.mu.Lock()
+	s.loadedAt = time.Time{}
+	s.mu.Unlock()
+}
+
+// parseJWTPublicKey 解析 PKIX PEM 公鑰
+func parseJWTPublicKey(publicKeyPEM string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(publicKeyPEM))
+	if block == nil {
+		return nil, errors.New("invalid PEM")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// toJWK 將公鑰轉為 JWK (RFC 7518 §6、RFC 8037)
+func toJWK(kid, algorithm string, pub crypto.PublicKey) (models.JWK, error) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwk := models.JWK{Use: "sig", Alg: algorithm, Kid: kid}
+
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = b64(k.N.Bytes())
+		jwk.E = b64(big.NewInt(int64(k.E)).Bytes())
+	case *ecdsa.PublicKey:
+		ecdhKey, err := k.ECDH()
+		if err != nil {
+			return jwk, fmt.Errorf("invalid EC public key %s: %w", kid, err)
+		}
+		// 未壓縮格式: 0x04 || X || Y
+		point := ecdhKey.Bytes()
+		size := (len(point) - 1) / 2
+		jwk.Kty = "EC"
+		jwk.Crv = k.Curve.Params().Name
+		jwk.X = b64(point[1 : 1+size])
+		jwk.Y = b64(point[1+size:])
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = b64(k)
+	default:
+		return jwk, fmt.Errorf("unsupported public key type for %s", kid)
+	}
+	return jwk, nil
+}
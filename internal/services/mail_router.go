@@ -15,17 +15,19 @@ import (
 // MailRouter 郵件路由服務
 // 根據 from_address 網域判斷使用 Graph API 或 SendGrid
 type MailRouter struct {
-	graphService    MailSender
-	sendgridService MailSender
-	orgDomain       string
+	graphService     MailSender
+	sendgridService  MailSender
+	smtpRelayService RawMailSender // 原始 MIME 直送的非組織網域出口 (可為 nil)
+	orgDomain        string
 }
 
 // NewMailRouter 建立郵件路由服務
-func NewMailRouter(cfg *config.Config, graphService MailSender, sendgridService MailSender) *MailRouter {
+func NewMailRouter(cfg *config.Config, graphService MailSender, sendgridService MailSender, smtpRelayService RawMailSender) *MailRouter {
 	return &MailRouter{
-		graphService:    graphService,
-		sendgridService: sendgridService,
-		orgDomain:       strings.ToLower(cfg.OrgEmailDomain),
+		graphService:     graphService,
+		sendgridService:  sendgridService,
+		smtpRelayService: smtpRelayService,
+		orgDomain:        strings.ToLower(cfg.OrgEmailDomain),
 	}
 }
 
@@ -42,6 +44,17 @@ func (r *MailRouter) Route(job *models.MailJob) MailSender {
 	return r.sendgridService
 }
 
+// Provider 回傳 job 實際使用的發送供應商 (供發送速率調節使用)
+func (r *MailRouter) Provider(job *models.MailJob) string {
+	if job.SenderConfigID != "" || strings.HasSuffix(strings.ToLower(job.FromAddress), r.orgDomain) {
+		return ProviderGraph
+	}
+	if job.SendMode == models.MailSendModeRaw && r.smtpRelayService != nil {
+		return ProviderSMTPRelay
+	}
+	return ProviderSendGrid
+}
+
 // SendMail 發送郵件 (自動路由到對應服務)
 func (r *MailRouter) SendMail(job *models.MailJob) error {
 	sender := r.Route(job)
@@ -49,6 +62,63 @@ func (r *MailRouter) SendMail(job *models.MailJob) error {
 	return sender.SendMail(job)
 }
 
+// RouteRaw 選擇原始 MIME 直送的服務
+// 組織網域使用 Graph MIME sendMail，非組織網域使用 SMTP Relay
+// 若對應服務不支援原始 MIME 則回傳 nil
+func (r *MailRouter) RouteRaw(job *models.MailJob) RawMailSender {
+	if strings.HasSuffix(strings.ToLower(job.FromAddress), r.orgDomain) {
+		// Graph MIME sendMail 依標頭決定收件者，無法限定只投遞給信封收件者
+		if job.Metadata[models.MetadataEnvelopeOnly] == "true" {
+			return nil
+		}
+		if rawSender, ok := r.graphService.(RawMailSender); ok {
+			return rawSender
+		}
+		return nil
+	}
+	return r.smtpRelayService
+}
+
+// SendRawMail 直送原始 MIME 郵件 (自動路由到對應服務)
+// 若無可用的原始直送出口，退回以解析後欄位發送
+func (r *MailRouter) SendRawMail(job *models.MailJob) error {
+	sender := r.RouteRaw(job)
+	if sender == nil {
+		log.Printf("No raw MIME sender available for %s, falling back to parsed mode", job.FromAddress)
+		return r.SendMail(job)
+	}
+
+	raw, err := LoadRawMessage(job)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Using %s (raw MIME) for sender: %s", sender.Name(), job.FromAddress)
+	return sender.SendRawMail(job, raw)
+}
+
+// SendRawMessage 直送記憶體中已組好的原始 MIME 郵件 (例如 DSN)
+// 信封寄件者為空 (NullSender) 的郵件只能經 SMTP Relay 送出 (Graph / SendGrid 無法指定空的信封寄件者)；
+// 其餘若無可用的原始直送出口，退回以 job 的解析後欄位發送
+func (r *MailRouter) SendRawMessage(job *models.MailJob, raw []byte) error {
+	if job.NullSender {
+		if r.smtpRelayService == nil {
+			return fmt.Errorf("SMTP relay is required to send mail with a null envelope sender")
+		}
+		log.Printf("Using %s (raw MIME, null envelope sender) for: %s", r.smtpRelayService.Name(), strings.Join(job.ToAddresses, ", "))
+		return r.smtpRelayService.SendRawMail(job, raw)
+	}
+
+	sender := r.RouteRaw(job)
+	if sender == nil {
+		log.Printf("No raw MIME sender available for %s, falling back to parsed mode", job.FromAddress)
+		return r.SendMail(job)
+	}
+
+	log.Printf("Using %s (raw MIME) for sender: %s", sender.Name(), job.FromAddress)
+	return sender.SendRawMail(job, raw)
+}
+
 // Name 回傳服務名稱
 func (r *MailRouter) Name() string {
 	return "MailRouter"
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"testing"
+
+	"mail-proxy/internal/config"
+	"mail-proxy/internal/models"
+)
+
+// fakeRawSender 記錄送出的 job 的原始 MIME 發送服務
+type fakeRawSender struct {
+	name string
+	sent []*models.MailJob
+}
+
+func (f *fakeRawSender) Name() string { return f.name }
+
+func (f *fakeRawSender) SendMail(job *models.MailJob) error {
+	f.sent = append(f.sent, job)
+	return nil
+}
+
+func (f *fakeRawSender) SendRawMail(job *models.MailJob, raw []byte) error {
+	f.sent = append(f.sent, job)
+	return nil
+}
+
+func TestSendRawMessageNullSender(t *testing.T) {
+	cfg := &config.Config{OrgEmailDomain: "@example.com"}
+	job := &models.MailJob{FromAddress: "postmaster@example.com", ToAddresses: []string{"user@other.test"}, NullSender: true}
+
+	graph := &fakeRawSender{name: "graph"}
+	sendgrid := &fakeRawSender{name: "sendgrid"}
+
+	// 未設定 SMTP Relay：不可退回 Graph / SendGrid
+	router := NewMailRouter(cfg, graph, sendgrid, nil)
+	if err := router.SendRawMessage(job, []byte("raw")); err == nil {
+		t.Fatal("SendRawMessage() without relay: want error")
+	}
+	if len(graph.sent)+len(sendgrid.sent) != 0 {
+		t.Fatalf("null sender mail sent via Graph / SendGrid")
+	}
+
+	// 組織網域的寄件者也必須經 SMTP Relay 送出
+	relay := &fakeRawSender{name: "relay"}
+	router = NewMailRouter(cfg, graph, sendgrid, relay)
+	if err := router.SendRawMessage(job, []byte("raw")); err != nil {
+		t.Fatalf("SendRawMessage() error = %v", err)
+	}
+	if len(relay.sent) != 1 || len(graph.sent) != 0 {
+		t.Fatalf("relay sent %d, graph sent %d; want relay only", len(relay.sent), len(graph.sent))
+	}
+}
@@ -14,3 +14,35 @@ type MailSender interface {
 	// Name 回傳服務名稱，用於 logging
 	Name() string
 }
+
+// RawMailSender 原始 MIME 發送服務介面
+// 支援直接送出完整 RFC 5322 郵件的服務（Graph MIME sendMail、SMTP Relay）需實作此介面
+type RawMailSender interface {
+	MailSender
+
+	// SendRawMail 直接送出原始 MIME 郵件
+	SendRawMail(job *models.MailJob, raw []byte) error
+}
+
+// MessageSigner 外送 MIME 簽章介面 (例如 DKIM)
+// 所有送出原始 MIME 的服務在送出前呼叫，沒有可用金鑰時回傳原內容
+type MessageSigner interface {
+	Sign(raw []byte) ([]byte, error)
+}
+
+// PermanentError 不可重試的發送錯誤 (例如下一跳不支援郵件所需的 SMTP 擴充)
+// Worker 收到後不再重試，直接標記失敗並以 Status 寄出 DSN
+type PermanentError struct {
+	Status string // RFC 3463 增強狀態碼，例如 5.6.7
+	Err    error
+}
+
+// Error 回傳錯誤訊息
+func (e *PermanentError) Error() string {
+	return e.Status + " " + e.Err.Error()
+}
+
+// Unwrap 回傳原始錯誤
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,50 @@
+// internal/services/microsoft_client.go
+// Microsoft 端點與共用 HTTP client 設定
+
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"mail-proxy/internal/config"
+	"mail-proxy/pkg/microsoft"
+)
+
+// MicrosoftEndpoints 設定檔的全域 Microsoft 端點
+func MicrosoftEndpoints(cfg *config.Config) microsoft.Endpoints {
+	return microsoft.Endpoints{
+		AuthorityURL: cfg.MicrosoftAuthorityURL,
+		GraphURL:     cfg.MicrosoftGraphURL,
+	}.WithDefaults(microsoft.EndpointsGlobal)
+}
+
+// ConfigureMicrosoftClient 驗證全域端點並建立共用的 HTTP client，
+// 設定至 DefaultOAuthManager 後回傳 (供 GraphMailService 共用同一個 client)
+func ConfigureMicrosoftClient(cfg *config.Config) (*http.Client, error) {
+	endpoints := MicrosoftEndpoints(cfg)
+	if err := microsoft.ValidateEndpointURL(endpoints.AuthorityURL, nil); err != nil {
+		return nil, fmt.Errorf("MICROSOFT_AUTHORITY_URL: %w", err)
+	}
+	if err := microsoft.ValidateEndpointURL(endpoints.GraphURL, nil); err != nil {
+		return nil, fmt.Errorf("MICROSOFT_GRAPH_URL: %w", err)
+	}
+
+	httpClient := &http.Client{Timeout: cfg.MicrosoftHTTPTimeout}
+	microsoft.DefaultOAuthManager.Configure(endpoints, httpClient)
+	return httpClient, nil
+}
+
+// senderEndpointHosts Sender Config 個別端點允許的主機：
+// 已知的國家雲端、全域設定的端點與 MICROSOFT_ALLOWED_ENDPOINT_HOSTS
+func senderEndpointHosts(cfg *config.Config) []string {
+	hosts := microsoft.KnownEndpointHosts()
+	endpoints := MicrosoftEndpoints(cfg)
+	for _, raw := range []string{endpoints.AuthorityURL, endpoints.GraphURL} {
+		if u, err := url.Parse(raw); err == nil && u.Host != "" {
+			hosts = append(hosts, u.Host)
+		}
+	}
+	return append(hosts, cfg.MicrosoftAllowedEndpointHosts...)
+}
@@ -0,0 +1,313 @@
+// internal/services/mime_builder.go
+// MIME 郵件組裝 - 將 MailJob 轉為 RFC 5322 原始郵件
+
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+	"github.com/emersion/go-message/textproto"
+
+	"mail-proxy/internal/models"
+)
+
+// BuildMIMEMessage 依 MailJob 欄位組裝 MIME 郵件
+// 用於不支援 JSON 結構化發送的出口（例如 SMTP Relay、含會議邀請的 Graph 郵件）
+//
+// 結構：
+//
+//	multipart/mixed
+//	├── multipart/alternative
+//	│   ├── text/plain
+//	│   ├── multipart/related (有內嵌附件時，否則為 text/html)
+//	│   │   ├── text/html
+//	│   │   └── 內嵌附件 (Content-ID)
+//	│   └── text/calendar; method=REQUEST (有會議邀請時)
+//	└── 一般附件
+func BuildMIMEMessage(job *models.MailJob) ([]byte, error) {
+	h, err := buildMIMEHeader(job)
+	if err != nil {
+		return nil, err
+	}
+	h.SetContentType("multipart/mixed", nil)
+
+	var buf bytes.Buffer
+	w, err := message.CreateWriter(&buf, h.Header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mail writer: %w", err)
+	}
+
+	// 內嵌附件只在有 HTML 時放入 multipart/related，否則視為一般附件
+	var inline, attachments []models.AttachmentInfo
+	for _, att := range job.Attachments {
+		if att.Inline && att.ContentID != "" && job.HTML != "" {
+			inline = append(inline, att)
+		} else {
+			attachments = append(attachments, att)
+		}
+	}
+
+	// 內文 (text/plain 在前，text/html 在後，text/calendar 最後)
+	aw, err := w.CreatePart(multipartHeader("multipart/alternative", nil))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create alternative part: %w", err)
+	}
+	if job.Body != "" || job.HTML == "" {
+		if err := writeTextPart(aw, "text/plain", nil, job.Body); err != nil {
+			return nil, err
+		}
+	}
+	if job.HTML != "" {
+		if err := writeHTMLPart(aw, job.HTML, inline); err != nil {
+			return nil, err
+		}
+	}
+	if job.Calendar != nil {
+		params := map[string]string{"method": job.Calendar.Method}
+		if err := writeTextPart(aw, "text/calendar", params, job.Calendar.Content); err != nil {
+			return nil, err
+		}
+	}
+	if err := aw.Close(); err != nil {
+		return nil, err
+	}
+
+	// 附件
+	for _, att := range attachments {
+		if err := writeAttachmentPart(w, att, "attachment"); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// buildMIMEHeader 組裝郵件標頭 (含顯示名稱、Reply-To、回覆串接標頭、重要性、自訂標頭與一鍵退訂)
+func buildMIMEHeader(job *models.MailJob) (mail.Header, error) {
+	options := job.Options
+
+	var h mail.Header
+	h.SetDate(time.Now())
+	h.SetSubject(job.Subject)
+	from := &mail.Address{Address: job.FromAddress}
+	if options != nil {
+		from.Name = options.FromName
+	}
+	h.SetAddressList("From", []*mail.Address{from})
+	h.SetAddressList("To", toMailAddresses(job.ToAddresses, options))
+	if len(job.CCAddresses) > 0 {
+		h.SetAddressList("Cc", toMailAddresses(job.CCAddresses, options))
+	}
+	if err := h.GenerateMessageID(); err != nil {
+		return h, fmt.Errorf("failed to generate message id: %w", err)
+	}
+
+	if options == nil {
+		return h, nil
+	}
+	if len(options.ReplyTo) > 0 {
+		h.SetAddressList("Reply-To", toMailAddresses(options.ReplyTo, options))
+	}
+	if options.InReplyTo != "" {
+		h.Set("In-Reply-To", options.InReplyTo)
+	}
+	if len(options.References) > 0 {
+		h.Set("References", strings.Join(options.References, " "))
+	}
+	switch options.Importance {
+	case models.ImportanceHigh:
+		h.Set("Importance", "high")
+		h.Set("X-Priority", "1 (Highest)")
+	case models.ImportanceLow:
+		h.Set("Importance", "low")
+		h.Set("X-Priority", "5 (Lowest)")
+	}
+	headers := options.Headers
+	if unsubscribe := options.UnsubscribeHeaders(); unsubscribe != nil {
+		headers = make(map[string]string, len(options.Headers)+len(unsubscribe))
+		for name, value := range options.Headers {
+			headers[name] = value
+		}
+		for name, value := range unsubscribe {
+			headers[name] = value
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		h.Set(name, headers[name])
+	}
+	return h, nil
+}
+
+// multipartHeader 建立 multipart 子部分標頭
+func multipartHeader(mediaType string, params map[string]string) message.Header {
+	var h message.Header
+	h.SetContentType(mediaType, params)
+	return h
+}
+
+// writeHTMLPart 寫入 HTML 內文；有內嵌附件時以 multipart/related 包裝
+func writeHTMLPart(w *message.Writer, html string, inline []models.AttachmentInfo) error {
+	if len(inline) == 0 {
+		return writeTextPart(w, "text/html", nil, html)
+	}
+
+	rw, err := w.CreatePart(multipartHeader("multipart/related", map[string]string{"type": "text/html"}))
+	if err != nil {
+		return fmt.Errorf("failed to create related part: %w", err)
+	}
+	if err := writeTextPart(rw, "text/html", nil, html); err != nil {
+		return err
+	}
+	for _, att := range inline {
+		if err := writeAttachmentPart(rw, att, "inline"); err != nil {
+			return err
+		}
+	}
+	return rw.Close()
+}
+
+// writeTextPart 寫入單一文字 part (UTF-8，quoted-printable)
+func writeTextPart(w *message.Writer, mediaType string, params map[string]string, content string) error {
+	ctParams := map[string]string{"charset": "utf-8"}
+	for k, v := range params {
+		ctParams[k] = v
+	}
+
+	var h message.Header
+	h.SetContentType(mediaType, ctParams)
+	h.Set("Content-Transfer-Encoding", "quoted-printable")
+
+	pw, err := w.CreatePart(h)
+	if err != nil {
+		return fmt.Errorf("failed to create %s part: %w", mediaType, err)
+	}
+	if _, err := io.WriteString(pw, content); err != nil {
+		return err
+	}
+	return pw.Close()
+}
+
+// writeAttachmentPart 寫入附件 part (disposition 為 attachment 或 inline)
+func writeAttachmentPart(w *message.Writer, att models.AttachmentInfo, disposition string) error {
+	content, err := os.ReadFile(att.StoragePath)
+	if err != nil {
+		return fmt.Errorf("failed to read attachment %s: %w", att.Filename, err)
+	}
+
+	contentType := att.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	var ah mail.AttachmentHeader
+	ah.SetContentType(contentType, nil)
+	ah.SetFilename(filepath.Base(att.Filename))
+	if disposition == "inline" {
+		_, params, _ := ah.ContentDisposition()
+		ah.SetContentDisposition("inline", params)
+		ah.Set("Content-Id", "<"+att.ContentID+">")
+	}
+	ah.Set("Content-Transfer-Encoding", "base64")
+
+	pw, err := w.CreatePart(ah.Header)
+	if err != nil {
+		return fmt.Errorf("failed to create attachment %s: %w", att.Filename, err)
+	}
+	if _, err := pw.Write(content); err != nil {
+		return err
+	}
+	return pw.Close()
+}
+
+// toMailAddresses 轉換地址字串為 mail.Address (含顯示名稱)
+func toMailAddresses(addrs []string, options *models.MessageOptions) []*mail.Address {
+	result := make([]*mail.Address, len(addrs))
+	for i, addr := range addrs {
+		result[i] = &mail.Address{Name: options.DisplayName(addr), Address: addr}
+	}
+	return result
+}
+
+// LoadRawMessage 讀取 MailJob 的原始 MIME 檔案
+func LoadRawMessage(job *models.MailJob) ([]byte, error) {
+	if job.RawMessagePath == "" {
+		return nil, fmt.Errorf("mail %s has no raw message", job.MailID)
+	}
+
+	raw, err := os.ReadFile(job.RawMessagePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read raw message: %w", err)
+	}
+	return raw, nil
+}
+
+// EnvelopeRecipients 取得信封收件者 (To + CC + BCC，去除重複)
+func EnvelopeRecipients(job *models.MailJob) []string {
+	seen := make(map[string]bool)
+	var recipients []string
+	for _, list := range [][]string{job.ToAddresses, job.CCAddresses, job.BCCAddresses} {
+		for _, addr := range list {
+			key := strings.ToLower(addr)
+			if addr == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			recipients = append(recipients, addr)
+		}
+	}
+	return recipients
+}
+
+// withEnvelopeBcc 將標頭中未列出的信封收件者加入 Bcc 標頭
+// Graph 的 MIME sendMail 只依標頭決定收件者，SMTP RCPT TO 中的隱藏收件者需以 Bcc 補上
+func withEnvelopeBcc(raw []byte, recipients []string) ([]byte, error) {
+	header, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read raw message header: %w", err)
+	}
+
+	listed := make(map[string]bool)
+	mh := mail.Header{Header: message.Header{Header: header}}
+	for _, key := range []string{"To", "Cc", "Bcc"} {
+		addrs, err := mh.AddressList(key)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			listed[strings.ToLower(addr.Address)] = true
+		}
+	}
+
+	var missing []string
+	for _, addr := range recipients {
+		if !listed[strings.ToLower(addr)] {
+			missing = append(missing, addr)
+		}
+	}
+	if len(missing) == 0 {
+		return raw, nil
+	}
+
+	result := make([]byte, 0, len(raw)+64)
+	result = append(result, "Bcc: "+strings.Join(missing, ", ")+"\r\n"...)
+	return append(result, raw...), nil
+}
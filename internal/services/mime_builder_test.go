@@ -0,0 +1,115 @@
+package services
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-message"
+
+	"mail-proxy/internal/models"
+)
+
+// mimePart 攤平後的 MIME part (path 為由外而內的 Content-Type)
+type mimePart struct {
+	path        string
+	disposition string
+	contentID   string
+}
+
+// flattenMIME 遞迴攤平 MIME 結構
+func flattenMIME(t *testing.T, e *message.Entity, parent string) []mimePart {
+	t.Helper()
+	mediaType, _, err := e.Header.ContentType()
+	if err != nil {
+		t.Fatalf("ContentType() error = %v", err)
+	}
+	path := mediaType
+	if parent != "" {
+		path = parent + ">" + mediaType
+	}
+	mr := e.MultipartReader()
+	if mr == nil {
+		disposition, _, _ := e.Header.ContentDisposition()
+		return []mimePart{{path: path, disposition: disposition, contentID: e.Header.Get("Content-Id")}}
+	}
+	var parts []mimePart
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		parts = append(parts, flattenMIME(t, p, path)...)
+	}
+	return parts
+}
+
+func TestBuildMIMEMessageInlineAttachments(t *testing.T) {
+	dir := t.TempDir()
+	logoPath := filepath.Join(dir, "logo.png")
+	if err := os.WriteFile(logoPath, []byte("png"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	attachments := []models.AttachmentInfo{
+		{Filename: "logo.png", ContentType: "image/png", StoragePath: logoPath, ContentID: "logo@example.com", Inline: true},
+		{Filename: "report.pdf", ContentType: "application/pdf", StoragePath: logoPath},
+	}
+
+	tests := []struct {
+		name string
+		html string
+		want []mimePart
+	}{
+		{
+			name: "inline under related",
+			html: `<img src="cid:logo@example.com">`,
+			want: []mimePart{
+				{path: "multipart/mixed>multipart/alternative>text/plain"},
+				{path: "multipart/mixed>multipart/alternative>multipart/related>text/html"},
+				{path: "multipart/mixed>multipart/alternative>multipart/related>image/png", disposition: "inline", contentID: "<logo@example.com>"},
+				{path: "multipart/mixed>application/pdf", disposition: "attachment"},
+			},
+		},
+		{
+			name: "inline without html becomes attachment",
+			want: []mimePart{
+				{path: "multipart/mixed>multipart/alternative>text/plain"},
+				{path: "multipart/mixed>image/png", disposition: "attachment"},
+				{path: "multipart/mixed>application/pdf", disposition: "attachment"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := BuildMIMEMessage(&models.MailJob{
+				FromAddress: "sender@example.com",
+				ToAddresses: []string{"to@example.com"},
+				Subject:     "Inline",
+				Body:        "text",
+				HTML:        tt.html,
+				Attachments: attachments,
+			})
+			if err != nil {
+				t.Fatalf("BuildMIMEMessage() error = %v", err)
+			}
+			entity, err := message.Read(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatalf("message.Read() error = %v", err)
+			}
+			got := flattenMIME(t, entity, "")
+			if len(got) != len(tt.want) {
+				t.Fatalf("parts = %+v, want %+v", got, tt.want)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("part %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+			if tt.html == "" && strings.Contains(string(raw), "Content-Id") {
+				t.Error("attachment without HTML must not carry Content-Id")
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+// internal/services/oauth_token_store.go
+// Microsoft OAuth Access Token 的 KeyDB 共用快取 (跨 Worker / API 實例)
+
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// unlockScript 只在鎖仍由自己持有時才刪除
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+  return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// cachedOAuthToken KeyDB 中的 Token 格式 (token 以 EncryptionService 加密)
+type cachedOAuthToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// OAuthTokenStore 實作 microsoft.TokenStore
+// key 為憑證指紋，不含 secret；Access Token 加密後才寫入 KeyDB
+type OAuthTokenStore struct {
+	client     *redis.Client
+	encryption *EncryptionService
+}
+
+// NewOAuthTokenStore 建立 OAuth Token 共用快取 (與狀態快取共用 KeyDB 連線)
+func NewOAuthTokenStore(keydbService *KeyDBService, encryption *EncryptionService) *OAuthTokenStore {
+	return &OAuthTokenStore{
+		client:     keydbService.client,
+		encryption: encryption,
+	}
+}
+
+// Get 取得快取的 Token
+func (s *OAuthTokenStore) Get(ctx context.Context, key string) (string, time.Time, bool, error) {
+	data, err := s.client.Get(ctx, "oauth:token:"+key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", time.Time{}, false, nil
+	}
+	if err != nil {
+		return "", time.Time{}, false, fmt.Errorf("failed to get oauth token: %w", err)
+	}
+
+	var cached cachedOAuthToken
+	if err := json.Unmarshal([]byte(data), &cached); err != nil {
+		return "", time.Time{}, false, fmt.Errorf("failed to unmarshal oauth token: %w", err)
+	}
+	token, err := s.encryption.Decrypt(cached.Token)
+	if err != nil {
+		return "", time.Time{}, false, fmt.Errorf("failed to decrypt oauth token: %w", err)
+	}
+	return token, cached.ExpiresAt, true, nil
+}
+
+// Set 寫入 Token，KeyDB 過期時間與 Token 相同
+func (s *OAuthTokenStore) Set(ctx context.Context, key, token string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+
+	encrypted, err := s.encryption.Encrypt(token)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt oauth token: %w", err)
+	}
+	data, err := json.Marshal(cachedOAuthToken{Token: encrypted, ExpiresAt: expiresAt})
+	if err != nil {
+		return fmt.Errorf("failed to marshal oauth token: %w", err)
+	}
+	return s.client.Set(ctx, "oauth:token:"+key, data, ttl).Err()
+}
+
+// Delete 刪除 Token
+func (s *OAuthTokenStore) Delete(ctx context.Context, key string) error {
+	return s.client.Del(ctx, "oauth:token:"+key).Err()
+}
+
+// Lock 以 SET NX 取得刷新鎖，逾時自動釋放
+func (s *OAuthTokenStore) Lock(ctx context.Context, key string, ttl time.Duration) (func(), bool, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, false, err
+	}
+	owner := hex.EncodeToString(b)
+	lockKey := "oauth:lock:" + key
+
+	acquired, err := s.client.SetNX(ctx, lockKey, owner, ttl).Result()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to acquire oauth refresh lock: %w", err)
+	}
+	if !acquired {
+		return nil, false, nil
+	}
+
+	unlock := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = unlockScript.Run(ctx, s.client, []string{lockKey}, owner).Err()
+	}
+	return unlock, true, nil
+}
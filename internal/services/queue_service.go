@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"log"
 	"sync"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 
@@ -96,6 +97,11 @@ func (s *QueueService) declareQueues() error {
 		return fmt.Errorf("failed to declare retry queue: %w", err)
 	}
 
+	// 宣告延遲重送隊列
+	if err := DeclareDelayQueues(s.channel, s.cfg); err != nil {
+		return err
+	}
+
 	// 宣告失敗隊列
 	_, err = s.channel.QueueDeclare(
 		s.cfg.FailedQueueName,
@@ -124,6 +130,64 @@ func (s *QueueService) declareQueues() error {
 	return nil
 }
 
+// DelayTiers 延遲重送隊列的延遲層級，每個層級各有一個固定 TTL 的隊列
+var DelayTiers = []time.Duration{
+	time.Second,
+	2 * time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	2 * time.Minute,
+	5 * time.Minute,
+	10 * time.Minute,
+	30 * time.Minute,
+	time.Hour,
+}
+
+// DeclareDelayQueues 宣告各延遲層級的延遲重送隊列
+// 隊列不被消費，訊息以隊列的 x-message-ttl 到期後經預設交換器以死信轉回主郵件隊列；
+// 同一隊列內的訊息延遲相同，到期順序即進入順序，不會被前方較長的延遲擋住
+func DeclareDelayQueues(channel *amqp.Channel, cfg *config.Config) error {
+	for _, tier := range DelayTiers {
+		name := delayQueueName(cfg, tier)
+		_, err := channel.QueueDeclare(
+			name,
+			true,  // durable
+			false, // auto-delete
+			false, // exclusive
+			false, // no-wait
+			amqp.Table{
+				"x-message-ttl":             tier.Milliseconds(),
+				"x-dead-letter-exchange":    "",
+				"x-dead-letter-routing-key": cfg.MailQueueName,
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to declare delay queue %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// DelayQueueFor 取得不短於 delay 的最短延遲層級與其隊列名稱
+// delay 超過最長層級時使用最長層級 (到期後重新檢查，必要時再次延後)
+func DelayQueueFor(cfg *config.Config, delay time.Duration) (string, time.Duration) {
+	tier := DelayTiers[len(DelayTiers)-1]
+	for _, t := range DelayTiers {
+		if t >= delay {
+			tier = t
+			break
+		}
+	}
+	return delayQueueName(cfg, tier), tier
+}
+
+// delayQueueName 延遲層級的隊列名稱 (例如 mail-delay-queue.30s)
+func delayQueueName(cfg *config.Config, tier time.Duration) string {
+	return fmt.Sprintf("%s.%ds", cfg.DelayQueueName, int64(tier/time.Second))
+}
+
 // PublishMail 發布郵件到隊列
 func (s *QueueService) PublishMail(job *models.MailJob) error {
 	s.mu.RLock()
@@ -0,0 +1,29 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"mail-proxy/internal/config"
+)
+
+func TestDelayQueueFor(t *testing.T) {
+	cfg := &config.Config{DelayQueueName: "mail-delay-queue"}
+	tests := []struct {
+		delay     time.Duration
+		wantQueue string
+		wantTier  time.Duration
+	}{
+		{delay: 0, wantQueue: "mail-delay-queue.1s", wantTier: time.Second},
+		{delay: time.Second, wantQueue: "mail-delay-queue.1s", wantTier: time.Second},
+		{delay: 3 * time.Second, wantQueue: "mail-delay-queue.5s", wantTier: 5 * time.Second},
+		{delay: 90 * time.Second, wantQueue: "mail-delay-queue.120s", wantTier: 2 * time.Minute},
+		{delay: 3 * time.Hour, wantQueue: "mail-delay-queue.3600s", wantTier: time.Hour},
+	}
+	for _, tt := range tests {
+		queue, tier := DelayQueueFor(cfg, tt.delay)
+		if queue != tt.wantQueue || tier != tt.wantTier {
+			t.Errorf("DelayQueueFor(%v) = %q, %v; want %q, %v", tt.delay, queue, tier, tt.wantQueue, tt.wantTier)
+		}
+	}
+}
@@ -0,0 +1,436 @@
+// internal/services/quota_service.go
+// Client 用量配額 - 以 KeyDB 計數器累計每日 / 每月用量，定期由實際發送的郵件校正
+
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+
+	"mail-proxy/internal/config"
+	"mail-proxy/internal/models"
+)
+
+// quotaBytesPerGB 附件配額的 GB 換算
+const quotaBytesPerGB = 1 << 30
+
+// quotaReconcileInterval KeyDB 計數器以 SQL 彙總重新校正的間隔
+// (退回取消 / 失敗郵件的額度，並計入 SMTP 接收等未經配額檢查的郵件)
+const quotaReconcileInterval = 5 * time.Minute
+
+// quotaScript 一次檢查並累加多個配額計數器，全部未超過上限才累加
+// KEYS: 各項目的計數器；ARGV: 每個項目依序為 limit (0 表示不限制)、cost
+// 回傳：{狀態 (1 通過、0 超過上限、-1 計數器不存在需校正), 超過項目的索引 (1-based), 各項目用量...}
+var quotaScript = redis.NewScript(`
+local n = #KEYS
+local used = {}
+for i = 1, n do
+  local v = redis.call("GET", KEYS[i])
+  if not v then
+    return {-1, i}
+  end
+  used[i] = tonumber(v)
+end
+
+for i = 1, n do
+  local limit = tonumber(ARGV[(i - 1) * 2 + 1])
+  local cost = tonumber(ARGV[(i - 1) * 2 + 2])
+  if limit > 0 and cost > 0 and used[i] + cost > limit then
+    return {0, i, unpack(used)}
+  end
+end
+
+for i = 1, n do
+  local cost = tonumber(ARGV[(i - 1) * 2 + 2])
+  if cost > 0 then
+    used[i] = redis.call("INCRBY", KEYS[i], cost)
+  end
+end
+return {1, 0, unpack(used)}
+`)
+
+// quotaCountedStatuses 計入用量的郵件狀態 (失敗與取消的郵件不計)
+var quotaCountedStatuses = []models.MailStatus{
+	models.MailStatusQueued,
+	models.MailStatusProcessing,
+	models.MailStatusSent,
+}
+
+// QuotaRequest 本次發送請求的用量
+type QuotaRequest struct {
+	Mails           int
+	Recipients      int
+	AttachmentBytes int64
+}
+
+// QuotaCheckResult 配額檢查結果
+type QuotaCheckResult struct {
+	Exceeded *models.QuotaMetricUsage  // 本次發送會超過上限的項目 (nil 表示通過)
+	Warnings []models.QuotaMetricUsage // 本次發送後達到警告門檻的項目 (用量已含本次)
+}
+
+// QuotaService 用量配額服務
+// 發送檢查以 KeyDB 計數器原子地檢查並累加 (計數器 TTL 對齊週期結束)，每 quotaReconcileInterval
+// 以 mails / attachments 彙總重新校正，取消或發送失敗的郵件於校正後退回額度；
+// KeyDB 無法使用時改以 SQL 彙總檢查，此時併發請求之間不互相鎖定，上限可能被小幅超出
+type QuotaService struct {
+	cfg   *config.Config
+	db    *gorm.DB
+	keydb *redis.Client
+	audit *AuditService
+}
+
+// NewQuotaService 建立用量配額服務 (與狀態快取共用 KeyDB 連線；首次達到警告門檻時寫入稽核紀錄)
+func NewQuotaService(cfg *config.Config, db *gorm.DB, keydbService *KeyDBService, audit *AuditService) *QuotaService {
+	s := &QuotaService{
+		cfg:   cfg,
+		db:    db,
+		audit: audit,
+	}
+	if keydbService != nil {
+		s.keydb = keydbService.client
+	}
+	return s
+}
+
+// limits Token 的配額上限 (Token 覆寫優先於全域設定；0 表示不限制)
+func (s *QuotaService) limits(client *models.ClientToken) (mailsPerDay, recipientsPerMonth, attachmentBytesPerMonth int64) {
+	mails := s.cfg.QuotaMailsPerDay
+	if client.QuotaMailsPerDay != nil {
+		mails = *client.QuotaMailsPerDay
+	}
+	recipients := s.cfg.QuotaRecipientsPerMonth
+	if client.QuotaRecipientsPerMonth != nil {
+		recipients = *client.QuotaRecipientsPerMonth
+	}
+	attachmentGB := float64(s.cfg.QuotaAttachmentGBPerMonth)
+	if client.QuotaAttachmentGBPerMonth != nil {
+		attachmentGB = *client.QuotaAttachmentGBPerMonth
+	}
+	return int64(mails), int64(recipients), int64(attachmentGB * quotaBytesPerGB)
+}
+
+// quotaPeriods 目前的日與月週期 (伺服器時區)
+func quotaPeriods(now time.Time) (dayStart, dayEnd, monthStart, monthEnd time.Time) {
+	now = now.In(time.Local)
+	dayStart = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	monthStart = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+	return dayStart, dayStart.AddDate(0, 0, 1), monthStart, monthStart.AddDate(0, 1, 0)
+}
+
+// quotaCounts 由 SQL 彙總的目前週期用量
+type quotaCounts struct {
+	MailsToday      int64
+	Recipients      int64
+	AttachmentBytes int64
+}
+
+// countUsage 由 mails / attachments 彙總 Client 目前週期的用量
+func (s *QuotaService) countUsage(client *models.ClientToken, dayStart, monthStart, monthEnd time.Time) (*quotaCounts, error) {
+	var counts quotaCounts
+	err := s.db.Raw(`
+		SELECT
+			COUNT(*) FILTER (WHERE m.created_at >= ?) AS mails_today,
+			COALESCE(SUM(COALESCE(cardinality(m.to_addresses), 0) + COALESCE(cardinality(m.cc_addresses), 0) + COALESCE(cardinality(m.bcc_addresses), 0)), 0) AS recipients,
+			COALESCE(SUM(a.size_bytes), 0) AS attachment_bytes
+		FROM mails m
+		LEFT JOIN LATERAL (SELECT SUM(size_bytes) AS size_bytes FROM attachments WHERE mail_id = m.id) a ON true
+		WHERE m.client_id = ? AND m.created_at >= ? AND m.created_at < ? AND m.status IN ?`,
+		dayStart, client.ClientID, monthStart, monthEnd, quotaCountedStatuses,
+	).Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	return &counts, nil
+}
+
+// Usage 查詢 Client 目前週期的用量 (以 SQL 彙總為準)
+func (s *QuotaService) Usage(client *models.ClientToken) (*models.QuotaUsage, error) {
+	dayStart, dayEnd, monthStart, monthEnd := quotaPeriods(time.Now())
+	mailsLimit, recipientsLimit, attachmentLimit := s.limits(client)
+
+	counts, err := s.countUsage(client, dayStart, monthStart, monthEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	var warnings []models.QuotaWarning
+	err = s.db.Where("client_id = ? AND period_start IN ?", client.ClientID, []time.Time{dayStart, monthStart}).
+		Find(&warnings).Error
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &models.QuotaUsage{
+		ClientID:       client.ClientID,
+		WarningPercent: s.cfg.QuotaWarningPercent,
+		Quotas: []models.QuotaMetricUsage{
+			s.metricUsage(models.QuotaMetricMailsPerDay, counts.MailsToday, mailsLimit, dayStart, dayEnd),
+			s.metricUsage(models.QuotaMetricRecipientsPerMonth, counts.Recipients, recipientsLimit, monthStart, monthEnd),
+			s.metricUsage(models.QuotaMetricAttachmentBytesPerMonth, counts.AttachmentBytes, attachmentLimit, monthStart, monthEnd),
+		},
+	}
+	for i := range usage.Quotas {
+		q := &usage.Quotas[i]
+		for _, w := range warnings {
+			if w.Metric == q.Metric && w.PeriodStart.Equal(q.PeriodStart) {
+				warnedAt := w.CreatedAt
+				q.WarnedAt = &warnedAt
+			}
+		}
+	}
+	return usage, nil
+}
+
+// metricUsage 計算單一項目的百分比與狀態
+func (s *QuotaService) metricUsage(metric string, used, limit int64, start, end time.Time) models.QuotaMetricUsage {
+	usage := models.QuotaMetricUsage{
+		Metric:      metric,
+		Used:        used,
+		Limit:       limit,
+		PeriodStart: start,
+		PeriodEnd:   end,
+	}
+	if limit > 0 {
+		usage.Percent = math.Round(float64(used)*1000/float64(limit)) / 10
+		usage.Warning = s.reachesWarning(used, limit)
+		usage.Exceeded = used >= limit
+	}
+	return usage
+}
+
+// reachesWarning 用量是否達到警告門檻
+func (s *QuotaService) reachesWarning(used, limit int64) bool {
+	return s.cfg.QuotaWarningPercent > 0 && used*100 >= limit*int64(s.cfg.QuotaWarningPercent)
+}
+
+// Check 檢查本次發送是否超過配額，通過時即計入用量
+// 本次發送使用到的項目會超過上限時回傳 Exceeded；達到警告門檻的項目每個週期只記錄一次警告
+func (s *QuotaService) Check(client *models.ClientToken, req QuotaRequest) (*QuotaCheckResult, error) {
+	if s.keydb != nil {
+		result, err := s.checkCounters(context.Background(), client, req)
+		if err == nil {
+			return result, nil
+		}
+		log.Printf("Quota counters unavailable, falling back to SQL: %v", err)
+	}
+	return s.checkUsage(client, req)
+}
+
+// quotaCounter 單一配額項目的 KeyDB 計數器
+type quotaCounter struct {
+	key    string
+	metric string
+	limit  int64
+	cost   int64
+	used   int64
+	start  time.Time
+	end    time.Time
+}
+
+// quotaCounters 目前週期各項目的計數器 (key 含週期起點，週期切換時自動使用新的計數器)
+func (s *QuotaService) quotaCounters(client *models.ClientToken, req QuotaRequest, now time.Time) []quotaCounter {
+	dayStart, dayEnd, monthStart, monthEnd := quotaPeriods(now)
+	mailsLimit, recipientsLimit, attachmentLimit := s.limits(client)
+	counters := []quotaCounter{
+		{metric: models.QuotaMetricMailsPerDay, limit: mailsLimit, cost: int64(req.Mails), start: dayStart, end: dayEnd},
+		{metric: models.QuotaMetricRecipientsPerMonth, limit: recipientsLimit, cost: int64(req.Recipients), start: monthStart, end: monthEnd},
+		{metric: models.QuotaMetricAttachmentBytesPerMonth, limit: attachmentLimit, cost: req.AttachmentBytes, start: monthStart, end: monthEnd},
+	}
+	for i := range counters {
+		counters[i].key = fmt.Sprintf("quota:%s:%s:%d", client.ClientID, counters[i].metric, counters[i].start.Unix())
+	}
+	return counters
+}
+
+// checkCounters 以 KeyDB 計數器檢查並累加用量；計數器不存在或超過校正間隔時先由 SQL 校正
+func (s *QuotaService) checkCounters(ctx context.Context, client *models.ClientToken, req QuotaRequest) (*QuotaCheckResult, error) {
+	counters := s.quotaCounters(client, req, time.Now())
+	keys := make([]string, 0, len(counters))
+	args := make([]interface{}, 0, len(counters)*2)
+	for _, c := range counters {
+		keys = append(keys, c.key)
+		args = append(args, c.limit, c.cost)
+	}
+
+	synced, err := s.keydb.Exists(ctx, quotaSyncedKey(client)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check quota counters: %w", err)
+	}
+	if synced == 0 {
+		if err := s.reconcile(ctx, client, counters); err != nil {
+			return nil, err
+		}
+	}
+
+	var res []interface{}
+	for attempt := 0; attempt < 2; attempt++ {
+		res, err = quotaScript.Run(ctx, s.keydb, keys, args...).Slice()
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate quota counters: %w", err)
+		}
+		if res[0].(int64) != -1 {
+			break
+		}
+		// 計數器已過期 (週期切換) 或被清除
+		if err := s.reconcile(ctx, client, counters); err != nil {
+			return nil, err
+		}
+	}
+	if res[0].(int64) == -1 || len(res) != 2+len(counters) {
+		return nil, fmt.Errorf("unexpected quota counter result: %v", res)
+	}
+	for i := range counters {
+		counters[i].used = res[2+i].(int64)
+	}
+
+	result := &QuotaCheckResult{}
+	if res[0].(int64) == 0 {
+		c := counters[res[1].(int64)-1]
+		exceeded := s.metricUsage(c.metric, c.used, c.limit, c.start, c.end)
+		result.Exceeded = &exceeded
+		return result, nil
+	}
+
+	for _, c := range counters {
+		if c.limit <= 0 || c.cost <= 0 {
+			continue
+		}
+		after := s.metricUsage(c.metric, c.used, c.limit, c.start, c.end)
+		if !after.Warning {
+			continue
+		}
+		s.warnOnce(ctx, client, c.key, &after)
+		result.Warnings = append(result.Warnings, after)
+	}
+	return result, nil
+}
+
+// reconcile 以 SQL 彙總重設計數器 (TTL 對齊週期結束)，並標記下次校正時間
+func (s *QuotaService) reconcile(ctx context.Context, client *models.ClientToken, counters []quotaCounter) error {
+	// counters 依序為每日郵件數、每月收件者數、每月附件大小
+	counts, err := s.countUsage(client, counters[0].start, counters[1].start, counters[1].end)
+	if err != nil {
+		return fmt.Errorf("failed to count quota usage: %w", err)
+	}
+	values := map[string]int64{
+		models.QuotaMetricMailsPerDay:             counts.MailsToday,
+		models.QuotaMetricRecipientsPerMonth:      counts.Recipients,
+		models.QuotaMetricAttachmentBytesPerMonth: counts.AttachmentBytes,
+	}
+
+	pipe := s.keydb.TxPipeline()
+	for _, c := range counters {
+		pipe.Set(ctx, c.key, values[c.metric], time.Until(c.end))
+	}
+	pipe.Set(ctx, quotaSyncedKey(client), strconv.FormatInt(time.Now().Unix(), 10), quotaReconcileInterval)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to reset quota counters: %w", err)
+	}
+	return nil
+}
+
+// quotaSyncedKey 計數器最近一次校正的標記 (過期後重新校正)
+func quotaSyncedKey(client *models.ClientToken) string {
+	return "quota:" + client.ClientID + ":synced"
+}
+
+// warnOnce 每個 Client、項目、週期只在第一次達到警告門檻時記錄警告
+// 先以 KeyDB SETNX 過濾重複請求，quota_warnings 的唯一鍵確保多個 API 實例之間仍只記錄一次
+func (s *QuotaService) warnOnce(ctx context.Context, client *models.ClientToken, counterKey string, usage *models.QuotaMetricUsage) {
+	first, err := s.keydb.SetNX(ctx, counterKey+":warned", "1", time.Until(usage.PeriodEnd)).Result()
+	if err != nil {
+		log.Printf("Failed to check quota warning for client %s: %v", client.ClientID, err)
+	} else if !first {
+		return
+	}
+	s.recordWarning(client, usage)
+}
+
+// checkUsage 以 SQL 彙總檢查本次發送是否超過配額 (不預扣，郵件建立後即計入用量)
+func (s *QuotaService) checkUsage(client *models.ClientToken, req QuotaRequest) (*QuotaCheckResult, error) {
+	usage, err := s.Usage(client)
+	if err != nil {
+		return nil, err
+	}
+
+	requested := map[string]int64{
+		models.QuotaMetricMailsPerDay:             int64(req.Mails),
+		models.QuotaMetricRecipientsPerMonth:      int64(req.Recipients),
+		models.QuotaMetricAttachmentBytesPerMonth: req.AttachmentBytes,
+	}
+
+	result := &QuotaCheckResult{}
+	for _, q := range usage.Quotas {
+		if q.Limit <= 0 || requested[q.Metric] <= 0 {
+			continue
+		}
+		if q.Used+requested[q.Metric] > q.Limit {
+			exceeded := q
+			result.Exceeded = &exceeded
+			return result, nil
+		}
+
+		after := s.metricUsage(q.Metric, q.Used+requested[q.Metric], q.Limit, q.PeriodStart, q.PeriodEnd)
+		if !after.Warning {
+			continue
+		}
+		after.WarnedAt = q.WarnedAt
+		if after.WarnedAt == nil {
+			s.recordWarning(client, &after)
+		}
+		result.Warnings = append(result.Warnings, after)
+	}
+	return result, nil
+}
+
+// recordWarning 記錄配額警告並寫入稽核紀錄 (同一 Client、項目、週期已有紀錄時略過)
+func (s *QuotaService) recordWarning(client *models.ClientToken, usage *models.QuotaMetricUsage) {
+	res := s.db.Exec(
+		`INSERT INTO quota_warnings (client_id, metric, period_start, used, quota_limit)
+		VALUES (?, ?, ?, ?, ?) ON CONFLICT (client_id, metric, period_start) DO NOTHING`,
+		client.ClientID, usage.Metric, usage.PeriodStart, usage.Used, usage.Limit,
+	)
+	if res.Error != nil {
+		log.Printf("Failed to record quota warning for client %s: %v", client.ClientID, res.Error)
+		return
+	}
+	if res.RowsAffected > 0 {
+		now := time.Now()
+		usage.WarnedAt = &now
+		log.Printf("Quota warning: client %s reached %.1f%% of %s (%d/%d) for period starting %s",
+			client.ClientID, usage.Percent, usage.Metric, usage.Used, usage.Limit, usage.PeriodStart.Format("2006-01-02"))
+		s.recordWarningEvent(client, usage)
+	}
+}
+
+// recordWarningEvent 將配額警告寫入稽核紀錄，可由稽核事件 API 查詢或匯出
+func (s *QuotaService) recordWarningEvent(client *models.ClientToken, usage *models.QuotaMetricUsage) {
+	if s.audit == nil {
+		return
+	}
+	event := &models.AuditEvent{
+		ActorClientID:   client.ClientID,
+		ActorClientName: client.ClientName,
+		Action:          models.AuditActionQuotaWarning,
+		TargetType:      models.AuditTargetToken,
+		TargetID:        client.ID.String(),
+		Changes: s.audit.Diff(nil, map[string]interface{}{
+			"metric":       usage.Metric,
+			"used":         usage.Used,
+			"limit":        usage.Limit,
+			"percent":      usage.Percent,
+			"period_start": usage.PeriodStart,
+		}),
+	}
+	if err := s.audit.Record(event); err != nil {
+		log.Printf("Failed to record quota warning event for client %s: %v", client.ClientID, err)
+	}
+}
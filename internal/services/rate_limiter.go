@@ -0,0 +1,236 @@
+// internal/services/rate_limiter.go
+// KeyDB 多層級速率限制 (GCRA)
+
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"mail-proxy/internal/config"
+	"mail-proxy/internal/models"
+)
+
+// 速率限制層級
+const (
+	RateLimitScopeDepartment = "department" // 每部門每分鐘 API 請求數
+	RateLimitScopeClient     = "client"     // 每 Client 每小時郵件數
+	RateLimitScopeRecipient  = "recipient"  // 相同收件者每小時郵件數
+)
+
+// gcraScript 以 GCRA 一次檢查多個限制，全部通過才扣除
+// KEYS: 各限制的 key；ARGV: 每個限制依序為 limit、period (秒)、cost
+// 回傳：{是否通過, 最嚴格限制的索引 (1-based), remaining, retry_after, reset_after}
+var gcraScript = redis.NewScript(`
+local t = redis.call("TIME")
+local now = (t[1] - 1483228800) + t[2] / 1000000
+
+local n = #KEYS
+local new_tats = {}
+local best, best_remaining, best_reset = 1, math.huge, 0
+local denied, denied_retry, denied_reset = 0, 0, 0
+
+for i = 1, n do
+  local limit = tonumber(ARGV[(i - 1) * 3 + 1])
+  local period = tonumber(ARGV[(i - 1) * 3 + 2])
+  local cost = tonumber(ARGV[(i - 1) * 3 + 3])
+  local interval = period / limit
+
+  local tat = tonumber(redis.call("GET", KEYS[i]) or now)
+  if tat < now then tat = now end
+
+  local new_tat = tat + interval * cost
+  local diff = now - (new_tat - interval * limit)
+  if diff < 0 then
+    if denied == 0 or -diff > denied_retry then
+      denied, denied_retry, denied_reset = i, -diff, tat - now
+    end
+  else
+    new_tats[i] = new_tat
+    local remaining = math.floor(diff / interval)
+    if remaining < best_remaining then
+      best, best_remaining, best_reset = i, remaining, new_tat - now
+    end
+  end
+end
+
+if denied > 0 then
+  return {0, denied, 0, tostring(denied_retry), tostring(denied_reset)}
+end
+
+for i = 1, n do
+  local ttl = math.ceil(new_tats[i] - now)
+  if ttl > 0 then
+    redis.call("SET", KEYS[i], tostring(new_tats[i]), "EX", ttl)
+  end
+end
+return {1, best, best_remaining, "0", tostring(best_reset)}
+`)
+
+// RateLimit 單一限制條件
+type RateLimit struct {
+	Scope  string
+	Key    string
+	Limit  int
+	Period time.Duration
+	Cost   int
+}
+
+// RateLimitResult 速率限制檢查結果 (Limit / Remaining 為最嚴格的一層)
+type RateLimitResult struct {
+	Allowed    bool
+	Exceeds    bool // 單次用量已超過限制本身，等待也無法通過 (不應重試)
+	Scope      string
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration // 被拒絕時需等待的時間
+	ResetAfter time.Duration // 額度完全恢復所需時間
+}
+
+// RateLimiter 多層級速率限制服務
+type RateLimiter struct {
+	cfg    *config.Config
+	client *redis.Client
+}
+
+// NewRateLimiter 建立速率限制服務 (與狀態快取共用 KeyDB 連線)
+func NewRateLimiter(cfg *config.Config, keydbService *KeyDBService) *RateLimiter {
+	return &RateLimiter{
+		cfg:    cfg,
+		client: keydbService.client,
+	}
+}
+
+// Enabled 是否啟用速率限制
+func (l *RateLimiter) Enabled() bool {
+	return l != nil && l.cfg.RateLimitEnabled
+}
+
+// Allow 檢查並扣除所有限制；任一層超過時全部不扣除
+// Limit <= 0 或 Cost <= 0 的限制會被略過；Cost 大於 Limit 時不查詢 KeyDB，直接回傳 Exceeds
+func (l *RateLimiter) Allow(ctx context.Context, limits []RateLimit) (*RateLimitResult, error) {
+	keys := make([]string, 0, len(limits))
+	args := make([]interface{}, 0, len(limits)*3)
+	active := make([]RateLimit, 0, len(limits))
+	for _, limit := range limits {
+		if limit.Limit <= 0 || limit.Cost <= 0 {
+			continue
+		}
+		if limit.Cost > limit.Limit {
+			return &RateLimitResult{Exceeds: true, Scope: limit.Scope, Limit: limit.Limit}, nil
+		}
+		keys = append(keys, "ratelimit:"+limit.Scope+":"+limit.Key)
+		args = append(args, limit.Limit, limit.Period.Seconds(), limit.Cost)
+		active = append(active, limit)
+	}
+	if len(active) == 0 {
+		return &RateLimitResult{Allowed: true}, nil
+	}
+
+	res, err := gcraScript.Run(ctx, l.client, keys, args...).Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate rate limit: %w", err)
+	}
+	if len(res) != 5 {
+		return nil, fmt.Errorf("unexpected rate limit result: %v", res)
+	}
+
+	idx := int(res[1].(int64)) - 1
+	if idx < 0 || idx >= len(active) {
+		return nil, fmt.Errorf("unexpected rate limit index %d", idx+1)
+	}
+	retryAfter, _ := strconv.ParseFloat(res[3].(string), 64)
+	resetAfter, _ := strconv.ParseFloat(res[4].(string), 64)
+
+	return &RateLimitResult{
+		Allowed:    res[0].(int64) == 1,
+		Scope:      active[idx].Scope,
+		Limit:      active[idx].Limit,
+		Remaining:  int(res[2].(int64)),
+		RetryAfter: time.Duration(retryAfter * float64(time.Second)),
+		ResetAfter: time.Duration(resetAfter * float64(time.Second)),
+	}, nil
+}
+
+// DepartmentRequestLimit 部門每分鐘 API 請求限制 (未綁定部門的 Token 自成一組)
+func (l *RateLimiter) DepartmentRequestLimit(client *models.ClientToken) RateLimit {
+	key := "client:" + client.ClientID
+	if client.DepartmentID != nil {
+		key = client.DepartmentID.String()
+	}
+	return RateLimit{
+		Scope:  RateLimitScopeDepartment,
+		Key:    key,
+		Limit:  l.cfg.RateLimitDepartmentPerMinute,
+		Period: time.Minute,
+		Cost:   1,
+	}
+}
+
+// ClientMailLimit Client 每小時郵件數限制 (Token 可覆寫)
+func (l *RateLimiter) ClientMailLimit(client *models.ClientToken, mails int) RateLimit {
+	limit := l.cfg.RateLimitClientMailsPerHour
+	if client.RateLimitMailsPerHour != nil {
+		limit = *client.RateLimitMailsPerHour
+	}
+	return RateLimit{
+		Scope:  RateLimitScopeClient,
+		Key:    client.ClientID,
+		Limit:  limit,
+		Period: time.Hour,
+		Cost:   mails,
+	}
+}
+
+// RecipientLimits 收件者每小時郵件數限制，counts 為收件者地址 -> 本次郵件數，地址不分大小寫
+// 預設所有 Client 共用同一收件者的額度；Token 覆寫限制時改以該 Client 自己的額度計算，
+// 避免覆寫值套用到其他 Client 共用的額度上
+func (l *RateLimiter) RecipientLimits(client *models.ClientToken, counts map[string]int) []RateLimit {
+	limit := l.cfg.RateLimitRecipientPerHour
+	keyPrefix := ""
+	if client != nil && client.RateLimitRecipientPerHour != nil {
+		limit = *client.RateLimitRecipientPerHour
+		keyPrefix = "client:" + client.ClientID + ":"
+	}
+
+	merged := make(map[string]int, len(counts))
+	for addr, n := range counts {
+		merged[strings.ToLower(strings.TrimSpace(addr))] += n
+	}
+
+	limits := make([]RateLimit, 0, len(merged))
+	for addr, n := range merged {
+		limits = append(limits, RateLimit{
+			Scope:  RateLimitScopeRecipient,
+			Key:    keyPrefix + addr,
+			Limit:  limit,
+			Period: time.Hour,
+			Cost:   n,
+		})
+	}
+	return limits
+}
+
+// CountRecipients 累計收件者 (To / CC / BCC) 出現次數
+func CountRecipients(counts map[string]int, lists ...[]string) map[string]int {
+	if counts == nil {
+		counts = make(map[string]int)
+	}
+	for _, list := range lists {
+		for _, addr := range list {
+			counts[addr]++
+		}
+	}
+	return counts
+}
+
+// RetryAfterSeconds 轉為 Retry-After 使用的整數秒 (至少 1 秒)
+func (r *RateLimitResult) RetryAfterSeconds() int {
+	return int(math.Max(1, math.Ceil(r.RetryAfter.Seconds())))
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"mail-proxy/internal/config"
+	"mail-proxy/internal/models"
+)
+
+func TestRateLimiterCostExceedsLimit(t *testing.T) {
+	// 未連線 KeyDB：用量大於限制本身時應在查詢前直接拒絕
+	limiter := &RateLimiter{cfg: &config.Config{RateLimitEnabled: true}}
+	result, err := limiter.Allow(context.Background(), []RateLimit{
+		{Scope: RateLimitScopeDepartment, Key: "dept", Limit: 200, Period: time.Minute, Cost: 1},
+		{Scope: RateLimitScopeClient, Key: "client-1", Limit: 500, Period: time.Hour, Cost: 501},
+	})
+	if err != nil {
+		t.Fatalf("Allow() error = %v", err)
+	}
+	if result.Allowed || !result.Exceeds || result.Scope != RateLimitScopeClient || result.Limit != 500 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
+
+func TestRecipientLimitsKey(t *testing.T) {
+	override := 50
+	limiter := &RateLimiter{cfg: &config.Config{RateLimitEnabled: true, RateLimitRecipientPerHour: 10}}
+	tests := []struct {
+		name      string
+		client    *models.ClientToken
+		wantKey   string
+		wantLimit int
+	}{
+		{name: "no client", wantKey: "user@example.com", wantLimit: 10},
+		{name: "global limit", client: &models.ClientToken{ClientID: "client-1"}, wantKey: "user@example.com", wantLimit: 10},
+		{name: "override", client: &models.ClientToken{ClientID: "client-1", RateLimitRecipientPerHour: &override}, wantKey: "client:client-1:user@example.com", wantLimit: 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limits := limiter.RecipientLimits(tt.client, map[string]int{"User@Example.com": 1, "user@example.com ": 1})
+			if len(limits) != 1 {
+				t.Fatalf("RecipientLimits() = %+v, want 1 limit", limits)
+			}
+			if limits[0].Key != tt.wantKey || limits[0].Limit != tt.wantLimit || limits[0].Cost != 2 {
+				t.Fatalf("RecipientLimits() = %+v, want key %q limit %d cost 2", limits[0], tt.wantKey, tt.wantLimit)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+// internal/services/send_shaper.go
+// Worker 發送速率調節 - 依供應商與寄件信箱限制送出速度，避免觸發供應商節流
+
+package services
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"mail-proxy/internal/config"
+	"mail-proxy/internal/models"
+)
+
+// 發送供應商
+const (
+	ProviderGraph     = "graph"
+	ProviderSendGrid  = "sendgrid"
+	ProviderSMTPRelay = "smtp_relay"
+)
+
+// rateLimitScopeShaper 發送速率調節在 KeyDB 的 key 前綴
+const rateLimitScopeShaper = "shaper"
+
+// SendShaper 發送速率調節服務
+// 以 RateLimiter 的 GCRA (等同 token bucket) 在 KeyDB 計數，多個 Worker 共用同一組額度
+type SendShaper struct {
+	cfg     *config.Config
+	limiter *RateLimiter
+}
+
+// NewSendShaper 建立發送速率調節服務
+func NewSendShaper(cfg *config.Config, limiter *RateLimiter) *SendShaper {
+	return &SendShaper{
+		cfg:     cfg,
+		limiter: limiter,
+	}
+}
+
+// Enabled 是否啟用發送速率調節
+func (s *SendShaper) Enabled() bool {
+	return s != nil && s.cfg.ShaperEnabled
+}
+
+// Acquire 嘗試取得發送額度
+// 回傳 0 表示可立即發送 (已扣除額度)；大於 0 表示需延後的時間 (未扣除任何額度)
+func (s *SendShaper) Acquire(ctx context.Context, provider string, job *models.MailJob) (time.Duration, error) {
+	mailbox := strings.ToLower(strings.TrimSpace(job.FromAddress))
+	recipients := len(job.ToAddresses) + len(job.CCAddresses) + len(job.BCCAddresses)
+	if recipients == 0 {
+		recipients = 1
+	}
+
+	var limits []RateLimit
+	switch provider {
+	case ProviderGraph:
+		// 單封超過每日上限時只要求整日額度，避免永遠無法送出
+		if limit := s.cfg.ShaperGraphRecipientsPerDay; limit > 0 && recipients > limit {
+			recipients = limit
+		}
+		limits = []RateLimit{
+			{Scope: rateLimitScopeShaper, Key: provider + ":messages:" + mailbox, Limit: s.cfg.ShaperGraphMessagesPerMinute, Period: time.Minute, Cost: 1},
+			{Scope: rateLimitScopeShaper, Key: provider + ":recipients:" + mailbox, Limit: s.cfg.ShaperGraphRecipientsPerDay, Period: 24 * time.Hour, Cost: recipients},
+		}
+	case ProviderSendGrid:
+		limits = []RateLimit{
+			{Scope: rateLimitScopeShaper, Key: provider + ":messages:" + mailbox, Limit: s.cfg.ShaperSendGridMessagesPerMinute, Period: time.Minute, Cost: 1},
+		}
+	case ProviderSMTPRelay:
+		limits = []RateLimit{
+			{Scope: rateLimitScopeShaper, Key: provider + ":messages:" + mailbox, Limit: s.cfg.ShaperRelayMessagesPerMinute, Period: time.Minute, Cost: 1},
+		}
+	}
+
+	result, err := s.limiter.Allow(ctx, limits)
+	if err != nil {
+		return 0, err
+	}
+	if result.Allowed {
+		return 0, nil
+	}
+	return result.RetryAfter, nil
+}
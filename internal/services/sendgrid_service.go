@@ -4,10 +4,12 @@
 package services
 
 import (
+	"bytes"
 	"encoding/base64"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/sendgrid/sendgrid-go"
 	"github.com/sendgrid/sendgrid-go/helpers/mail"
@@ -21,14 +23,18 @@ import (
 type SendGridService struct {
 	cfg    *config.Config
 	client *sendgrid.Client
+	signer MessageSigner     // 寄件網域的 DKIM 簽章 (可為 nil)
+	smtp   *SMTPRelayService // 簽章後的 MIME 經 SendGrid SMTP 送出 (JSON API 無法帶上自訂簽章)
 }
 
 // NewSendGridService 建立 SendGrid 服務
-func NewSendGridService(cfg *config.Config) *SendGridService {
+func NewSendGridService(cfg *config.Config, signer MessageSigner) *SendGridService {
 	client := sendgrid.NewSendClient(cfg.SendGridAPIKey)
 	return &SendGridService{
 		cfg:    cfg,
 		client: client,
+		signer: signer,
+		smtp:   newSendGridSMTPRelay(cfg),
 	}
 }
 
@@ -43,9 +49,27 @@ func (s *SendGridService) IsConfigured() bool {
 }
 
 // SendMail 發送郵件 (使用 SendGrid API)
+// 寄件網域有啟用的 DKIM 金鑰時，改為組成 MIME 簽章後經 SendGrid SMTP 送出
 func (s *SendGridService) SendMail(job *models.MailJob) error {
+	signed, err := s.signedMessage(job)
+	if err != nil {
+		return err
+	}
+	if signed != nil {
+		if err := s.smtp.SendRawMail(job, signed); err != nil {
+			return fmt.Errorf("failed to send signed email via SendGrid SMTP: %w", err)
+		}
+		return nil
+	}
+
+	options := job.Options
+
 	// 建立寄件人
-	from := mail.NewEmail("", job.FromAddress)
+	var fromName string
+	if options != nil {
+		fromName = options.FromName
+	}
+	from := mail.NewEmail(fromName, job.FromAddress)
 
 	// 建立郵件
 	message := mail.NewV3Mail()
@@ -57,21 +81,26 @@ func (s *SendGridService) SendMail(job *models.MailJob) error {
 
 	// To 收件人
 	for _, addr := range job.ToAddresses {
-		personalization.AddTos(mail.NewEmail("", addr))
+		personalization.AddTos(mail.NewEmail(options.DisplayName(addr), addr))
 	}
 
 	// CC 收件人
 	for _, addr := range job.CCAddresses {
-		personalization.AddCCs(mail.NewEmail("", addr))
+		personalization.AddCCs(mail.NewEmail(options.DisplayName(addr), addr))
 	}
 
 	// BCC 收件人
 	for _, addr := range job.BCCAddresses {
-		personalization.AddBCCs(mail.NewEmail("", addr))
+		personalization.AddBCCs(mail.NewEmail(options.DisplayName(addr), addr))
 	}
 
 	message.AddPersonalizations(personalization)
 
+	// Reply-To、重要性與標頭
+	if options != nil {
+		s.applyOptions(message, options)
+	}
+
 	// 設定郵件內容 (SendGrid 要求順序: text/plain 必須在 text/html 之前)
 	if job.Body != "" {
 		message.AddContent(mail.NewContent("text/plain", job.Body))
@@ -80,6 +109,12 @@ func (s *SendGridService) SendMail(job *models.MailJob) error {
 		message.AddContent(mail.NewContent("text/html", job.HTML))
 	}
 
+	// 會議邀請：SendGrid 的 content type 不接受參數，method 由 iCalendar 內容的 METHOD 表示；
+	// 另附 invite.ics 供不解析 text/calendar 內文的用戶端匯入
+	if job.Calendar != nil {
+		message.AddContent(mail.NewContent("text/calendar", job.Calendar.Content))
+	}
+
 	// 載入附件
 	if err := s.loadAttachments(job, message); err != nil {
 		return fmt.Errorf("failed to load attachments: %w", err)
@@ -99,12 +134,66 @@ func (s *SendGridService) SendMail(job *models.MailJob) error {
 	return nil
 }
 
-// loadAttachments 載入附件
-func (s *SendGridService) loadAttachments(job *models.MailJob, message *mail.SGMailV3) error {
-	if len(job.Attachments) == 0 {
-		return nil
+// signedMessage 組成 MIME 並以寄件網域的金鑰簽章
+// 未設定簽章或該網域沒有金鑰 (Sign 原樣回傳) 時回傳 nil，以 JSON API 發送
+func (s *SendGridService) signedMessage(job *models.MailJob) ([]byte, error) {
+	if s.signer == nil {
+		return nil, nil
+	}
+
+	raw, err := BuildMIMEMessage(job)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build MIME message: %w", err)
+	}
+	signed, err := s.signer.Sign(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign message: %w", err)
+	}
+	if bytes.Equal(signed, raw) {
+		return nil, nil
+	}
+	return signed, nil
+}
+
+// applyOptions 設定 Reply-To、回覆串接標頭、重要性、自訂標頭與一鍵退訂標頭
+func (s *SendGridService) applyOptions(message *mail.SGMailV3, options *models.MessageOptions) {
+	switch len(options.ReplyTo) {
+	case 0:
+	case 1:
+		message.SetReplyTo(mail.NewEmail(options.DisplayName(options.ReplyTo[0]), options.ReplyTo[0]))
+	default:
+		replyTo := make([]*mail.Email, 0, len(options.ReplyTo))
+		for _, addr := range options.ReplyTo {
+			replyTo = append(replyTo, mail.NewEmail(options.DisplayName(addr), addr))
+		}
+		message.SetReplyToList(replyTo)
 	}
 
+	for name, value := range options.Headers {
+		message.SetHeader(name, value)
+	}
+	for name, value := range options.UnsubscribeHeaders() {
+		message.SetHeader(name, value)
+	}
+	if options.InReplyTo != "" {
+		message.SetHeader("In-Reply-To", options.InReplyTo)
+	}
+	if len(options.References) > 0 {
+		message.SetHeader("References", strings.Join(options.References, " "))
+	}
+
+	switch options.Importance {
+	case models.ImportanceHigh:
+		message.SetHeader("Importance", "high")
+		message.SetHeader("X-Priority", "1 (Highest)")
+	case models.ImportanceLow:
+		message.SetHeader("Importance", "low")
+		message.SetHeader("X-Priority", "5 (Lowest)")
+	}
+}
+
+// loadAttachments 載入附件
+func (s *SendGridService) loadAttachments(job *models.MailJob, message *mail.SGMailV3) error {
 	for _, att := range job.Attachments {
 		// 讀取附件檔案
 		content, err := os.ReadFile(att.StoragePath)
@@ -123,10 +212,24 @@ func (s *SendGridService) loadAttachments(job *models.MailJob, message *mail.SGM
 		attachment.SetContent(base64.StdEncoding.EncodeToString(content))
 		attachment.SetType(contentType)
 		attachment.SetFilename(filepath.Base(att.Filename))
-		attachment.SetDisposition("attachment")
+		if att.Inline && att.ContentID != "" {
+			attachment.SetDisposition("inline")
+			attachment.SetContentID(att.ContentID)
+		} else {
+			attachment.SetDisposition("attachment")
+		}
 
 		message.AddAttachment(attachment)
 	}
 
+	if job.Calendar != nil {
+		attachment := mail.NewAttachment()
+		attachment.SetContent(base64.StdEncoding.EncodeToString([]byte(job.Calendar.Content)))
+		attachment.SetType("text/calendar")
+		attachment.SetFilename("invite.ics")
+		attachment.SetDisposition("attachment")
+		message.AddAttachment(attachment)
+	}
+
 	return nil
 }
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"mail-proxy/internal/config"
+	"mail-proxy/internal/models"
+)
+
+// domainSigner 只簽署指定網域寄件者的測試用簽章
+type domainSigner struct {
+	domain string
+}
+
+func (s domainSigner) Sign(raw []byte) ([]byte, error) {
+	if !bytes.Contains(raw, []byte("@"+s.domain)) {
+		return raw, nil
+	}
+	return append([]byte("DKIM-Signature: v=1; d="+s.domain+"\r\n"), raw...), nil
+}
+
+func TestSendGridSignedMessage(t *testing.T) {
+	cfg := &config.Config{SendGridAPIKey: "key", SendGridSMTPAddr: "smtp.sendgrid.net:587"}
+	tests := []struct {
+		name       string
+		signer     MessageSigner
+		from       string
+		wantSigned bool
+	}{
+		{name: "no signer", from: "a@signed.test"},
+		{name: "domain without key", signer: domainSigner{domain: "signed.test"}, from: "a@other.test"},
+		{name: "domain with key", signer: domainSigner{domain: "signed.test"}, from: "a@signed.test", wantSigned: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSendGridService(cfg, tt.signer)
+			job := &models.MailJob{MailID: "1", FromAddress: tt.from, ToAddresses: []string{"b@example.com"}, Subject: "hi", Body: "hello"}
+			signed, err := s.signedMessage(job)
+			if err != nil {
+				t.Fatalf("signedMessage() error = %v", err)
+			}
+			if (signed != nil) != tt.wantSigned {
+				t.Fatalf("signedMessage() signed = %v, want %v", signed != nil, tt.wantSigned)
+			}
+			if signed != nil && !strings.HasPrefix(string(signed), "DKIM-Signature:") {
+				t.Fatalf("signedMessage() = %q, want DKIM-Signature first", signed[:40])
+			}
+		})
+	}
+}
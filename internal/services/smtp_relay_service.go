@@ -0,0 +1,255 @@
+// internal/services/smtp_relay_service.go
+// SMTP Relay 郵件發送服務 - 透過上游 MTA 送出原始 MIME
+
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"strings"
+
+	"github.com/emersion/go-message/textproto"
+	"github.com/emersion/go-sasl"
+	gosmtp "github.com/emersion/go-smtp"
+
+	"mail-proxy/internal/config"
+	"mail-proxy/internal/models"
+)
+
+// SMTPRelayService SMTP Relay 郵件發送服務
+// 實作 MailSender 與 RawMailSender interface
+type SMTPRelayService struct {
+	name     string
+	addr     string
+	username string
+	password string
+	startTLS bool
+	signer   MessageSigner // 送出前的簽章 (可為 nil)
+}
+
+// NewSMTPRelayService 建立 SMTP Relay 服務
+func NewSMTPRelayService(cfg *config.Config, signer MessageSigner) *SMTPRelayService {
+	return &SMTPRelayService{
+		name:     "SMTP Relay",
+		addr:     cfg.SMTPRelayAddr,
+		username: cfg.SMTPRelayUsername,
+		password: cfg.SMTPRelayPassword,
+		startTLS: cfg.SMTPRelayStartTLS,
+		signer:   signer,
+	}
+}
+
+// newSendGridSMTPRelay 建立 SendGrid SMTP 出口 (帳號固定為 apikey，密碼為 API Key)
+// 郵件已由 SendGridService 簽章，這裡不再簽章
+func newSendGridSMTPRelay(cfg *config.Config) *SMTPRelayService {
+	return &SMTPRelayService{
+		name:     "SendGrid SMTP",
+		addr:     cfg.SendGridSMTPAddr,
+		username: "apikey",
+		password: cfg.SendGridAPIKey,
+		startTLS: true,
+	}
+}
+
+// Name 回傳服務名稱
+func (s *SMTPRelayService) Name() string {
+	return s.name
+}
+
+// IsConfigured 檢查 SMTP Relay 是否已設定
+func (s *SMTPRelayService) IsConfigured() bool {
+	return s.addr != ""
+}
+
+// SendMail 依 MailJob 欄位組信後送出
+func (s *SMTPRelayService) SendMail(job *models.MailJob) error {
+	raw, err := BuildMIMEMessage(job)
+	if err != nil {
+		return fmt.Errorf("failed to build MIME message: %w", err)
+	}
+	return s.SendRawMail(job, raw)
+}
+
+// SendRawMail 直送原始 MIME 郵件
+// 信封收件者取自 MailJob，郵件內容除 DKIM 簽章外原封不動送出；NullSender 時以 MAIL FROM:<> 送出
+// 收信時協商的 SMTPUTF8 / BODY 參數會沿用至下一跳，下一跳不支援時回傳 PermanentError
+func (s *SMTPRelayService) SendRawMail(job *models.MailJob, raw []byte) error {
+	if !s.IsConfigured() {
+		return fmt.Errorf("SMTP relay is not configured")
+	}
+
+	recipients := EnvelopeRecipients(job)
+	if len(recipients) == 0 {
+		return fmt.Errorf("no recipients for mail %s", job.MailID)
+	}
+
+	body := gosmtp.BodyType(job.BodyType)
+	if body == gosmtp.BodyBinaryMIME {
+		// go-smtp client 不支援 BDAT，binary 部分改以 base64 編碼後用 DATA 送出 (RFC 3030 §3 允許轉換)
+		encoded, err := encodeBinaryParts(raw)
+		if err != nil {
+			return &PermanentError{Status: "5.6.3", Err: fmt.Errorf("failed to convert BINARYMIME message: %w", err)}
+		}
+		raw = encoded
+		body = gosmtp.Body8BitMIME
+	}
+
+	if s.signer != nil {
+		signed, err := s.signer.Sign(raw)
+		if err != nil {
+			return fmt.Errorf("failed to sign message: %w", err)
+		}
+		raw = signed
+	}
+
+	client, err := s.dial()
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP relay: %w", err)
+	}
+	defer client.Close()
+
+	if s.username != "" {
+		auth := sasl.NewPlainClient("", s.username, s.password)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("SMTP relay authentication failed: %w", err)
+		}
+	}
+
+	if job.SMTPUTF8 {
+		if ok, _ := client.Extension("SMTPUTF8"); !ok {
+			return &PermanentError{Status: "5.6.7", Err: errors.New("SMTP relay does not support SMTPUTF8")}
+		}
+	}
+	if body == gosmtp.Body8BitMIME {
+		if ok, _ := client.Extension("8BITMIME"); !ok {
+			return &PermanentError{Status: "5.6.3", Err: errors.New("SMTP relay does not support 8BITMIME")}
+		}
+	}
+
+	from := job.FromAddress
+	if job.NullSender {
+		from = ""
+	}
+	if err := s.transfer(client, from, recipients, &gosmtp.MailOptions{UTF8: job.SMTPUTF8, Body: body}, raw); err != nil {
+		return fmt.Errorf("failed to send mail via SMTP relay: %w", err)
+	}
+
+	return client.Quit()
+}
+
+// transfer 以指定的 MAIL FROM 參數送出 MAIL / RCPT / DATA
+func (s *SMTPRelayService) transfer(client *gosmtp.Client, from string, recipients []string, opts *gosmtp.MailOptions, raw []byte) error {
+	if err := client.Mail(from, opts); err != nil {
+		return err
+	}
+	for _, rcpt := range recipients {
+		if err := client.Rcpt(rcpt, nil); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(raw); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// dial 連線至 SMTP Relay (依設定使用 STARTTLS)
+func (s *SMTPRelayService) dial() (*gosmtp.Client, error) {
+	if !s.startTLS {
+		return gosmtp.Dial(s.addr)
+	}
+
+	host, _, err := net.SplitHostPort(s.addr)
+	if err != nil {
+		return nil, err
+	}
+	return gosmtp.DialStartTLS(s.addr, &tls.Config{ServerName: host})
+}
+
+// encodeBinaryParts 將 Content-Transfer-Encoding: binary 的部分改為 base64，其餘內容原樣保留
+func encodeBinaryParts(raw []byte) ([]byte, error) {
+	br := bufio.NewReader(bytes.NewReader(raw))
+	header, err := textproto.ReadHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := writeEncodedEntity(&buf, header, br); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeEncodedEntity 遞迴寫出 MIME entity，遇到 binary 單一部分時以 base64 重新編碼
+func writeEncodedEntity(w io.Writer, header textproto.Header, body io.Reader) error {
+	binary := strings.EqualFold(strings.TrimSpace(header.Get("Content-Transfer-Encoding")), "binary")
+	mediaType, params, _ := mime.ParseMediaType(header.Get("Content-Type"))
+
+	if boundary := params["boundary"]; strings.HasPrefix(mediaType, "multipart/") && boundary != "" {
+		if binary {
+			header.Set("Content-Transfer-Encoding", "8bit")
+		}
+		if err := textproto.WriteHeader(w, header); err != nil {
+			return err
+		}
+		mr := textproto.NewMultipartReader(body, boundary)
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "--%s\r\n", boundary); err != nil {
+				return err
+			}
+			if err := writeEncodedEntity(w, part.Header, part); err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, "\r\n"); err != nil {
+				return err
+			}
+		}
+		_, err := fmt.Fprintf(w, "--%s--\r\n", boundary)
+		return err
+	}
+
+	if !binary {
+		if err := textproto.WriteHeader(w, header); err != nil {
+			return err
+		}
+		_, err := io.Copy(w, body)
+		return err
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	header.Set("Content-Transfer-Encoding", "base64")
+	if err := textproto.WriteHeader(w, header); err != nil {
+		return err
+	}
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		if _, err := io.WriteString(w, encoded[:76]+"\r\n"); err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err = io.WriteString(w, encoded)
+	return err
+}
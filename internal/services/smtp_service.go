@@ -12,6 +12,8 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"mail-proxy/internal/config"
 	"mail-proxy/internal/models"
@@ -25,15 +27,24 @@ type GraphMailService struct {
 	oauthService *microsoft.OAuthService // 用於 SMTP Receiver (環境變數配置)
 	oauthManager *microsoft.OAuthManager // 用於 API 請求 (資料庫配置)
 	httpClient   *http.Client
+	signer       MessageSigner // 原始 MIME 送出前的簽章 (可為 nil)
+
+	// uploads 大型附件上傳進度 (跨重試續傳；nil 時每次重試重新上傳)
+	uploads GraphUploadStateStore
 }
 
 // NewGraphMailService 建立 Graph API 郵件服務
-func NewGraphMailService(cfg *config.Config, oauthService *microsoft.OAuthService) *GraphMailService {
+// httpClient 為 nil 時使用 http.DefaultClient；Graph 端點與 oauthService / Sender Config 的端點相同
+func NewGraphMailService(cfg *config.Config, oauthService *microsoft.OAuthService, signer MessageSigner, httpClient *http.Client) *GraphMailService {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
 	return &GraphMailService{
 		cfg:          cfg,
 		oauthService: oauthService,
 		oauthManager: microsoft.DefaultOAuthManager,
-		httpClient:   &http.Client{},
+		httpClient:   httpClient,
+		signer:       signer,
 	}
 }
 
@@ -52,12 +63,38 @@ type GraphMailRequest struct {
 type GraphMessage struct {
 	Subject       string            `json:"subject"`
 	Body          GraphBody         `json:"body"`
+	From          *GraphRecipient   `json:"from,omitempty"`
 	ToRecipients  []GraphRecipient  `json:"toRecipients"`
 	CcRecipients  []GraphRecipient  `json:"ccRecipients,omitempty"`
 	BccRecipients []GraphRecipient  `json:"bccRecipients,omitempty"`
+	ReplyTo       []GraphRecipient  `json:"replyTo,omitempty"`
+	Importance    string            `json:"importance,omitempty"`
 	Attachments   []GraphAttachment `json:"attachments,omitempty"`
+
+	// 自訂 X- 標頭
+	InternetMessageHeaders []GraphHeader `json:"internetMessageHeaders,omitempty"`
+	// In-Reply-To / References (Graph 不接受以 internetMessageHeaders 設定，改用 MAPI 屬性)
+	SingleValueExtendedProperties []GraphExtendedProperty `json:"singleValueExtendedProperties,omitempty"`
+}
+
+// GraphHeader Graph API 郵件標頭結構
+type GraphHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// GraphExtendedProperty Graph API 單值延伸屬性結構
+type GraphExtendedProperty struct {
+	ID    string `json:"id"`
+	Value string `json:"value"`
 }
 
+// MAPI 屬性 (PidTagInReplyToId / PidTagInternetReferences)
+const (
+	graphPropInReplyTo  = "String 0x1042"
+	graphPropReferences = "String 0x1039"
+)
+
 // GraphBody Graph API 郵件內容結構
 type GraphBody struct {
 	ContentType string `json:"contentType"`
@@ -71,6 +108,7 @@ type GraphRecipient struct {
 
 // GraphEmailAddress Graph API 電子郵件地址結構
 type GraphEmailAddress struct {
+	Name    string `json:"name,omitempty"`
 	Address string `json:"address"`
 }
 
@@ -80,6 +118,8 @@ type GraphAttachment struct {
 	Name         string `json:"name"`
 	ContentType  string `json:"contentType"`
 	ContentBytes string `json:"contentBytes"`
+	ContentID    string `json:"contentId,omitempty"` // 內嵌附件 (HTML 以 cid: 參照)
+	IsInline     bool   `json:"isInline,omitempty"`
 }
 
 // GraphErrorResponse Graph API 錯誤回應
@@ -92,8 +132,27 @@ type GraphErrorResponse struct {
 
 // SendMail 發送郵件 (使用 Microsoft Graph API)
 func (s *GraphMailService) SendMail(job *models.MailJob) error {
+	return s.sendMessage(s.oauthService, job)
+}
+
+// sendMessage 發送郵件；附件合計超過門檻時改以草稿 + upload session 上傳
+// 含會議邀請或一鍵退訂時改以 MIME 送出 (Graph JSON 無法加入 text/calendar 內文，
+// internetMessageHeaders 也只接受 X- 開頭的標頭)
+func (s *GraphMailService) sendMessage(oauthService *microsoft.OAuthService, job *models.MailJob) error {
+	if job.Calendar != nil || job.Options.UnsubscribeHeaders() != nil {
+		return s.sendMIME(oauthService, job)
+	}
+
+	large, err := s.needsUploadSession(job)
+	if err != nil {
+		return fmt.Errorf("failed to load attachments: %w", err)
+	}
+	if large {
+		return s.sendWithUploadSession(oauthService, job)
+	}
+
 	// 取得 OAuth 2.0 Access Token
-	accessToken, err := s.oauthService.GetAccessToken()
+	accessToken, err := oauthService.GetAccessToken()
 	if err != nil {
 		return fmt.Errorf("failed to get access token: %w", err)
 	}
@@ -112,41 +171,22 @@ func (s *GraphMailService) SendMail(job *models.MailJob) error {
 		return fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	// Graph API 端點
-	graphURL := fmt.Sprintf(
-		"https://graph.microsoft.com/v1.0/users/%s/sendMail",
-		job.FromAddress,
-	)
+	return s.postSendMail(oauthService.Endpoints(), accessToken, job.FromAddress, "application/json", jsonBody)
+}
 
-	// 建立 HTTP 請求
-	req, err := http.NewRequest("POST", graphURL, bytes.NewBuffer(jsonBody))
+// sendMIME 組裝 MIME 郵件並以 Graph MIME 格式送出
+func (s *GraphMailService) sendMIME(oauthService *microsoft.OAuthService, job *models.MailJob) error {
+	raw, err := BuildMIMEMessage(job)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return fmt.Errorf("failed to build MIME message: %w", err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+accessToken)
-	req.Header.Set("Content-Type", "application/json")
-
-	// 發送請求
-	resp, err := s.httpClient.Do(req)
+	accessToken, err := oauthService.GetAccessToken()
 	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// 檢查回應 (202 Accepted 表示成功)
-	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-
-		var errResp GraphErrorResponse
-		if err := json.Unmarshal(body, &errResp); err == nil && errResp.Error.Message != "" {
-			return fmt.Errorf("Graph API error (%s): %s", errResp.Error.Code, errResp.Error.Message)
-		}
-
-		return fmt.Errorf("Graph API request failed with status %d: %s", resp.StatusCode, string(body))
+		return fmt.Errorf("failed to get access token: %w", err)
 	}
 
-	return nil
+	return s.sendRaw(oauthService.Endpoints(), accessToken, job, raw)
 }
 
 // buildGraphRequest 建立 Graph API 請求結構
@@ -159,45 +199,63 @@ func (s *GraphMailService) buildGraphRequest(job *models.MailJob) *GraphMailRequ
 		content = job.HTML
 	}
 
-	// 建立收件人列表
-	toRecipients := make([]GraphRecipient, len(job.ToAddresses))
-	for i, addr := range job.ToAddresses {
-		toRecipients[i] = GraphRecipient{
-			EmailAddress: GraphEmailAddress{Address: addr},
-		}
+	// 建立收件人列表 (To / CC / BCC)
+	options := job.Options
+	message := GraphMessage{
+		Subject: job.Subject,
+		Body: GraphBody{
+			ContentType: contentType,
+			Content:     content,
+		},
+		ToRecipients:  graphRecipients(job.ToAddresses, options),
+		CcRecipients:  graphRecipients(job.CCAddresses, options),
+		BccRecipients: graphRecipients(job.BCCAddresses, options),
 	}
 
-	// CC 收件人
-	ccRecipients := make([]GraphRecipient, len(job.CCAddresses))
-	for i, addr := range job.CCAddresses {
-		ccRecipients[i] = GraphRecipient{
-			EmailAddress: GraphEmailAddress{Address: addr},
+	// 顯示名稱、Reply-To、重要性與標頭
+	if options != nil {
+		if options.FromName != "" {
+			message.From = &GraphRecipient{
+				EmailAddress: GraphEmailAddress{Name: options.FromName, Address: job.FromAddress},
+			}
 		}
-	}
+		message.ReplyTo = graphRecipients(options.ReplyTo, options)
+		message.Importance = options.Importance
 
-	// BCC 收件人
-	bccRecipients := make([]GraphRecipient, len(job.BCCAddresses))
-	for i, addr := range job.BCCAddresses {
-		bccRecipients[i] = GraphRecipient{
-			EmailAddress: GraphEmailAddress{Address: addr},
+		for name, value := range options.Headers {
+			message.InternetMessageHeaders = append(message.InternetMessageHeaders, GraphHeader{Name: name, Value: value})
+		}
+		sort.Slice(message.InternetMessageHeaders, func(i, j int) bool {
+			return message.InternetMessageHeaders[i].Name < message.InternetMessageHeaders[j].Name
+		})
+
+		if options.InReplyTo != "" {
+			message.SingleValueExtendedProperties = append(message.SingleValueExtendedProperties,
+				GraphExtendedProperty{ID: graphPropInReplyTo, Value: options.InReplyTo})
+		}
+		if len(options.References) > 0 {
+			message.SingleValueExtendedProperties = append(message.SingleValueExtendedProperties,
+				GraphExtendedProperty{ID: graphPropReferences, Value: strings.Join(options.References, " ")})
 		}
 	}
 
 	return &GraphMailRequest{
-		Message: GraphMessage{
-			Subject: job.Subject,
-			Body: GraphBody{
-				ContentType: contentType,
-				Content:     content,
-			},
-			ToRecipients:  toRecipients,
-			CcRecipients:  ccRecipients,
-			BccRecipients: bccRecipients,
-		},
+		Message:         message,
 		SaveToSentItems: true,
 	}
 }
 
+// graphRecipients 建立收件人列表 (含顯示名稱)
+func graphRecipients(addresses []string, options *models.MessageOptions) []GraphRecipient {
+	recipients := make([]GraphRecipient, len(addresses))
+	for i, addr := range addresses {
+		recipients[i] = GraphRecipient{
+			EmailAddress: GraphEmailAddress{Name: options.DisplayName(addr), Address: addr},
+		}
+	}
+	return recipients
+}
+
 // loadAttachments 載入附件
 func (s *GraphMailService) loadAttachments(job *models.MailJob, message *GraphMessage) error {
 	if len(job.Attachments) == 0 {
@@ -224,6 +282,8 @@ func (s *GraphMailService) loadAttachments(job *models.MailJob, message *GraphMe
 			Name:         filepath.Base(att.Filename),
 			ContentType:  contentType,
 			ContentBytes: base64.StdEncoding.EncodeToString(content),
+			ContentID:    att.ContentID,
+			IsInline:     att.Inline,
 		})
 	}
 
@@ -232,41 +292,87 @@ func (s *GraphMailService) loadAttachments(job *models.MailJob, message *GraphMe
 }
 
 // SendMailWithConfig 使用指定的 OAuth 配置發送郵件 (用於 API 請求)
-func (s *GraphMailService) SendMailWithConfig(job *models.MailJob, tenantID, clientID, clientSecret string) error {
-	// 從 OAuthManager 取得 Access Token
-	accessToken, err := s.oauthManager.GetAccessToken(tenantID, clientID, clientSecret)
+func (s *GraphMailService) SendMailWithConfig(job *models.MailJob, tenantID, clientID string, credential microsoft.Credential) error {
+	// 從 OAuthManager 取得配置對應的 OAuthService
+	oauthService, err := s.oauthManager.GetOrCreateServiceWithCredential(tenantID, clientID, credential)
 	if err != nil {
 		return fmt.Errorf("failed to get access token: %w", err)
 	}
 
-	// 建立 Graph API 請求
-	mailRequest := s.buildGraphRequest(job)
+	return s.sendMessage(oauthService, job)
+}
 
-	// 讀取附件
-	if err := s.loadAttachments(job, &mailRequest.Message); err != nil {
-		return fmt.Errorf("failed to load attachments: %w", err)
+// accessTokenWithConfig 從 OAuthManager 取得配置對應的 OAuthService 與 Access Token
+func (s *GraphMailService) accessTokenWithConfig(tenantID, clientID string, credential microsoft.Credential) (*microsoft.OAuthService, string, error) {
+	oauthService, err := s.oauthManager.GetOrCreateServiceWithCredential(tenantID, clientID, credential)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get access token: %w", err)
 	}
+	accessToken, err := oauthService.GetAccessToken()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get access token: %w", err)
+	}
+	return oauthService, accessToken, nil
+}
 
-	// 序列化請求
-	jsonBody, err := json.Marshal(mailRequest)
+// SendRawMail 直送原始 MIME 郵件 (使用環境變數 OAuth 配置)
+// 實作 RawMailSender interface
+func (s *GraphMailService) SendRawMail(job *models.MailJob, raw []byte) error {
+	accessToken, err := s.oauthService.GetAccessToken()
 	if err != nil {
-		return fmt.Errorf("failed to marshal request: %w", err)
+		return fmt.Errorf("failed to get access token: %w", err)
+	}
+
+	return s.sendRaw(s.oauthService.Endpoints(), accessToken, job, raw)
+}
+
+// SendRawMailWithConfig 使用指定的 OAuth 配置直送原始 MIME 郵件
+func (s *GraphMailService) SendRawMailWithConfig(job *models.MailJob, raw []byte, tenantID, clientID string, credential microsoft.Credential) error {
+	oauthService, accessToken, err := s.accessTokenWithConfig(tenantID, clientID, credential)
+	if err != nil {
+		return err
+	}
+
+	return s.sendRaw(oauthService.Endpoints(), accessToken, job, raw)
+}
+
+// sendRaw 以 Graph MIME 格式送出 (Content-Type: text/plain，內容為 base64 編碼的 MIME)
+func (s *GraphMailService) sendRaw(endpoints microsoft.Endpoints, accessToken string, job *models.MailJob, raw []byte) error {
+	// 先簽章再補 Bcc，Bcc 不納入簽章範圍
+	if s.signer != nil {
+		signed, err := s.signer.Sign(raw)
+		if err != nil {
+			return fmt.Errorf("failed to sign message: %w", err)
+		}
+		raw = signed
+	}
+
+	raw, err := withEnvelopeBcc(raw, EnvelopeRecipients(job))
+	if err != nil {
+		return err
 	}
 
+	body := []byte(base64.StdEncoding.EncodeToString(raw))
+	return s.postSendMail(endpoints, accessToken, job.FromAddress, "text/plain", body)
+}
+
+// postSendMail 呼叫 Graph API sendMail 端點
+func (s *GraphMailService) postSendMail(endpoints microsoft.Endpoints, accessToken, fromAddress, contentType string, body []byte) error {
 	// Graph API 端點
 	graphURL := fmt.Sprintf(
-		"https://graph.microsoft.com/v1.0/users/%s/sendMail",
-		job.FromAddress,
+		"%s/users/%s/sendMail",
+		endpoints.GraphAPIURL(),
+		fromAddress,
 	)
 
 	// 建立 HTTP 請求
-	req, err := http.NewRequest("POST", graphURL, bytes.NewBuffer(jsonBody))
+	req, err := http.NewRequest("POST", graphURL, bytes.NewBuffer(body))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Authorization", "Bearer "+accessToken)
-	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Type", contentType)
 
 	// 發送請求
 	resp, err := s.httpClient.Do(req)
@@ -277,15 +383,37 @@ func (s *GraphMailService) SendMailWithConfig(job *models.MailJob, tenantID, cli
 
 	// 檢查回應 (202 Accepted 表示成功)
 	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
+		return newGraphAPIError(resp)
+	}
 
-		var errResp GraphErrorResponse
-		if err := json.Unmarshal(body, &errResp); err == nil && errResp.Error.Message != "" {
-			return fmt.Errorf("Graph API error (%s): %s", errResp.Error.Code, errResp.Error.Message)
-		}
+	return nil
+}
 
-		return fmt.Errorf("Graph API request failed with status %d: %s", resp.StatusCode, string(body))
+// GraphAPIError Graph API 的錯誤回應
+type GraphAPIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Body       string
+}
+
+// Error 實作 error interface
+func (e *GraphAPIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("Graph API error (%s): %s", e.Code, e.Message)
 	}
+	return fmt.Sprintf("Graph API request failed with status %d: %s", e.StatusCode, e.Body)
+}
 
-	return nil
+// newGraphAPIError 讀取非成功回應並解析 Graph 錯誤格式
+func newGraphAPIError(resp *http.Response) *GraphAPIError {
+	respBody, _ := io.ReadAll(resp.Body)
+	apiErr := &GraphAPIError{StatusCode: resp.StatusCode, Body: string(respBody)}
+
+	var errResp GraphErrorResponse
+	if err := json.Unmarshal(respBody, &errResp); err == nil {
+		apiErr.Code = errResp.Error.Code
+		apiErr.Message = errResp.Error.Message
+	}
+	return apiErr
 }
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"mail-proxy/internal/config"
+	"mail-proxy/internal/models"
+	"mail-proxy/pkg/microsoft"
+	"mail-proxy/pkg/microsoft/graphtest"
+)
+
+// newTestGraphService 建立連線至 graphtest 伺服器的 GraphMailService
+func newTestGraphService(srv *graphtest.Server, cfg *config.Config) *GraphMailService {
+	manager := microsoft.NewOAuthManager()
+	manager.Configure(srv.Endpoints(), srv.Client())
+	oauthService := manager.GetOrCreateService("tenant-1", "client-1", "secret-1")
+
+	service := NewGraphMailService(cfg, oauthService, nil, srv.Client())
+	service.oauthManager = manager
+	return service
+}
+
+func TestGraphSendMail(t *testing.T) {
+	srv := graphtest.NewServer()
+	defer srv.Close()
+	service := newTestGraphService(srv, &config.Config{GraphUploadThresholdKB: 3072})
+
+	job := &models.MailJob{
+		MailID:      "mail-1",
+		FromAddress: "sender@example.com",
+		ToAddresses: []string{"a@example.com"},
+		CCAddresses: []string{"b@example.com"},
+		Subject:     "Hello",
+		HTML:        "<p>Hi</p>",
+		Options:     &models.MessageOptions{FromName: "Sender", Importance: "high"},
+	}
+	if err := service.SendMail(job); err != nil {
+		t.Fatalf("SendMail() error = %v", err)
+	}
+
+	requests := srv.SendRequests()
+	if len(requests) != 1 {
+		t.Fatalf("send requests = %d, want 1", len(requests))
+	}
+	req := requests[0]
+	if req.Mailbox != "sender@example.com" || req.ContentType != "application/json" {
+		t.Fatalf("unexpected send request %+v", req)
+	}
+
+	var message GraphMessage
+	if err := json.Unmarshal(req.Message, &message); err != nil {
+		t.Fatalf("failed to decode message: %v", err)
+	}
+	if message.Subject != "Hello" || message.Body.ContentType != "html" || message.Body.Content != "<p>Hi</p>" {
+		t.Fatalf("unexpected message body %+v", message)
+	}
+	if len(message.ToRecipients) != 1 || message.ToRecipients[0].EmailAddress.Address != "a@example.com" ||
+		len(message.CcRecipients) != 1 || message.CcRecipients[0].EmailAddress.Address != "b@example.com" {
+		t.Fatalf("unexpected recipients %+v / %+v", message.ToRecipients, message.CcRecipients)
+	}
+	if message.From == nil || message.From.EmailAddress.Name != "Sender" || message.Importance != "high" {
+		t.Fatalf("unexpected from / importance %+v / %q", message.From, message.Importance)
+	}
+}
+
+func TestGraphSendMailError(t *testing.T) {
+	srv := graphtest.NewServer()
+	defer srv.Close()
+	service := newTestGraphService(srv, &config.Config{GraphUploadThresholdKB: 3072})
+
+	srv.FailNextSend(http.StatusTooManyRequests, "ApplicationThrottled", "Too many requests")
+	err := service.SendMail(&models.MailJob{FromAddress: "sender@example.com", ToAddresses: []string{"a@example.com"}, Subject: "Hello", Body: "hi"})
+
+	var apiErr *GraphAPIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests || apiErr.Code != "ApplicationThrottled" {
+		t.Fatalf("SendMail() error = %v, want GraphAPIError 429", err)
+	}
+}
+
+func TestGraphSendRawMail(t *testing.T) {
+	srv := graphtest.NewServer()
+	defer srv.Close()
+	service := newTestGraphService(srv, &config.Config{})
+
+	raw := []byte("From: sender@example.com\r\nTo: a@example.com\r\nSubject: Raw\r\n\r\nHello\r\n")
+	job := &models.MailJob{
+		MailID:       "mail-1",
+		FromAddress:  "sender@example.com",
+		ToAddresses:  []string{"a@example.com"},
+		BCCAddresses: []string{"hidden@example.com"},
+		SendMode:     models.MailSendModeRaw,
+	}
+	if err := service.SendRawMail(job, raw); err != nil {
+		t.Fatalf("SendRawMail() error = %v", err)
+	}
+
+	requests := srv.SendRequests()
+	if len(requests) != 1 {
+		t.Fatalf("send requests = %d, want 1", len(requests))
+	}
+	req := requests[0]
+	if req.ContentType != "text/plain" {
+		t.Fatalf("content type = %q, want text/plain", req.ContentType)
+	}
+	// 信封 Bcc 補入標頭，其餘內容原樣送出
+	if !bytes.Contains(req.MIME, []byte("Subject: Raw\r\n")) || !bytes.Contains(req.MIME, []byte("hidden@example.com")) {
+		t.Fatalf("unexpected MIME %q", req.MIME)
+	}
+}
+
+func TestGraphSendRawMailRefreshesExpiredToken(t *testing.T) {
+	srv := graphtest.NewServer()
+	defer srv.Close()
+	srv.TokenLifetime = 30 * time.Second
+	service := newTestGraphService(srv, &config.Config{})
+
+	job := &models.MailJob{FromAddress: "sender@example.com", ToAddresses: []string{"a@example.com"}}
+	raw := []byte("Subject: Raw\r\n\r\nHello\r\n")
+	if err := service.SendRawMail(job, raw); err != nil {
+		t.Fatalf("SendRawMail() error = %v", err)
+	}
+	// 前一個 Token 已在提前更新的時間內，第二封應以新 Token 送出
+	if err := service.SendRawMail(job, raw); err != nil {
+		t.Fatalf("SendRawMail() error = %v", err)
+	}
+	if n := len(srv.TokenRequests()); n != 2 {
+		t.Fatalf("token requests = %d, want 2", n)
+	}
+	requests := srv.SendRequests()
+	if len(requests) != 2 || requests[0].AccessToken == requests[1].AccessToken {
+		t.Fatalf("send requests did not use a refreshed token: %+v", requests)
+	}
+}
@@ -0,0 +1,87 @@
+// internal/services/suppression_service.go
+// 收件者抑制清單服務 - 查詢收件者是否已被抑制、加入退訂的收件者
+
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"mail-proxy/internal/models"
+)
+
+// SuppressionService 抑制清單服務
+type SuppressionService struct {
+	db *gorm.DB
+}
+
+// NewSuppressionService 建立抑制清單服務
+func NewSuppressionService(db *gorm.DB) *SuppressionService {
+	return &SuppressionService{db: db}
+}
+
+// IsSuppressed 檢查收件者是否在抑制清單中
+// 同時比對全域抑制與指定 Client 的抑制記錄 (不含只針對特定退訂類別的記錄)
+func (s *SuppressionService) IsSuppressed(email, clientID string) (bool, error) {
+	return s.IsSuppressedForCategory(email, clientID, "")
+}
+
+// IsSuppressedForCategory 檢查收件者是否在抑制清單中
+// 除全域與 Client 的抑制記錄外，category 不為空白時也比對該類別的退訂記錄
+func (s *SuppressionService) IsSuppressedForCategory(email, clientID, category string) (bool, error) {
+	var count int64
+	err := s.db.Model(&models.Suppression{}).
+		Where("email = ?", strings.ToLower(email)).
+		Where("client_id = '' OR (client_id = ? AND category IN ?)", clientID, []string{"", category}).
+		Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to query suppressions: %w", err)
+	}
+	return count > 0, nil
+}
+
+// SuppressedRecipients 回傳 emails 中已被抑制的收件者 (全域或該 Client 不限類別的抑制記錄)
+// 以單一查詢比對所有收件者，回傳的地址為小寫
+func (s *SuppressionService) SuppressedRecipients(emails []string, clientID string) ([]string, error) {
+	if len(emails) == 0 {
+		return nil, nil
+	}
+	lowered := make([]string, 0, len(emails))
+	for _, email := range emails {
+		lowered = append(lowered, strings.ToLower(email))
+	}
+
+	var suppressed []string
+	err := s.db.Model(&models.Suppression{}).
+		Distinct("email").
+		Where("email IN ?", lowered).
+		Where("client_id = '' OR (client_id = ? AND category = '')", clientID).
+		Order("email").
+		Pluck("email", &suppressed).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query suppressions: %w", err)
+	}
+	return suppressed, nil
+}
+
+// Suppress 將收件者加入指定 Client (與類別) 的抑制清單
+// 已存在相同記錄時不重複新增，回傳 nil
+func (s *SuppressionService) Suppress(email, clientID, category, reason string) (*models.Suppression, error) {
+	suppression := &models.Suppression{
+		Email:    strings.ToLower(email),
+		ClientID: clientID,
+		Category: category,
+		Reason:   reason,
+	}
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(suppression)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to create suppression: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+	return suppression, nil
+}
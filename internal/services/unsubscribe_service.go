@@ -0,0 +1,159 @@
+// internal/services/unsubscribe_service.go
+// 一鍵退訂服務 - 產生與驗證簽章退訂連結 (RFC 8058)，退訂時寫入抑制清單並記錄事件
+
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"mail-proxy/internal/config"
+	"mail-proxy/internal/models"
+)
+
+// unsubscribePath 退訂端點路徑 (公開路由，不需認證)
+const unsubscribePath = "/unsubscribe/"
+
+// ErrInvalidUnsubscribeToken 退訂連結格式錯誤或簽章不符
+var ErrInvalidUnsubscribeToken = errors.New("invalid unsubscribe token")
+
+// UnsubscribeClaims 退訂連結內容 (以 HMAC-SHA256 簽章，不設到期時間)
+type UnsubscribeClaims struct {
+	MailID   string `json:"m"`
+	Email    string `json:"e"`
+	ClientID string `json:"c"`
+	Category string `json:"g,omitempty"`
+}
+
+// UnsubscribeResult 退訂結果
+type UnsubscribeResult struct {
+	Claims  *UnsubscribeClaims
+	Created bool // false 表示先前已退訂
+}
+
+// suppressionStore 退訂所需的抑制清單操作 (由 SuppressionService 實作)
+type suppressionStore interface {
+	IsSuppressedForCategory(email, clientID, category string) (bool, error)
+	SuppressedRecipients(emails []string, clientID string) ([]string, error)
+	Suppress(email, clientID, category, reason string) (*models.Suppression, error)
+}
+
+// UnsubscribeService 一鍵退訂服務
+type UnsubscribeService struct {
+	baseURL      string
+	secret       []byte
+	suppressions suppressionStore
+	audit        *AuditService
+}
+
+// NewUnsubscribeService 建立一鍵退訂服務
+func NewUnsubscribeService(cfg *config.Config, suppressions *SuppressionService, audit *AuditService) *UnsubscribeService {
+	return &UnsubscribeService{
+		baseURL:      cfg.UnsubscribeBaseURL,
+		secret:       []byte(cfg.UnsubscribeSecret),
+		suppressions: suppressions,
+		audit:        audit,
+	}
+}
+
+// Enabled 是否已設定退訂連結網址與簽章金鑰
+func (s *UnsubscribeService) Enabled() bool {
+	return s != nil && s.baseURL != "" && len(s.secret) > 0
+}
+
+// URL 產生收件者的簽章退訂連結
+func (s *UnsubscribeService) URL(claims *UnsubscribeClaims) (string, error) {
+	if !s.Enabled() {
+		return "", errors.New("list unsubscribe is not configured")
+	}
+	claims.Email = strings.ToLower(claims.Email)
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal unsubscribe claims: %w", err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return s.baseURL + unsubscribePath + encoded + "." + s.sign(encoded), nil
+}
+
+// IsUnsubscribed 收件者是否已退訂 (含全域與該 Client 的抑制記錄)
+func (s *UnsubscribeService) IsUnsubscribed(email, clientID, category string) (bool, error) {
+	return s.suppressions.IsSuppressedForCategory(email, clientID, category)
+}
+
+// SuppressedRecipients 回傳已退訂該 Client 所有郵件 (或全域抑制) 的收件者
+func (s *UnsubscribeService) SuppressedRecipients(emails []string, clientID string) ([]string, error) {
+	return s.suppressions.SuppressedRecipients(emails, clientID)
+}
+
+// Verify 驗證退訂連結的 token 並取得內容
+func (s *UnsubscribeService) Verify(token string) (*UnsubscribeClaims, error) {
+	if !s.Enabled() {
+		return nil, ErrInvalidUnsubscribeToken
+	}
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(s.sign(encoded))) {
+		return nil, ErrInvalidUnsubscribeToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidUnsubscribeToken
+	}
+	var claims UnsubscribeClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Email == "" || claims.ClientID == "" {
+		return nil, ErrInvalidUnsubscribeToken
+	}
+	return &claims, nil
+}
+
+// Unsubscribe 驗證 token 後將收件者加入抑制清單，首次退訂時寫入稽核事件
+// 重複退訂不視為錯誤 (郵件服務商可能重送一鍵退訂請求)
+func (s *UnsubscribeService) Unsubscribe(token, sourceIP string) (*UnsubscribeResult, error) {
+	claims, err := s.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	suppression, err := s.suppressions.Suppress(claims.Email, claims.ClientID, claims.Category, models.SuppressionReasonUnsubscribe)
+	if err != nil {
+		return nil, err
+	}
+	result := &UnsubscribeResult{Claims: claims, Created: suppression != nil}
+	if suppression == nil {
+		return result, nil
+	}
+
+	log.Printf("Recipient %s unsubscribed (client=%s, category=%q, mail=%s)", claims.Email, claims.ClientID, claims.Category, claims.MailID)
+	if s.audit != nil {
+		event := &models.AuditEvent{
+			ActorClientID: claims.ClientID,
+			Action:        models.AuditActionUnsubscribe,
+			TargetType:    models.AuditTargetSuppression,
+			TargetID:      suppression.ID.String(),
+			Changes: s.audit.Diff(nil, map[string]interface{}{
+				"email":     claims.Email,
+				"client_id": claims.ClientID,
+				"category":  claims.Category,
+				"mail_id":   claims.MailID,
+				"reason":    models.SuppressionReasonUnsubscribe,
+			}),
+			SourceIP: sourceIP,
+		}
+		if err := s.audit.Record(event); err != nil {
+			log.Printf("Failed to record unsubscribe event for %s: %v", claims.Email, err)
+		}
+	}
+	return result, nil
+}
+
+// sign 計算 token 內容的簽章
+func (s *UnsubscribeService) sign(encoded string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
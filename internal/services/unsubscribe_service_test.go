@@ -0,0 +1,153 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"mail-proxy/internal/models"
+)
+
+// memorySuppressions 以記憶體模擬抑制清單 (同 email/client/category 只建立一次)
+type memorySuppressions struct {
+	entries map[string]*models.Suppression
+}
+
+func (m *memorySuppressions) IsSuppressedForCategory(email, clientID, category string) (bool, error) {
+	_, ok := m.entries[email+"|"+clientID+"|"+category]
+	return ok, nil
+}
+
+func (m *memorySuppressions) SuppressedRecipients(emails []string, clientID string) ([]string, error) {
+	return nil, nil
+}
+
+func (m *memorySuppressions) Suppress(email, clientID, category, reason string) (*models.Suppression, error) {
+	key := email + "|" + clientID + "|" + category
+	if _, ok := m.entries[key]; ok {
+		return nil, nil
+	}
+	suppression := &models.Suppression{ID: uuid.New(), Email: email, ClientID: clientID, Category: category, Reason: reason}
+	m.entries[key] = suppression
+	return suppression, nil
+}
+
+func newTestUnsubscribeService() (*UnsubscribeService, *memorySuppressions) {
+	store := &memorySuppressions{entries: map[string]*models.Suppression{}}
+	return &UnsubscribeService{
+		baseURL:      "https://mail.example.com",
+		secret:       []byte("test-secret"),
+		suppressions: store,
+	}, store
+}
+
+// unsubscribeToken 產生退訂連結並取出 token
+func unsubscribeToken(t *testing.T, s *UnsubscribeService, claims *UnsubscribeClaims) string {
+	t.Helper()
+	link, err := s.URL(claims)
+	if err != nil {
+		t.Fatalf("URL() error = %v", err)
+	}
+	token, ok := strings.CutPrefix(link, "https://mail.example.com"+unsubscribePath)
+	if !ok {
+		t.Fatalf("URL() = %q, want unsubscribe path", link)
+	}
+	return token
+}
+
+func TestUnsubscribeVerify(t *testing.T) {
+	s, _ := newTestUnsubscribeService()
+	token := unsubscribeToken(t, s, &UnsubscribeClaims{MailID: "mail-1", Email: "User@Example.com", ClientID: "client-1", Category: "news"})
+
+	claims, err := s.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	want := UnsubscribeClaims{MailID: "mail-1", Email: "user@example.com", ClientID: "client-1", Category: "news"}
+	if *claims != want {
+		t.Errorf("Verify() = %+v, want %+v", *claims, want)
+	}
+
+	encoded, signature, _ := strings.Cut(token, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"m":"mail-1","e":"victim@example.com","c":"client-1"}`))
+	otherSecret := &UnsubscribeService{baseURL: s.baseURL, secret: []byte("other-secret")}
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"m":"mail-1","e":"","c":"client-1"}`))
+	notJSON := base64.RawURLEncoding.EncodeToString([]byte("not json"))
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "tampered payload", token: forged + "." + signature},
+		{name: "tampered signature", token: encoded + "." + strings.Repeat("A", len(signature))},
+		{name: "truncated signature", token: encoded + "." + signature[:len(signature)-1]},
+		{name: "missing signature", token: encoded},
+		{name: "empty", token: ""},
+		{name: "signed with other secret", token: unsigned + "." + otherSecret.sign(unsigned)},
+		{name: "signed invalid base64", token: "!!!." + s.sign("!!!")},
+		{name: "signed non JSON", token: notJSON + "." + s.sign(notJSON)},
+		{name: "signed without email", token: unsigned + "." + s.sign(unsigned)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.Verify(tt.token); !errors.Is(err, ErrInvalidUnsubscribeToken) {
+				t.Fatalf("Verify() error = %v, want ErrInvalidUnsubscribeToken", err)
+			}
+		})
+	}
+}
+
+func TestUnsubscribeDisabled(t *testing.T) {
+	s, _ := newTestUnsubscribeService()
+	token := unsubscribeToken(t, s, &UnsubscribeClaims{Email: "user@example.com", ClientID: "client-1"})
+
+	s.secret = nil
+	if s.Enabled() {
+		t.Fatal("Enabled() = true without secret")
+	}
+	if _, err := s.URL(&UnsubscribeClaims{Email: "user@example.com", ClientID: "client-1"}); err == nil {
+		t.Error("URL() error = nil without secret")
+	}
+	if _, err := s.Verify(token); !errors.Is(err, ErrInvalidUnsubscribeToken) {
+		t.Errorf("Verify() error = %v, want ErrInvalidUnsubscribeToken", err)
+	}
+}
+
+func TestUnsubscribeRepeat(t *testing.T) {
+	s, store := newTestUnsubscribeService()
+	token := unsubscribeToken(t, s, &UnsubscribeClaims{MailID: "mail-1", Email: "user@example.com", ClientID: "client-1", Category: "news"})
+
+	first, err := s.Unsubscribe(token, "203.0.113.1")
+	if err != nil {
+		t.Fatalf("first Unsubscribe() error = %v", err)
+	}
+	if !first.Created {
+		t.Error("first Unsubscribe() Created = false, want true")
+	}
+	suppression := store.entries["user@example.com|client-1|news"]
+	if suppression == nil || suppression.Reason != models.SuppressionReasonUnsubscribe {
+		t.Fatalf("suppression = %+v, want unsubscribe entry", suppression)
+	}
+
+	// 郵件服務商重送一鍵退訂請求：不視為錯誤，也不重複建立
+	second, err := s.Unsubscribe(token, "203.0.113.1")
+	if err != nil {
+		t.Fatalf("repeat Unsubscribe() error = %v", err)
+	}
+	if second.Created {
+		t.Error("repeat Unsubscribe() Created = true, want false")
+	}
+	if second.Claims.Email != "user@example.com" || len(store.entries) != 1 {
+		t.Errorf("repeat Unsubscribe() claims = %+v, entries = %d", second.Claims, len(store.entries))
+	}
+
+	if _, err := s.Unsubscribe(token+"x", ""); !errors.Is(err, ErrInvalidUnsubscribeToken) {
+		t.Errorf("Unsubscribe() with tampered token error = %v, want ErrInvalidUnsubscribeToken", err)
+	}
+	if len(store.entries) != 1 {
+		t.Errorf("tampered token created suppression, entries = %d", len(store.entries))
+	}
+}
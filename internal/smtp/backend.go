@@ -5,6 +5,7 @@ package smtp
 
 import (
 	"log"
+	"net"
 
 	gosmtp "github.com/emersion/go-smtp"
 	"gorm.io/gorm"
@@ -20,15 +21,24 @@ type Backend struct {
 	db           *gorm.DB               // 資料庫連線
 	queueService *services.QueueService // RabbitMQ 佇列服務
 	keydbService *services.KeyDBService // KeyDB 快取服務
+
+	suppressionService *services.SuppressionService // 收件者抑制清單服務
+	rateLimiter        *services.RateLimiter        // Client / 收件者速率限制
+	resolver           DNSResolver                  // SPF / DKIM 使用的 DNS 查詢
+	lmtpNetworks       []*net.IPNet                 // LMTP tcp 連線允許的來源網段 (loopback 一律允許)
 }
 
 // NewBackend 建立 SMTP Backend
-func NewBackend(cfg *config.Config, db *gorm.DB, queueService *services.QueueService, keydbService *services.KeyDBService) *Backend {
+func NewBackend(cfg *config.Config, db *gorm.DB, queueService *services.QueueService, keydbService *services.KeyDBService, resolver DNSResolver) *Backend {
 	return &Backend{
 		cfg:          cfg,
 		db:           db,
 		queueService: queueService,
 		keydbService: keydbService,
+
+		suppressionService: services.NewSuppressionService(db),
+		rateLimiter:        services.NewRateLimiter(cfg, keydbService),
+		resolver:           resolver,
 	}
 }
 
@@ -37,5 +47,35 @@ func NewBackend(cfg *config.Config, db *gorm.DB, queueService *services.QueueSer
 func (b *Backend) NewSession(c *gosmtp.Conn) (gosmtp.Session, error) {
 	log.Printf("[SMTP] 新連線來自: %s", c.Hostname())
 
-	return NewSession(b.cfg, b.db, b.queueService, b.keydbService), nil
+	if c.Server().LMTP && !b.lmtpAllowed(c.Conn().RemoteAddr()) {
+		log.Printf("[SMTP] 拒絕 LMTP 連線: %s 不在允許的來源網段內", c.Conn().RemoteAddr())
+		return nil, &gosmtp.SMTPError{
+			Code:         554,
+			EnhancedCode: gosmtp.EnhancedCode{5, 7, 1},
+			Message:      "Connection not allowed",
+		}
+	}
+
+	session := NewSession(b.cfg, b.db, b.queueService, b.keydbService, b.suppressionService, b.resolver)
+	session.lmtp = c.Server().LMTP
+	session.rateLimiter = b.rateLimiter
+	session.helo = c.Hostname()
+	if addr, ok := c.Conn().RemoteAddr().(*net.TCPAddr); ok {
+		session.remoteIP = addr.IP
+	}
+	return session, nil
+}
+
+// lmtpAllowed LMTP 連線來源是否允許：unix socket 與 loopback 一律允許，其餘須在 LMTP_ALLOWED_NETWORKS 內
+func (b *Backend) lmtpAllowed(remote net.Addr) bool {
+	addr, ok := remote.(*net.TCPAddr)
+	if !ok || addr.IP.IsLoopback() {
+		return true
+	}
+	for _, network := range b.lmtpNetworks {
+		if network.Contains(addr.IP) {
+			return true
+		}
+	}
+	return false
 }
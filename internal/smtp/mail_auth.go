@@ -0,0 +1,260 @@
+// internal/smtp/mail_auth.go
+// 寄件者驗證 - SPF 與 DKIM 檢查，並產生 Authentication-Results
+
+package smtp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"blitiri.com.ar/go/spf"
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+	"github.com/emersion/go-message/textproto"
+	"github.com/emersion/go-msgauth/authres"
+	"github.com/emersion/go-msgauth/dkim"
+	gosmtp "github.com/emersion/go-smtp"
+	"golang.org/x/net/publicsuffix"
+)
+
+// DNSResolver SPF 與 DKIM 共用的 DNS 查詢介面
+// 預設使用 net.DefaultResolver，可替換為離線測試用的實作
+type DNSResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+// 驗證結果處理策略
+const (
+	AuthPolicyOff    = "off"    // 不檢查
+	AuthPolicyAccept = "accept" // 檢查並記錄結果
+	AuthPolicyTag    = "tag"    // 檢查失敗時在主旨加上標記
+	AuthPolicyReject = "reject" // 檢查失敗時拒收
+)
+
+// dnsTimeout 單次 SPF / DKIM 檢查的 DNS 查詢逾時
+const dnsTimeout = 10 * time.Second
+
+// checkSPF 依連線 IP、HELO 與 MAIL FROM 評估 SPF (RFC 7208)
+func checkSPF(resolver DNSResolver, ip net.IP, helo, from string) *authres.SPFResult {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+	defer cancel()
+
+	result, err := spf.CheckHostWithSender(ip, helo, from, spf.WithResolver(resolver), spf.WithContext(ctx))
+
+	res := &authres.SPFResult{
+		Value: authres.ResultValue(result),
+		From:  from,
+		Helo:  helo,
+	}
+	if err != nil {
+		res.Reason = err.Error()
+	}
+	return res
+}
+
+// checkDKIM 驗證郵件中所有 DKIM-Signature (RFC 6376)
+// 沒有任何簽章時回傳單一 dkim=none 結果
+func checkDKIM(resolver DNSResolver, r io.Reader) ([]*authres.DKIMResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+	defer cancel()
+
+	verifications, err := dkim.VerifyWithOptions(r, &dkim.VerifyOptions{
+		LookupTXT: func(domain string) ([]string, error) {
+			return resolver.LookupTXT(ctx, domain)
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify DKIM signatures: %w", err)
+	}
+
+	if len(verifications) == 0 {
+		return []*authres.DKIMResult{{Value: authres.ResultNone}}, nil
+	}
+
+	results := make([]*authres.DKIMResult, 0, len(verifications))
+	for _, v := range verifications {
+		res := &authres.DKIMResult{
+			Value:      authres.ResultPass,
+			Domain:     v.Domain,
+			Identifier: v.Identifier,
+		}
+		switch {
+		case v.Err == nil:
+		case dkim.IsTempFail(v.Err):
+			res.Value = authres.ResultTempError
+			res.Reason = v.Err.Error()
+		case dkim.IsPermFail(v.Err):
+			res.Value = authres.ResultPermError
+			res.Reason = v.Err.Error()
+		default:
+			res.Value = authres.ResultFail
+			res.Reason = v.Err.Error()
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// spfFailed 判斷 SPF 結果是否應套用策略
+// 只有 fail 視為驗證失敗；softfail / neutral 等僅記錄
+func spfFailed(res *authres.SPFResult) bool {
+	return res != nil && res.Value == authres.ResultFail
+}
+
+// dkimFailed 判斷 DKIM 結果是否應套用策略
+// 需有通過驗證且 d= 與標頭 From 網域對齊 (relaxed) 的簽章；沒有簽章或只有其他網域的簽章皆視為失敗
+func dkimFailed(results []*authres.DKIMResult, fromDomain string) bool {
+	for _, res := range results {
+		if res.Value == authres.ResultPass && dkimAligned(res.Domain, fromDomain) {
+			return false
+		}
+	}
+	return true
+}
+
+// dkimAligned 簽章網域與 From 網域是否 relaxed 對齊 (組織網域相同，RFC 7489 §3.1.1)
+func dkimAligned(signingDomain, fromDomain string) bool {
+	signingDomain = strings.ToLower(strings.TrimSuffix(signingDomain, "."))
+	fromDomain = strings.ToLower(strings.TrimSuffix(fromDomain, "."))
+	if signingDomain == "" || fromDomain == "" {
+		return false
+	}
+	if signingDomain == fromDomain {
+		return true
+	}
+	signingOrg, err := publicsuffix.EffectiveTLDPlusOne(signingDomain)
+	if err != nil {
+		return false
+	}
+	fromOrg, err := publicsuffix.EffectiveTLDPlusOne(fromDomain)
+	if err != nil {
+		return false
+	}
+	return signingOrg == fromOrg
+}
+
+// headerFromDomain 讀取郵件標頭 From 的網域 (無法解析時回傳空白)
+func headerFromDomain(r *bufio.Reader) string {
+	h, err := textproto.ReadHeader(r)
+	if err != nil {
+		return ""
+	}
+	header := mail.Header{Header: message.Header{Header: h}}
+	addrs, err := header.AddressList("From")
+	if err != nil || len(addrs) == 0 {
+		return ""
+	}
+	_, domain, _ := strings.Cut(addrs[0].Address, "@")
+	return domain
+}
+
+// spfRejectError SPF 驗證失敗的拒收回應 (RFC 7372)
+func spfRejectError(res *authres.SPFResult) *gosmtp.SMTPError {
+	return &gosmtp.SMTPError{
+		Code:         550,
+		EnhancedCode: gosmtp.EnhancedCode{5, 7, 23},
+		Message:      fmt.Sprintf("SPF validation failed for %s", res.From),
+	}
+}
+
+// dkimRejectError DKIM 驗證失敗的拒收回應 (RFC 7372)
+func dkimRejectError() *gosmtp.SMTPError {
+	return &gosmtp.SMTPError{
+		Code:         550,
+		EnhancedCode: gosmtp.EnhancedCode{5, 7, 20},
+		Message:      "No passing DKIM signature found",
+	}
+}
+
+// formatAuthResults 產生 Authentication-Results 標頭值 (RFC 8601)
+// authServID 使用 SMTP_HOSTNAME，與 Server Domain 一致
+func formatAuthResults(authServID string, spfResult *authres.SPFResult, dkimResults []*authres.DKIMResult) string {
+	var results []authres.Result
+	if spfResult != nil {
+		results = append(results, spfResult)
+	}
+	for _, res := range dkimResults {
+		results = append(results, res)
+	}
+	return authres.Format(authServID, results)
+}
+
+// rewriteAuthHeaders 改寫暫存郵件的 Authentication-Results 與 Subject 標頭
+// 先移除所有宣稱為本機 authserv-id 的標頭 (避免寄件端偽造驗證結果，RFC 8601 §5)，
+// value 非空白時再於標頭最前方加上本機的驗證結果；subjectTag 非空白時加在主旨前 (tag 策略，
+// 原始直送模式也會帶上標記)；其餘標頭與內文原樣保留
+func rewriteAuthHeaders(spoolPath, authServID, value, subjectTag string) error {
+	f, err := os.Open(spoolPath)
+	if err != nil {
+		return fmt.Errorf("failed to open spooled message: %w", err)
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	header, err := textproto.ReadHeader(br)
+	if err != nil {
+		return fmt.Errorf("failed to read message header: %w", err)
+	}
+
+	fields := header.FieldsByKey("Authentication-Results")
+	for fields.Next() {
+		if strings.EqualFold(authResultsServID(fields.Value()), authServID) {
+			fields.Del()
+		}
+	}
+	if subjectTag != "" {
+		subject := header.Get("Subject")
+		if subject != "" {
+			subject = subjectTag + " " + subject
+		} else {
+			subject = subjectTag
+		}
+		header.Set("Subject", subject)
+	}
+	if value != "" {
+		header.Add("Authentication-Results", value)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(spoolPath), filepath.Base(spoolPath)+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to create spool file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // 成功時已 rename，此處為失敗時的清理
+
+	w := bufio.NewWriter(tmp)
+	if err := textproto.WriteHeader(w, header); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write message header: %w", err)
+	}
+	if _, err := io.Copy(w, br); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write message body: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write spool file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write spool file: %w", err)
+	}
+	return os.Rename(tmp.Name(), spoolPath)
+}
+
+// authResultsServID 取出 Authentication-Results 標頭值開頭的 authserv-id
+func authResultsServID(value string) string {
+	id, _, _ := strings.Cut(value, ";")
+	if fields := strings.Fields(id); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
@@ -0,0 +1,156 @@
+package smtp
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+	"github.com/emersion/go-msgauth/authres"
+)
+
+func TestRewriteAuthHeaders(t *testing.T) {
+	raw := "Authentication-Results: MX.Example.COM; spf=pass smtp.mailfrom=forged@example.com\r\n" +
+		"Authentication-Results: upstream.example.net; dkim=pass header.d=example.net\r\n" +
+		"DKIM-Signature: v=1; a=rsa-sha256; d=example.net; s=sel;\r\n" +
+		"\tb=abc\r\n" +
+		"Subject: Hello\r\n" +
+		"\r\n" +
+		"Body line\r\n"
+	path := filepath.Join(t.TempDir(), "spool.eml")
+	if err := os.WriteFile(path, []byte(raw), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	value := formatAuthResults("mx.example.com", &authres.SPFResult{Value: authres.ResultFail, From: "sender@example.org"}, nil)
+	if err := rewriteAuthHeaders(path, "mx.example.com", value, ""); err != nil {
+		t.Fatalf("rewriteAuthHeaders() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(data)
+	if !strings.HasPrefix(got, "Authentication-Results: mx.example.com;") || !strings.Contains(got, "spf=fail") {
+		t.Fatalf("own Authentication-Results was not prepended:\n%s", got)
+	}
+	if strings.Contains(got, "forged@example.com") {
+		t.Fatalf("forged Authentication-Results was kept:\n%s", got)
+	}
+	// 其他 authserv-id 的結果、既有簽章與內文原樣保留
+	for _, want := range []string{
+		"Authentication-Results: upstream.example.net; dkim=pass header.d=example.net\r\n",
+		"DKIM-Signature: v=1; a=rsa-sha256; d=example.net; s=sel;\r\n\tb=abc\r\n",
+		"\r\n\r\nBody line\r\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("missing %q in:\n%s", want, got)
+		}
+	}
+}
+
+func TestRewriteAuthHeadersWithoutChecks(t *testing.T) {
+	raw := "Authentication-Results: mx.example.com 1; dkim=pass\r\nSubject: Hello\r\n\r\nBody\r\n"
+	path := filepath.Join(t.TempDir(), "spool.eml")
+	if err := os.WriteFile(path, []byte(raw), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// 未做任何檢查時仍移除偽造的標頭
+	if err := rewriteAuthHeaders(path, "mx.example.com", "", ""); err != nil {
+		t.Fatalf("rewriteAuthHeaders() error = %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != "Subject: Hello\r\n\r\nBody\r\n" {
+		t.Fatalf("rewritten message = %q", got)
+	}
+}
+
+func TestDKIMFailed(t *testing.T) {
+	pass := func(domain string) *authres.DKIMResult {
+		return &authres.DKIMResult{Value: authres.ResultPass, Domain: domain}
+	}
+	tests := []struct {
+		name       string
+		results    []*authres.DKIMResult
+		fromDomain string
+		want       bool
+	}{
+		{"unsigned", []*authres.DKIMResult{{Value: authres.ResultNone}}, "example.com", true},
+		{"no results", nil, "example.com", true},
+		{"aligned pass", []*authres.DKIMResult{pass("example.com")}, "example.com", false},
+		{"case insensitive", []*authres.DKIMResult{pass("Example.COM")}, "example.com", false},
+		{"relaxed subdomain From", []*authres.DKIMResult{pass("example.com")}, "mail.example.com", false},
+		{"relaxed subdomain signer", []*authres.DKIMResult{pass("bounce.example.com")}, "example.com", false},
+		{"unrelated pass", []*authres.DKIMResult{pass("attacker.test")}, "example.com", true},
+		{"public suffix only", []*authres.DKIMResult{pass("other.co.uk")}, "example.co.uk", true},
+		{"aligned fail", []*authres.DKIMResult{{Value: authres.ResultFail, Domain: "example.com"}}, "example.com", true},
+		{"one aligned pass among failures", []*authres.DKIMResult{{Value: authres.ResultFail, Domain: "example.com"}, pass("example.com")}, "example.com", false},
+		{"missing From", []*authres.DKIMResult{pass("example.com")}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dkimFailed(tt.results, tt.fromDomain); got != tt.want {
+				t.Errorf("dkimFailed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeaderFromDomain(t *testing.T) {
+	raw := "From: \"Alice\" <alice@Mail.Example.com>\r\nSubject: Hi\r\n\r\nBody\r\n"
+	if got := headerFromDomain(bufio.NewReader(strings.NewReader(raw))); got != "Mail.Example.com" {
+		t.Fatalf("headerFromDomain() = %q", got)
+	}
+	if got := headerFromDomain(bufio.NewReader(strings.NewReader("Subject: Hi\r\n\r\n"))); got != "" {
+		t.Fatalf("headerFromDomain() without From = %q", got)
+	}
+}
+
+func TestRewriteAuthHeadersSubjectTag(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"plain", "Subject: Invoice\r\n\r\nBody\r\n", "[SUSPICIOUS] Invoice"},
+		{"encoded", "Subject: =?UTF-8?B?5pyI5aCx?=\r\n\r\nBody\r\n", "[SUSPICIOUS] 月報"},
+		{"missing", "From: a@example.com\r\n\r\nBody\r\n", "[SUSPICIOUS]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "spool.eml")
+			if err := os.WriteFile(path, []byte(tt.raw), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := rewriteAuthHeaders(path, "mx.example.com", "mx.example.com; spf=fail", "[SUSPICIOUS]"); err != nil {
+				t.Fatalf("rewriteAuthHeaders() error = %v", err)
+			}
+
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			entity, err := message.Read(bufio.NewReader(f))
+			if err != nil {
+				t.Fatalf("message.Read() error = %v", err)
+			}
+			header := mail.Header{Header: entity.Header}
+			if subject, _ := header.Subject(); subject != tt.want {
+				t.Fatalf("subject = %q, want %q", subject, tt.want)
+			}
+			if body, _ := io.ReadAll(entity.Body); string(body) != "Body\r\n" {
+				t.Fatalf("body = %q", body)
+			}
+		})
+	}
+}
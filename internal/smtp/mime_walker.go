@@ -0,0 +1,291 @@
+// internal/smtp/mime_walker.go
+// MIME 結構走訪 - 字集轉換、RFC 2047 解碼與 multipart 內文選擇
+
+package smtp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"strings"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/charset" // 匯入即註冊 message.CharsetReader (Big5、GB2312、ISO-2022-JP、Shift_JIS 等)
+
+	"mail-proxy/internal/models"
+)
+
+// mimeWordDecoder RFC 2047 encoded-word 解碼器（支援所有已註冊字集）
+var mimeWordDecoder = &mime.WordDecoder{CharsetReader: charset.Reader}
+
+// maxInlineBodyBytes 單一內文部分保留於記憶體的上限
+// 超過此大小的 text/plain、text/html 改以附件方式串流寫入磁碟，確保每個 Session 的記憶體用量有上限
+const maxInlineBodyBytes = 2 * 1024 * 1024
+
+// attachmentStore 附件串流寫入函式，回傳儲存路徑與大小
+type attachmentStore func(filename string, r io.Reader) (storagePath string, size int64, err error)
+
+// mimeAttachment 走訪取得的附件（內容已寫入磁碟）
+type mimeAttachment struct {
+	Filename    string
+	ContentType string
+	SizeBytes   int64
+	StoragePath string
+	ContentID   string // 內嵌部分的 Content-ID (不含角括號)
+}
+
+// mimeContent MIME 走訪結果（內文皆已轉為 UTF-8）
+type mimeContent struct {
+	Text        string
+	HTML        string
+	Attachments []mimeAttachment
+
+	store attachmentStore
+}
+
+// walkMIME 遞迴走訪整封郵件的 MIME 結構
+// 附件在走訪過程中直接透過 store 寫入最終儲存位置，不保留於記憶體
+func walkMIME(entity *message.Entity, store attachmentStore) (*mimeContent, error) {
+	content := &mimeContent{store: store}
+	if err := content.walk(entity); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// walk 依 Content-Type 分派處理
+func (c *mimeContent) walk(entity *message.Entity) error {
+	mediaType, params, _ := entity.Header.ContentType()
+
+	mr := entity.MultipartReader()
+	if mr == nil {
+		return c.walkLeaf(entity, mediaType, params)
+	}
+
+	switch mediaType {
+	case "multipart/alternative":
+		return c.walkAlternative(mr)
+	case "multipart/related":
+		return c.walkRelated(mr)
+	default:
+		// multipart/mixed 及其他未知 multipart 一律逐一走訪
+		return c.walkParts(mr, c.walk)
+	}
+}
+
+// walkParts 逐一走訪 multipart 子部分
+func (c *mimeContent) walkParts(mr message.MultipartReader, fn func(*message.Entity) error) error {
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+			return fmt.Errorf("failed to read MIME part: %w", err)
+		}
+		if err != nil {
+			log.Printf("[SMTP] MIME 部分字集或編碼無法辨識，保留原始內容: %v", err)
+		}
+
+		if err := fn(part); err != nil {
+			return err
+		}
+	}
+}
+
+// walkAlternative 處理 multipart/alternative
+// RFC 2046 §5.1.4：越後面的替代版本越貼近原意，因此各類型取最後一個有內容的版本
+func (c *mimeContent) walkAlternative(mr message.MultipartReader) error {
+	var text, html string
+	var attachments []mimeAttachment
+
+	err := c.walkParts(mr, func(part *message.Entity) error {
+		alt := &mimeContent{store: c.store}
+		if err := alt.walk(part); err != nil {
+			return err
+		}
+		if alt.Text != "" {
+			text = alt.Text
+		}
+		if alt.HTML != "" {
+			html = alt.HTML
+		}
+		// 替代版本內的附件（例如 multipart/related 的內嵌圖片、text/calendar）全部保留
+		attachments = append(attachments, alt.Attachments...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	c.appendText(text)
+	c.appendHTML(html)
+	c.Attachments = append(c.Attachments, attachments...)
+	return nil
+}
+
+// walkRelated 處理 multipart/related
+// 第一個部分為主體，其餘部分（內嵌圖片、樣式表等）視為附件
+func (c *mimeContent) walkRelated(mr message.MultipartReader) error {
+	root := true
+	return c.walkParts(mr, func(part *message.Entity) error {
+		if root {
+			root = false
+			return c.walk(part)
+		}
+		mediaType, params, _ := part.Header.ContentType()
+		return c.addAttachment(part, mediaType, params)
+	})
+}
+
+// walkLeaf 處理非 multipart 的單一部分
+func (c *mimeContent) walkLeaf(entity *message.Entity, mediaType string, params map[string]string) error {
+	disposition, _, _ := entity.Header.ContentDisposition()
+	isBody := (mediaType == "text/plain" || mediaType == "text/html") &&
+		!strings.EqualFold(disposition, "attachment") &&
+		partFilename(entity.Header, params) == ""
+
+	if !isBody {
+		return c.addAttachment(entity, mediaType, params)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(entity.Body, maxInlineBodyBytes+1))
+	if err != nil {
+		return fmt.Errorf("failed to read %s part: %w", mediaType, err)
+	}
+
+	// 內文過大，改為附件串流寫入磁碟
+	if len(content) > maxInlineBodyBytes {
+		ext := ".txt"
+		if mediaType == "text/html" {
+			ext = ".html"
+		}
+		filename := fmt.Sprintf("body_%d%s", len(c.Attachments)+1, ext)
+		log.Printf("[SMTP] 內文超過 %d bytes，改存為附件: %s", maxInlineBodyBytes, filename)
+		return c.storeAttachment(filename, mediaType, "", io.MultiReader(bytes.NewReader(content), entity.Body))
+	}
+
+	if mediaType == "text/html" {
+		c.appendHTML(string(content))
+	} else {
+		c.appendText(string(content))
+	}
+	return nil
+}
+
+// addAttachment 取得附件檔名並串流寫入磁碟
+// 非 attachment disposition 且帶有合法 Content-ID 的部分保留為內嵌附件 (HTML 以 cid: 參照)
+func (c *mimeContent) addAttachment(entity *message.Entity, mediaType string, params map[string]string) error {
+	filename := attachmentFilename(entity.Header, mediaType, params, len(c.Attachments)+1)
+
+	var contentID string
+	disposition, _, _ := entity.Header.ContentDisposition()
+	if cid := entity.Header.Get("Content-Id"); cid != "" && !strings.EqualFold(disposition, "attachment") {
+		contentID, _ = models.NormalizeContentID(cid)
+	}
+	return c.storeAttachment(filename, mediaType, contentID, entity.Body)
+}
+
+// storeAttachment 透過 store 寫入附件並加入結果
+// 單一附件寫入失敗只記錄日誌，不中斷整封郵件的接收
+func (c *mimeContent) storeAttachment(filename, mediaType, contentID string, r io.Reader) error {
+	storagePath, size, err := c.store(filename, r)
+	if err != nil {
+		log.Printf("[SMTP] 儲存附件失敗 %s: %v", filename, err)
+		// 讀完剩餘內容，讓 multipart reader 可以繼續下一個部分
+		io.Copy(io.Discard, r)
+		return nil
+	}
+
+	log.Printf("[SMTP] 儲存附件: %s (%s, %d bytes) -> %s", filename, mediaType, size, storagePath)
+	c.Attachments = append(c.Attachments, mimeAttachment{
+		Filename:    filename,
+		ContentType: mediaType,
+		SizeBytes:   size,
+		StoragePath: storagePath,
+		ContentID:   contentID,
+	})
+	return nil
+}
+
+// appendText 加入純文字內文（multipart/mixed 中的多段內文依序串接）
+func (c *mimeContent) appendText(text string) {
+	if text == "" {
+		return
+	}
+	if c.Text != "" {
+		c.Text += "\n"
+	}
+	c.Text += text
+}
+
+// appendHTML 加入 HTML 內文
+func (c *mimeContent) appendHTML(html string) {
+	if html == "" {
+		return
+	}
+	if c.HTML != "" {
+		c.HTML += "\n"
+	}
+	c.HTML += html
+}
+
+// partFilename 取得部分的原始檔名（未解碼）
+// 依序嘗試 Content-Disposition filename、Content-Type name、X-Attachment-Name
+func partFilename(h message.Header, params map[string]string) string {
+	if _, dispParams, err := h.ContentDisposition(); err == nil {
+		if filename := dispParams["filename"]; filename != "" {
+			return filename
+		}
+	}
+
+	// Content-Disposition 格式不合法時 (例如未加引號的 encoded-word)，手動解析 filename 參數
+	if disp := h.Get("Content-Disposition"); disp != "" {
+		for _, param := range strings.Split(disp, ";") {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(strings.ToLower(param), "filename=") {
+				if filename := strings.Trim(param[len("filename="):], `"`); filename != "" {
+					return filename
+				}
+			}
+		}
+	}
+
+	if name := params["name"]; name != "" {
+		return name
+	}
+
+	return h.Get("X-Attachment-Name")
+}
+
+// attachmentFilename 取得附件檔名並解碼 RFC 2047 encoded-word
+// 若完全沒有檔名，依 Content-Type 產生預設檔名
+func attachmentFilename(h message.Header, mediaType string, params map[string]string, index int) string {
+	filename := partFilename(h, params)
+	if filename != "" {
+		if decoded, err := mimeWordDecoder.DecodeHeader(filename); err == nil {
+			return decoded
+		}
+		return filename
+	}
+
+	ext := ".bin"
+	switch {
+	case mediaType == "message/rfc822":
+		ext = ".eml"
+	case strings.HasPrefix(mediaType, "image/"):
+		ext = "." + strings.TrimPrefix(mediaType, "image/")
+	case mediaType == "application/pdf":
+		ext = ".pdf"
+	case mediaType == "text/calendar":
+		ext = ".ics"
+	case strings.HasPrefix(mediaType, "text/"):
+		ext = ".txt"
+	}
+
+	filename = fmt.Sprintf("attachment_%d%s", index, ext)
+	log.Printf("[SMTP] 無法取得附件檔名，使用預設檔名: %s", filename)
+	return filename
+}
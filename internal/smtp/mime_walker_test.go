@@ -0,0 +1,155 @@
+package smtp
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+)
+
+// tempAttachmentStore 將附件寫入測試暫存目錄
+func tempAttachmentStore(t *testing.T) attachmentStore {
+	dir := t.TempDir()
+	return func(filename string, r io.Reader) (string, int64, error) {
+		f, err := os.CreateTemp(dir, "attachment-*")
+		if err != nil {
+			return "", 0, err
+		}
+		defer f.Close()
+		size, err := io.Copy(f, r)
+		return f.Name(), size, err
+	}
+}
+
+func TestWalkMIMEFixtures(t *testing.T) {
+	type wantAttachment struct {
+		filename  string
+		mediaType string
+		contentID string
+		content   string // 空白時不比對內容
+	}
+	tests := []struct {
+		file        string
+		subject     string
+		text        string
+		html        string
+		attachments []wantAttachment
+	}{
+		{
+			file:    "big5_related_in_alternative.eml",
+			subject: "月報表通知",
+			text:    "您好，這是繁體中文測試郵件。\r\n請參閱附件報表。\r\n",
+			html:    "<html><body><p>您好，這是<b>繁體中文</b>測試郵件。</p><img src=\"cid:logo@legacy\"></body></html>\r\n",
+			attachments: []wantAttachment{
+				{filename: "attachment_1.png", mediaType: "image/png", contentID: "logo@legacy"},
+				{filename: "月報表.xls", mediaType: "application/vnd.ms-excel", content: "fake-xls-content"},
+			},
+		},
+		{
+			file:    "gb2312_base64.eml",
+			subject: "简体中文主题",
+			text:    "您好，这是简体中文测试邮件。\n",
+		},
+		{
+			file:    "iso2022jp_with_sjis_attachment.eml",
+			subject: "会議のお知らせ",
+			text:    "こんにちは。これは日本語のテストです。\r\n",
+			attachments: []wantAttachment{
+				{filename: "議事録.txt", mediaType: "text/plain", content: "議事録の内容です。"},
+			},
+		},
+		{
+			// alternative 的 text 與 html 各自保留，不互相覆蓋
+			file:    "alternative_order.eml",
+			subject: "Alternative order",
+			text:    "Plain version",
+			html:    "<p>HTML version</p>",
+		},
+		{
+			// 未知字集保留原始內文
+			file:    "unknown_charset.eml",
+			subject: "Unknown charset",
+			text:    "Body kept as-is when the charset cannot be decoded.\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			entity, err := message.Read(bufio.NewReader(f))
+			if err != nil && !message.IsUnknownCharset(err) {
+				t.Fatalf("message.Read() error = %v", err)
+			}
+			header := mail.Header{Header: entity.Header}
+			if subject, _ := header.Subject(); subject != tt.subject {
+				t.Errorf("subject = %q, want %q", subject, tt.subject)
+			}
+
+			content, err := walkMIME(entity, tempAttachmentStore(t))
+			if err != nil {
+				t.Fatalf("walkMIME() error = %v", err)
+			}
+			if content.Text != tt.text {
+				t.Errorf("text = %q, want %q", content.Text, tt.text)
+			}
+			if content.HTML != tt.html {
+				t.Errorf("html = %q, want %q", content.HTML, tt.html)
+			}
+
+			if len(content.Attachments) != len(tt.attachments) {
+				t.Fatalf("attachments = %+v, want %d", content.Attachments, len(tt.attachments))
+			}
+			for i, want := range tt.attachments {
+				got := content.Attachments[i]
+				if got.Filename != want.filename || got.ContentType != want.mediaType || got.ContentID != want.contentID {
+					t.Errorf("attachment %d = %+v, want %+v", i, got, want)
+				}
+				stored, err := os.ReadFile(got.StoragePath)
+				if err != nil {
+					t.Fatalf("failed to read stored attachment: %v", err)
+				}
+				if int64(len(stored)) != got.SizeBytes {
+					t.Errorf("attachment %d size = %d, stored %d bytes", i, got.SizeBytes, len(stored))
+				}
+				if want.content != "" && string(stored) != want.content {
+					t.Errorf("attachment %d content = %q, want %q", i, stored, want.content)
+				}
+			}
+		})
+	}
+}
+
+func TestWalkMIMEInlineImageIsDecoded(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "big5_related_in_alternative.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	entity, err := message.Read(bufio.NewReader(f))
+	if err != nil {
+		t.Fatalf("message.Read() error = %v", err)
+	}
+	content, err := walkMIME(entity, tempAttachmentStore(t))
+	if err != nil {
+		t.Fatalf("walkMIME() error = %v", err)
+	}
+
+	png, err := os.ReadFile(content.Attachments[0].StoragePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(png), "\x89PNG\r\n\x1a\n") {
+		t.Fatalf("inline image was not base64-decoded: %q", png)
+	}
+}
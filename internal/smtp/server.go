@@ -4,8 +4,13 @@
 package smtp
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	gosmtp "github.com/emersion/go-smtp"
@@ -22,6 +27,8 @@ type Server struct {
 	queueService *services.QueueService
 	keydbService *services.KeyDBService
 	smtpServer   *gosmtp.Server
+	lmtpServer   *gosmtp.Server
+	resolver     DNSResolver
 }
 
 // NewServer 建立 SMTP 伺服器
@@ -31,27 +38,36 @@ func NewServer(cfg *config.Config, db *gorm.DB, queueService *services.QueueServ
 		db:           db,
 		queueService: queueService,
 		keydbService: keydbService,
+		resolver:     net.DefaultResolver,
 	}
 }
 
+// SetDNSResolver 替換 SPF / DKIM 使用的 DNS 查詢（需在 Start 之前呼叫）
+func (s *Server) SetDNSResolver(resolver DNSResolver) {
+	s.resolver = resolver
+}
+
 // Start 啟動 SMTP 伺服器
 func (s *Server) Start() error {
 	// 建立 Backend
-	backend := NewBackend(s.cfg, s.db, s.queueService, s.keydbService)
+	backend := NewBackend(s.cfg, s.db, s.queueService, s.keydbService, s.resolver)
 
 	// 設定 SMTP 伺服器
-	s.smtpServer = gosmtp.NewServer(backend)
+	s.smtpServer = s.newServer(backend)
 	s.smtpServer.Addr = fmt.Sprintf(":%s", s.cfg.SMTPInboundPort)
-	s.smtpServer.Domain = "mail-proxy.local"
-	s.smtpServer.ReadTimeout = 30 * time.Second
-	s.smtpServer.WriteTimeout = 30 * time.Second
-	s.smtpServer.MaxMessageBytes = int64(s.cfg.SMTPMaxMessageSize) * 1024 * 1024
-	s.smtpServer.MaxRecipients = 50
 	s.smtpServer.AllowInsecureAuth = true // 開發環境允許，生產環境應使用 TLS
 
 	log.Printf("[SMTP] 伺服器啟動中... 監聽埠號: %s", s.cfg.SMTPInboundPort)
 	log.Printf("[SMTP] 認證需求: %v", s.cfg.SMTPAuthRequired)
+	if s.cfg.SMTPAuthRequired && s.cfg.SMTPAuthPassword == "" {
+		// 舊版以 JWT_SECRET 作為共用密碼，升級後未設定 SMTP_AUTH_PASSWORD 會讓這些寄件端全部認證失敗
+		log.Printf("[SMTP] 警告: SMTP_AUTH_REQUIRED=true 但未設定 SMTP_AUTH_PASSWORD，只接受 client_id + Client Token 認證；" +
+			"舊版以 JWT_SECRET 作為 SMTP 密碼的寄件端將無法認證，如需相容請將 SMTP_AUTH_PASSWORD 設為原 JWT_SECRET 值")
+	}
 	log.Printf("[SMTP] 最大訊息大小: %d MB", s.cfg.SMTPMaxMessageSize)
+	log.Printf("[SMTP] 最大收件者數: %d", s.cfg.SMTPMaxRecipients)
+
+	log.Printf("[SMTP] SPF 策略: %s, DKIM 策略: %s", s.cfg.SMTPSPFPolicy, s.cfg.SMTPDKIMPolicy)
 
 	if len(s.cfg.SMTPAllowedDomains) > 0 {
 		log.Printf("[SMTP] 允許的寄件網域: %v", s.cfg.SMTPAllowedDomains)
@@ -59,16 +75,130 @@ func (s *Server) Start() error {
 		log.Printf("[SMTP] 允許所有寄件網域")
 	}
 
-	// 啟動伺服器（阻塞式）
-	if err := s.smtpServer.ListenAndServe(); err != nil {
-		return fmt.Errorf("SMTP server error: %w", err)
+	errCh := make(chan error, 2)
+
+	// 啟動 LMTP 監聽
+	if s.cfg.LMTPEnabled {
+		if err := s.startLMTP(backend, errCh); err != nil {
+			return err
+		}
 	}
 
+	// 啟動伺服器（阻塞式，任一監聽結束即返回）
+	go func() {
+		if err := s.smtpServer.ListenAndServe(); err != nil {
+			errCh <- fmt.Errorf("SMTP server error: %w", err)
+			return
+		}
+		errCh <- nil
+	}()
+
+	return <-errCh
+}
+
+// startLMTP 啟動 LMTP 監聽
+// 供既有 MTA (例如 Postfix) 作為最終投遞傳輸使用，DATA 後逐一回報每個收件者的狀態
+func (s *Server) startLMTP(backend *Backend, errCh chan<- error) error {
+	s.lmtpServer = s.newServer(backend)
+	s.lmtpServer.LMTP = true
+	s.lmtpServer.Network = s.cfg.LMTPNetwork
+	s.lmtpServer.Addr = s.cfg.LMTPAddr
+
+	// LMTP 不需 AUTH，tcp 監聽只允許 loopback 或明確列出的來源網段
+	networks, err := parseNetworks(s.cfg.LMTPAllowedNetworks)
+	if err != nil {
+		return fmt.Errorf("invalid LMTP_ALLOWED_NETWORKS: %w", err)
+	}
+	if s.cfg.LMTPNetwork != "unix" && len(networks) == 0 && !isLoopbackAddr(s.cfg.LMTPAddr) {
+		return fmt.Errorf("LMTP over %s must listen on a loopback address or set LMTP_ALLOWED_NETWORKS", s.cfg.LMTPNetwork)
+	}
+	backend.lmtpNetworks = networks
+
+	if s.cfg.LMTPNetwork == "unix" {
+		if err := os.MkdirAll(filepath.Dir(s.cfg.LMTPAddr), 0755); err != nil {
+			return fmt.Errorf("failed to create LMTP socket directory: %w", err)
+		}
+		// 移除上次未正常關閉留下的 socket 檔案
+		if err := os.Remove(s.cfg.LMTPAddr); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove stale LMTP socket: %w", err)
+		}
+	}
+
+	log.Printf("[SMTP] LMTP 監聽中... %s:%s", s.cfg.LMTPNetwork, s.cfg.LMTPAddr)
+
+	go func() {
+		if err := s.lmtpServer.ListenAndServe(); err != nil {
+			errCh <- fmt.Errorf("LMTP server error: %w", err)
+		}
+	}()
 	return nil
 }
 
+// parseNetworks 解析 CIDR 或單一 IP 清單
+func parseNetworks(values []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", value)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// isLoopbackAddr 監聽位址 (host:port) 是否為 loopback
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// newServer 建立套用共用設定的 go-smtp 伺服器
+func (s *Server) newServer(backend *Backend) *gosmtp.Server {
+	server := gosmtp.NewServer(backend)
+	server.Domain = s.cfg.SMTPHostname
+	server.ReadTimeout = 30 * time.Second
+	server.WriteTimeout = 30 * time.Second
+	server.MaxMessageBytes = int64(s.cfg.SMTPMaxMessageSize) * 1024 * 1024
+	server.MaxRecipients = s.cfg.SMTPMaxRecipients
+	// CHUNKING (BDAT) 由 go-smtp 預設提供
+	server.EnableSMTPUTF8 = true
+	server.EnableBINARYMIME = true
+	server.EnableDSN = true
+	return server
+}
+
 // Shutdown 優雅關機
 func (s *Server) Shutdown() error {
+	if s.lmtpServer != nil {
+		log.Println("[SMTP] 正在關閉 LMTP 監聽...")
+		if err := s.lmtpServer.Close(); err != nil {
+			log.Printf("[SMTP] 關閉 LMTP 監聽失敗: %v", err)
+		}
+	}
 	if s.smtpServer != nil {
 		log.Println("[SMTP] 正在關閉伺服器...")
 		return s.smtpServer.Close()
@@ -4,17 +4,24 @@
 package smtp
 
 import (
-	"bytes"
+	"bufio"
 	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
+	netmail "net/mail"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/emersion/go-message"
 	"github.com/emersion/go-message/mail"
+	"github.com/emersion/go-msgauth/authres"
+	"github.com/emersion/go-sasl"
 	gosmtp "github.com/emersion/go-smtp"
 	"github.com/google/uuid"
 	"github.com/lib/pq"
@@ -25,6 +32,13 @@ import (
 	"mail-proxy/internal/services"
 )
 
+// errTemporaryFailure 暫時性處理失敗，MTA 應稍後重試
+var errTemporaryFailure = &gosmtp.SMTPError{
+	Code:         451,
+	EnhancedCode: gosmtp.EnhancedCode{4, 3, 0},
+	Message:      "Temporary local error, please try again later",
+}
+
 // Session 實作 smtp.Session 介面
 // 處理單一 SMTP 連線的郵件接收
 type Session struct {
@@ -33,38 +47,94 @@ type Session struct {
 	queueService *services.QueueService
 	keydbService *services.KeyDBService
 
+	suppressionService *services.SuppressionService
+	rateLimiter        *services.RateLimiter // Client / 收件者速率限制 (nil 表示不限制)
+	resolver           DNSResolver           // SPF / DKIM 使用的 DNS 查詢
+
+	remoteIP net.IP // 連線來源 IP
+	helo     string // HELO / EHLO 主機名稱
+
+	lmtp          bool                // 是否為 LMTP 連線（由本機 MTA 投遞，不需認證）
+	authenticated bool                // 是否已通過認證
+	client        *models.ClientToken // 認證的 Client（以 JWT Secret 認證時為 nil）
+
 	from string   // 寄件者地址
 	to   []string // 收件者地址列表
+
+	mailOpts *gosmtp.MailOptions            // MAIL FROM 參數 (BODY、SMTPUTF8、RET、ENVID)
+	rcptOpts map[string]*gosmtp.RcptOptions // 各收件者的 RCPT TO 參數 (NOTIFY、ORCPT)
+
+	spfResult *authres.SPFResult // MAIL FROM 階段的 SPF 檢查結果 (未檢查時為 nil)
 }
 
 // NewSession 建立新的 Session
-func NewSession(cfg *config.Config, db *gorm.DB, queueService *services.QueueService, keydbService *services.KeyDBService) *Session {
+func NewSession(cfg *config.Config, db *gorm.DB, queueService *services.QueueService, keydbService *services.KeyDBService, suppressionService *services.SuppressionService, resolver DNSResolver) *Session {
 	return &Session{
-		cfg:          cfg,
-		db:           db,
-		queueService: queueService,
-		keydbService: keydbService,
-		to:           make([]string, 0),
+		cfg:                cfg,
+		db:                 db,
+		queueService:       queueService,
+		keydbService:       keydbService,
+		suppressionService: suppressionService,
+		resolver:           resolver,
+		to:                 make([]string, 0),
+		rcptOpts:           make(map[string]*gosmtp.RcptOptions),
 	}
 }
 
+// AuthMechanisms 回傳支援的認證機制
+// 實作 smtp.AuthSession 介面
+func (s *Session) AuthMechanisms() []string {
+	return []string{sasl.Plain}
+}
+
+// Auth 建立 SASL 認證伺服器
+// 實作 smtp.AuthSession 介面
+func (s *Session) Auth(mech string) (sasl.Server, error) {
+	return sasl.NewPlainServer(func(identity, username, password string) error {
+		return s.AuthPlain(username, password)
+	}), nil
+}
+
 // AuthPlain 處理 PLAIN 認證
+// username 為 client_id、password 為該 Client 的 API Token 時，需具備 mail:send 權限，並套用該 Client 的寄件地址與收件網域限制
 // 若 SMTPAuthRequired 為 false，則直接接受所有連線
 func (s *Session) AuthPlain(username, password string) error {
-	if !s.cfg.SMTPAuthRequired {
-		// 不需要認證，直接接受
-		return nil
+	log.Printf("[SMTP] 認證嘗試: username=%s", username)
+
+	// 以 Client API Token 認證
+	var client models.ClientToken
+	err := s.db.Where("client_id = ? AND is_active = ?", username, true).First(&client).Error
+	if err == nil {
+		if client.VerifyToken(password, time.Now()) == nil {
+			if !models.HasScope(client.Permissions, models.ScopeMailSend) {
+				log.Printf("[SMTP] Client %s 缺少 %s 權限", client.ClientID, models.ScopeMailSend)
+				return &gosmtp.SMTPError{
+					Code:         535,
+					EnhancedCode: gosmtp.EnhancedCode{5, 7, 8},
+					Message:      "Client token lacks mail:send permission",
+				}
+			}
+			s.authenticated = true
+			s.client = &client
+			return nil
+		}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("[SMTP] 查詢 Client 失敗: %v", err)
+		return fmt.Errorf("failed to verify credentials: %w", err)
 	}
 
-	// TODO: 可擴展認證邏輯，例如驗證 API Token
-	log.Printf("[SMTP] 認證嘗試: username=%s", username)
+	// 共用 SMTP AUTH 密碼 (與 JWT 簽章金鑰分離)
+	if s.cfg.SMTPAuthPassword != "" && subtle.ConstantTimeCompare([]byte(password), []byte(s.cfg.SMTPAuthPassword)) == 1 {
+		s.authenticated = true
+		return nil
+	}
 
-	// 目前簡單實作：若有設定 JWT Secret 則比對密碼
-	if password == s.cfg.JWTSecret {
+	if !s.cfg.SMTPAuthRequired {
+		// 不需要認證，直接接受
 		return nil
 	}
 
-	return fmt.Errorf("invalid credentials")
+	return gosmtp.ErrAuthFailed
 }
 
 // Mail 處理 MAIL FROM 指令
@@ -74,6 +144,14 @@ func (s *Session) Mail(from string, opts *gosmtp.MailOptions) error {
 	from = cleanEmail(from)
 	log.Printf("[SMTP] MAIL FROM: %s", from)
 
+	if s.cfg.SMTPAuthRequired && !s.authenticated && !s.lmtp {
+		return gosmtp.ErrAuthRequired
+	}
+
+	if err := s.verifySPF(from); err != nil {
+		return err
+	}
+
 	// 檢查是否在允許的網域清單中
 	if len(s.cfg.SMTPAllowedDomains) > 0 {
 		allowed := false
@@ -88,7 +166,46 @@ func (s *Session) Mail(from string, opts *gosmtp.MailOptions) error {
 		}
 	}
 
+	// 已認證 Client 的寄件地址限制
+	if s.client != nil && !s.client.AllowsFrom(from) {
+		return &gosmtp.SMTPError{
+			Code:         550,
+			EnhancedCode: gosmtp.EnhancedCode{5, 7, 1},
+			Message:      "Sender address not allowed for this client",
+		}
+	}
+
 	s.from = from
+	s.mailOpts = opts
+	if opts != nil && (opts.Body != "" || opts.UTF8 || opts.Return != "" || opts.EnvelopeID != "") {
+		log.Printf("[SMTP] MAIL 參數: body=%s smtputf8=%v ret=%s envid=%s", opts.Body, opts.UTF8, opts.Return, opts.EnvelopeID)
+	}
+	return nil
+}
+
+// verifySPF 評估 MAIL FROM 的 SPF 並依策略處理
+// 已認證的 Client 與 LMTP 連線不經過 SPF 檢查（來源並非寄件網域的 MTA）
+func (s *Session) verifySPF(from string) error {
+	if s.cfg.SMTPSPFPolicy == AuthPolicyOff || s.authenticated || s.lmtp || s.remoteIP == nil {
+		return nil
+	}
+
+	s.spfResult = checkSPF(s.resolver, s.remoteIP, s.helo, from)
+	log.Printf("[SMTP] SPF 檢查: ip=%s from=%s result=%s", s.remoteIP, from, s.spfResult.Value)
+
+	if s.cfg.SMTPSPFPolicy != AuthPolicyReject {
+		return nil
+	}
+	if spfFailed(s.spfResult) {
+		return spfRejectError(s.spfResult)
+	}
+	if s.spfResult.Value == authres.ResultTempError {
+		return &gosmtp.SMTPError{
+			Code:         451,
+			EnhancedCode: gosmtp.EnhancedCode{4, 7, 24},
+			Message:      "SPF validation temporary error, please try again later",
+		}
+	}
 	return nil
 }
 
@@ -99,39 +216,338 @@ func (s *Session) Rcpt(to string, opts *gosmtp.RcptOptions) error {
 	to = cleanEmail(to)
 	log.Printf("[SMTP] RCPT TO: %s", to)
 
+	if err := s.checkRecipient(to); err != nil {
+		log.Printf("[SMTP] 拒絕收件者 %s: %s", to, err.Error())
+		return err
+	}
+
 	s.to = append(s.to, to)
+	if opts != nil {
+		s.rcptOpts[to] = opts
+	}
+	return nil
+}
+
+// checkRecipient 驗證單一收件者
+// 依序檢查地址格式、Client 允許的收件網域與抑制清單
+// 收件者數量上限由 Server.MaxRecipients 處理 (452 4.5.3)
+func (s *Session) checkRecipient(to string) error {
+	addr, err := netmail.ParseAddress(to)
+	if err != nil || addr.Address != to || !strings.Contains(to, "@") {
+		return &gosmtp.SMTPError{
+			Code:         553,
+			EnhancedCode: gosmtp.EnhancedCode{5, 1, 3},
+			Message:      "Bad recipient address syntax",
+		}
+	}
+
+	if s.client != nil && !s.client.AllowsRecipient(to) {
+		return &gosmtp.SMTPError{
+			Code:         550,
+			EnhancedCode: gosmtp.EnhancedCode{5, 7, 1},
+			Message:      "Recipient domain not allowed for this client",
+		}
+	}
+
+	clientID := "smtp-inbound"
+	if s.client != nil {
+		clientID = s.client.ClientID
+	}
+	suppressed, err := s.suppressionService.IsSuppressed(to, clientID)
+	if err != nil {
+		log.Printf("[SMTP] 查詢抑制清單失敗: %v", err)
+		return &gosmtp.SMTPError{
+			Code:         451,
+			EnhancedCode: gosmtp.EnhancedCode{4, 3, 0},
+			Message:      "Temporary failure checking recipient",
+		}
+	}
+	if suppressed {
+		return &gosmtp.SMTPError{
+			Code:         550,
+			EnhancedCode: gosmtp.EnhancedCode{5, 1, 1},
+			Message:      "Recipient address suppressed",
+		}
+	}
+
 	return nil
 }
 
 // Data 處理 DATA 指令，接收郵件內容
+// 所有收件者共用同一筆郵件記錄，成功或失敗一併回應
 func (s *Session) Data(r io.Reader) error {
 	log.Printf("[SMTP] 開始接收郵件資料 (from=%s, to=%v)", s.from, s.to)
 
-	// 讀取完整的郵件內容
-	buf := new(bytes.Buffer)
-	size, err := buf.ReadFrom(r)
+	if err := s.checkRateLimit(); err != nil {
+		return err
+	}
+
+	mail, err := s.receiveMail(r)
+	if err != nil {
+		return err
+	}
+	return s.enqueueMail(mail)
+}
+
+// LMTPData 處理 LMTP 的 DATA 指令，逐一回報每個收件者的狀態
+// 實作 smtp.LMTPSession 介面
+// 每個收件者建立獨立的郵件記錄與佇列工作，單一收件者失敗時 MTA 只需重送該收件者
+func (s *Session) LMTPData(r io.Reader, status gosmtp.StatusCollector) error {
+	log.Printf("[SMTP] 開始接收 LMTP 郵件資料 (from=%s, to=%v)", s.from, s.to)
+
+	if err := s.checkRateLimit(); err != nil {
+		return err
+	}
+
+	mail, err := s.receiveMail(r)
+	if err != nil {
+		// 非 SMTPError 在 LMTP 會被視為永久失敗 (554)，暫時性錯誤需明確回應 4xx 讓 MTA 重試
+		var smtpErr *gosmtp.SMTPError
+		if errors.As(err, &smtpErr) {
+			return smtpErr
+		}
+		return errTemporaryFailure
+	}
+
+	for i, rcpt := range s.to {
+		// 最後一個收件者直接使用原記錄，其餘皆由尚未寫入的原記錄複製
+		rcptMail := mail
+		if i < len(s.to)-1 {
+			rcptMail = copyMail(mail)
+		}
+		rcptMail.ToAddresses = pq.StringArray{rcpt}
+		rcptMail.CCAddresses = nil
+		rcptMail.BCCAddresses = nil
+		rcptMail.Metadata["protocol"] = "lmtp"
+		rcptMail.Metadata[models.MetadataEnvelopeOnly] = "true"
+		rcptMail.DSNRecipients = dsnRecipientsFor(rcptMail.DSNRecipients, rcpt)
+
+		if err := s.enqueueMail(rcptMail); err != nil {
+			log.Printf("[SMTP] 收件者 %s 投遞失敗: %v", rcpt, err)
+			status.SetStatus(rcpt, errTemporaryFailure)
+			continue
+		}
+		status.SetStatus(rcpt, nil)
+	}
+	return nil
+}
+
+// checkRateLimit 檢查 Client 每小時郵件數與收件者每小時郵件數
+// 超過時回應 451 4.7.1 讓寄件端稍後重試；單封用量大於限制本身時回應 552 5.7.1 (重試也無法通過)
+// KeyDB 無法使用時放行
+func (s *Session) checkRateLimit() error {
+	if !s.rateLimiter.Enabled() {
+		return nil
+	}
+
+	limits := s.rateLimiter.RecipientLimits(s.client, services.CountRecipients(nil, s.to))
+	if s.client != nil {
+		limits = append(limits, s.rateLimiter.ClientMailLimit(s.client, 1))
+	}
+
+	result, err := s.rateLimiter.Allow(context.Background(), limits)
+	if err != nil {
+		log.Printf("[SMTP] 速率限制檢查失敗，放行郵件: %v", err)
+		return nil
+	}
+	if result.Exceeds {
+		log.Printf("[SMTP] 單封郵件超過速率限制上限 (%s: %d)", result.Scope, result.Limit)
+		return &gosmtp.SMTPError{
+			Code:         552,
+			EnhancedCode: gosmtp.EnhancedCode{5, 7, 1},
+			Message:      fmt.Sprintf("Message exceeds the rate limit (%s: %d)", result.Scope, result.Limit),
+		}
+	}
+	if !result.Allowed {
+		log.Printf("[SMTP] 超過速率限制 (%s: %d)，%d 秒後可重試", result.Scope, result.Limit, result.RetryAfterSeconds())
+		return &gosmtp.SMTPError{
+			Code:         451,
+			EnhancedCode: gosmtp.EnhancedCode{4, 7, 1},
+			Message:      fmt.Sprintf("Rate limit exceeded (%s), try again in %d seconds", result.Scope, result.RetryAfterSeconds()),
+		}
+	}
+	return nil
+}
+
+// receiveMail 暫存並解析郵件內容，回傳尚未寫入資料庫的郵件記錄
+func (s *Session) receiveMail(r io.Reader) (*models.Mail, error) {
+	mailID := uuid.New()
+	receivedAt := time.Now()
+
+	// 將郵件內容串流寫入暫存檔，不在記憶體中保留完整郵件
+	spoolPath, size, err := s.spoolMessage(mailID, r)
 	if err != nil {
 		log.Printf("[SMTP] 讀取郵件資料失敗: %v", err)
-		return fmt.Errorf("failed to read mail data: %w", err)
+		return nil, fmt.Errorf("failed to read mail data: %w", err)
 	}
+	defer os.Remove(spoolPath) // 成功時已移至最終位置，此處為失敗時的清理
 
 	// 檢查郵件大小
 	maxSizeBytes := int64(s.cfg.SMTPMaxMessageSize) * 1024 * 1024
 	if size > maxSizeBytes {
-		return fmt.Errorf("message too large: %d bytes (max: %d bytes)", size, maxSizeBytes)
+		return nil, &gosmtp.SMTPError{
+			Code:         552,
+			EnhancedCode: gosmtp.EnhancedCode{5, 3, 4},
+			Message:      fmt.Sprintf("Message too large: %d bytes (max: %d bytes)", size, maxSizeBytes),
+		}
 	}
 
 	log.Printf("[SMTP] 收到郵件: %d bytes", size)
 
-	// 解析 MIME 郵件並創建資料庫記錄
-	mail, err := s.parseMailData(buf)
+	// DKIM 驗證（依策略可能直接拒收）
+	dkimResults, dkimFail, err := s.verifyDKIM(spoolPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// 將驗證結果寫入原始郵件標頭並移除偽造的同名標頭；tag 策略的主旨標記也直接寫入原始郵件
+	authResults := s.authResults(dkimResults)
+	tagged := s.authTagged(dkimFail)
+	subjectTag := ""
+	if tagged {
+		subjectTag = s.cfg.SMTPAuthTagPrefix
+	}
+	if err := rewriteAuthHeaders(spoolPath, s.cfg.SMTPHostname, authResults, subjectTag); err != nil {
+		log.Printf("[SMTP] 寫入 Authentication-Results 失敗: %v", err)
+		return nil, fmt.Errorf("failed to write authentication results: %w", err)
+	}
+
+	// 解析 MIME 郵件並創建資料庫記錄（附件直接寫入最終儲存位置）
+	mail, err := s.parseMailData(mailID, receivedAt, spoolPath)
 	if err != nil {
 		log.Printf("[SMTP] 解析郵件失敗: %v", err)
-		return fmt.Errorf("failed to parse mail: %w", err)
+		os.RemoveAll(s.attachmentDir(mailID, receivedAt))
+		return nil, fmt.Errorf("failed to parse mail: %w", err)
+	}
+
+	// 保存原始 MIME，供稽核與原始直送模式使用
+	rawPath, err := s.moveRawMessage(mailID, receivedAt, spoolPath)
+	if err != nil {
+		log.Printf("[SMTP] 儲存原始郵件失敗: %v", err)
+		os.RemoveAll(s.attachmentDir(mailID, receivedAt))
+		return nil, fmt.Errorf("failed to store raw message: %w", err)
+	}
+	mail.RawMessagePath = rawPath
+	mail.SendMode = models.MailSendModeParsed
+	if s.cfg.SMTPRawPassthrough {
+		mail.SendMode = models.MailSendModeRaw
+	}
+	s.applyDSN(mail)
+	s.applyAuthResults(mail, authResults, tagged)
+
+	return mail, nil
+}
+
+// verifyDKIM 驗證暫存郵件的 DKIM 簽章並依策略處理
+// 回傳的 failed 表示沒有與標頭 From 網域對齊的有效簽章 (包含未簽章的郵件)
+func (s *Session) verifyDKIM(spoolPath string) ([]*authres.DKIMResult, bool, error) {
+	if s.cfg.SMTPDKIMPolicy == AuthPolicyOff {
+		return nil, false, nil
+	}
+
+	f, err := os.Open(spoolPath)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open spooled message: %w", err)
+	}
+	defer f.Close()
+
+	fromDomain := headerFromDomain(bufio.NewReader(f))
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, false, fmt.Errorf("failed to read spooled message: %w", err)
+	}
+
+	results, err := checkDKIM(s.resolver, bufio.NewReader(f))
+	if err != nil {
+		// 郵件格式無法解析時記錄為 permerror，依策略視為驗證失敗
+		log.Printf("[SMTP] DKIM 檢查失敗: %v", err)
+		results = []*authres.DKIMResult{{Value: authres.ResultPermError, Reason: err.Error()}}
+	}
+	for _, res := range results {
+		log.Printf("[SMTP] DKIM 檢查: domain=%s result=%s from=%s", res.Domain, res.Value, fromDomain)
+	}
+
+	failed := dkimFailed(results, fromDomain)
+	if s.cfg.SMTPDKIMPolicy == AuthPolicyReject && failed {
+		return nil, false, dkimRejectError()
+	}
+	return results, failed, nil
+}
+
+// authResults 產生本次郵件的 Authentication-Results 標頭值；未做任何檢查時回傳空白
+func (s *Session) authResults(dkimResults []*authres.DKIMResult) string {
+	if s.spfResult == nil && dkimResults == nil {
+		return ""
+	}
+	return formatAuthResults(s.cfg.SMTPHostname, s.spfResult, dkimResults)
+}
+
+// authTagged 策略為 tag 且 SPF 或 DKIM 驗證失敗時需在主旨前加上標記
+func (s *Session) authTagged(dkimFail bool) bool {
+	return (s.cfg.SMTPSPFPolicy == AuthPolicyTag && spfFailed(s.spfResult)) ||
+		(s.cfg.SMTPDKIMPolicy == AuthPolicyTag && dkimFail)
+}
+
+// applyAuthResults 將 SPF / DKIM 結果記錄於 metadata 的 Authentication-Results
+// (原始郵件標頭與主旨標記已由 rewriteAuthHeaders 寫入，解析後的主旨已含標記)
+func (s *Session) applyAuthResults(mail *models.Mail, authResults string, tagged bool) {
+	if authResults == "" {
+		return
+	}
+
+	mail.Metadata["authentication_results"] = authResults
+	if tagged {
+		mail.Metadata["authentication_tagged"] = "true"
+	}
+}
+
+// applyDSN 保存 DSN 參數 (RFC 3461)
+// 信封寄件者為空 (MAIL FROM:<>) 時不產生 DSN，避免通知迴圈
+func (s *Session) applyDSN(mail *models.Mail) {
+	if s.from == "" {
+		return
+	}
+
+	if s.mailOpts != nil {
+		mail.DSNReturn = string(s.mailOpts.Return)
+		mail.DSNEnvelopeID = s.mailOpts.EnvelopeID
+	}
+
+	for _, rcpt := range s.to {
+		dsn := models.MailDSNRecipient{
+			ID:        uuid.New(),
+			MailID:    mail.ID,
+			Recipient: rcpt,
+		}
+		if opts := s.rcptOpts[rcpt]; opts != nil {
+			for _, n := range opts.Notify {
+				dsn.Notify = append(dsn.Notify, string(n))
+			}
+			if opts.OriginalRecipient != "" {
+				dsn.OriginalRecipient = fmt.Sprintf("%s; %s", opts.OriginalRecipientType, opts.OriginalRecipient)
+			}
+		}
+		// NOTIFY=NEVER 不需保存
+		if len(dsn.Notify) == 1 && dsn.Notify[0] == models.DSNNotifyNever {
+			continue
+		}
+		mail.DSNRecipients = append(mail.DSNRecipients, dsn)
+	}
+}
+
+// dsnRecipientsFor 取得指定收件者的 DSN 參數
+func dsnRecipientsFor(recipients []models.MailDSNRecipient, rcpt string) []models.MailDSNRecipient {
+	for _, dsn := range recipients {
+		if dsn.Recipient == rcpt {
+			return []models.MailDSNRecipient{dsn}
+		}
 	}
+	return nil
+}
 
+// enqueueMail 建立郵件記錄並排入佇列
+func (s *Session) enqueueMail(mail *models.Mail) error {
 	// 儲存到資料庫
-	if err := s.db.Create(&mail).Error; err != nil {
+	if err := s.db.Create(mail).Error; err != nil {
 		log.Printf("[SMTP] 儲存郵件記錄失敗: %v", err)
 		return fmt.Errorf("failed to create mail record: %w", err)
 	}
@@ -146,6 +562,8 @@ func (s *Session) Data(r io.Reader) error {
 			ContentType: att.ContentType,
 			SizeBytes:   att.SizeBytes,
 			StoragePath: att.StoragePath,
+			ContentID:   att.ContentID,
+			Inline:      att.Inline,
 		})
 	}
 
@@ -162,6 +580,15 @@ func (s *Session) Data(r io.Reader) error {
 		Attachments:  attachmentInfos,
 		Metadata:     mail.Metadata,
 		RetryCount:   0,
+
+		SendMode:       mail.SendMode,
+		RawMessagePath: mail.RawMessagePath,
+
+		DSNRequested: len(mail.DSNRecipients) > 0,
+	}
+	if s.mailOpts != nil {
+		mailJob.SMTPUTF8 = s.mailOpts.UTF8
+		mailJob.BodyType = string(s.mailOpts.Body)
 	}
 
 	// 發送到 RabbitMQ 佇列
@@ -180,18 +607,50 @@ func (s *Session) Data(r io.Reader) error {
 	return nil
 }
 
-// parseMailData 解析 MIME 郵件資料並創建資料庫模型
-func (s *Session) parseMailData(buf *bytes.Buffer) (*models.Mail, error) {
-	// 使用 go-message 解析郵件
-	mr, err := mail.CreateReader(bytes.NewReader(buf.Bytes()))
+// copyMail 複製郵件記錄（含附件），供 LMTP 每個收件者建立獨立記錄
+// 附件與原始 MIME 檔案共用同一份儲存內容
+func copyMail(mail *models.Mail) *models.Mail {
+	cp := *mail
+	cp.ID = uuid.New()
+
+	cp.Metadata = make(map[string]string, len(mail.Metadata))
+	for k, v := range mail.Metadata {
+		cp.Metadata[k] = v
+	}
+
+	cp.Attachments = make([]models.Attachment, len(mail.Attachments))
+	for i, att := range mail.Attachments {
+		att.ID = uuid.New()
+		att.MailID = cp.ID
+		cp.Attachments[i] = att
+	}
+
+	cp.DSNRecipients = make([]models.MailDSNRecipient, len(mail.DSNRecipients))
+	for i, dsn := range mail.DSNRecipients {
+		dsn.ID = uuid.New()
+		dsn.MailID = cp.ID
+		cp.DSNRecipients[i] = dsn
+	}
+	return &cp
+}
+
+// parseMailData 串流解析暫存的 MIME 郵件並創建資料庫模型
+func (s *Session) parseMailData(mailID uuid.UUID, receivedAt time.Time, spoolPath string) (*models.Mail, error) {
+	f, err := os.Open(spoolPath)
 	if err != nil {
+		return nil, fmt.Errorf("failed to open spooled message: %w", err)
+	}
+	defer f.Close()
+
+	// 使用 go-message 解析郵件（字集與 Content-Transfer-Encoding 會自動解碼）
+	entity, err := message.Read(bufio.NewReader(f))
+	if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
 		// 若解析失敗，嘗試將整個內容作為純文字處理
-		return s.createSimpleMailJob(buf.String())
+		return s.createSimpleMailJob(mailID, receivedAt, spoolPath)
 	}
-	defer mr.Close()
 
-	// 取得郵件標頭
-	header := mr.Header
+	// 取得郵件標頭 (主旨中的 RFC 2047 encoded-word 會依宣告字集解碼)
+	header := mail.Header{Header: entity.Header}
 
 	// 取得主旨
 	subject, _ := header.Subject()
@@ -230,134 +689,28 @@ func (s *Session) parseMailData(buf *bytes.Buffer) (*models.Mail, error) {
 		}
 	}
 
-	// 解析郵件內容（純文字與 HTML）
-	var bodyText, bodyHTML string
-	var attachments []models.Attachment
-	var attachmentDataList [][]byte // 儲存附件二進制資料
-
-	for {
-		part, err := mr.NextPart()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			log.Printf("[SMTP] 解析郵件部分失敗: %v", err)
-			continue
-		}
-
-		switch h := part.Header.(type) {
-		case *mail.InlineHeader:
-			// 內嵌內容（純文字或 HTML）
-			contentType, _, _ := h.ContentType()
-			content, _ := io.ReadAll(part.Body)
-
-			if strings.HasPrefix(contentType, "text/plain") {
-				bodyText = string(content)
-			} else if strings.HasPrefix(contentType, "text/html") {
-				bodyHTML = string(content)
-			}
-
-		case *mail.AttachmentHeader:
-			// 附件處理
-			filename, _ := h.Filename()
-			contentType, params, _ := h.ContentType()
-
-			// 詳細日誌：輸出所有標頭以便除錯
-			log.Printf("[SMTP] 發現 AttachmentHeader - filename=%q, contentType=%s", filename, contentType)
-
-			// 列出所有標頭以便除錯
-			headerFields := h.Fields()
-			for headerFields.Next() {
-				log.Printf("[SMTP] Header: %s = %s", headerFields.Key(), headerFields.Value())
-			}
-
-			// 修復：嘗試從多個來源獲取檔名
-			if filename == "" {
-				// 1. 從 Content-Disposition 獲取
-				disp := h.Get("Content-Disposition")
-				if disp != "" {
-					log.Printf("[SMTP] Content-Disposition: %s", disp)
-					// 解析 filename 參數（可能用 ; 分隔）
-					parts := strings.Split(disp, ";")
-					for _, part := range parts {
-						part = strings.TrimSpace(part)
-						if strings.HasPrefix(part, "filename=") {
-							filename = strings.TrimPrefix(part, "filename=")
-							filename = strings.Trim(filename, `"`)
-							break
-						}
-					}
-				}
-			}
-
-			// 2. 如果還是沒有檔名，從 Content-Type 的 name 參數獲取
-			if filename == "" && params != nil {
-				if name, ok := params["name"]; ok {
-					filename = name
-					log.Printf("[SMTP] 從 Content-Type name 參數取得檔名: %s", filename)
-				}
-			}
-
-			// 3. 嘗試從其他可能的標頭獲取
-			if filename == "" {
-				// 檢查 X-Attachment-Id 或其他自定義標頭
-				if xFilename := h.Get("X-Attachment-Name"); xFilename != "" {
-					filename = xFilename
-					log.Printf("[SMTP] 從 X-Attachment-Name 取得檔名: %s", filename)
-				}
-			}
-
-			// 4. 如果仍然沒有檔名，生成一個預設檔名
-			if filename == "" {
-				// 根據 content type 生成副檔名
-				ext := ".bin"
-				if strings.HasPrefix(contentType, "image/") {
-					parts := strings.Split(contentType, "/")
-					if len(parts) == 2 {
-						ext = "." + parts[1]
-					}
-				} else if strings.HasPrefix(contentType, "application/pdf") {
-					ext = ".pdf"
-				} else if strings.HasPrefix(contentType, "text/") {
-					ext = ".txt"
-				}
-
-				filename = fmt.Sprintf("attachment_%d%s", time.Now().Unix(), ext)
-				log.Printf("[SMTP] 無法取得附件檔名，使用預設檔名: %s", filename)
-			} else {
-				log.Printf("[SMTP] 解析附件檔名: %s", filename)
-			}
-
-			// 讀取附件內容
-			attachmentData, err := io.ReadAll(part.Body)
-			if err != nil {
-				log.Printf("[SMTP] 讀取附件失敗 %s: %v", filename, err)
-				continue
-			}
-
-			sizeBytes := int64(len(attachmentData))
-
-			// 暫時儲存附件資料，稍後在建立 mailID 後才寫入檔案
-			attachments = append(attachments, models.Attachment{
-				ID:          uuid.New(),
-				Filename:    filename,
-				ContentType: contentType,
-				SizeBytes:   sizeBytes,
-				// StoragePath 和 MailID 會在後面設定（需要先有 mailID）
-			})
-
-			// 暫存附件內容（稍後儲存）
-			attachmentDataList = append(attachmentDataList, attachmentData)
-		}
+	// 走訪 MIME 結構，取得內文（已轉為 UTF-8），附件直接串流寫入最終儲存位置
+	store := func(filename string, r io.Reader) (string, int64, error) {
+		return s.storeAttachment(mailID, receivedAt, filename, r)
+	}
+	content, err := walkMIME(entity, store)
+	if err != nil {
+		log.Printf("[SMTP] 走訪 MIME 結構失敗，改以純文字處理: %v", err)
+		os.RemoveAll(s.attachmentDir(mailID, receivedAt))
+		return s.createSimpleMailJob(mailID, receivedAt, spoolPath)
 	}
 
+	bodyText, bodyHTML := content.Text, content.HTML
+
 	// 若沒有解析到內容，使用原始資料
 	if bodyText == "" && bodyHTML == "" {
-		bodyText = buf.String()
+		bodyText, err = readRawPrefix(spoolPath)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// 建立 Mail 資料庫模型
-	mailID := uuid.New()
 	mail := &models.Mail{
 		ID:           mailID,
 		FromAddress:  from,
@@ -372,25 +725,24 @@ func (s *Session) parseMailData(buf *bytes.Buffer) (*models.Mail, error) {
 		ClientName:   "SMTP Receiver",
 		Metadata: map[string]string{
 			"source":      "smtp-inbound",
-			"received_at": time.Now().Format(time.RFC3339),
+			"received_at": receivedAt.Format(time.RFC3339),
 		},
 		RetryCount: 0,
 	}
 
-	// 儲存附件並設定 MailID 和 StoragePath
-	for i := range attachments {
-		attachments[i].MailID = mailID
-
-		// 儲存附件到檔案系統（現在有 mailID 了）
-		if i < len(attachmentDataList) {
-			storagePath, err := s.saveAttachmentWithMailID(mailID, attachments[i].Filename, attachmentDataList[i])
-			if err != nil {
-				log.Printf("[SMTP] 儲存附件失敗 %s: %v", attachments[i].Filename, err)
-				continue
-			}
-			attachments[i].StoragePath = storagePath
-			log.Printf("[SMTP] 儲存附件: %s -> %s", attachments[i].Filename, storagePath)
-		}
+	// 附件已在走訪時寫入檔案系統，此處只建立資料庫記錄
+	var attachments []models.Attachment
+	for _, att := range content.Attachments {
+		attachments = append(attachments, models.Attachment{
+			ID:          uuid.New(),
+			MailID:      mailID,
+			Filename:    att.Filename,
+			ContentType: att.ContentType,
+			SizeBytes:   att.SizeBytes,
+			StoragePath: att.StoragePath,
+			ContentID:   att.ContentID,
+			Inline:      att.ContentID != "",
+		})
 	}
 	mail.Attachments = attachments
 
@@ -398,8 +750,12 @@ func (s *Session) parseMailData(buf *bytes.Buffer) (*models.Mail, error) {
 }
 
 // createSimpleMailJob 建立簡單的 Mail（無法解析 MIME 時使用）
-func (s *Session) createSimpleMailJob(rawContent string) (*models.Mail, error) {
-	mailID := uuid.New()
+func (s *Session) createSimpleMailJob(mailID uuid.UUID, receivedAt time.Time, spoolPath string) (*models.Mail, error) {
+	rawContent, err := readRawPrefix(spoolPath)
+	if err != nil {
+		return nil, err
+	}
+
 	return &models.Mail{
 		ID:          mailID,
 		FromAddress: s.from,
@@ -412,7 +768,7 @@ func (s *Session) createSimpleMailJob(rawContent string) (*models.Mail, error) {
 		Metadata: map[string]string{
 			"source":      "smtp-inbound",
 			"raw_content": "true",
-			"received_at": time.Now().Format(time.RFC3339),
+			"received_at": receivedAt.Format(time.RFC3339),
 		},
 		RetryCount: 0,
 	}, nil
@@ -422,33 +778,116 @@ func (s *Session) createSimpleMailJob(rawContent string) (*models.Mail, error) {
 func (s *Session) Reset() {
 	s.from = ""
 	s.to = make([]string, 0)
+	s.mailOpts = nil
+	s.rcptOpts = make(map[string]*gosmtp.RcptOptions)
+	s.spfResult = nil
+}
+
+// spoolMessage 將 DATA 內容串流寫入暫存檔
+// 暫存目錄位於 AttachmentPath 下，確保與最終儲存位置在同一檔案系統，完成後可直接 rename
+func (s *Session) spoolMessage(mailID uuid.UUID, r io.Reader) (string, int64, error) {
+	spoolDir := filepath.Join(s.cfg.AttachmentPath, ".spool")
+	if err := os.MkdirAll(spoolDir, 0755); err != nil {
+		return "", 0, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	f, err := os.CreateTemp(spoolDir, mailID.String()+"-*.eml")
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create spool file: %w", err)
+	}
+	defer f.Close()
+
+	size, err := io.Copy(f, r)
+	if err != nil {
+		os.Remove(f.Name())
+		return "", 0, err
+	}
+
+	return f.Name(), size, nil
 }
 
-// saveAttachmentWithMailID 儲存附件到檔案系統（使用 API handler 相同的目錄結構）
-// 返回完整的絕對儲存路徑
-func (s *Session) saveAttachmentWithMailID(mailID uuid.UUID, filename string, data []byte) (string, error) {
-	// 使用與 API handler 相同的路徑結構：AttachmentPath/YYYY/MM/DD/mailID/filename
-	storagePath := filepath.Join(
+// attachmentDir 取得郵件附件目錄（使用 API handler 相同的目錄結構）
+// 路徑為 AttachmentPath/YYYY/MM/DD/mailID
+func (s *Session) attachmentDir(mailID uuid.UUID, receivedAt time.Time) string {
+	return filepath.Join(
 		s.cfg.AttachmentPath,
-		time.Now().Format("2006/01/02"),
+		receivedAt.Format("2006/01/02"),
 		mailID.String(),
-		filepath.Base(filename), // 清理檔名，避免路徑穿越攻擊
 	)
+}
+
+// storeAttachment 將附件內容串流寫入最終儲存位置
+// 返回完整的絕對儲存路徑與寫入大小；同名附件自動加上序號避免覆蓋
+func (s *Session) storeAttachment(mailID uuid.UUID, receivedAt time.Time, filename string, r io.Reader) (string, int64, error) {
+	dir := s.attachmentDir(mailID, receivedAt)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", 0, fmt.Errorf("failed to create attachment directory: %w", err)
+	}
+
+	// 清理檔名，避免路徑穿越攻擊
+	base := filepath.Base(filename)
+	ext := filepath.Ext(base)
+	name := strings.TrimSuffix(base, ext)
+
+	for i := 0; ; i++ {
+		storagePath := filepath.Join(dir, base)
+		if i > 0 {
+			storagePath = filepath.Join(dir, fmt.Sprintf("%s_%d%s", name, i, ext))
+		}
+
+		f, err := os.OpenFile(storagePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to create attachment file: %w", err)
+		}
+
+		size, err := io.Copy(f, r)
+		closeErr := f.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(storagePath)
+			return "", 0, fmt.Errorf("failed to write attachment file: %w", err)
+		}
+
+		return storagePath, size, nil
+	}
+}
+
+// moveRawMessage 將暫存檔移至原始 MIME 儲存位置
+// 路徑為 AttachmentPath/YYYY/MM/DD/mailID.eml，與附件目錄並列以避免檔名衝突
+func (s *Session) moveRawMessage(mailID uuid.UUID, receivedAt time.Time, spoolPath string) (string, error) {
+	storagePath := s.attachmentDir(mailID, receivedAt) + ".eml"
 
-	// 建立目錄
 	if err := os.MkdirAll(filepath.Dir(storagePath), 0755); err != nil {
-		return "", fmt.Errorf("failed to create attachment directory: %w", err)
+		return "", fmt.Errorf("failed to create raw message directory: %w", err)
 	}
 
-	// 寫入檔案
-	if err := os.WriteFile(storagePath, data, 0644); err != nil {
-		return "", fmt.Errorf("failed to write attachment file: %w", err)
+	if err := os.Rename(spoolPath, storagePath); err != nil {
+		return "", fmt.Errorf("failed to move raw message file: %w", err)
 	}
 
-	// 返回完整的絕對路徑
 	return storagePath, nil
 }
 
+// readRawPrefix 讀取原始郵件開頭作為純文字內文（最多 maxInlineBodyBytes）
+func readRawPrefix(spoolPath string) (string, error) {
+	f, err := os.Open(spoolPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open spooled message: %w", err)
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxInlineBodyBytes))
+	if err != nil {
+		return "", fmt.Errorf("failed to read spooled message: %w", err)
+	}
+	return string(data), nil
+}
+
 // Logout 處理 QUIT 指令
 func (s *Session) Logout() error {
 	log.Printf("[SMTP] Session 結束")
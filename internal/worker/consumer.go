@@ -6,7 +6,10 @@ package worker
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"math/rand"
 	"strings"
 	"sync"
 	"time"
@@ -21,6 +24,9 @@ import (
 	"mail-proxy/pkg/microsoft"
 )
 
+// publishConfirmTimeout 等待 RabbitMQ 發布確認的最長時間
+const publishConfirmTimeout = 10 * time.Second
+
 // Consumer RabbitMQ Consumer
 type Consumer struct {
 	cfg                 *config.Config
@@ -32,6 +38,8 @@ type Consumer struct {
 	keydbService        *services.KeyDBService
 	senderConfigService *services.EmailSenderConfigService
 	graphMailService    *services.GraphMailService
+	dsnService          *services.DSNService
+	sendShaper          *services.SendShaper
 
 	isShutdown bool
 	activeJobs int
@@ -48,6 +56,8 @@ func NewConsumer(
 	keydbService *services.KeyDBService,
 	senderConfigService *services.EmailSenderConfigService,
 	graphMailService *services.GraphMailService,
+	dsnService *services.DSNService,
+	sendShaper *services.SendShaper,
 ) *Consumer {
 	return &Consumer{
 		cfg:                 cfg,
@@ -57,6 +67,8 @@ func NewConsumer(
 		keydbService:        keydbService,
 		senderConfigService: senderConfigService,
 		graphMailService:    graphMailService,
+		dsnService:          dsnService,
+		sendShaper:          sendShaper,
 	}
 }
 
@@ -89,6 +101,14 @@ func (c *Consumer) Start() error {
 	if err != nil {
 		return err
 	}
+	if err := services.DeclareDelayQueues(c.channel, c.cfg); err != nil {
+		return err
+	}
+
+	// 發布至延遲與失敗隊列時等待確認，確認後才 Ack 原訊息
+	if err := c.channel.Confirm(false); err != nil {
+		return err
+	}
 
 	// 設定 prefetch
 	err = c.channel.Qos(c.cfg.WorkerPrefetch, 0, false)
@@ -169,6 +189,17 @@ func (c *Consumer) handleMessage(msg amqp.Delivery) {
 		}
 	}
 
+	// 發送速率調節：超過供應商 / 寄件信箱的速率時延後，不計入重試次數
+	if c.sendShaper.Enabled() {
+		wait, err := c.sendShaper.Acquire(ctx, c.mailRouter.Provider(&job), &job)
+		if err != nil {
+			log.Printf("Send shaper unavailable for mail %s, sending without shaping: %v", job.MailID, err)
+		} else if wait > 0 {
+			c.deferJob(ctx, msg, &job, wait)
+			return
+		}
+	}
+
 	// 更新狀態為 processing
 	c.keydbService.SetStatus(ctx, job.MailID, "processing", job.RetryCount, "")
 	c.db.Model(&models.Mail{}).Where("id = ?", job.MailID).Update("status", models.MailStatusProcessing)
@@ -184,15 +215,27 @@ func (c *Consumer) handleMessage(msg amqp.Delivery) {
 			return
 		}
 
-		config, secret, err := c.senderConfigService.GetDecryptedConfig(senderConfigUUID)
+		config, credential, err := c.senderConfigService.GetDecryptedConfig(senderConfigUUID)
 		if err != nil {
 			log.Printf("Failed to get sender config for mail %s: %v", job.MailID, err)
 			c.handleRetry(ctx, msg, &job, err)
 			return
 		}
 
-		log.Printf("Using database OAuth config for sender: %s", job.FromAddress)
-		sendErr = c.graphMailService.SendMailWithConfig(&job, config.MSTenantID, config.MSClientID, secret)
+		log.Printf("Using database OAuth config for sender: %s (%s)", job.FromAddress, config.AuthMethod())
+		if job.SendMode == models.MailSendModeRaw {
+			raw, err := services.LoadRawMessage(&job)
+			if err != nil {
+				c.handleRetry(ctx, msg, &job, err)
+				return
+			}
+			sendErr = c.graphMailService.SendRawMailWithConfig(&job, raw, config.MSTenantID, config.MSClientID, credential)
+		} else {
+			sendErr = c.graphMailService.SendMailWithConfig(&job, config.MSTenantID, config.MSClientID, credential)
+		}
+	} else if job.SendMode == models.MailSendModeRaw {
+		// 原始 MIME 直送 (SMTP Receiver 來源)
+		sendErr = c.mailRouter.SendRawMail(&job)
 	} else {
 		// 使用環境變數配置 (組織網域) 或 SendGrid (非組織網域)
 		if strings.HasSuffix(strings.ToLower(job.FromAddress), strings.ToLower(c.cfg.OrgEmailDomain)) {
@@ -215,6 +258,7 @@ func (c *Consumer) handleMessage(msg amqp.Delivery) {
 		"sent_at": now,
 	})
 	c.keydbService.SetStatus(ctx, job.MailID, "sent", job.RetryCount, "")
+	c.dsnService.NotifyRelayed(&job)
 
 	msg.Ack(false)
 }
@@ -224,24 +268,30 @@ func (c *Consumer) handleRetry(ctx context.Context, msg amqp.Delivery, job *mode
 	job.RetryCount++
 	errorMsg := sendErr.Error()
 
-	if job.RetryCount >= c.cfg.MaxRetryCount {
-		// 達到最大重試次數
-		log.Printf("Mail %s failed after %d retries", job.MailID, job.RetryCount)
+	// 永久性錯誤 (例如下一跳不支援 SMTPUTF8) 重試也不會成功，直接視為最終失敗
+	dsnStatus := "5.0.0"
+	var permanent *services.PermanentError
+	if errors.As(sendErr, &permanent) {
+		dsnStatus = permanent.Status
+	}
+
+	if permanent != nil || job.RetryCount >= c.cfg.MaxRetryCount {
+		// 達到最大重試次數或永久性錯誤
+		if permanent != nil {
+			log.Printf("Mail %s failed permanently: %v", job.MailID, sendErr)
+		} else {
+			log.Printf("Mail %s failed after %d retries", job.MailID, job.RetryCount)
+		}
 
-		// 發送到失敗隊列
+		// 發送到失敗隊列 (失敗狀態已寫入資料庫，發布失敗只記錄)
 		body, _ := json.Marshal(job)
-		c.channel.PublishWithContext(
-			ctx,
-			"",
-			c.cfg.FailedQueueName,
-			false,
-			false,
-			amqp.Publishing{
-				DeliveryMode: amqp.Persistent,
-				ContentType:  "application/json",
-				Body:         body,
-			},
-		)
+		if err := c.publishConfirmed(ctx, c.cfg.FailedQueueName, amqp.Publishing{
+			DeliveryMode: amqp.Persistent,
+			ContentType:  "application/json",
+			Body:         body,
+		}); err != nil {
+			log.Printf("Failed to publish mail %s to failed queue: %v", job.MailID, err)
+		}
 
 		// 更新資料庫
 		c.db.Model(&models.Mail{}).Where("id = ?", job.MailID).Updates(map[string]interface{}{
@@ -250,6 +300,7 @@ func (c *Consumer) handleRetry(ctx context.Context, msg amqp.Delivery, job *mode
 			"error_message": errorMsg,
 		})
 		c.keydbService.SetStatus(ctx, job.MailID, "failed", job.RetryCount, errorMsg)
+		c.dsnService.NotifyFailed(job, dsnStatus, errorMsg)
 
 		msg.Ack(false)
 		return
@@ -263,30 +314,65 @@ func (c *Consumer) handleRetry(ctx context.Context, msg amqp.Delivery, job *mode
 	c.db.Model(&models.Mail{}).Where("id = ?", job.MailID).Update("retry_count", job.RetryCount)
 	c.keydbService.SetStatus(ctx, job.MailID, "queued", job.RetryCount, "")
 
-	// 延遲後重新發送
-	go func() {
-		time.Sleep(delay)
-		body, _ := json.Marshal(job)
-		c.channel.PublishWithContext(
-			context.Background(),
-			"",
-			c.cfg.MailQueueName,
-			false,
-			false,
-			amqp.Publishing{
-				DeliveryMode: amqp.Persistent,
-				ContentType:  "application/json",
-				Body:         body,
-				Headers: amqp.Table{
-					"x-retry-count": job.RetryCount,
-				},
-			},
-		)
-	}()
+	// 放入延遲隊列，到期後重新發送
+	c.requeueAfter(ctx, msg, job, delay)
+}
+
+// deferJob 因發送速率調節延後 job，不增加重試次數
+// 延後時間加上隨機抖動，避免同一信箱大量延後的 job 同時醒來；上限為 SHAPER_MAX_DEFER_SECONDS，到期後重新檢查
+func (c *Consumer) deferJob(ctx context.Context, msg amqp.Delivery, job *models.MailJob, wait time.Duration) {
+	delay := wait + time.Duration(rand.Int63n(int64(wait)+1))
+	if maxDelay := time.Duration(c.cfg.ShaperMaxDeferSeconds) * time.Second; maxDelay > 0 && delay > maxDelay {
+		delay = maxDelay
+	}
+	log.Printf("Deferring mail %s from %s for %v (send rate limit)", job.MailID, job.FromAddress, delay.Round(time.Second))
+
+	c.keydbService.SetStatus(ctx, job.MailID, "queued", job.RetryCount, "deferred by send rate limit")
+	c.requeueAfter(ctx, msg, job, delay)
+}
+
+// requeueAfter 將 job 放入不短於 delay 的延遲層級隊列，到期後由 RabbitMQ 以死信轉回主隊列
+// 延遲期間的 job 持久保存於 RabbitMQ，Worker 重啟或關機不會遺失；
+// 確認寫入後才 Ack 原訊息，發布失敗時原訊息重新排隊
+func (c *Consumer) requeueAfter(ctx context.Context, msg amqp.Delivery, job *models.MailJob, delay time.Duration) {
+	queue, _ := services.DelayQueueFor(c.cfg, delay)
+	body, _ := json.Marshal(job)
+	err := c.publishConfirmed(ctx, queue, amqp.Publishing{
+		DeliveryMode: amqp.Persistent,
+		ContentType:  "application/json",
+		Body:         body,
+		Headers: amqp.Table{
+			"x-retry-count": job.RetryCount,
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to schedule mail %s for redelivery, requeueing: %v", job.MailID, err)
+		msg.Nack(false, true)
+		return
+	}
 
 	msg.Ack(false)
 }
 
+// publishConfirmed 發布訊息至指定隊列並等待 RabbitMQ 確認 (publisher confirms)
+func (c *Consumer) publishConfirmed(ctx context.Context, queue string, publishing amqp.Publishing) error {
+	confirmation, err := c.channel.PublishWithDeferredConfirmWithContext(ctx, "", queue, false, false, publishing)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, publishConfirmTimeout)
+	defer cancel()
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return fmt.Errorf("publish to %s was not confirmed", queue)
+	}
+	return nil
+}
+
 // GracefulShutdown 優雅關機
 func (c *Consumer) GracefulShutdown() {
 	log.Println("Initiating graceful shutdown...")
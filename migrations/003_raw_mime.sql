@@ -0,0 +1,8 @@
+-- migrations/003_raw_mime.sql
+-- 保存 SMTP 接收的原始 MIME 並支援原始直送模式
+
+-- ============================================
+-- 更新 mails 表 - 新增原始 MIME 欄位
+-- ============================================
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS raw_message_path VARCHAR(500);
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS send_mode VARCHAR(20) NOT NULL DEFAULT 'parsed';
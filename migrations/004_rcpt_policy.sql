@@ -0,0 +1,24 @@
+-- migrations/004_rcpt_policy.sql
+-- SMTP 收件者驗證 - 抑制清單與 Client 允許的收件網域
+
+-- ============================================
+-- Suppressions 表 - 收件者抑制清單
+-- ============================================
+CREATE TABLE IF NOT EXISTS suppressions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    email VARCHAR(255) NOT NULL,
+    client_id VARCHAR(255) NOT NULL DEFAULT '', -- 空白表示全域抑制
+    reason VARCHAR(255),
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- ============================================
+-- 索引
+-- ============================================
+CREATE UNIQUE INDEX IF NOT EXISTS idx_suppressions_email_client
+    ON suppressions(email, client_id);
+
+-- ============================================
+-- 更新 client_tokens 表 - 新增允許的收件網域
+-- ============================================
+ALTER TABLE client_tokens ADD COLUMN IF NOT EXISTS allowed_recipient_domains TEXT[];
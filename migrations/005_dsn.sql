@@ -0,0 +1,26 @@
+-- migrations/005_dsn.sql
+-- SMTP DSN 擴充 (RFC 3461) - 保存 RET / ENVID 與每個收件者的 NOTIFY / ORCPT
+
+-- ============================================
+-- 更新 mails 表 - 新增 DSN 參數欄位
+-- ============================================
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS dsn_return VARCHAR(10);
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS dsn_envelope_id VARCHAR(100);
+
+-- ============================================
+-- Mail DSN Recipients 表 - 每個收件者的 DSN 參數
+-- ============================================
+CREATE TABLE IF NOT EXISTS mail_dsn_recipients (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    mail_id UUID NOT NULL REFERENCES mails(id) ON DELETE CASCADE,
+    recipient VARCHAR(255) NOT NULL,
+    notify TEXT[],                  -- NEVER / SUCCESS / FAILURE / DELAY，空白表示未指定
+    original_recipient VARCHAR(500), -- ORCPT，格式為 "addr-type; address"
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- ============================================
+-- 索引
+-- ============================================
+CREATE INDEX IF NOT EXISTS idx_mail_dsn_recipients_mail_id
+    ON mail_dsn_recipients(mail_id);
@@ -0,0 +1,24 @@
+-- migrations/006_dkim_keys.sql
+-- DKIM Keys 表 - 儲存每個寄件網域的 DKIM 簽章金鑰
+
+-- ============================================
+-- DKIM Keys 表
+-- ============================================
+CREATE TABLE IF NOT EXISTS dkim_keys (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    domain VARCHAR(255) NOT NULL,
+    selector VARCHAR(63) NOT NULL,
+    algorithm VARCHAR(20) NOT NULL,          -- rsa-sha256 / ed25519-sha256
+    private_key_encrypted TEXT NOT NULL,     -- AES-256-GCM 加密的 PKCS#8 PEM
+    public_key TEXT NOT NULL,                -- DNS TXT 記錄 p= 的值
+    is_active BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW(),
+    UNIQUE(domain, selector)
+);
+
+-- ============================================
+-- 索引
+-- ============================================
+CREATE INDEX IF NOT EXISTS idx_dkim_keys_domain
+    ON dkim_keys(domain);
@@ -0,0 +1,10 @@
+-- migrations/007_token_rotation.sql
+-- Client Token 有效期限與輪替
+
+-- ============================================
+-- 更新 client_tokens 表 - 有效期限與輪替寬限
+-- ============================================
+ALTER TABLE client_tokens ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+ALTER TABLE client_tokens ADD COLUMN IF NOT EXISTS rotated_at TIMESTAMPTZ;
+ALTER TABLE client_tokens ADD COLUMN IF NOT EXISTS previous_token_hash VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE client_tokens ADD COLUMN IF NOT EXISTS previous_token_expires_at TIMESTAMPTZ;
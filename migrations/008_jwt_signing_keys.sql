@@ -0,0 +1,27 @@
+-- migrations/008_jwt_signing_keys.sql
+-- JWT Signing Keys 表 - Client Token 的非對稱簽章金鑰 (RS256 / ES256 / EdDSA)
+
+-- ============================================
+-- JWT Signing Keys 表
+-- ============================================
+CREATE TABLE IF NOT EXISTS jwt_signing_keys (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    kid VARCHAR(64) NOT NULL UNIQUE,
+    algorithm VARCHAR(10) NOT NULL,          -- RS256 / ES256 / EdDSA
+    private_key_encrypted TEXT NOT NULL,     -- AES-256-GCM 加密的 PKCS#8 PEM
+    public_key TEXT NOT NULL,                -- PKIX PEM
+    is_active BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    retired_at TIMESTAMPTZ
+);
+
+-- ============================================
+-- 更新 client_tokens 表 - 記錄簽章金鑰
+-- ============================================
+ALTER TABLE client_tokens ADD COLUMN IF NOT EXISTS signing_kid VARCHAR(64) NOT NULL DEFAULT '';
+
+-- ============================================
+-- 索引
+-- ============================================
+CREATE INDEX IF NOT EXISTS idx_client_tokens_signing_kid
+    ON client_tokens(signing_kid);
@@ -0,0 +1,22 @@
+-- migrations/009_token_scopes.sql
+-- Client Token 細粒度權限與寄件地址限制
+
+-- ============================================
+-- 更新 client_tokens 表 - 允許的寄件地址
+-- ============================================
+ALTER TABLE client_tokens ADD COLUMN IF NOT EXISTS allowed_from_addresses TEXT[];
+
+-- ============================================
+-- 權限名稱正規化 (mail.send -> mail:send)
+-- ============================================
+UPDATE client_tokens
+SET permissions = ARRAY(SELECT DISTINCT replace(p, '.', ':') FROM unnest(permissions) AS p)
+WHERE EXISTS (SELECT 1 FROM unnest(permissions) AS p WHERE p LIKE '%.%');
+
+-- ============================================
+-- 既有可寄信的 Token 保留批次發送能力
+-- ============================================
+UPDATE client_tokens
+SET permissions = array_append(permissions, 'mail:batch')
+WHERE 'mail:send' = ANY(permissions)
+  AND NOT ('mail:batch' = ANY(permissions));
@@ -0,0 +1,34 @@
+-- migrations/010_departments.sql
+-- 部門 (租戶) 與委派管理
+
+-- ============================================
+-- 部門表
+-- ============================================
+CREATE TABLE IF NOT EXISTS departments (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(100) NOT NULL UNIQUE,
+    description TEXT,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- ============================================
+-- 由既有 Token 的部門名稱建立部門
+-- ============================================
+INSERT INTO departments (name)
+SELECT DISTINCT trim(department)
+FROM client_tokens
+WHERE department IS NOT NULL AND trim(department) <> ''
+ON CONFLICT (name) DO NOTHING;
+
+-- ============================================
+-- 更新 client_tokens 表 - 所屬部門
+-- ============================================
+ALTER TABLE client_tokens ADD COLUMN IF NOT EXISTS department_id UUID REFERENCES departments(id);
+
+UPDATE client_tokens t
+SET department_id = d.id
+FROM departments d
+WHERE t.department_id IS NULL AND trim(t.department) = d.name;
+
+CREATE INDEX IF NOT EXISTS idx_client_tokens_department_id ON client_tokens(department_id);
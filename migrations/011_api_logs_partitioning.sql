@@ -0,0 +1,84 @@
+-- migrations/011_api_logs_partitioning.sql
+-- api_logs 改為依月份分區，以便依保留期限整個分區刪除
+
+-- ============================================
+-- 保留舊表 (一般資料表) 以便搬移資料
+-- ============================================
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_class WHERE relname = 'api_logs' AND relkind = 'r') THEN
+        ALTER TABLE api_logs RENAME TO api_logs_legacy;
+        ALTER INDEX IF EXISTS api_logs_pkey RENAME TO api_logs_legacy_pkey;
+        ALTER INDEX IF EXISTS idx_api_logs_client_id RENAME TO idx_api_logs_legacy_client_id;
+        ALTER INDEX IF EXISTS idx_api_logs_created_at RENAME TO idx_api_logs_legacy_created_at;
+        ALTER SEQUENCE IF EXISTS api_logs_id_seq RENAME TO api_logs_legacy_id_seq;
+    END IF;
+END $$;
+
+-- ============================================
+-- API 請求日誌表 (依 created_at 月分區)
+-- 不再參照 mails(id)，避免日誌阻擋郵件資料清除
+-- ============================================
+CREATE TABLE IF NOT EXISTS api_logs (
+    id BIGSERIAL,
+    client_id VARCHAR(100) NOT NULL,
+    client_name VARCHAR(255),
+    request_ip INET,
+    endpoint VARCHAR(255) NOT NULL,
+    method VARCHAR(10) NOT NULL,
+    mail_id UUID,
+    status_code INT,
+    response_time_ms INT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id, created_at)
+) PARTITION BY RANGE (created_at);
+
+-- 預設分區：月分區尚未建立時的落點 (服務每日預先建立當月與下個月分區)
+CREATE TABLE IF NOT EXISTS api_logs_default PARTITION OF api_logs DEFAULT;
+
+CREATE INDEX IF NOT EXISTS idx_api_logs_client_id ON api_logs(client_id);
+CREATE INDEX IF NOT EXISTS idx_api_logs_created_at ON api_logs(created_at);
+CREATE INDEX IF NOT EXISTS idx_api_logs_mail_id ON api_logs(mail_id);
+
+-- ============================================
+-- 建立月分區並搬移舊資料
+-- ============================================
+DO $$
+DECLARE
+    m DATE;
+BEGIN
+    FOR m IN
+        SELECT date_trunc('month', NOW() AT TIME ZONE 'UTC')::date
+    LOOP
+        EXECUTE format(
+            'CREATE TABLE IF NOT EXISTS %I PARTITION OF api_logs FOR VALUES FROM (%L) TO (%L)',
+            'api_logs_y' || to_char(m, 'YYYY') || 'm' || to_char(m, 'MM'),
+            m::timestamp AT TIME ZONE 'UTC',
+            (m + INTERVAL '1 month')::timestamp AT TIME ZONE 'UTC'
+        );
+    END LOOP;
+
+    IF to_regclass('api_logs_legacy') IS NULL THEN
+        RETURN;
+    END IF;
+
+    FOR m IN EXECUTE
+        'SELECT DISTINCT date_trunc(''month'', created_at AT TIME ZONE ''UTC'')::date
+         FROM api_logs_legacy WHERE created_at IS NOT NULL'
+    LOOP
+        EXECUTE format(
+            'CREATE TABLE IF NOT EXISTS %I PARTITION OF api_logs FOR VALUES FROM (%L) TO (%L)',
+            'api_logs_y' || to_char(m, 'YYYY') || 'm' || to_char(m, 'MM'),
+            m::timestamp AT TIME ZONE 'UTC',
+            (m + INTERVAL '1 month')::timestamp AT TIME ZONE 'UTC'
+        );
+    END LOOP;
+
+    INSERT INTO api_logs (id, client_id, client_name, request_ip, endpoint, method, mail_id, status_code, response_time_ms, created_at)
+    SELECT id, client_id, client_name, request_ip, endpoint, method, mail_id, status_code, response_time_ms, COALESCE(created_at, NOW())
+    FROM api_logs_legacy;
+
+    PERFORM setval(pg_get_serial_sequence('api_logs', 'id'), (SELECT COALESCE(MAX(id), 0) + 1 FROM api_logs_legacy), false);
+
+    DROP TABLE api_logs_legacy;
+END $$;
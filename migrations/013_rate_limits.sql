@@ -0,0 +1,8 @@
+-- migrations/013_rate_limits.sql
+-- Client Token 速率限制覆寫
+
+-- ============================================
+-- 更新 client_tokens 表 - 速率限制覆寫 (NULL 使用全域設定，0 表示不限制)
+-- ============================================
+ALTER TABLE client_tokens ADD COLUMN IF NOT EXISTS rate_limit_mails_per_hour INT;
+ALTER TABLE client_tokens ADD COLUMN IF NOT EXISTS rate_limit_recipient_per_hour INT;
@@ -0,0 +1,29 @@
+-- migrations/014_quotas.sql
+-- Client Token 用量配額
+
+-- ============================================
+-- 更新 client_tokens 表 - 用量配額 (NULL 使用全域設定，0 表示不限制)
+-- ============================================
+ALTER TABLE client_tokens ADD COLUMN IF NOT EXISTS quota_mails_per_day INT;
+ALTER TABLE client_tokens ADD COLUMN IF NOT EXISTS quota_recipients_per_month INT;
+ALTER TABLE client_tokens ADD COLUMN IF NOT EXISTS quota_attachment_gb_per_month NUMERIC(12, 3);
+
+-- ============================================
+-- 配額警告紀錄 (每個 Client、項目、週期只記錄一次)
+-- ============================================
+CREATE TABLE IF NOT EXISTS quota_warnings (
+    id BIGSERIAL PRIMARY KEY,
+    client_id VARCHAR(100) NOT NULL,
+    metric VARCHAR(50) NOT NULL,
+    period_start TIMESTAMPTZ NOT NULL,
+    used BIGINT NOT NULL,
+    quota_limit BIGINT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    UNIQUE (client_id, metric, period_start)
+);
+
+-- ============================================
+-- 索引 (用量彙總)
+-- ============================================
+CREATE INDEX IF NOT EXISTS idx_mails_client_id_created_at ON mails(client_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_attachments_mail_id ON attachments(mail_id);
@@ -0,0 +1,21 @@
+-- migrations/015_sender_config_certificates.sql
+-- Sender Config 憑證認證 (JWT client assertion)
+
+-- ============================================
+-- 更新 email_sender_configs 表 - 認證方式與憑證
+-- ============================================
+ALTER TABLE email_sender_configs ADD COLUMN IF NOT EXISTS ms_auth_method VARCHAR(20) NOT NULL DEFAULT 'client_secret';
+ALTER TABLE email_sender_configs ADD COLUMN IF NOT EXISTS ms_certificate TEXT;
+ALTER TABLE email_sender_configs ADD COLUMN IF NOT EXISTS ms_private_key_encrypted TEXT;
+ALTER TABLE email_sender_configs ADD COLUMN IF NOT EXISTS ms_certificate_thumbprint VARCHAR(40);
+ALTER TABLE email_sender_configs ADD COLUMN IF NOT EXISTS ms_certificate_expires_at TIMESTAMPTZ;
+
+-- 憑證認證不需要 client secret
+ALTER TABLE email_sender_configs ALTER COLUMN ms_client_secret_encrypted DROP NOT NULL;
+
+ALTER TABLE email_sender_configs DROP CONSTRAINT IF EXISTS chk_email_sender_configs_auth_method;
+ALTER TABLE email_sender_configs ADD CONSTRAINT chk_email_sender_configs_auth_method
+    CHECK (ms_auth_method IN ('client_secret', 'certificate'));
+
+CREATE INDEX IF NOT EXISTS idx_email_sender_configs_cert_expires_at
+    ON email_sender_configs(ms_certificate_expires_at) WHERE ms_auth_method = 'certificate';
@@ -0,0 +1,9 @@
+-- migrations/016_sender_config_endpoints.sql
+-- Sender Config 個別的 Microsoft 端點 (國家雲端)
+
+-- ============================================
+-- 更新 email_sender_configs 表 - authority / Graph 端點
+-- ============================================
+-- 空白 (NULL 或空字串) 表示使用全域設定 MICROSOFT_AUTHORITY_URL / MICROSOFT_GRAPH_URL
+ALTER TABLE email_sender_configs ADD COLUMN IF NOT EXISTS ms_authority_url VARCHAR(255);
+ALTER TABLE email_sender_configs ADD COLUMN IF NOT EXISTS ms_graph_url VARCHAR(255);
@@ -0,0 +1,8 @@
+-- migrations/017_mail_message_options.sql
+-- 郵件選項：顯示名稱、Reply-To、回覆串接標頭、重要性與自訂標頭
+
+-- ============================================
+-- 更新 mails 表 - 郵件選項
+-- ============================================
+-- {"from_name","display_names","reply_to","in_reply_to","references","importance","headers"}
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS message_options JSONB;
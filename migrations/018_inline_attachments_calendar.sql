@@ -0,0 +1,14 @@
+-- migrations/018_inline_attachments_calendar.sql
+-- 內嵌附件 (Content-ID) 與會議邀請
+
+-- ============================================
+-- 更新 attachments 表 - 內嵌附件
+-- ============================================
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS content_id VARCHAR(255);
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS is_inline BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- ============================================
+-- 更新 mails 表 - 會議邀請
+-- ============================================
+-- {"method","uid","content"}
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS calendar_invite JSONB;
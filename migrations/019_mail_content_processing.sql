@@ -0,0 +1,10 @@
+-- migrations/019_mail_content_processing.sql
+-- 內容處理：由 HTML 產生純文字、HTML 消毒與 CSS 內嵌
+
+-- ============================================
+-- 更新 mails 表 - 內容處理紀錄
+-- ============================================
+-- body / html 為處理後實際送出的內容，original_html 保存處理前的 HTML (有變更時)
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS original_html TEXT;
+-- {"text_generated","css_inlined","sanitized","removed_tags","removed_attributes"}
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS content_processing JSONB;
@@ -0,0 +1,15 @@
+-- migrations/020_list_unsubscribe.sql
+-- 一鍵退訂 (RFC 8058)：抑制清單依退訂類別區分
+
+-- ============================================
+-- 更新 suppressions 表 - 退訂類別
+-- ============================================
+-- 空白表示抑制該 Client (或全域) 的所有郵件，否則只抑制帶有相同 list_unsubscribe.category 的郵件
+ALTER TABLE suppressions ADD COLUMN IF NOT EXISTS category VARCHAR(64) NOT NULL DEFAULT '';
+
+-- ============================================
+-- 索引
+-- ============================================
+DROP INDEX IF EXISTS idx_suppressions_email_client;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_suppressions_email_client
+    ON suppressions(email, client_id, category);
@@ -0,0 +1,8 @@
+-- migrations/021_dkim_keys_inactive.sql
+-- DKIM Keys 新金鑰預設停用，發布 DNS 記錄後再以 API 啟用
+
+-- ============================================
+-- 更新 dkim_keys 表 - is_active 預設值
+-- ============================================
+ALTER TABLE dkim_keys
+    ALTER COLUMN is_active SET DEFAULT FALSE;
@@ -0,0 +1,109 @@
+// pkg/microsoft/certificate.go
+// 憑證式 client credentials (JWT client assertion)
+
+package microsoft
+
+import (
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// clientAssertionType RFC 7523 JWT bearer client assertion
+const clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// clientAssertionLifetime client assertion 有效時間
+const clientAssertionLifetime = 10 * time.Minute
+
+// CertificateCredential 已解析的應用程式憑證與私鑰
+// Entra ID 的憑證認證只支援 RSA 金鑰
+type CertificateCredential struct {
+	Certificate *x509.Certificate
+	PrivateKey  *rsa.PrivateKey
+	Thumbprint  string // SHA-1 指紋 (大寫 hex，與 Entra ID 入口網站顯示相同)
+}
+
+// ParseCertificateCredential 解析 PEM 憑證與私鑰，並確認兩者相符
+// 私鑰支援 PKCS#8 與 PKCS#1 (不支援加密的 PEM)；憑證 PEM 含多張時使用第一張
+func ParseCertificateCredential(certificatePEM, privateKeyPEM string) (*CertificateCredential, error) {
+	certBlock, _ := pem.Decode([]byte(strings.TrimSpace(certificatePEM)))
+	if certBlock == nil || certBlock.Type != "CERTIFICATE" {
+		return nil, errors.New("invalid certificate: PEM CERTIFICATE block not found")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate: %w", err)
+	}
+
+	keyBlock, _ := pem.Decode([]byte(strings.TrimSpace(privateKeyPEM)))
+	if keyBlock == nil {
+		return nil, errors.New("invalid private key: PEM block not found")
+	}
+	var key interface{}
+	switch keyBlock.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	default:
+		return nil, fmt.Errorf("invalid private key: unsupported PEM type %q", keyBlock.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("invalid private key: only RSA keys are supported")
+	}
+	certKey, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok || !certKey.Equal(&rsaKey.PublicKey) {
+		return nil, errors.New("private key does not match certificate")
+	}
+
+	sum := sha1.Sum(cert.Raw)
+	return &CertificateCredential{
+		Certificate: cert,
+		PrivateKey:  rsaKey,
+		Thumbprint:  strings.ToUpper(hex.EncodeToString(sum[:])),
+	}, nil
+}
+
+// ValidAt 憑證在指定時間是否有效
+func (c *CertificateCredential) ValidAt(t time.Time) error {
+	if t.Before(c.Certificate.NotBefore) {
+		return fmt.Errorf("certificate is not valid before %s", c.Certificate.NotBefore.Format(time.RFC3339))
+	}
+	if t.After(c.Certificate.NotAfter) {
+		return fmt.Errorf("certificate expired at %s", c.Certificate.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// clientAssertion 簽發 client assertion (RS256，x5t 為憑證 SHA-1 指紋)
+func (c *CertificateCredential) clientAssertion(tokenURL, clientID string) (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"aud": tokenURL,
+		"iss": clientID,
+		"sub": clientID,
+		"jti": uuid.NewString(),
+		"nbf": now.Unix(),
+		"iat": now.Unix(),
+		"exp": now.Add(clientAssertionLifetime).Unix(),
+	})
+	sum := sha1.Sum(c.Certificate.Raw)
+	token.Header["x5t"] = base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return token.SignedString(c.PrivateKey)
+}
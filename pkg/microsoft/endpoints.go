@@ -0,0 +1,94 @@
+// pkg/microsoft/endpoints.go
+// Microsoft 身分識別平台與 Graph API 端點 (全球 / 國家雲端 / 本機測試)
+
+package microsoft
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Endpoints 身分識別平台 (authority) 與 Graph API 的基底 URL
+type Endpoints struct {
+	AuthorityURL string `json:"authority_url,omitempty"` // 如 https://login.microsoftonline.com
+	GraphURL     string `json:"graph_url,omitempty"`     // 如 https://graph.microsoft.com
+}
+
+// 已知的雲端環境
+var (
+	EndpointsGlobal = Endpoints{AuthorityURL: "https://login.microsoftonline.com", GraphURL: "https://graph.microsoft.com"}
+	EndpointsUSGov  = Endpoints{AuthorityURL: "https://login.microsoftonline.us", GraphURL: "https://graph.microsoft.us"}
+	EndpointsUSDoD  = Endpoints{AuthorityURL: "https://login.microsoftonline.us", GraphURL: "https://dod-graph.microsoft.us"}
+	EndpointsChina  = Endpoints{AuthorityURL: "https://login.chinacloudapi.cn", GraphURL: "https://microsoftgraph.chinacloudapi.cn"}
+)
+
+// KnownEndpointHosts 已知雲端環境的端點主機 (Sender Config 個別設定時允許的主機)
+func KnownEndpointHosts() []string {
+	var hosts []string
+	for _, e := range []Endpoints{EndpointsGlobal, EndpointsUSGov, EndpointsUSDoD, EndpointsChina} {
+		for _, raw := range []string{e.AuthorityURL, e.GraphURL} {
+			if u, err := url.Parse(raw); err == nil {
+				hosts = append(hosts, u.Host)
+			}
+		}
+	}
+	return hosts
+}
+
+// WithDefaults 以 defaults 補上空白的欄位，並移除結尾的斜線
+func (e Endpoints) WithDefaults(defaults Endpoints) Endpoints {
+	if e.AuthorityURL == "" {
+		e.AuthorityURL = defaults.AuthorityURL
+	}
+	if e.GraphURL == "" {
+		e.GraphURL = defaults.GraphURL
+	}
+	e.AuthorityURL = strings.TrimRight(e.AuthorityURL, "/")
+	e.GraphURL = strings.TrimRight(e.GraphURL, "/")
+	return e
+}
+
+// IsZero 是否未設定任何端點
+func (e Endpoints) IsZero() bool {
+	return e.AuthorityURL == "" && e.GraphURL == ""
+}
+
+// TokenURL OAuth 2.0 token 端點
+func (e Endpoints) TokenURL(tenantID string) string {
+	return fmt.Sprintf("%s/%s/oauth2/v2.0/token", e.AuthorityURL, url.PathEscape(tenantID))
+}
+
+// GraphScope client credentials 使用的 Graph scope
+func (e Endpoints) GraphScope() string {
+	return e.GraphURL + "/.default"
+}
+
+// GraphAPIURL Graph v1.0 API 基底 URL
+func (e Endpoints) GraphAPIURL() string {
+	return e.GraphURL + "/v1.0"
+}
+
+// ValidateEndpointURL 檢查端點 URL 格式 (http / https、含主機、不含查詢字串)
+// allowedHosts 非空白時主機必須在清單內
+func ValidateEndpointURL(raw string, allowedHosts []string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint URL %q: %w", raw, err)
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("invalid endpoint URL %q: must be an absolute http(s) URL", raw)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("invalid endpoint URL %q: query and fragment are not allowed", raw)
+	}
+	if len(allowedHosts) == 0 {
+		return nil
+	}
+	for _, host := range allowedHosts {
+		if strings.EqualFold(u.Host, host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("endpoint host %q is not allowed", u.Host)
+}
@@ -0,0 +1,695 @@
+// pkg/microsoft/graphtest/server.go
+// 以 httptest 模擬 Microsoft 身分識別平台與 Graph sendMail API (單元測試用)
+
+// Package graphtest 提供假的 Token / Graph 伺服器，
+// 讓 OAuthService 與 GraphMailService 不需連線 Microsoft 即可測試：
+//
+//	srv := graphtest.NewServer()
+//	defer srv.Close()
+//	microsoft.DefaultOAuthManager.Configure(srv.Endpoints(), srv.Client())
+package graphtest
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"mail-proxy/pkg/microsoft"
+)
+
+// clientAssertionType RFC 7523 JWT bearer client assertion
+const clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// TokenRequest 收到的 Token 請求
+type TokenRequest struct {
+	TenantID        string
+	ClientID        string
+	ClientSecret    string
+	ClientAssertion string
+	Scope           string
+	GrantType       string
+}
+
+// SendRequest 收到的 sendMail 請求
+type SendRequest struct {
+	Mailbox     string
+	AccessToken string
+	ContentType string
+	Body        []byte          // 原始請求內容
+	Message     json.RawMessage // JSON 格式時的 message 欄位
+	MIME        []byte          // text/plain (MIME 直送) 時解碼後的郵件
+	DraftID     string          // 以草稿流程送出時的草稿 ID (附件見 Drafts)
+}
+
+// Draft 以草稿流程建立的郵件 (大型附件)
+type Draft struct {
+	ID          string
+	Mailbox     string
+	Message     json.RawMessage
+	Attachments []Attachment
+	Sent        bool
+}
+
+// Attachment 草稿的附件 (直接 POST 或 upload session 上傳)
+type Attachment struct {
+	Name        string
+	ContentType string
+	Content     []byte
+	ContentID   string
+	IsInline    bool
+	Uploaded    bool // 是否經 upload session 上傳
+	Chunks      int  // upload session 的分段數
+}
+
+// uploadSession 進行中的 upload session
+type uploadSession struct {
+	draftID     string
+	name        string
+	contentType string
+	contentID   string
+	isInline    bool
+	size        int64
+	content     []byte
+	chunks      int
+}
+
+// failure 預先設定的錯誤回應
+type failure struct {
+	status  int
+	code    string
+	message string
+}
+
+// Server 假的 Microsoft Token 與 Graph 伺服器
+// 未註冊的 client_id 接受任何 secret / assertion；註冊後才驗證
+type Server struct {
+	// TokenLifetime 發出 Token 的有效時間 (預設 1 小時)
+	TokenLifetime time.Duration
+
+	server *httptest.Server
+
+	mu            sync.Mutex
+	secrets       map[string]string            // client_id -> client secret
+	certificates  map[string]*x509.Certificate // client_id -> 憑證
+	tokens        map[string]string            // access token -> client_id
+	tokenRequests []TokenRequest
+	sendRequests  []SendRequest
+	tokenFailures []failure
+	sendFailures  []failure
+
+	drafts        map[string]*Draft
+	draftOrder    []string
+	sessions      map[string]*uploadSession
+	chunkFailures int
+}
+
+// NewServer 啟動假伺服器 (http)
+func NewServer() *Server {
+	s := newServer()
+	s.server = httptest.NewServer(s.handler())
+	return s
+}
+
+// NewTLSServer 啟動假伺服器 (https，需使用 Client() 取得信任其憑證的 client)
+func NewTLSServer() *Server {
+	s := newServer()
+	s.server = httptest.NewTLSServer(s.handler())
+	return s
+}
+
+func newServer() *Server {
+	return &Server{
+		TokenLifetime: time.Hour,
+		secrets:       make(map[string]string),
+		certificates:  make(map[string]*x509.Certificate),
+		tokens:        make(map[string]string),
+		drafts:        make(map[string]*Draft),
+		sessions:      make(map[string]*uploadSession),
+	}
+}
+
+// Close 關閉伺服器
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// URL 伺服器基底 URL
+func (s *Server) URL() string {
+	return s.server.URL
+}
+
+// Endpoints authority 與 Graph 皆指向本伺服器
+func (s *Server) Endpoints() microsoft.Endpoints {
+	return microsoft.Endpoints{AuthorityURL: s.server.URL, GraphURL: s.server.URL}
+}
+
+// Client 連線至本伺服器的 HTTP client (TLS 伺服器時信任其自簽憑證)
+func (s *Server) Client() *http.Client {
+	return s.server.Client()
+}
+
+// RegisterSecret 註冊應用程式的 client secret
+func (s *Server) RegisterSecret(clientID, secret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.secrets[clientID] = secret
+}
+
+// RegisterCertificate 註冊應用程式的憑證 (驗證 client assertion 的簽章與 x5t)
+func (s *Server) RegisterCertificate(clientID string, cert *x509.Certificate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.certificates[clientID] = cert
+}
+
+// FailNextToken 下一次 Token 請求回應指定的錯誤
+func (s *Server) FailNextToken(status int, code, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokenFailures = append(s.tokenFailures, failure{status: status, code: code, message: message})
+}
+
+// FailNextSend 下一次 sendMail 請求回應指定的 Graph 錯誤 (如 429 / 503)
+func (s *Server) FailNextSend(status int, code, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sendFailures = append(s.sendFailures, failure{status: status, code: code, message: message})
+}
+
+// FailNextChunks 之後 n 個 upload session 分段回應 503 (模擬上傳中斷)
+func (s *Server) FailNextChunks(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chunkFailures += n
+}
+
+// ExpireUploadSessions 使進行中的 upload session 全部失效 (回應 404)
+func (s *Server) ExpireUploadSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions = make(map[string]*uploadSession)
+}
+
+// Drafts 以草稿流程建立的郵件 (依建立順序)
+func (s *Server) Drafts() []Draft {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	drafts := make([]Draft, 0, len(s.draftOrder))
+	for _, id := range s.draftOrder {
+		if d, ok := s.drafts[id]; ok {
+			copied := *d
+			copied.Attachments = append([]Attachment(nil), d.Attachments...)
+			drafts = append(drafts, copied)
+		}
+	}
+	return drafts
+}
+
+// RevokeTokens 使已發出的 Token 全部失效 (模擬 Token 過期或撤銷)
+func (s *Server) RevokeTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = make(map[string]string)
+}
+
+// TokenRequests 已收到的 Token 請求
+func (s *Server) TokenRequests() []TokenRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]TokenRequest(nil), s.tokenRequests...)
+}
+
+// SendRequests 已收到的 sendMail 請求
+func (s *Server) SendRequests() []SendRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]SendRequest(nil), s.sendRequests...)
+}
+
+// Reset 清除已記錄的請求與預先設定的錯誤 (註冊的憑證與 Token 保留)
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokenRequests = nil
+	s.sendRequests = nil
+	s.tokenFailures = nil
+	s.sendFailures = nil
+}
+
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /{tenant}/oauth2/v2.0/token", s.handleToken)
+	mux.HandleFunc("POST /v1.0/users/{mailbox}/sendMail", s.handleSendMail)
+	mux.HandleFunc("POST /v1.0/users/{mailbox}/messages", s.authorized(s.handleCreateDraft))
+	mux.HandleFunc("GET /v1.0/users/{mailbox}/messages/{id}", s.authorized(s.handleGetDraft))
+	mux.HandleFunc("DELETE /v1.0/users/{mailbox}/messages/{id}", s.authorized(s.handleDeleteDraft))
+	mux.HandleFunc("GET /v1.0/users/{mailbox}/messages/{id}/attachments", s.authorized(s.handleListAttachments))
+	mux.HandleFunc("POST /v1.0/users/{mailbox}/messages/{id}/attachments", s.authorized(s.handleAddAttachment))
+	mux.HandleFunc("POST /v1.0/users/{mailbox}/messages/{id}/attachments/createUploadSession", s.authorized(s.handleCreateUploadSession))
+	mux.HandleFunc("POST /v1.0/users/{mailbox}/messages/{id}/send", s.authorized(s.handleSendDraft))
+	mux.HandleFunc("GET /upload/{session}", s.handleUploadStatus)
+	mux.HandleFunc("PUT /upload/{session}", s.handleUploadChunk)
+	return mux
+}
+
+// authorized 檢查 Bearer Token 是否由本伺服器發出
+func (s *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		s.mu.Lock()
+		_, ok := s.tokens[token]
+		s.mu.Unlock()
+		if !ok {
+			writeGraphError(w, http.StatusUnauthorized, "InvalidAuthenticationToken", "Access token is empty or invalid.")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleToken 模擬 client credentials 流程
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	req := TokenRequest{
+		TenantID:        r.PathValue("tenant"),
+		ClientID:        r.PostForm.Get("client_id"),
+		ClientSecret:    r.PostForm.Get("client_secret"),
+		ClientAssertion: r.PostForm.Get("client_assertion"),
+		Scope:           r.PostForm.Get("scope"),
+		GrantType:       r.PostForm.Get("grant_type"),
+	}
+
+	s.mu.Lock()
+	s.tokenRequests = append(s.tokenRequests, req)
+	if len(s.tokenFailures) > 0 {
+		f := s.tokenFailures[0]
+		s.tokenFailures = s.tokenFailures[1:]
+		s.mu.Unlock()
+		writeTokenError(w, f.status, f.code, f.message)
+		return
+	}
+	secret, hasSecret := s.secrets[req.ClientID]
+	cert, hasCert := s.certificates[req.ClientID]
+	s.mu.Unlock()
+
+	switch {
+	case req.GrantType != "client_credentials":
+		writeTokenError(w, http.StatusBadRequest, "unsupported_grant_type", "grant_type must be client_credentials")
+		return
+	case req.ClientID == "":
+		writeTokenError(w, http.StatusBadRequest, "invalid_request", "client_id is required")
+		return
+	case req.Scope != s.server.URL+"/.default":
+		writeTokenError(w, http.StatusBadRequest, "invalid_scope", "unexpected scope "+req.Scope)
+		return
+	}
+
+	if req.ClientAssertion != "" {
+		if r.PostForm.Get("client_assertion_type") != clientAssertionType {
+			writeTokenError(w, http.StatusBadRequest, "invalid_request", "invalid client_assertion_type")
+			return
+		}
+		if hasCert {
+			tokenURL := s.server.URL + r.URL.Path
+			if err := verifyAssertion(req.ClientAssertion, req.ClientID, tokenURL, cert); err != nil {
+				writeTokenError(w, http.StatusUnauthorized, "invalid_client", err.Error())
+				return
+			}
+		}
+	} else if req.ClientSecret == "" || (hasSecret && req.ClientSecret != secret) {
+		writeTokenError(w, http.StatusUnauthorized, "invalid_client", "invalid client secret")
+		return
+	}
+
+	token := newToken()
+	s.mu.Lock()
+	s.tokens[token] = req.ClientID
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"token_type":   "Bearer",
+		"access_token": token,
+		"expires_in":   int(s.TokenLifetime.Seconds()),
+	})
+}
+
+// handleSendMail 模擬 Graph sendMail (成功時回應 202)
+func (s *Server) handleSendMail(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeGraphError(w, http.StatusBadRequest, "BadRequest", err.Error())
+		return
+	}
+
+	req := SendRequest{
+		Mailbox:     r.PathValue("mailbox"),
+		AccessToken: strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "),
+		ContentType: r.Header.Get("Content-Type"),
+		Body:        body,
+	}
+
+	s.mu.Lock()
+	_, authorized := s.tokens[req.AccessToken]
+	if !authorized {
+		s.mu.Unlock()
+		writeGraphError(w, http.StatusUnauthorized, "InvalidAuthenticationToken", "Access token is empty or invalid.")
+		return
+	}
+	s.sendRequests = append(s.sendRequests, req)
+	idx := len(s.sendRequests) - 1
+	if len(s.sendFailures) > 0 {
+		f := s.sendFailures[0]
+		s.sendFailures = s.sendFailures[1:]
+		s.mu.Unlock()
+		writeGraphError(w, f.status, f.code, f.message)
+		return
+	}
+	s.mu.Unlock()
+
+	if strings.HasPrefix(req.ContentType, "text/plain") {
+		raw, err := base64.StdEncoding.DecodeString(string(body))
+		if err != nil {
+			writeGraphError(w, http.StatusBadRequest, "ErrorMimeContentInvalidBase64String", "Invalid base64 string for MIME content.")
+			return
+		}
+		req.MIME = raw
+	} else {
+		var payload struct {
+			Message json.RawMessage `json:"message"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil || len(payload.Message) == 0 {
+			writeGraphError(w, http.StatusBadRequest, "ErrorInvalidRequest", "Invalid request body.")
+			return
+		}
+		req.Message = payload.Message
+	}
+
+	s.mu.Lock()
+	s.sendRequests[idx] = req
+	s.mu.Unlock()
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleCreateDraft 建立草稿
+func (s *Server) handleCreateDraft(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil || !json.Valid(body) {
+		writeGraphError(w, http.StatusBadRequest, "ErrorInvalidRequest", "Invalid request body.")
+		return
+	}
+
+	draft := &Draft{ID: "draft-" + newToken(), Mailbox: r.PathValue("mailbox"), Message: body}
+	s.mu.Lock()
+	s.drafts[draft.ID] = draft
+	s.draftOrder = append(s.draftOrder, draft.ID)
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"id": draft.ID, "isDraft": true})
+}
+
+// handleGetDraft 查詢草稿 (送出後 isDraft 為 false；使用不可變 ID，送出後 ID 不變)
+func (s *Server) handleGetDraft(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	draft, ok := s.drafts[r.PathValue("id")]
+	var sent bool
+	if ok {
+		sent = draft.Sent
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeGraphError(w, http.StatusNotFound, "ErrorItemNotFound", "The specified object was not found in the store.")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"id": r.PathValue("id"), "isDraft": !sent})
+}
+
+// handleDeleteDraft 刪除草稿
+func (s *Server) handleDeleteDraft(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	_, ok := s.drafts[r.PathValue("id")]
+	delete(s.drafts, r.PathValue("id"))
+	s.mu.Unlock()
+
+	if !ok {
+		writeGraphError(w, http.StatusNotFound, "ErrorItemNotFound", "The specified object was not found in the store.")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListAttachments 列出草稿已完成的附件 (upload session 上傳中的附件不列出)
+func (s *Server) handleListAttachments(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	draft, ok := s.drafts[r.PathValue("id")]
+	if !ok {
+		writeGraphError(w, http.StatusNotFound, "ErrorItemNotFound", "The specified object was not found in the store.")
+		return
+	}
+	value := make([]map[string]interface{}, 0, len(draft.Attachments))
+	for _, att := range draft.Attachments {
+		value = append(value, map[string]interface{}{"name": att.Name, "size": len(att.Content)})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"value": value})
+}
+
+// handleAddAttachment 直接加入附件 (3 MB 以下)
+func (s *Server) handleAddAttachment(w http.ResponseWriter, r *http.Request) {
+	var att struct {
+		Name         string `json:"name"`
+		ContentType  string `json:"contentType"`
+		ContentBytes string `json:"contentBytes"`
+		ContentID    string `json:"contentId"`
+		IsInline     bool   `json:"isInline"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&att); err != nil {
+		writeGraphError(w, http.StatusBadRequest, "ErrorInvalidRequest", "Invalid request body.")
+		return
+	}
+	content, err := base64.StdEncoding.DecodeString(att.ContentBytes)
+	if err != nil {
+		writeGraphError(w, http.StatusBadRequest, "ErrorInvalidRequest", "Invalid contentBytes.")
+		return
+	}
+	if len(content) > 3<<20 {
+		writeGraphError(w, http.StatusRequestEntityTooLarge, "ErrorRequestEntityTooLarge", "Attachment exceeds 3 MB, use an upload session.")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	draft, ok := s.drafts[r.PathValue("id")]
+	if !ok || draft.Sent {
+		writeGraphError(w, http.StatusNotFound, "ErrorItemNotFound", "The specified object was not found in the store.")
+		return
+	}
+	draft.Attachments = append(draft.Attachments, Attachment{
+		Name:        att.Name,
+		ContentType: att.ContentType,
+		Content:     content,
+		ContentID:   att.ContentID,
+		IsInline:    att.IsInline,
+	})
+	writeJSON(w, http.StatusCreated, map[string]string{"id": newToken()})
+}
+
+// handleCreateUploadSession 建立附件的 upload session
+func (s *Server) handleCreateUploadSession(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		AttachmentItem struct {
+			AttachmentType string `json:"attachmentType"`
+			Name           string `json:"name"`
+			Size           int64  `json:"size"`
+			ContentType    string `json:"contentType"`
+			ContentID      string `json:"contentId"`
+			IsInline       bool   `json:"isInline"`
+		} `json:"AttachmentItem"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.AttachmentItem.Size <= 0 {
+		writeGraphError(w, http.StatusBadRequest, "ErrorInvalidRequest", "Invalid AttachmentItem.")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if draft, ok := s.drafts[r.PathValue("id")]; !ok || draft.Sent {
+		writeGraphError(w, http.StatusNotFound, "ErrorItemNotFound", "The specified object was not found in the store.")
+		return
+	}
+	id := newToken()
+	s.sessions[id] = &uploadSession{
+		draftID:     r.PathValue("id"),
+		name:        req.AttachmentItem.Name,
+		contentType: req.AttachmentItem.ContentType,
+		contentID:   req.AttachmentItem.ContentID,
+		isInline:    req.AttachmentItem.IsInline,
+		size:        req.AttachmentItem.Size,
+	}
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"uploadUrl":          s.server.URL + "/upload/" + id,
+		"expirationDateTime": time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+		"nextExpectedRanges": []string{"0-"},
+	})
+}
+
+// handleUploadStatus 查詢 upload session 的下一段起點
+func (s *Server) handleUploadStatus(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	session, ok := s.sessions[r.PathValue("session")]
+	var next int64
+	if ok {
+		next = int64(len(session.content))
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeGraphError(w, http.StatusNotFound, "ErrorItemNotFound", "Upload session not found.")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"nextExpectedRanges": []string{strconv.FormatInt(next, 10) + "-"}})
+}
+
+// handleUploadChunk 接收分段 (Content-Range 須接續已上傳的內容，且不可帶 Authorization)
+func (s *Server) handleUploadChunk(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "" {
+		writeGraphError(w, http.StatusUnauthorized, "InvalidAuthenticationToken", "Upload URL requests must not include an Authorization header.")
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeGraphError(w, http.StatusBadRequest, "BadRequest", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.chunkFailures > 0 {
+		s.chunkFailures--
+		writeGraphError(w, http.StatusServiceUnavailable, "ServiceUnavailable", "Simulated upload failure.")
+		return
+	}
+	session, ok := s.sessions[r.PathValue("session")]
+	if !ok {
+		writeGraphError(w, http.StatusNotFound, "ErrorItemNotFound", "Upload session not found.")
+		return
+	}
+
+	var start, end, total int64
+	if _, err := fmt.Sscanf(r.Header.Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &total); err != nil ||
+		start != int64(len(session.content)) || end-start+1 != int64(len(body)) || total != session.size {
+		writeGraphError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "Unexpected Content-Range "+r.Header.Get("Content-Range"))
+		return
+	}
+	session.content = append(session.content, body...)
+	session.chunks++
+
+	if int64(len(session.content)) < session.size {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"nextExpectedRanges": []string{strconv.FormatInt(int64(len(session.content)), 10) + "-"}})
+		return
+	}
+
+	delete(s.sessions, r.PathValue("session"))
+	if draft, ok := s.drafts[session.draftID]; ok {
+		draft.Attachments = append(draft.Attachments, Attachment{
+			Name:        session.name,
+			ContentType: session.contentType,
+			Content:     session.content,
+			ContentID:   session.contentID,
+			IsInline:    session.isInline,
+			Uploaded:    true,
+			Chunks:      session.chunks,
+		})
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"id": newToken()})
+}
+
+// handleSendDraft 送出草稿 (回應 202，並記錄於 SendRequests)
+func (s *Server) handleSendDraft(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.sendFailures) > 0 {
+		f := s.sendFailures[0]
+		s.sendFailures = s.sendFailures[1:]
+		writeGraphError(w, f.status, f.code, f.message)
+		return
+	}
+	draft, ok := s.drafts[r.PathValue("id")]
+	if !ok || draft.Sent {
+		writeGraphError(w, http.StatusNotFound, "ErrorItemNotFound", "The specified object was not found in the store.")
+		return
+	}
+	draft.Sent = true
+	s.sendRequests = append(s.sendRequests, SendRequest{
+		Mailbox:     draft.Mailbox,
+		AccessToken: strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "),
+		ContentType: "application/json",
+		Message:     draft.Message,
+		DraftID:     draft.ID,
+	})
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// verifyAssertion 驗證 client assertion 的簽章、x5t、aud 與 iss / sub
+func verifyAssertion(assertion, clientID, tokenURL string, cert *x509.Certificate) error {
+	token, err := jwt.Parse(assertion, func(t *jwt.Token) (interface{}, error) {
+		return cert.PublicKey, nil
+	},
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithAudience(tokenURL),
+		jwt.WithIssuer(clientID),
+		jwt.WithSubject(clientID),
+	)
+	if err != nil {
+		return err
+	}
+
+	sum := sha1.Sum(cert.Raw)
+	if token.Header["x5t"] != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		return jwt.ErrTokenUnverifiable
+	}
+	return nil
+}
+
+func newToken() string {
+	b := make([]byte, 24)
+	_, _ = rand.Read(b)
+	return "graphtest-" + hex.EncodeToString(b)
+}
+
+func writeTokenError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": message})
+}
+
+func writeGraphError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]string{"code": code, "message": message},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
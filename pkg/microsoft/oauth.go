@@ -4,8 +4,10 @@
 package microsoft
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"net/url"
 	"strings"
@@ -13,11 +15,30 @@ import (
 	"time"
 )
 
+// tokenRefreshSkew Token 到期前提前更新的時間
+const tokenRefreshSkew = 60 * time.Second
+
+// 跨實例共用快取的逾時設定
+const (
+	sharedStoreTimeout = 2 * time.Second  // 單次 TokenStore 操作
+	sharedLockTTL      = 15 * time.Second // 刷新鎖的最長持有時間
+	sharedWaitTimeout  = 5 * time.Second  // 等待其他實例完成刷新的最長時間
+	sharedPollInterval = 200 * time.Millisecond
+)
+
 // OAuthService Microsoft OAuth 2.0 服務
 type OAuthService struct {
 	tenantID     string
 	clientID     string
 	clientSecret string
+	certificate  *CertificateCredential // 設定時以 client assertion 取代 client secret
+
+	endpoints  Endpoints
+	httpClient *http.Client
+
+	// 跨實例共用快取 (由 OAuthManager 設定，可為 nil)
+	cacheKey string
+	store    TokenStore
 
 	accessToken string
 	expiresAt   time.Time
@@ -37,14 +58,37 @@ func NewOAuthService(tenantID, clientID, clientSecret string) *OAuthService {
 		tenantID:     tenantID,
 		clientID:     clientID,
 		clientSecret: clientSecret,
+		endpoints:    EndpointsGlobal,
+		httpClient:   http.DefaultClient,
+	}
+}
+
+// NewOAuthServiceWithCertificate 建立以憑證認證的 OAuth 服務
+func NewOAuthServiceWithCertificate(tenantID, clientID string, certificate *CertificateCredential) *OAuthService {
+	return &OAuthService{
+		tenantID:    tenantID,
+		clientID:    clientID,
+		certificate: certificate,
+		endpoints:   EndpointsGlobal,
+		httpClient:  http.DefaultClient,
 	}
 }
 
+// Endpoints 取得 Token 時使用的端點
+func (s *OAuthService) Endpoints() Endpoints {
+	return s.endpoints
+}
+
+// tokenFresh Token 是否仍可使用 (提前 tokenRefreshSkew 視為過期)
+func tokenFresh(token string, expiresAt time.Time) bool {
+	return token != "" && time.Now().Add(tokenRefreshSkew).Before(expiresAt)
+}
+
 // GetAccessToken 取得 Access Token (帶快取)
 func (s *OAuthService) GetAccessToken() (string, error) {
 	s.mu.RLock()
-	// 檢查快取是否有效 (提前 60 秒更新)
-	if s.accessToken != "" && time.Now().Add(60*time.Second).Before(s.expiresAt) {
+	// 檢查快取是否有效
+	if tokenFresh(s.accessToken, s.expiresAt) {
 		token := s.accessToken
 		s.mu.RUnlock()
 		return token, nil
@@ -56,83 +100,343 @@ func (s *OAuthService) GetAccessToken() (string, error) {
 }
 
 // refreshToken 刷新 Access Token
+// 程序內以 mu 確保同時只有一個刷新請求；設定 TokenStore 時先讀取共用快取，
+// 並以分散式鎖讓多個實例同時只有一個向 Microsoft 取得新 Token
 func (s *OAuthService) refreshToken() (string, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	// Double-check: 可能其他 goroutine 已經更新
-	if s.accessToken != "" && time.Now().Add(60*time.Second).Before(s.expiresAt) {
+	if tokenFresh(s.accessToken, s.expiresAt) {
 		return s.accessToken, nil
 	}
 
+	if s.store != nil {
+		if s.loadShared() {
+			return s.accessToken, nil
+		}
+
+		unlock, acquired := s.lockShared()
+		if acquired {
+			defer unlock()
+			// 取得鎖之前其他實例可能剛完成刷新
+			if s.loadShared() {
+				return s.accessToken, nil
+			}
+		} else if s.waitShared() {
+			return s.accessToken, nil
+		}
+	}
+
+	token, expiresAt, err := s.requestToken()
+	if err != nil {
+		return "", err
+	}
+
+	// 更新快取
+	s.accessToken = token
+	s.expiresAt = expiresAt
+	s.saveShared()
+
+	return s.accessToken, nil
+}
+
+// requestToken 以 client credentials 向 Microsoft 取得新 Token
+func (s *OAuthService) requestToken() (string, time.Time, error) {
 	// 建立 Token 請求
-	tokenURL := fmt.Sprintf(
-		"https://login.microsoftonline.com/%s/oauth2/v2.0/token",
-		s.tenantID,
-	)
+	tokenURL := s.endpoints.TokenURL(s.tenantID)
 
 	data := url.Values{}
 	data.Set("client_id", s.clientID)
-	data.Set("client_secret", s.clientSecret)
-	data.Set("scope", "https://graph.microsoft.com/.default")
+	if s.certificate != nil {
+		if err := s.certificate.ValidAt(time.Now()); err != nil {
+			return "", time.Time{}, err
+		}
+		assertion, err := s.certificate.clientAssertion(tokenURL, s.clientID)
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("failed to sign client assertion: %w", err)
+		}
+		data.Set("client_assertion_type", clientAssertionType)
+		data.Set("client_assertion", assertion)
+	} else {
+		data.Set("client_secret", s.clientSecret)
+	}
+	data.Set("scope", s.endpoints.GraphScope())
 	data.Set("grant_type", "client_credentials")
 
 	// 發送請求
-	resp, err := http.Post(
+	resp, err := s.httpClient.Post(
 		tokenURL,
 		"application/x-www-form-urlencoded",
 		strings.NewReader(data.Encode()),
 	)
 	if err != nil {
-		return "", fmt.Errorf("failed to request token: %w", err)
+		return "", time.Time{}, fmt.Errorf("failed to request token: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// 檢查回應狀態
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("token request failed with status: %d", resp.StatusCode)
+		return "", time.Time{}, fmt.Errorf("token request failed with status: %d", resp.StatusCode)
 	}
 
 	// 解析回應
 	var tokenResp tokenResponse
 	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
-		return "", fmt.Errorf("failed to decode token response: %w", err)
+		return "", time.Time{}, fmt.Errorf("failed to decode token response: %w", err)
 	}
 
-	// 更新快取
-	s.accessToken = tokenResp.AccessToken
-	s.expiresAt = time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
+	return tokenResp.AccessToken, time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second), nil
+}
 
-	return s.accessToken, nil
+// loadShared 讀取共用快取，仍有效時更新本地快取 (需持有 mu)
+func (s *OAuthService) loadShared() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), sharedStoreTimeout)
+	defer cancel()
+
+	token, expiresAt, ok, err := s.store.Get(ctx, s.cacheKey)
+	if err != nil {
+		log.Printf("OAuth token cache read failed: %v", err)
+		return false
+	}
+	if !ok || !tokenFresh(token, expiresAt) {
+		return false
+	}
+	s.accessToken = token
+	s.expiresAt = expiresAt
+	return true
+}
+
+// saveShared 將本地 Token 寫入共用快取 (需持有 mu)
+func (s *OAuthService) saveShared() {
+	if s.store == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sharedStoreTimeout)
+	defer cancel()
+
+	if err := s.store.Set(ctx, s.cacheKey, s.accessToken, s.expiresAt); err != nil {
+		log.Printf("OAuth token cache write failed: %v", err)
+	}
+}
+
+// lockShared 取得跨實例刷新鎖；共用快取無法使用時視為取得 (各自刷新)
+func (s *OAuthService) lockShared() (func(), bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), sharedStoreTimeout)
+	defer cancel()
+
+	unlock, acquired, err := s.store.Lock(ctx, s.cacheKey, sharedLockTTL)
+	if err != nil {
+		log.Printf("OAuth token cache lock failed: %v", err)
+		return func() {}, true
+	}
+	return unlock, acquired
+}
+
+// waitShared 等待持有刷新鎖的實例寫入新 Token；逾時回傳 false 由呼叫端自行刷新
+func (s *OAuthService) waitShared() bool {
+	deadline := time.Now().Add(sharedWaitTimeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(sharedPollInterval)
+		if s.loadShared() {
+			return true
+		}
+	}
+	return false
 }
 
 // IsConfigured 檢查 OAuth 是否已設定
 func (s *OAuthService) IsConfigured() bool {
-	return s.tenantID != "" && s.clientID != "" && s.clientSecret != ""
+	return s.tenantID != "" && s.clientID != "" && (s.clientSecret != "" || s.certificate != nil)
+}
+
+// Credential 應用程式憑證 (ClientSecret 或 CertificatePEM + PrivateKeyPEM 擇一)
+type Credential struct {
+	ClientSecret   string
+	CertificatePEM string
+	PrivateKeyPEM  string
+
+	// Endpoints 應用程式所屬雲端的端點 (空白欄位使用 OAuthManager 的預設值)
+	Endpoints Endpoints
+}
+
+// IsCertificate 是否為憑證認證
+func (c Credential) IsCertificate() bool {
+	return c.CertificatePEM != ""
+}
+
+// cacheMaterial 參與快取 key 計算的憑證內容
+func (c Credential) cacheMaterial() string {
+	if c.IsCertificate() {
+		return "certificate\x00" + c.CertificatePEM + "\x00" + c.PrivateKeyPEM
+	}
+	return c.ClientSecret
+}
+
+// managerIdleTimeout 超過此時間未使用的 OAuthService 會從 OAuthManager 移除 (如憑證已變更)
+const managerIdleTimeout = 2 * time.Hour
+
+// managerEntry OAuthManager 快取項目
+type managerEntry struct {
+	service  *OAuthService
+	lastUsed time.Time
 }
 
 // OAuthManager 多租戶 OAuth 管理器
-// 不使用快取，每次都建立新的 OAuthService 以確保使用最新的憑證
-type OAuthManager struct{}
+// 依憑證 (tenant + client + secret 指紋) 快取 OAuthService，相同憑證的郵件共用 Token；
+// 憑證變更後即為不同的 key，不會沿用舊 Token。設定 TokenStore 後另與其他實例共用
+type OAuthManager struct {
+	mu        sync.Mutex
+	entries   map[string]*managerEntry
+	store     TokenStore
+	lastPrune time.Time
+
+	endpoints  Endpoints    // 預設端點
+	httpClient *http.Client // 所有 OAuthService 共用
+}
 
 // NewOAuthManager 建立 OAuth 管理器
 func NewOAuthManager() *OAuthManager {
-	return &OAuthManager{}
+	return &OAuthManager{
+		entries:    make(map[string]*managerEntry),
+		endpoints:  EndpointsGlobal,
+		httpClient: http.DefaultClient,
+	}
 }
 
-// GetOrCreateService 建立 OAuthService
-// 每次都建立新的 OAuthService，不使用快取
+// Configure 設定預設端點與共用的 HTTP client (啟動時呼叫；已快取的 OAuthService 會被清除)
+// endpoints 空白欄位維持全球雲端，httpClient 為 nil 時使用 http.DefaultClient
+func (m *OAuthManager) Configure(endpoints Endpoints, httpClient *http.Client) {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.endpoints = endpoints.WithDefaults(EndpointsGlobal)
+	m.httpClient = httpClient
+	m.entries = make(map[string]*managerEntry)
+}
+
+// ResolveEndpoints 以預設端點補上空白欄位
+func (m *OAuthManager) ResolveEndpoints(endpoints Endpoints) Endpoints {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return endpoints.WithDefaults(m.endpoints)
+}
+
+// SetTokenStore 設定跨實例共用的 Token 快取 (啟動時呼叫；已快取的 OAuthService 會被清除)
+func (m *OAuthManager) SetTokenStore(store TokenStore) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.store = store
+	m.entries = make(map[string]*managerEntry)
+}
+
+// GetOrCreateService 取得 client secret 對應的 OAuthService，不存在時建立
 func (m *OAuthManager) GetOrCreateService(tenantID, clientID, clientSecret string) *OAuthService {
-	// 每次都建立新的 OAuthService，確保使用傳入的最新憑證
-	return NewOAuthService(tenantID, clientID, clientSecret)
+	service, _ := m.GetOrCreateServiceWithCredential(tenantID, clientID, Credential{ClientSecret: clientSecret})
+	return service
+}
+
+// GetOrCreateServiceWithCredential 取得憑證對應的 OAuthService，不存在時建立
+// 憑證認證的 PEM 只在建立時解析一次，解析失敗回傳錯誤
+func (m *OAuthManager) GetOrCreateServiceWithCredential(tenantID, clientID string, credential Credential) (*OAuthService, error) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	endpoints := credential.Endpoints.WithDefaults(m.endpoints)
+	key := m.cacheKeyLocked(tenantID, clientID, credential)
+
+	m.pruneLocked(now)
+	if entry, ok := m.entries[key]; ok {
+		entry.lastUsed = now
+		return entry.service, nil
+	}
+
+	var service *OAuthService
+	if credential.IsCertificate() {
+		certificate, err := ParseCertificateCredential(credential.CertificatePEM, credential.PrivateKeyPEM)
+		if err != nil {
+			return nil, err
+		}
+		service = NewOAuthServiceWithCertificate(tenantID, clientID, certificate)
+	} else {
+		service = NewOAuthService(tenantID, clientID, credential.ClientSecret)
+	}
+	service.cacheKey = key
+	service.store = m.store
+	service.endpoints = endpoints
+	service.httpClient = m.httpClient
+	m.entries[key] = &managerEntry{service: service, lastUsed: now}
+	return service, nil
 }
 
 // GetAccessToken 根據配置取得 Access Token
 func (m *OAuthManager) GetAccessToken(tenantID, clientID, clientSecret string) (string, error) {
-	service := m.GetOrCreateService(tenantID, clientID, clientSecret)
+	return m.GetAccessTokenWithCredential(tenantID, clientID, Credential{ClientSecret: clientSecret})
+}
+
+// GetAccessTokenWithCredential 根據配置 (client secret 或憑證) 取得 Access Token
+func (m *OAuthManager) GetAccessTokenWithCredential(tenantID, clientID string, credential Credential) (string, error) {
+	service, err := m.GetOrCreateServiceWithCredential(tenantID, clientID, credential)
+	if err != nil {
+		return "", err
+	}
 	return service.GetAccessToken()
 }
 
+// Invalidate 移除 client secret 對應的快取 Token
+func (m *OAuthManager) Invalidate(tenantID, clientID, clientSecret string) {
+	m.InvalidateCredential(tenantID, clientID, Credential{ClientSecret: clientSecret})
+}
+
+// InvalidateCredential 移除憑證對應的快取 Token (程序內與共用快取)
+// 其他實例的程序內快取以憑證為 key，憑證變更後自然不會再使用
+func (m *OAuthManager) InvalidateCredential(tenantID, clientID string, credential Credential) {
+	m.mu.Lock()
+	key := m.cacheKeyLocked(tenantID, clientID, credential)
+	delete(m.entries, key)
+	store := m.store
+	m.mu.Unlock()
+
+	if store == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sharedStoreTimeout)
+	defer cancel()
+	if err := store.Delete(ctx, key); err != nil {
+		log.Printf("OAuth token cache invalidation failed: %v", err)
+	}
+}
+
+// cacheKeyLocked 計算快取 key (含端點，不同雲端的 Token 不共用；需持有 mu)
+func (m *OAuthManager) cacheKeyLocked(tenantID, clientID string, credential Credential) string {
+	endpoints := credential.Endpoints.WithDefaults(m.endpoints)
+	material := credential.cacheMaterial()
+	if endpoints != EndpointsGlobal {
+		material += "\x00" + endpoints.AuthorityURL + "\x00" + endpoints.GraphURL
+	}
+	return CredentialKey(tenantID, clientID, material)
+}
+
+// pruneLocked 定期移除閒置的 OAuthService (需持有 mu)
+func (m *OAuthManager) pruneLocked(now time.Time) {
+	if now.Sub(m.lastPrune) < managerIdleTimeout/4 {
+		return
+	}
+	m.lastPrune = now
+	for key, entry := range m.entries {
+		if now.Sub(entry.lastUsed) > managerIdleTimeout {
+			delete(m.entries, key)
+		}
+	}
+}
+
 // DefaultOAuthManager 全域預設管理器
 var DefaultOAuthManager = NewOAuthManager()
 
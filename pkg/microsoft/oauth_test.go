@@ -0,0 +1,177 @@
+package microsoft_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"mail-proxy/pkg/microsoft"
+	"mail-proxy/pkg/microsoft/graphtest"
+)
+
+func newTestManager(srv *graphtest.Server) *microsoft.OAuthManager {
+	manager := microsoft.NewOAuthManager()
+	manager.Configure(srv.Endpoints(), srv.Client())
+	return manager
+}
+
+// newTestCertificate 產生自簽 RSA 憑證與 PKCS#8 私鑰 PEM
+func newTestCertificate(t *testing.T) (*x509.Certificate, string, string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "mail-proxy test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return cert, string(certPEM), string(keyPEM)
+}
+
+func TestOAuthServiceClientSecret(t *testing.T) {
+	srv := graphtest.NewServer()
+	defer srv.Close()
+	srv.RegisterSecret("client-1", "secret-1")
+	manager := newTestManager(srv)
+
+	token, err := manager.GetAccessToken("tenant-1", "client-1", "secret-1")
+	if err != nil {
+		t.Fatalf("GetAccessToken() error = %v", err)
+	}
+	if token == "" {
+		t.Fatal("GetAccessToken() returned an empty token")
+	}
+
+	// 第二次取得使用快取
+	again, err := manager.GetAccessToken("tenant-1", "client-1", "secret-1")
+	if err != nil || again != token {
+		t.Fatalf("cached GetAccessToken() = %q, %v; want %q", again, err, token)
+	}
+
+	requests := srv.TokenRequests()
+	if len(requests) != 1 {
+		t.Fatalf("token requests = %d, want 1", len(requests))
+	}
+	req := requests[0]
+	if req.TenantID != "tenant-1" || req.ClientSecret != "secret-1" || req.ClientAssertion != "" || req.GrantType != "client_credentials" {
+		t.Fatalf("unexpected token request %+v", req)
+	}
+	if req.Scope != srv.URL()+"/.default" {
+		t.Fatalf("scope = %q, want %q", req.Scope, srv.URL()+"/.default")
+	}
+}
+
+func TestOAuthServiceRejectedSecret(t *testing.T) {
+	srv := graphtest.NewServer()
+	defer srv.Close()
+	srv.RegisterSecret("client-1", "secret-1")
+	manager := newTestManager(srv)
+
+	if _, err := manager.GetAccessToken("tenant-1", "client-1", "wrong"); err == nil {
+		t.Fatal("GetAccessToken() with a wrong secret succeeded")
+	}
+
+	srv.FailNextToken(http.StatusServiceUnavailable, "temporarily_unavailable", "try again")
+	if _, err := manager.GetAccessToken("tenant-1", "client-1", "secret-1"); err == nil {
+		t.Fatal("GetAccessToken() succeeded despite a token endpoint failure")
+	}
+	if _, err := manager.GetAccessToken("tenant-1", "client-1", "secret-1"); err != nil {
+		t.Fatalf("GetAccessToken() after recovery error = %v", err)
+	}
+}
+
+func TestOAuthServiceRefresh(t *testing.T) {
+	srv := graphtest.NewServer()
+	defer srv.Close()
+	// 有效時間短於提前更新的時間，每次取得都應重新向 Token 端點請求
+	srv.TokenLifetime = 30 * time.Second
+	manager := newTestManager(srv)
+
+	first, err := manager.GetAccessToken("tenant-1", "client-1", "secret-1")
+	if err != nil {
+		t.Fatalf("GetAccessToken() error = %v", err)
+	}
+	second, err := manager.GetAccessToken("tenant-1", "client-1", "secret-1")
+	if err != nil {
+		t.Fatalf("GetAccessToken() error = %v", err)
+	}
+	if first == second {
+		t.Fatal("expired token was not refreshed")
+	}
+	if n := len(srv.TokenRequests()); n != 2 {
+		t.Fatalf("token requests = %d, want 2", n)
+	}
+
+	// 憑證變更後不沿用舊 Token
+	srv.TokenLifetime = time.Hour
+	srv.Reset()
+	if _, err := manager.GetAccessToken("tenant-1", "client-1", "secret-2"); err != nil {
+		t.Fatalf("GetAccessToken() error = %v", err)
+	}
+	if requests := srv.TokenRequests(); len(requests) != 1 || requests[0].ClientSecret != "secret-2" {
+		t.Fatalf("token requests after secret change = %+v", requests)
+	}
+}
+
+func TestOAuthServiceCertificateAssertion(t *testing.T) {
+	srv := graphtest.NewServer()
+	defer srv.Close()
+	cert, certPEM, keyPEM := newTestCertificate(t)
+	srv.RegisterCertificate("client-1", cert)
+	manager := newTestManager(srv)
+
+	credential := microsoft.Credential{CertificatePEM: certPEM, PrivateKeyPEM: keyPEM}
+	if _, err := manager.GetAccessTokenWithCredential("tenant-1", "client-1", credential); err != nil {
+		t.Fatalf("GetAccessTokenWithCredential() error = %v", err)
+	}
+	requests := srv.TokenRequests()
+	if len(requests) != 1 || requests[0].ClientAssertion == "" || requests[0].ClientSecret != "" {
+		t.Fatalf("unexpected token requests %+v", requests)
+	}
+
+	// 以其他憑證簽發的 assertion 應被拒絕
+	_, otherCertPEM, otherKeyPEM := newTestCertificate(t)
+	other := microsoft.Credential{CertificatePEM: otherCertPEM, PrivateKeyPEM: otherKeyPEM}
+	if _, err := manager.GetAccessTokenWithCredential("tenant-1", "client-1", other); err == nil {
+		t.Fatal("GetAccessTokenWithCredential() with an unregistered certificate succeeded")
+	}
+}
+
+func TestOAuthServiceInvalidCertificate(t *testing.T) {
+	srv := graphtest.NewServer()
+	defer srv.Close()
+	_, certPEM, _ := newTestCertificate(t)
+	_, _, otherKeyPEM := newTestCertificate(t)
+	manager := newTestManager(srv)
+
+	credential := microsoft.Credential{CertificatePEM: certPEM, PrivateKeyPEM: otherKeyPEM}
+	if _, err := manager.GetAccessTokenWithCredential("tenant-1", "client-1", credential); err == nil {
+		t.Fatal("GetAccessTokenWithCredential() with a mismatched key succeeded")
+	}
+	if n := len(srv.TokenRequests()); n != 0 {
+		t.Fatalf("token requests = %d, want 0", n)
+	}
+}
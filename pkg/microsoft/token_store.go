@@ -0,0 +1,31 @@
+// pkg/microsoft/token_store.go
+// Access Token 跨程序共用快取介面
+
+package microsoft
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// TokenStore 跨程序共用的 Access Token 快取 (如 KeyDB)
+// 多個 Worker / API 實例以相同 key 共用同一組憑證取得的 Token
+type TokenStore interface {
+	// Get 取得快取的 Token；不存在時 ok 為 false
+	Get(ctx context.Context, key string) (token string, expiresAt time.Time, ok bool, err error)
+	// Set 寫入 Token，快取至 expiresAt 為止
+	Set(ctx context.Context, key, token string, expiresAt time.Time) error
+	// Delete 刪除 Token
+	Delete(ctx context.Context, key string) error
+	// Lock 取得刷新 Token 的分散式鎖 (跨實例 single-flight)；已被其他實例持有時 acquired 為 false
+	Lock(ctx context.Context, key string, ttl time.Duration) (unlock func(), acquired bool, err error)
+}
+
+// CredentialKey 依 tenant / client / secret 計算快取 key
+// secret 只以 SHA-256 指紋參與計算，快取 key 不會洩漏憑證；任一值變更即為不同的 key
+func CredentialKey(tenantID, clientID, clientSecret string) string {
+	sum := sha256.Sum256([]byte(tenantID + "\x00" + clientID + "\x00" + clientSecret))
+	return hex.EncodeToString(sum[:16])
+}